@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runBench implements the bench command.
+func runBench(args []string) {
+	var (
+		only          string
+		jsonOutput    bool
+		savePath      string
+		baselinePath  string
+		maxRegression float64
+	)
+
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.StringVar(&only, "only", "", "Comma-separated list of benchmarks to run (e.g. mrtd-100mb, kernel-16mb, td-hob, acpi-tables)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.StringVar(&savePath, "save", "", "Path to save the results to, for use as a baseline")
+	fs.StringVar(&baselinePath, "baseline", "", "Path to baseline results to compare against")
+	fs.Float64Var(&maxRegression, "max-regression", 20, "Maximum allowed slowdown against the baseline, in percent")
+	parseFlags(fs, args)
+
+	var filter []string
+	if only != "" {
+		filter = strings.Split(only, ",")
+	}
+
+	results, err := internal.RunBenchmarks(filter)
+	if err != nil {
+		fmt.Printf("Error running benchmarks: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, r := range results {
+			fmt.Printf("%-12s %8d iterations %14d ns/op %10d allocs/op %12d B/op", r.Name, r.Iterations, r.NsPerOp, r.AllocsPerOp, r.BytesPerOp)
+			if r.MBPerSec > 0 {
+				fmt.Printf(" %10.2f MB/s", r.MBPerSec)
+			}
+			fmt.Println()
+		}
+	}
+
+	if savePath != "" {
+		jsonData, _ := json.MarshalIndent(results, "", "  ")
+		if err = os.WriteFile(savePath, jsonData, 0o644); err != nil {
+			fmt.Printf("Error writing results: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if baselinePath != "" {
+		data, err := os.ReadFile(baselinePath)
+		if err != nil {
+			fmt.Printf("Error reading baseline: %v\n", err)
+			os.Exit(1)
+		}
+		var baseline []internal.BenchmarkResult
+		if err = json.Unmarshal(data, &baseline); err != nil {
+			fmt.Printf("Error decoding baseline: %v\n", err)
+			os.Exit(1)
+		}
+
+		var regressed bool
+		for _, r := range results {
+			for _, b := range baseline {
+				if b.Name != r.Name || b.NsPerOp == 0 {
+					continue
+				}
+				change := 100 * float64(r.NsPerOp-b.NsPerOp) / float64(b.NsPerOp)
+				if change > maxRegression {
+					fmt.Printf("REGRESSION: %s is %.1f%% slower than the baseline\n", r.Name, change)
+					regressed = true
+				}
+			}
+		}
+		if regressed {
+			os.Exit(1)
+		}
+	}
+}
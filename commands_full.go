@@ -0,0 +1,30 @@
+//go:build !minimal
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// Commands that serve, verify or fetch over the network, or manage deployments. They are left out
+// of minimal builds.
+func init() {
+	commands["verify"] = runVerify
+	commands["operator"] = runOperator
+	commands["watch"] = runWatch
+	commands["registry"] = runRegistry
+	commands["init-project"] = runInitProject
+	commands["fetch-evidence"] = runFetchEvidence
+	commands["serve"] = runServe
+	commands["check-runtime"] = runCheckRuntime
+	commands["docker"] = runDocker
+	commands[internal.SandboxWorkerCommand] = func([]string) {
+		if err := internal.RunSandboxWorker(os.Stdin, os.NewFile(3, "result")); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
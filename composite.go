@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// compositeOutput is the JSON output of the composite command.
+type compositeOutput struct {
+	MrAggregated string `json:"mr_aggregated"`
+	MrImage      string `json:"mr_image"`
+	MrSystem     string `json:"mr_system"`
+
+	Composites map[string]map[string]string `json:"composites,omitempty"`
+}
+
+// compositeSpecFlags selects additional composite spec versions to compute.
+type compositeSpecFlags struct {
+	versions  stringList
+	specsPath string
+}
+
+// register defines the composite spec flags on the given flag set.
+func (c *compositeSpecFlags) register(fs *flag.FlagSet) {
+	fs.Var(&c.versions, "composite-spec", "Version of a composite spec to additionally compute composite values for (can be repeated)")
+	fs.StringVar(&c.specsPath, "composite-specs", "", "Path to a JSON file with composite spec definitions in addition to the built-in ones")
+}
+
+// resolve returns the selected composite specs, exiting on errors.
+func (c *compositeSpecFlags) resolve() []*internal.CompositeSpec {
+	var custom []*internal.CompositeSpec
+	if c.specsPath != "" {
+		var err error
+		if custom, err = internal.LoadCompositeSpecs(c.specsPath); err != nil {
+			fmt.Printf("Error loading composite specs: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var specs []*internal.CompositeSpec
+	for _, version := range c.versions {
+		spec, err := internal.LookupCompositeSpec(version)
+		for _, s := range custom {
+			if s.Version == version {
+				spec, err = s, nil
+			}
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		specs = append(specs, spec)
+	}
+	return specs
+}
+
+// computeComposites computes the composite values of all given specs keyed by spec version and
+// composite name, exiting on errors.
+func computeComposites(specs []*internal.CompositeSpec, m *internal.TdxMeasurements, mrKeyProvider string) map[string]map[string]string {
+	if len(specs) == 0 {
+		return nil
+	}
+	result := make(map[string]map[string]string)
+	for _, spec := range specs {
+		values, err := spec.Compute(m, mrKeyProvider)
+		if err != nil {
+			fmt.Printf("Error computing composite values of spec %s: %v\n", spec.Version, err)
+			os.Exit(1)
+		}
+		result[spec.Version] = make(map[string]string)
+		for _, v := range values {
+			result[spec.Version][v.Name] = v.Value
+		}
+	}
+	return result
+}
+
+// printComposites prints the composite values of all given specs.
+func printComposites(specs []*internal.CompositeSpec, composites map[string]map[string]string) {
+	for _, spec := range specs {
+		for _, c := range spec.Composites {
+			fmt.Printf("COMPOSITE %s %s: %s\n", spec.Version, strings.ToUpper(c.Name), composites[spec.Version][c.Name])
+		}
+	}
+}
+
+// runComposite implements the composite command.
+func runComposite(args []string) {
+	var (
+		mrKeyProvider string
+		jsonOutput    bool
+		specFlags     compositeSpecFlags
+		digests       digestFlags
+	)
+	names := internal.TdxRegisterNames()
+	registers := make([]string, len(names))
+
+	fs := flag.NewFlagSet("composite", flag.ExitOnError)
+	for i, name := range names {
+		fs.StringVar(&registers[i], strings.ToLower(name), "", fmt.Sprintf("Hex-encoded %s value", name))
+	}
+	fs.StringVar(&mrKeyProvider, "mrkp", defaultMrKeyProvider, "Measurement of key provider")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	specFlags.register(fs)
+	digests.register(fs)
+	parseFlags(fs, args)
+	digests.check(false)
+	specs := specFlags.resolve()
+
+	if knownKeyProvider, ok := knownKeyProviders[mrKeyProvider]; ok {
+		mrKeyProvider = knownKeyProvider
+	}
+	if _, err := hex.DecodeString(strings.TrimPrefix(mrKeyProvider, "0x")); err != nil {
+		fmt.Printf("Error: invalid key provider measurement: %v\n", err)
+		os.Exit(1)
+	}
+
+	values := make(internal.RegisterList, len(names))
+	for i, name := range names {
+		if registers[i] == "" {
+			fmt.Println("Error: MRTD and all RTMR values are required")
+			fs.Usage()
+			os.Exit(1)
+		}
+		v, err := hex.DecodeString(strings.TrimPrefix(registers[i], "0x"))
+		if err != nil || len(v) != 48 {
+			fmt.Printf("Error: %s must be a 48 byte hex value\n", name)
+			os.Exit(1)
+		}
+		values[i] = internal.Register{Name: name, Value: v}
+	}
+
+	measurements := internal.TdxMeasurementsFromRegisters(values)
+	output := compositeOutput{
+		MrImage:    measurements.CalculateMrImage(),
+		Composites: computeComposites(specs, measurements, mrKeyProvider),
+	}
+	var err error
+	if output.MrAggregated, err = measurements.CalculateMrAggregated(mrKeyProvider); err == nil {
+		output.MrSystem, err = measurements.CalculateMrSystem(mrKeyProvider)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	output.MrAggregated = digests.reformat(output.MrAggregated)
+	output.MrImage = digests.reformat(output.MrImage)
+	output.MrSystem = digests.reformat(output.MrSystem)
+	output.Composites = digests.reformatComposites(output.Composites)
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Printf("MR_AGGREGATED: %s\n", output.MrAggregated)
+	fmt.Printf("MR_IMAGE: %s\n", output.MrImage)
+	fmt.Printf("MR_SYSTEM: %s\n", output.MrSystem)
+	printComposites(specs, output.Composites)
+}
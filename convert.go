@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runConvert implements the convert command, which converts event logs between formats.
+func runConvert(args []string) {
+	var (
+		inPath    string
+		ccelTable string
+		outPath   string
+		from      string
+		to        string
+	)
+
+	formats := strings.Join(internal.EventLogFormats, ", ")
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.StringVar(&inPath, "in", "", "Path to the event log to convert")
+	fs.StringVar(&ccelTable, "ccel-table", "", ccelTableUsage)
+	fs.StringVar(&outPath, "out", "", "Path to write the converted event log to (defaults to stdout)")
+	fs.StringVar(&from, "from", "", "Format of the input event log: "+formats+" (detected from the contents by default)")
+	fs.StringVar(&to, "to", "", "Format of the output event log: "+formats)
+	parseFlags(fs, args)
+
+	if inPath == "" || to == "" {
+		fmt.Println("Error: -in and -to are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	data, err := os.ReadFile(inPath)
+	if err != nil {
+		fmt.Printf("Error reading event log: %v\n", err)
+		os.Exit(1)
+	}
+	if data, err = boundEventLog(data, ccelTable); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if from == "" {
+		from = internal.DetectEventLogFormat(data)
+	}
+	events, err := internal.ParseEventLog(data, from)
+	if err != nil {
+		fmt.Printf("Error parsing event log: %v\n", err)
+		os.Exit(1)
+	}
+	out, err := internal.EncodeEventLog(events, to)
+	if err != nil {
+		fmt.Printf("Error encoding event log: %v\n", err)
+		os.Exit(1)
+	}
+
+	if outPath == "" {
+		os.Stdout.Write(out)
+		return
+	}
+	if err = os.WriteFile(outPath, out, 0o644); err != nil {
+		fmt.Printf("Error writing event log: %v\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// crosscheckOutput is the JSON output of the crosscheck command.
+type crosscheckOutput struct {
+	Registers []internal.RegisterResult `json:"registers"`
+	Match     bool                      `json:"match"`
+	Warnings  []internal.Warning        `json:"warnings"`
+}
+
+// runCrosscheck implements the crosscheck command, which compares the computed measurements with
+// those of another tool for the same inputs.
+func runCrosscheck(args []string) {
+	var (
+		opts       measureOptions
+		intelJSON  string
+		exportPath string
+		jsonOutput bool
+		noColor    bool
+	)
+
+	fs := flag.NewFlagSet("crosscheck", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&intelJSON, "intel-json", "", "Path to the JSON output of Intel's TDX measurement tool to compare against")
+	fs.StringVar(&exportPath, "export-intel-json", "", "Path to write the computed measurements to in the JSON format of Intel's TDX measurement tool")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.BoolVar(&noColor, "no-color", false, "Disable ANSI colors in the result table")
+	parseFlags(fs, args)
+
+	if intelJSON == "" && exportPath == "" {
+		fmt.Println("Error: -intel-json or -export-intel-json is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var other *internal.IntelMeasurements
+	if intelJSON != "" {
+		data, err := os.ReadFile(intelJSON)
+		if err != nil {
+			fmt.Printf("Error reading measurement tool output: %v\n", err)
+			os.Exit(1)
+		}
+		if other, err = internal.ParseIntelMeasurements(data); err != nil {
+			fmt.Printf("Error parsing measurement tool output: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	job := opts.prepare(fs)
+	measurements, err := job.measure(job.kernelData)
+	if err != nil {
+		fmt.Printf("Error calculating measurements: %v\n", err)
+		os.Exit(1)
+	}
+
+	if exportPath != "" {
+		data, err := internal.MarshalIntelMeasurements(measurements)
+		if err != nil {
+			fmt.Printf("Error encoding measurements: %v\n", err)
+			os.Exit(1)
+		}
+		if err = os.WriteFile(exportPath, append(data, '\n'), 0o644); err != nil {
+			fmt.Printf("Error writing measurements: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if other == nil {
+		return
+	}
+
+	// Only the registers computed by both tools are compared.
+	var registers []string
+	for _, r := range other.Registers() {
+		if v, _ := internal.LookupRegister(measurements, r.Name); v != nil {
+			registers = append(registers, r.Name)
+		}
+	}
+	results, err := internal.CompareRegisters(measurements, other, registers)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	coverage := measurements.RegisterCoverage()
+	match := len(results) > 0
+	for i := range results {
+		results[i].Coverage = coverage[results[i].Register]
+		match = match && results[i].Match
+	}
+	warnings := append(append([]internal.Warning{}, job.warnings...), measurements.Warnings...)
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(crosscheckOutput{Registers: results, Match: match, Warnings: warnings}, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		if len(results) > 0 {
+			printVerifyTable(results, useColor(noColor))
+		} else {
+			fmt.Println("No register was computed by both tools")
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if !match {
+		os.Exit(1)
+	}
+}
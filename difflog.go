@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// diffLogRegister is the comparison of the events of a register in the diff-log output.
+type diffLogRegister struct {
+	Register  string `json:"register"`
+	Predicted string `json:"predicted"`
+	Replayed  string `json:"replayed"`
+	Match     bool   `json:"match"`
+	// FirstMismatch is the index of the first divergent event, -1 if all events match.
+	FirstMismatch int                  `json:"first_mismatch"`
+	Events        []internal.EventDiff `json:"events"`
+}
+
+// runDiffLog implements the diff-log command, which replays the event log of a TD, e.g. its CCEL,
+// and compares it event by event with the event log the measurement inputs predict.
+func runDiffLog(args []string) {
+	var (
+		opts       measureOptions
+		eventLog   string
+		ccelTable  string
+		registers  string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("diff-log", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&eventLog, "event-log", "", "Path to the event log of the TD, e.g. a copy of /sys/firmware/acpi/tables/data/CCEL, in any format of the convert command")
+	fs.StringVar(&ccelTable, "ccel-table", "", ccelTableUsage)
+	fs.StringVar(&registers, "registers", "rtmr0,rtmr1,rtmr2", "Comma-separated list of the registers to compare")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if eventLog == "" {
+		fmt.Println("Error: -event-log is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(eventLog)
+	if err != nil {
+		fmt.Printf("Error reading event log: %v\n", err)
+		os.Exit(1)
+	}
+	if data, err = boundEventLog(data, ccelTable); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	events, err := internal.ParseEventLog(data, internal.DetectEventLogFormat(data))
+	if err != nil {
+		fmt.Printf("Error parsing event log: %v\n", err)
+		os.Exit(1)
+	}
+
+	var names []string
+	for _, name := range strings.Split(registers, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if !internal.IsTdxRegister(name) {
+			fmt.Printf("Error: unknown register '%s'\n", name)
+			os.Exit(1)
+		}
+		if name == "MRTD" {
+			fmt.Println("Error: MRTD is not recorded in the event log")
+			os.Exit(1)
+		}
+		names = append(names, name)
+	}
+	opts.only = strings.Join(names, ",")
+	job := opts.prepare(fs)
+	measurements, err := job.measure(job.kernelData)
+	if err != nil {
+		fmt.Printf("Error calculating measurements: %v\n", err)
+		os.Exit(1)
+	}
+
+	var output []diffLogRegister
+	match := true
+	for _, name := range names {
+		replayed, err := internal.ReplayEventLog(events, name)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		predicted, _ := internal.LookupRegister(measurements, name)
+		r := diffLogRegister{
+			Register:      name,
+			Predicted:     hex.EncodeToString(predicted),
+			Replayed:      hex.EncodeToString(replayed),
+			FirstMismatch: -1,
+			Events:        internal.DiffEventLog(measurements, events, name),
+		}
+		if r.Events == nil {
+			r.Events = []internal.EventDiff{}
+		}
+		for _, e := range r.Events {
+			if !e.Match {
+				r.FirstMismatch = e.Index
+				break
+			}
+		}
+		r.Match = r.Predicted == r.Replayed && r.FirstMismatch < 0
+		match = match && r.Match
+		output = append(output, r)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, r := range output {
+			result := "MATCH"
+			if !r.Match {
+				result = "MISMATCH"
+			}
+			fmt.Printf("%s: %s\n", r.Register, result)
+			fmt.Printf("  predicted: %s\n", r.Predicted)
+			fmt.Printf("  replayed:  %s\n", r.Replayed)
+			for _, e := range r.Events {
+				status := "ok  "
+				if !e.Match {
+					status = "DIFF"
+				}
+				label := e.Event
+				if label == "" {
+					label = "(not expected)"
+				}
+				fmt.Printf("  #%-3d %s %s\n", e.Index, status, label)
+				if e.Match {
+					continue
+				}
+				actual := e.Actual
+				if actual == "" {
+					actual = "(missing from the event log)"
+				}
+				fmt.Printf("         expected: %s\n", e.Expected)
+				fmt.Printf("         actual:   %s\n", actual)
+				if e.Index == r.FirstMismatch {
+					fmt.Println("         first divergent extend")
+				}
+			}
+		}
+		for _, w := range job.warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if !match {
+		os.Exit(1)
+	}
+}
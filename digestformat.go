@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// Digest formats selected with -digest-format.
+const (
+	digestFormatHex    = "hex"
+	digestFormatHex0x  = "hex0x"
+	digestFormatBase64 = "base64"
+)
+
+// digestFlags selects how register values, composites and event digests are encoded in the
+// output, so that consumers expecting e.g. uppercase or base64 digests need no reformatting.
+type digestFlags struct {
+	format    string
+	upperCase bool
+}
+
+// register defines the digest format flags on the given flag set.
+func (d *digestFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&d.format, "digest-format", digestFormatHex, "Encoding of digests in the output: hex, hex0x (0x-prefixed hex) or base64")
+	fs.BoolVar(&d.upperCase, "digest-upper", false, "Use uppercase hex digits in hex and hex0x digests")
+}
+
+// check validates the flags, exiting on errors. Canonical JSON lowercases all hex values, so
+// uppercase digests cannot be combined with it.
+func (d *digestFlags) check(canonical bool) {
+	switch d.format {
+	case digestFormatHex, digestFormatHex0x, digestFormatBase64:
+	default:
+		fmt.Printf("Error: unknown digest format '%s', expected hex, hex0x or base64\n", d.format)
+		os.Exit(1)
+	}
+	if d.upperCase && canonical {
+		fmt.Println("Error: -digest-upper cannot be combined with -canonical, which lowercases hex values")
+		os.Exit(1)
+	}
+}
+
+// encode encodes a digest in the selected format. Missing digests are encoded as empty strings.
+func (d *digestFlags) encode(digest []byte) string {
+	if digest == nil {
+		return ""
+	}
+	switch d.format {
+	case digestFormatBase64:
+		return base64.StdEncoding.EncodeToString(digest)
+	case digestFormatHex0x:
+		return "0x" + d.hexCase(hex.EncodeToString(digest))
+	default:
+		return d.hexCase(hex.EncodeToString(digest))
+	}
+}
+
+// reformat re-encodes a hex-encoded digest in the selected format. Values that are not hex are
+// returned unchanged.
+func (d *digestFlags) reformat(value string) string {
+	digest, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+	if err != nil || value == "" {
+		return value
+	}
+	return d.encode(digest)
+}
+
+// reformatValues re-encodes the hex-encoded digests of a map of values.
+func (d *digestFlags) reformatValues(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	result := make(map[string]string, len(values))
+	for name, value := range values {
+		result[name] = d.reformat(value)
+	}
+	return result
+}
+
+// reformatComposites re-encodes the composite values of all specs.
+func (d *digestFlags) reformatComposites(composites map[string]map[string]string) map[string]map[string]string {
+	if composites == nil {
+		return nil
+	}
+	result := make(map[string]map[string]string, len(composites))
+	for version, values := range composites {
+		result[version] = d.reformatValues(values)
+	}
+	return result
+}
+
+// reformatCoverage re-encodes the digests of coverage entries.
+func (d *digestFlags) reformatCoverage(entries []internal.CoverageEntry) []internal.CoverageEntry {
+	result := make([]internal.CoverageEntry, len(entries))
+	for i, e := range entries {
+		e.Digest = d.reformat(e.Digest)
+		result[i] = e
+	}
+	return result
+}
+
+func (d *digestFlags) hexCase(value string) string {
+	if d.upperCase {
+		return strings.ToUpper(value)
+	}
+	return value
+}
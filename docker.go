@@ -0,0 +1,93 @@
+//go:build !minimal
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runDocker implements the docker command, which builds a distroless container image running the
+// measurement service.
+func runDocker(args []string) {
+	var (
+		srcDir         string
+		templatesDir   string
+		tag            string
+		goVersion      string
+		port           uint
+		dockerfilePath string
+	)
+
+	version := internal.ReadBuildInfo().Version
+	fs := flag.NewFlagSet("docker", flag.ExitOnError)
+	fs.StringVar(&srcDir, "src", ".", "Path to the source tree of the tool used as build context")
+	fs.StringVar(&templatesDir, "templates", "", "Path to the ACPI templates directory within the source tree to include in the image")
+	fs.StringVar(&tag, "tag", "reproduce-mr:"+version, "Tag of the built image")
+	fs.StringVar(&goVersion, "go-version", "1.22", "Version of the Go toolchain image used to build the binary")
+	fs.UintVar(&port, "port", 8080, "Port the service listens on in the container")
+	fs.StringVar(&dockerfilePath, "dockerfile", "", "Path to write the Dockerfile to instead of building the image")
+	parseFlags(fs, args)
+
+	if templatesDir == "" {
+		fmt.Println("Error: templates directory is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if port == 0 || port > 65535 {
+		fmt.Printf("Error: invalid port %d\n", port)
+		os.Exit(1)
+	}
+	if _, err := os.Stat(filepath.Join(srcDir, "go.mod")); err != nil {
+		fmt.Printf("Error: %s is not the source tree of the tool: %v\n", srcDir, err)
+		os.Exit(1)
+	}
+
+	var out *os.File
+	if dockerfilePath == "" {
+		f, err := os.CreateTemp("", "reproduce-mr-Dockerfile-")
+		if err != nil {
+			fmt.Printf("Error creating Dockerfile: %v\n", err)
+			os.Exit(1)
+		}
+		defer os.Remove(f.Name())
+		out = f
+	} else {
+		f, err := os.Create(dockerfilePath)
+		if err != nil {
+			fmt.Printf("Error creating Dockerfile: %v\n", err)
+			os.Exit(1)
+		}
+		out = f
+	}
+	err := internal.WriteDockerfile(out, internal.DockerfileOptions{
+		GoVersion: goVersion,
+		Templates: filepath.ToSlash(templatesDir),
+		Port:      uint16(port),
+		Version:   version,
+	})
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Printf("Error writing Dockerfile: %v\n", err)
+		os.Exit(1)
+	}
+	if dockerfilePath != "" {
+		fmt.Printf("Wrote %s\n", dockerfilePath)
+		return
+	}
+
+	cmd := exec.Command("docker", "build", "-t", tag, "-f", out.Name(), srcDir)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err = cmd.Run(); err != nil {
+		fmt.Printf("Error building image: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Built %s\n", tag)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix is the prefix of environment variables configuring flags.
+const envPrefix = "DSTACK_MR_"
+
+// envName returns the environment variable configuring the flag with the given name, e.g.
+// DSTACK_MR_TEMPLATES_URL for -templates-url.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// parseFlags parses the command line arguments and then sets every flag that was not given on the
+// command line from its environment variable. Flags take precedence over environment variables,
+// which in turn take precedence over values from manifests and metadata.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	_ = fs.Parse(args)
+
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	fs.VisitAll(func(f *flag.Flag) {
+		if setFlags[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		// Repeatable flags take a comma-separated list.
+		values := []string{value}
+		if _, ok := f.Value.(*stringList); ok {
+			values = strings.Split(value, ",")
+		}
+		for _, v := range values {
+			if err := fs.Set(f.Name, v); err != nil {
+				fmt.Printf("Error: invalid value for %s: %v\n", envName(f.Name), err)
+				os.Exit(1)
+			}
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Output formats of the measure command selected with -format.
+const (
+	outputFormatText = "text"
+	outputFormatJSON = "json"
+	outputFormatEnv  = "env"
+)
+
+// envVariable is a line of env output.
+type envVariable struct {
+	name  string
+	value string
+}
+
+// validEnvName reports whether name can be used as a shell variable name.
+func validEnvName(name string) bool {
+	for i, c := range name {
+		if c != '_' && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// envVariableName converts a value name such as a composite name to a variable name, replacing
+// characters that are not allowed in variable names with underscores.
+func envVariableName(name string) string {
+	return strings.Map(func(c rune) rune {
+		if c == '_' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' {
+			return c
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+// printEnv prints the variables as NAME=value lines that can be sourced by a shell or used as an
+// env file by docker and CI systems. Digests only contain characters that need no quoting. Empty
+// values are left out.
+func printEnv(prefix string, variables []envVariable) {
+	for _, v := range variables {
+		if v.value != "" {
+			fmt.Printf("%s%s=%s\n", prefix, v.name, v.value)
+		}
+	}
+}
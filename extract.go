@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/sha512"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runExtractFwSection implements the extract-fw-section command.
+func runExtractFwSection(args []string) {
+	var (
+		fwPath      string
+		sectionType string
+		outPath     string
+		index       int
+		memorySize  memoryValue = 2048 // 2G default (in MB)
+		profileName string
+		tdHobMap    string
+	)
+
+	fs := flag.NewFlagSet("extract-fw-section", flag.ExitOnError)
+	fs.StringVar(&fwPath, "fw", "", "Path to firmware file")
+	fs.StringVar(&sectionType, "type", "", "Section type (bfv, cfv, td_hob)")
+	fs.StringVar(&outPath, "out", "", "Path to output file")
+	fs.IntVar(&index, "index", 0, "Index of the section when several sections of the same type are present")
+	fs.Var(&memorySize, "memory", "Memory size used to generate the TD HOB (e.g., 512M, 1G, 2G)")
+	fs.StringVar(&profileName, "profile", internal.DefaultProfile, "Name of the QEMU/firmware profile used to generate the TD HOB")
+	fs.StringVar(&tdHobMap, "td-hob-map", "", "Path to a JSON list of TD HOB resource descriptors replacing the memory map QEMU describes for -memory")
+	parseFlags(fs, args)
+
+	profile, err := internal.LookupProfile(profileName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if fwPath == "" || sectionType == "" || outPath == "" {
+		fmt.Println("Error: firmware path, section type and output path are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if tdHobMap != "" {
+		data, err := os.ReadFile(tdHobMap)
+		if err != nil {
+			fmt.Printf("Error reading TD HOB memory map: %v\n", err)
+			os.Exit(1)
+		}
+		resources, err := internal.ParseHobResources(data)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		profile = profile.WithHobResources(resources)
+	}
+
+	fwData, err := os.ReadFile(fwPath)
+	if err != nil {
+		fmt.Printf("Error reading firmware file: %v\n", err)
+		os.Exit(1)
+	}
+
+	data, err := internal.ExtractFirmwareSection(fwData, sectionType, index, uint64(memorySize), profile)
+	if err != nil {
+		fmt.Printf("Error extracting firmware section: %v\n", err)
+		os.Exit(1)
+	}
+
+	if err = os.WriteFile(outPath, data, 0o644); err != nil {
+		fmt.Printf("Error writing output file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d bytes (SHA384: %x)\n", len(data), sha512.Sum384(data))
+}
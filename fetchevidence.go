@@ -0,0 +1,83 @@
+//go:build !minimal
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runFetchEvidence implements the fetch-evidence command.
+func runFetchEvidence(args []string) {
+	var (
+		local         bool
+		sshTarget     string
+		agent         string
+		reportDataHex string
+		outDir        string
+	)
+
+	fs := flag.NewFlagSet("fetch-evidence", flag.ExitOnError)
+	fs.BoolVar(&local, "local", false, "Collect evidence from within the TD running this command")
+	fs.StringVar(&sshTarget, "ssh", "", "Collect evidence from a TD over SSH ([user@]host)")
+	fs.StringVar(&agent, "agent", "", "Collect evidence through the dstack guest agent (http(s) URL or unix:<socket path>)")
+	fs.StringVar(&reportDataHex, "report-data", "", "Hex-encoded report data to request the quote for, up to 64 bytes (defaults to a random nonce)")
+	fs.StringVar(&outDir, "out", "evidence", "Directory to write the evidence to")
+	parseFlags(fs, args)
+
+	sources := 0
+	for _, set := range []bool{local, sshTarget != "", agent != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		fmt.Println("Error: exactly one of -local, -ssh and -agent is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var reportData []byte
+	if reportDataHex != "" {
+		var err error
+		reportData, err = hex.DecodeString(strings.TrimPrefix(reportDataHex, "0x"))
+		if err != nil {
+			fmt.Printf("Error: invalid report data: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		reportData = make([]byte, 32)
+		if _, err := rand.Read(reportData); err != nil {
+			fmt.Printf("Error generating nonce: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var (
+		evidence *internal.Evidence
+		err      error
+	)
+	switch {
+	case local:
+		evidence, err = internal.FetchLocalEvidence(reportData)
+	case sshTarget != "":
+		evidence, err = internal.FetchSSHEvidence(sshTarget, reportData)
+	default:
+		evidence, err = internal.FetchAgentEvidence(agent, reportData)
+	}
+	if err != nil {
+		fmt.Printf("Error fetching evidence: %v\n", err)
+		os.Exit(1)
+	}
+	if err = evidence.Write(outDir); err != nil {
+		fmt.Printf("Error writing evidence: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Evidence from %s written to %s (quote: %s, event log: %s)\n", evidence.Source, outDir, evidence.QuoteFile, evidence.EventLogFile)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runGenVectors implements the gen-vectors command, which writes test vectors for other
+// implementations of the event digests, composite values and RTMR replay.
+func runGenVectors(args []string) {
+	var (
+		outPath   string
+		canonical bool
+	)
+
+	fs := flag.NewFlagSet("gen-vectors", flag.ExitOnError)
+	fs.StringVar(&outPath, "out", "", "Path to write the test vectors to (defaults to stdout)")
+	fs.BoolVar(&canonical, "canonical", false, "Output canonical JSON (RFC 8785) suitable for signing and content addressing")
+	parseFlags(fs, args)
+
+	vectors, err := internal.GenerateTestVectors(knownKeyProviders)
+	if err != nil {
+		fmt.Printf("Error generating test vectors: %v\n", err)
+		os.Exit(1)
+	}
+	jsonData, err := marshalOutput(vectors, canonical)
+	if err != nil {
+		fmt.Printf("Error encoding JSON: %v\n", err)
+		os.Exit(1)
+	}
+
+	if outPath == "" {
+		fmt.Println(string(jsonData))
+		return
+	}
+	if err = os.WriteFile(outPath, append(jsonData, '\n'), 0o644); err != nil {
+		fmt.Printf("Error writing test vectors: %v\n", err)
+		os.Exit(1)
+	}
+}
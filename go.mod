@@ -4,12 +4,14 @@ go 1.22
 
 require (
 	github.com/foxboron/go-uefi v0.0.0-20241017190036-fab4fdf2f2f3
-	golang.org/x/text v0.14.0
+	github.com/lib/pq v1.10.9
+	golang.org/x/crypto v0.16.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/spf13/afero v1.9.3 // indirect
-	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/sys v0.15.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
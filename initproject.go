@@ -0,0 +1,36 @@
+//go:build !minimal
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runInitProject implements the init-project command.
+func runInitProject(args []string) {
+	var dir, module string
+
+	fs := flag.NewFlagSet("init-project", flag.ExitOnError)
+	fs.StringVar(&dir, "dir", "", "Directory to create the project in")
+	fs.StringVar(&module, "module", "", "Go module path of the project (e.g., example.com/verifier)")
+	parseFlags(fs, args)
+
+	if dir == "" || module == "" {
+		fmt.Println("Error: project directory and module path are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	files, err := internal.ScaffoldProject(dir, internal.ScaffoldOptions{Module: module})
+	if err != nil {
+		fmt.Printf("Error creating project: %v\n", err)
+		os.Exit(1)
+	}
+	for _, f := range files {
+		fmt.Printf("Created %s\n", f)
+	}
+}
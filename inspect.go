@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// inspectOutput is the JSON output of the inspect command.
+type inspectOutput struct {
+	Firmware *firmwareInfo `json:"firmware,omitempty"`
+	Kernel   *kernelInfo   `json:"kernel,omitempty"`
+}
+
+// firmwareInfo describes a TDVF firmware.
+type firmwareInfo struct {
+	Size      int                    `json:"size"`
+	Metadata  *internal.TdvfMetadata `json:"metadata"`
+	TdHobBase string                 `json:"td_hob_base,omitempty"`
+	MRTD      string                 `json:"mrtd"`
+}
+
+// kernelInfo describes a kernel image.
+type kernelInfo struct {
+	Size          int    `json:"size"`
+	Format        string `json:"format"`
+	PeMachine     string `json:"pe_machine,omitempty"`
+	BootProtocol  string `json:"boot_protocol,omitempty"`
+	Bootable      bool   `json:"bootable"`
+	BootableError string `json:"bootable_error,omitempty"`
+}
+
+// runInspect implements the inspect command, which describes a firmware and a kernel image as
+// they are loaded and measured, without computing the registers of a TD.
+func runInspect(args []string) {
+	var (
+		fwPath     string
+		kernelPath string
+		tcbver     uint
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	fs.StringVar(&fwPath, "fw", "", "Path to firmware file")
+	fs.StringVar(&kernelPath, "kernel", "", "Path to kernel file")
+	fs.UintVar(&tcbver, "tcbver", 7, "TDX module TCB version the MRTD of the firmware is computed for (6 or 7)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if fwPath == "" && kernelPath == "" {
+		fmt.Println("Error: at least one of -fw and -kernel is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var output inspectOutput
+	if fwPath != "" {
+		fwData, err := os.ReadFile(fwPath)
+		if err != nil {
+			fmt.Printf("Error reading firmware file: %v\n", err)
+			os.Exit(1)
+		}
+		if output.Firmware, err = inspectFirmware(fwData, uint8(tcbver)); err != nil {
+			fmt.Printf("Error inspecting firmware: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if kernelPath != "" {
+		kernelData, err := os.ReadFile(kernelPath)
+		if err != nil {
+			fmt.Printf("Error reading kernel file: %v\n", err)
+			os.Exit(1)
+		}
+		output.Kernel = inspectKernel(kernelData)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	if fw := output.Firmware; fw != nil {
+		fmt.Printf("Firmware: %d bytes\n", fw.Size)
+		fmt.Printf("  MRTD: %s\n", fw.MRTD)
+		if fw.TdHobBase != "" {
+			fmt.Printf("  TD HOB: %s\n", fw.TdHobBase)
+		}
+		fmt.Printf("  TDVF metadata descriptor at 0x%x, %d sections\n", fw.Metadata.DescriptorOffset, len(fw.Metadata.Sections))
+		for i, s := range fw.Metadata.Sections {
+			fmt.Printf("    %d: %-13s GPA 0x%x-0x%x\n", i, s.Type, s.MemoryAddress, s.MemoryEnd())
+		}
+	}
+	if k := output.Kernel; k != nil {
+		fmt.Printf("Kernel: %d bytes\n", k.Size)
+		fmt.Printf("  Format: %s\n", k.Format)
+		if k.PeMachine != "" {
+			fmt.Printf("  PE machine: %s\n", k.PeMachine)
+		}
+		if k.BootProtocol != "" {
+			fmt.Printf("  Boot protocol: %s\n", k.BootProtocol)
+		}
+		if k.Bootable {
+			fmt.Println("  Bootable by TDVF: yes")
+		} else {
+			fmt.Printf("  Bootable by TDVF: no (%s)\n", k.BootableError)
+		}
+	}
+}
+
+// inspectFirmware parses the TDVF metadata of a firmware and computes its MRTD.
+func inspectFirmware(fw []byte, tcbver uint8) (*firmwareInfo, error) {
+	meta, err := internal.ParseTdvfMetadata(fw)
+	if err != nil {
+		return nil, err
+	}
+	mrtd, err := internal.ComputeFirmwareMrtd(fw, tcbver)
+	if err != nil {
+		return nil, err
+	}
+	info := &firmwareInfo{Size: len(fw), Metadata: meta, MRTD: hex.EncodeToString(mrtd)}
+	// Firmware without a TD HOB section is still described.
+	if base, err := internal.TdHobBase(fw); err == nil {
+		info.TdHobBase = fmt.Sprintf("0x%x", base)
+	}
+	return info, nil
+}
+
+// inspectKernel describes the headers of a kernel image.
+func inspectKernel(kd []byte) *kernelInfo {
+	format, machine := internal.DetectKernelFormat(kd)
+	info := &kernelInfo{Size: len(kd), Format: format.String(), Bootable: true}
+	if machine != 0 {
+		info.PeMachine = fmt.Sprintf("0x%04x", machine)
+	}
+	if format == internal.KernelFormatBzImage || format == internal.KernelFormatBzImageNoStub {
+		version := binary.LittleEndian.Uint16(kd[0x206:0x208])
+		info.BootProtocol = fmt.Sprintf("%d.%02d", version>>8, version&0xff)
+	}
+	if err := internal.CheckKernelImage(kd); err != nil {
+		info.Bootable, info.BootableError = false, err.Error()
+	}
+	return info
+}
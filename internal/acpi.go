@@ -2,34 +2,86 @@ package internal
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strings"
 )
 
-func GenerateTablesQemu(templatesPath string, memorySize uint64, cpuCount uint8) ([]byte, []byte, []byte, error) {
-	// Fetch template based on CPU count.
-	fn := fmt.Sprintf("template_qemu_cpu%d.hex", cpuCount)
+// preloadedTemplates holds the contents of templates by path that were read before the sandbox
+// worker lost access to the file system.
+var preloadedTemplates = map[string][]byte{}
 
-	tplHex, err := os.ReadFile(filepath.Join(templatesPath, fn))
+// ReadTemplate returns the hex encoded ACPI table template in templatesPath for the CPU count and
+// memory hotplug configuration.
+func ReadTemplate(templatesPath string, cpuCount uint32, hotplug *MemoryHotplug) ([]byte, error) {
+	path := filepath.Join(templatesPath, templateFileName(cpuCount, hotplug))
+	if tplHex, ok := preloadedTemplates[path]; ok {
+		return tplHex, nil
+	}
+	tplHex, err := os.ReadFile(path)
 	if err != nil {
-		return nil, nil, nil, fmt.Errorf("template for ACPI tables is not available: %w", err)
+		return nil, fmt.Errorf("%w: %w (templates cover %s CPUs)", ErrTemplateNotFound, err, templateCoverage(templatesPath, hotplug))
 	}
+	return tplHex, nil
+}
 
+// GenerateTablesQemu generates the ACPI tables, the RSDP and the table loader QEMU provides to a TD
+// guest from the template for the CPU count.
+func GenerateTablesQemu(templatesPath string, memorySize uint64, cpuCount uint32, profile *Profile) ([]byte, []byte, []byte, error) {
+	return GenerateTablesQemuContext(context.Background(), nil, templatesPath, memorySize, cpuCount, profile)
+}
+
+// GenerateTablesQemuContext generates the ACPI tables like GenerateTablesQemu, returning the error
+// of the context if it is canceled before the template is read or processed. The generated RSDP is
+// logged at debug level to the logger, which may be nil.
+func GenerateTablesQemuContext(ctx context.Context, logger *slog.Logger, templatesPath string, memorySize uint64, cpuCount uint32, profile *Profile) ([]byte, []byte, []byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
+	if cpuCount > maxLocalApicCpus {
+		return nil, nil, nil, fmt.Errorf("%d CPUs are not supported, guests with more than %d CPUs need Local x2APIC MADT entries, which are not generated", cpuCount, maxLocalApicCpus)
+	}
+	// Fetch template based on CPU count.
+	tplHex, err := ReadTemplate(templatesPath, cpuCount, profile.MemoryHotplug)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, nil, err
+	}
 	tpl, err := hex.DecodeString(strings.ReplaceAll(string(tplHex), "\n", ""))
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("malformed ACPI table template %s", err)
 	}
 
+	// Revision 2 RSDPs point to an XSDT with 64-bit entries instead of an RSDT.
+	var (
+		rsdpRevision uint8
+		rootSig      = "RSDT"
+		entrySize    = uint32(4)
+	)
+	switch profile.RsdpRevision {
+	case 0:
+	case 2:
+		rsdpRevision = 2
+		rootSig = "XSDT"
+		entrySize = 8
+	default:
+		return nil, nil, nil, fmt.Errorf("unsupported RSDP revision %d", profile.RsdpRevision)
+	}
+
 	// Generate RSDP.
 	rsdp := append([]byte{},
 		0x52, 0x53, 0x44, 0x20, 0x50, 0x54, 0x52, 0x20, // Signature ("RSDP PTR ").
 		0x00,                               // Checksum.
 		0x42, 0x4F, 0x43, 0x48, 0x53, 0x20, // OEM ID ("BOCHS ").
-		0x00, // Revision.
+		rsdpRevision, // Revision.
 	)
 
 	// Find all required ACPI tables.
@@ -53,16 +105,26 @@ func GenerateTablesQemu(templatesPath string, memorySize uint64, cpuCount uint8)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	rsdtOffset, rsdtCsum, rsdtLen, err := findAcpiTable(tpl, "RSDT")
+	rootOffset, rootCsum, rootLen, err := findAcpiTable(tpl, rootSig)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	// Update RSDP with RSDT address.
-	var rsdtAddress [4]byte
-	binary.LittleEndian.PutUint32(rsdtAddress[:], rsdtOffset)
-	rsdp = append(rsdp, rsdtAddress[:]...)
-	fmt.Printf("RSDP: %s\n", rsdp)
+	// Update RSDP with RSDT/XSDT address.
+	if rsdpRevision == 0 {
+		var rsdtAddress [4]byte
+		binary.LittleEndian.PutUint32(rsdtAddress[:], rootOffset)
+		rsdp = append(rsdp, rsdtAddress[:]...)
+	} else {
+		var val [8]byte
+		rsdp = append(rsdp, val[:4]...) // RsdtAddress (not present).
+		binary.LittleEndian.PutUint32(val[:4], 36)
+		rsdp = append(rsdp, val[:4]...) // Length.
+		binary.LittleEndian.PutUint64(val[:], uint64(rootOffset))
+		rsdp = append(rsdp, val[:]...)              // XsdtAddress.
+		rsdp = append(rsdp, 0x00, 0x00, 0x00, 0x00) // Extended checksum and reserved.
+	}
+	loggerOrDiscard(logger).Debug("generated ACPI RSDP", "template", templateFileName(cpuCount, profile.MemoryHotplug), "rsdp", hex.EncodeToString(rsdp))
 
 	// Generate table loader commands.
 	const ldrLength = 4096
@@ -76,13 +138,19 @@ func GenerateTablesQemu(templatesPath string, memorySize uint64, cpuCount uint8)
 	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", apicCsum, apicOffset, apicLen}) // APIC
 	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", mcfgCsum, mcfgOffset, mcfgLen}) // MCFG
 	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", waetCsum, waetOffset, waetLen}) // WAET
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/tables", "etc/acpi/tables", rsdtOffset + 36, 4})
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/tables", "etc/acpi/tables", rsdtOffset + 40, 4})
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/tables", "etc/acpi/tables", rsdtOffset + 44, 4})
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/tables", "etc/acpi/tables", rsdtOffset + 48, 4})
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", rsdtCsum, rsdtOffset, rsdtLen}) // RSDT
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/rsdp", "etc/acpi/tables", 16, 4})             // RSDT address
-	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/rsdp", 8, 0, 20})                        // RSDP
+	for i := range uint32(4) {
+		// RSDT/XSDT entries pointing to FACP, APIC, MCFG and WAET.
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/tables", "etc/acpi/tables", rootOffset + 36 + i*entrySize, uint8(entrySize)})
+	}
+	ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/tables", rootCsum, rootOffset, rootLen}) // RSDT/XSDT
+	if rsdpRevision == 0 {
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/rsdp", "etc/acpi/tables", 16, 4}) // RSDT address
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/rsdp", 8, 0, 20})            // RSDP
+	} else {
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddPtr{"etc/acpi/rsdp", "etc/acpi/tables", 24, 8}) // XSDT address
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/rsdp", 8, 0, 20})            // RSDP
+		ldr = qemuLoaderAppend(ldr, &qemuLoaderCmdAddChecksum{"etc/acpi/rsdp", 32, 0, 36})           // RSDP (extended)
+	}
 	if len(ldr) < ldrLength {
 		ldr = append(ldr, bytes.Repeat([]byte{0x00}, ldrLength-len(ldr))...)
 	}
@@ -90,6 +158,11 @@ func GenerateTablesQemu(templatesPath string, memorySize uint64, cpuCount uint8)
 	return tpl, rsdp, ldr, nil
 }
 
+// maxLocalApicCpus is the number of CPUs that can be described by Local APIC MADT entries, as
+// APIC ID 255 is the broadcast ID. QEMU describes further CPUs by Local x2APIC entries, which are
+// not generated.
+const maxLocalApicCpus = 255
+
 // findAcpiTable searches for the ACPI table with the given signature and returns its offset,
 // checksum offset and length.
 func findAcpiTable(tables []byte, signature string) (uint32, uint32, uint32, error) {
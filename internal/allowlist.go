@@ -0,0 +1,151 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// AllowlistSource provides the measurement sets that are currently allowed, e.g. by a dstack KMS or
+// its on-chain governance contract.
+type AllowlistSource interface {
+	// Check reports whether the TD report is allowed and describes the matching entry.
+	Check(report *TdReport) (bool, string, error)
+	// String describes the source.
+	String() string
+}
+
+// ParseAllowlistSource parses an allowlist source specification, either kms:<url> for a KMS
+// endpoint serving allowed measurement sets or chain:<contract> for a dstack KmsAuth contract which
+// is queried through the Ethereum JSON-RPC endpoint at rpcURL.
+func ParseAllowlistSource(spec, rpcURL string) (AllowlistSource, error) {
+	kind, value, ok := strings.Cut(spec, ":")
+	if !ok || value == "" {
+		return nil, fmt.Errorf("malformed allowlist source '%s', expected kms:<url> or chain:<contract>", spec)
+	}
+	switch kind {
+	case "kms":
+		return &kmsAllowlist{url: value}, nil
+	case "chain":
+		contract, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || len(contract) != 20 {
+			return nil, fmt.Errorf("invalid contract address '%s'", value)
+		}
+		if rpcURL == "" {
+			return nil, fmt.Errorf("an RPC URL is required for on-chain allowlists")
+		}
+		return &chainAllowlist{rpcURL: rpcURL, contract: "0x" + hex.EncodeToString(contract)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported allowlist source type '%s'", kind)
+	}
+}
+
+// kmsAllowlist fetches allowed measurement sets from a KMS endpoint. The endpoint serves a JSON
+// document of the form {"measurements": [{"name": ..., "mrtd": ..., "rtmr0": ..., ...}]}; an entry
+// matches when all of its values match.
+type kmsAllowlist struct {
+	url string
+}
+
+func (s *kmsAllowlist) String() string {
+	return "kms:" + s.url
+}
+
+func (s *kmsAllowlist) Check(report *TdReport) (bool, string, error) {
+	data, err := httpGet(s.url)
+	if err != nil {
+		return false, "", fmt.Errorf("failed to fetch allowlist: %w", err)
+	}
+	var doc struct {
+		Measurements []map[string]string `json:"measurements"`
+	}
+	if err = json.Unmarshal(data, &doc); err != nil {
+		return false, "", fmt.Errorf("failed to parse allowlist: %w", err)
+	}
+
+	values := reportValues(report)
+	for i, entry := range doc.Measurements {
+		checked, matched := 0, true
+		for key, expected := range entry {
+			actual, ok := values[key]
+			if !ok {
+				continue
+			}
+			checked++
+			if !strings.EqualFold(strings.TrimPrefix(expected, "0x"), hex.EncodeToString(actual)) {
+				matched = false
+			}
+		}
+		// Entries without any known value must not allow everything.
+		if checked > 0 && matched {
+			name := entry["name"]
+			if name == "" {
+				name = fmt.Sprintf("entry %d", i)
+			}
+			return true, name, nil
+		}
+	}
+	return false, "", nil
+}
+
+// chainAllowlist queries the allowedOsImages mapping of a dstack KmsAuth contract for the image
+// hash of the TD report.
+type chainAllowlist struct {
+	rpcURL   string
+	contract string
+}
+
+func (s *chainAllowlist) String() string {
+	return "chain:" + s.contract
+}
+
+func (s *chainAllowlist) Check(report *TdReport) (bool, string, error) {
+	mrImage := reportValues(report)["mr_image"]
+
+	h := sha3.NewLegacyKeccak256()
+	h.Write([]byte("allowedOsImages(bytes32)"))
+	callData := append(h.Sum(nil)[:4], mrImage...)
+
+	req, err := json.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "eth_call",
+		"params":  []any{map[string]string{"to": s.contract, "data": "0x" + hex.EncodeToString(callData)}, "latest"},
+	})
+	if err != nil {
+		return false, "", err
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(s.rpcURL, "application/json", bytes.NewReader(req))
+	if err != nil {
+		return false, "", fmt.Errorf("failed to query contract: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var rpcResp struct {
+		Result string `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&rpcResp); err != nil {
+		return false, "", fmt.Errorf("failed to decode RPC response: %w", err)
+	}
+	if rpcResp.Error != nil {
+		return false, "", fmt.Errorf("contract call failed: %s", rpcResp.Error.Message)
+	}
+	result, err := hex.DecodeString(strings.TrimPrefix(rpcResp.Result, "0x"))
+	if err != nil || len(result) != 32 {
+		return false, "", fmt.Errorf("unexpected contract call result '%s'", rpcResp.Result)
+	}
+	allowed := result[31] == 1
+	return allowed, fmt.Sprintf("os image %x", mrImage), nil
+}
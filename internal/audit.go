@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry records a single measurement or verification request served by the service.
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// Client is the network address the request came from.
+	Client string `json:"client"`
+	// Identity is the subject of the TLS client certificate, if one was presented.
+	Identity string `json:"identity,omitempty"`
+	// Operation is the requested operation, e.g. "measure" or "verify".
+	Operation string `json:"operation"`
+	// Inputs holds the SHA256 digests of the submitted artifacts by name.
+	Inputs map[string]string `json:"inputs"`
+	// Parameters holds the measurement parameters of the request.
+	Parameters map[string]string `json:"parameters,omitempty"`
+	// Registers holds the register values issued in the response.
+	Registers map[string]string `json:"registers,omitempty"`
+	// Match is the verification result, set for verify requests.
+	Match *bool  `json:"match,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// AuditLog appends audit entries to a JSONL file, rotating it once it exceeds a maximum size.
+// Rotated files are renamed to <path>.1 (the most recent) up to <path>.<maxFiles>.
+type AuditLog struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenAuditLog opens the audit log at path for appending. With a maxSize of zero the log is never
+// rotated.
+func OpenAuditLog(path string, maxSize int64, maxFiles int) (*AuditLog, error) {
+	if maxFiles < 1 {
+		return nil, fmt.Errorf("at least one rotated audit log file must be kept")
+	}
+	l := &AuditLog{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *AuditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	l.file, l.size = f, fi.Size()
+	return nil
+}
+
+// Record appends an entry to the audit log. The entry is synced to disk before Record returns, so
+// no issued reference value goes unrecorded.
+func (l *AuditLog) Record(entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(data)) > l.maxSize {
+		if err = l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.file.Write(data)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write audit log: %w", err)
+	}
+	if err = l.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a new log file.
+func (l *AuditLog) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit log: %w", err)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.maxFiles))
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return fmt.Errorf("failed to rotate audit log: %w", err)
+	}
+	return l.open()
+}
+
+// Close closes the audit log.
+func (l *AuditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
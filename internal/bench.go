@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BenchmarkResult is the result of a single benchmark.
+type BenchmarkResult struct {
+	Name        string  `json:"name"`
+	Iterations  int     `json:"iterations"`
+	NsPerOp     int64   `json:"ns_per_op"`
+	AllocsPerOp int64   `json:"allocs_per_op"`
+	BytesPerOp  int64   `json:"alloc_bytes_per_op"`
+	MBPerSec    float64 `json:"mb_per_sec,omitempty"`
+}
+
+// benchFirmwareSize is the size of the synthetic firmware of the MRTD benchmarks.
+const benchFirmwareSize = 100 * 1024 * 1024
+
+// benchFirmware returns the synthetic firmware of the MRTD benchmarks and its metadata. It is only
+// allocated once an MRTD benchmark runs.
+var benchFirmware = sync.OnceValues(func() ([]byte, *tdvfMetadata) {
+	fw := make([]byte, benchFirmwareSize)
+	for i := range fw {
+		fw[i] = byte(i)
+	}
+	return fw, &tdvfMetadata{sections: []*tdvfSection{{
+		dataOffset:     0,
+		rawDataSize:    benchFirmwareSize,
+		memoryAddress:  0xFA000000,
+		memoryDataSize: benchFirmwareSize,
+		attributes:     attributeMrExtend,
+	}}}
+})
+
+// benchKernel returns the synthetic kernel of the kernel benchmark.
+var benchKernel = sync.OnceValue(func() []byte { return syntheticKernel(16 * 1024 * 1024) })
+
+// benchmark is a named benchmark over synthetic inputs. run performs n operations; dir is a
+// directory holding the ACPI table template of the synthetic guest.
+type benchmark struct {
+	name string
+	// bytes is the size of the input processed by an operation, zero if throughput is not
+	// meaningful.
+	bytes int64
+	run   func(dir string, n int) error
+}
+
+// benchmarks are the benchmarks run by RunBenchmarks and the Go benchmarks of the package.
+var benchmarks = []benchmark{
+	{"mrtd-100mb", benchFirmwareSize, func(_ string, n int) error {
+		fw, meta := benchFirmware()
+		for range n {
+			if _, err := meta.computeMrtd(context.Background(), fw, mrtdVariantTwoPass, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"kernel-16mb", 16 * 1024 * 1024, func(_ string, n int) error {
+		kernel := benchKernel()
+		for range n {
+			if _, err := MeasureTdxQemuKernelImageData(kernel, 1024*1024, 2048, 0x28000, KernelPatchQemu); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"td-hob", 0, func(_ string, n int) error {
+		_, meta := benchFirmware()
+		for range n {
+			measureTdxQemuTdHob(2048, meta, profiles[DefaultProfile])
+		}
+		return nil
+	}},
+	{"acpi-tables", 0, func(dir string, n int) error {
+		for range n {
+			if _, _, _, err := measureTdxQemuAcpiTables(dir, 2048, 1, profiles[DefaultProfile]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"rtmr-log", 0, func(_ string, n int) error {
+		log := make([][]byte, 16)
+		for i := range log {
+			log[i] = measureSha384([]byte{byte(i)})
+		}
+		for range n {
+			measureLog(discardLogger, 0, log)
+		}
+		return nil
+	}},
+	{"rtmr-replay", 0, func(_ string, n int) error {
+		history := []string{
+			hex.EncodeToString(measureSha256([]byte("compose"))),
+			hex.EncodeToString(measureSha256([]byte("rootfs"))),
+		}
+		for range n {
+			if _, err := replayRTMR(history); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{"efi-variable", 0, func(_ string, n int) error {
+		for range n {
+			measureTdxEfiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "SecureBoot")
+		}
+		return nil
+	}},
+	{"kernel-cmdline", 0, func(_ string, n int) error {
+		for range n {
+			if _, err := MeasureKernelCmdline("console=ttyS0 root=/dev/vda1 ro", EncodingOvmf); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+}
+
+// writeBenchTemplates writes the ACPI table template of the synthetic guest into dir.
+func writeBenchTemplates(dir string) error {
+	return os.WriteFile(filepath.Join(dir, templateFileName(1, nil)), []byte(hex.EncodeToString(syntheticAcpiTables("RSDT"))), 0o644)
+}
+
+// benchTime is how long RunBenchmarks runs each benchmark for at least.
+const benchTime = time.Second
+
+// RunBenchmarks runs the measurement benchmark suite on synthetic inputs and returns the results.
+// When filter is non-empty only benchmarks with the given names are run. The same benchmarks run
+// with go test -bench in the internal package.
+func RunBenchmarks(filter []string) ([]BenchmarkResult, error) {
+	tmpDir, err := os.MkdirTemp("", "reproduce-mr-bench")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err = writeBenchTemplates(tmpDir); err != nil {
+		return nil, err
+	}
+
+	var results []BenchmarkResult
+	for _, bm := range benchmarks {
+		if len(filter) > 0 && !slices.Contains(filter, bm.name) {
+			continue
+		}
+		result, err := runBenchmark(bm, tmpDir)
+		if err != nil {
+			return nil, fmt.Errorf("benchmark %s failed: %w", bm.name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// runBenchmark runs a benchmark with a growing number of operations until it takes benchTime,
+// after a first operation that creates the inputs and warms up caches.
+func runBenchmark(bm benchmark, dir string) (BenchmarkResult, error) {
+	if err := bm.run(dir, 1); err != nil {
+		return BenchmarkResult{}, err
+	}
+	for n := 1; ; {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		start := time.Now()
+		if err := bm.run(dir, n); err != nil {
+			return BenchmarkResult{}, err
+		}
+		elapsed := time.Since(start)
+		runtime.ReadMemStats(&after)
+
+		if elapsed >= benchTime || n >= 1e9 {
+			result := BenchmarkResult{
+				Name:        bm.name,
+				Iterations:  n,
+				NsPerOp:     elapsed.Nanoseconds() / int64(n),
+				AllocsPerOp: int64(after.Mallocs-before.Mallocs) / int64(n),
+				BytesPerOp:  int64(after.TotalAlloc-before.TotalAlloc) / int64(n),
+			}
+			if bm.bytes > 0 {
+				result.MBPerSec = float64(bm.bytes) * float64(n) / 1e6 / elapsed.Seconds()
+			}
+			return result, nil
+		}
+		// Aim for benchTime with some headroom, growing by at most 100x per round as the testing
+		// package does.
+		next := int(float64(n) * 1.2 * float64(benchTime) / float64(max(elapsed, time.Microsecond)))
+		n = min(max(next, n+1), 100*n, 1e9)
+	}
+}
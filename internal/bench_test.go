@@ -0,0 +1,53 @@
+package internal
+
+import "testing"
+
+// runGoBenchmark runs the named benchmark of the suite under the testing package.
+func runGoBenchmark(b *testing.B, name string) {
+	dir := b.TempDir()
+	if err := writeBenchTemplates(dir); err != nil {
+		b.Fatal(err)
+	}
+	for _, bm := range benchmarks {
+		if bm.name != name {
+			continue
+		}
+		// The first operation creates the inputs, which are not part of the measured time.
+		if err := bm.run(dir, 1); err != nil {
+			b.Fatal(err)
+		}
+		b.SetBytes(bm.bytes)
+		b.ReportAllocs()
+		b.ResetTimer()
+		if err := bm.run(dir, b.N); err != nil {
+			b.Fatal(err)
+		}
+		return
+	}
+	b.Fatalf("unknown benchmark %s", name)
+}
+
+func BenchmarkMrtd100MB(b *testing.B)     { runGoBenchmark(b, "mrtd-100mb") }
+func BenchmarkKernel16MB(b *testing.B)    { runGoBenchmark(b, "kernel-16mb") }
+func BenchmarkTdHob(b *testing.B)         { runGoBenchmark(b, "td-hob") }
+func BenchmarkAcpiTables(b *testing.B)    { runGoBenchmark(b, "acpi-tables") }
+func BenchmarkRtmrLog(b *testing.B)       { runGoBenchmark(b, "rtmr-log") }
+func BenchmarkRtmrReplay(b *testing.B)    { runGoBenchmark(b, "rtmr-replay") }
+func BenchmarkEfiVariable(b *testing.B)   { runGoBenchmark(b, "efi-variable") }
+func BenchmarkKernelCmdline(b *testing.B) { runGoBenchmark(b, "kernel-cmdline") }
+
+// TestBenchmarks runs every benchmark once, so that a broken benchmark fails go test.
+func TestBenchmarks(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeBenchTemplates(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, bm := range benchmarks {
+		if testing.Short() && bm.bytes > 0 {
+			continue
+		}
+		if err := bm.run(dir, 1); err != nil {
+			t.Errorf("benchmark %s: %v", bm.name, err)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// minCanonicalHexLength is the minimum length of strings of hex digits that are lowercased in
+// canonical JSON, which covers digests and register values but not short identifiers.
+const minCanonicalHexLength = 16
+
+// MarshalCanonical returns the canonical JSON encoding of v, following the JSON Canonicalization
+// Scheme (RFC 8785): no whitespace, object keys sorted by their UTF-16 code units, minimal string
+// escaping and numbers in their shortest form. Additionally, hex-encoded values (optionally with a
+// 0x prefix) are lowercased, so the encoding of a report does not depend on how its values were
+// entered. The result is stable across Go versions and suitable for signing and content addressing.
+func MarshalCanonical(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err = dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	if err = writeCanonical(&b, doc); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeCanonical(b *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case json.Number:
+		n, err := canonicalNumber(v)
+		if err != nil {
+			return err
+		}
+		b.WriteString(n)
+	case string:
+		writeCanonicalString(b, canonicalHex(v))
+	case []any:
+		b.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeCanonical(b, e); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool { return lessUTF16(keys[i], keys[j]) })
+		b.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			writeCanonicalString(b, k)
+			b.WriteByte(':')
+			if err := writeCanonical(b, v[k]); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value of type %T", v)
+	}
+	return nil
+}
+
+// canonicalNumber formats a number in its shortest form. Integers are kept exactly, as the Go
+// encoder already writes them in their shortest form and 64-bit values must not lose precision.
+func canonicalNumber(n json.Number) (string, error) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		return s, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return "", err
+	}
+	if f == 0 {
+		return "0", nil
+	}
+	if abs := max(f, -f); abs >= 1e-6 && abs < 1e21 {
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	// Exponents are written without leading zeros, e.g. 1e-7 and 1e+21.
+	mantissa, exp, _ := strings.Cut(strconv.FormatFloat(f, 'e', -1, 64), "e")
+	sign, digits := exp[:1], strings.TrimLeft(exp[1:], "0")
+	return mantissa + "e" + sign + digits, nil
+}
+
+// canonicalHex lowercases hex-encoded values of at least minCanonicalHexLength digits.
+func canonicalHex(s string) string {
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(digits) < minCanonicalHexLength || len(digits)%2 != 0 {
+		return s
+	}
+	for i := 0; i < len(digits); i++ {
+		if _, ok := fromHexChar(digits[i]); !ok {
+			return s
+		}
+	}
+	return strings.ToLower(s)
+}
+
+// writeCanonicalString writes a JSON string, escaping only quotes, backslashes and control
+// characters.
+func writeCanonicalString(b *bytes.Buffer, s string) {
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// lessUTF16 compares strings by their UTF-16 code units as required for sorting object keys.
+func lessUTF16(a, b string) bool {
+	ua, ub := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(ua) && i < len(ub); i++ {
+		if ua[i] != ub[i] {
+			return ua[i] < ub[i]
+		}
+	}
+	return len(ua) < len(ub)
+}
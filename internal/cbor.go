@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// CBOR major types (RFC 8949).
+const (
+	cborUint  = 0
+	cborBytes = 2
+	cborText  = 3
+	cborArray = 4
+	cborMap   = 5
+	cborTag   = 6
+)
+
+// maxCborDepth limits the nesting of decoded CBOR items.
+const maxCborDepth = 16
+
+// appendCborHead appends the head of a CBOR item of the given major type and argument.
+func appendCborHead(b []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(b, major|byte(n))
+	case n <= math.MaxUint8:
+		return append(b, major|24, byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, major|25), uint16(n))
+	case n <= math.MaxUint32:
+		return binary.BigEndian.AppendUint32(append(b, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, major|27), n)
+	}
+}
+
+// appendCborBytes appends a CBOR byte string.
+func appendCborBytes(b, data []byte) []byte {
+	return append(appendCborHead(b, cborBytes, uint64(len(data))), data...)
+}
+
+// cborDecoder decodes the subset of CBOR used by event logs: unsigned integers, byte and text
+// strings, arrays and maps of definite length, tags (which are skipped) and simple values.
+type cborDecoder struct {
+	data []byte
+	off  int
+}
+
+// decodeCbor decodes a single CBOR item that must span all of data. Unsigned integers are returned
+// as uint64, byte strings as []byte, text strings as string, arrays as []any and maps as
+// map[any]any with uint64 or string keys.
+func decodeCbor(data []byte) (any, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.item(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.off != len(data) {
+		return nil, fmt.Errorf("trailing data after CBOR item at offset %d", d.off)
+	}
+	return v, nil
+}
+
+// head reads the head of an item and returns its major type and argument.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.off >= len(d.data) {
+		return 0, 0, fmt.Errorf("CBOR data is truncated")
+	}
+	major, info := d.data[d.off]>>5, d.data[d.off]&0x1f
+	d.off++
+	if info < 24 {
+		return major, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, fmt.Errorf("unsupported CBOR item 0x%02x at offset %d", d.data[d.off-1], d.off-1)
+	}
+	size := 1 << (info - 24)
+	if d.off+size > len(d.data) {
+		return 0, 0, fmt.Errorf("CBOR data is truncated")
+	}
+	var n uint64
+	for _, c := range d.data[d.off : d.off+size] {
+		n = n<<8 | uint64(c)
+	}
+	d.off += size
+	return major, n, nil
+}
+
+// item decodes the next item at the given nesting depth.
+func (d *cborDecoder) item(depth int) (any, error) {
+	if depth > maxCborDepth {
+		return nil, fmt.Errorf("CBOR items are nested too deeply")
+	}
+	start := d.off
+	major, n, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	// Every element takes at least one byte, which bounds the lengths to the remaining data.
+	if major >= cborBytes && major <= cborMap && n > uint64(len(d.data)-d.off) {
+		return nil, fmt.Errorf("CBOR data is truncated")
+	}
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborBytes:
+		v := d.data[d.off : d.off+int(n)]
+		d.off += int(n)
+		return v, nil
+	case cborText:
+		v := string(d.data[d.off : d.off+int(n)])
+		d.off += int(n)
+		return v, nil
+	case cborArray:
+		v := make([]any, 0, n)
+		for range n {
+			e, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, e)
+		}
+		return v, nil
+	case cborMap:
+		v := make(map[any]any, n)
+		for range n {
+			k, err := d.item(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch k.(type) {
+			case uint64, string:
+			default:
+				return nil, fmt.Errorf("unsupported CBOR map key of type %T", k)
+			}
+			if v[k], err = d.item(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case cborTag:
+		return d.item(depth + 1)
+	case 7:
+		switch n {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported CBOR item 0x%02x at offset %d", d.data[start], start)
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ccelTableSize is the size of the CCEL ACPI table of the UEFI specification, a 36 byte ACPI
+// header followed by the CC type and subtype, two reserved bytes, the log area minimum length
+// (LAML) and the log area start address (LASA).
+const ccelTableSize = 56
+
+// ccTypeTdx is the CC type of the CCEL table of a TDX guest.
+const ccTypeTdx = 2
+
+// CcelTable is the CCEL ACPI table, which tells the guest where the firmware recorded the event
+// log of the TD. Linux exposes it at /sys/firmware/acpi/tables/CCEL and the log area it
+// describes at /sys/firmware/acpi/tables/data/CCEL.
+type CcelTable struct {
+	CcType    uint8
+	CcSubtype uint8
+	// LogAreaLength is the size of the log area the firmware reserved for the event log.
+	LogAreaLength uint64
+	// LogAreaStart is the guest physical address of the log area.
+	LogAreaStart uint64
+}
+
+// ParseCcelTable parses a CCEL ACPI table and checks that it belongs to a TDX guest.
+func ParseCcelTable(data []byte) (*CcelTable, error) {
+	if len(data) < ccelTableSize || string(data[:4]) != "CCEL" {
+		return nil, fmt.Errorf("not a CCEL ACPI table")
+	}
+	length := binary.LittleEndian.Uint32(data[4:8])
+	if length < ccelTableSize || int64(length) > int64(len(data)) {
+		return nil, fmt.Errorf("CCEL ACPI table has an invalid length %d", length)
+	}
+	var sum byte
+	for _, b := range data[:length] {
+		sum += b
+	}
+	if sum != 0 {
+		return nil, fmt.Errorf("CCEL ACPI table has an invalid checksum")
+	}
+	t := &CcelTable{
+		CcType:        data[36],
+		CcSubtype:     data[37],
+		LogAreaLength: binary.LittleEndian.Uint64(data[40:48]),
+		LogAreaStart:  binary.LittleEndian.Uint64(data[48:56]),
+	}
+	if t.CcType != ccTypeTdx {
+		return nil, fmt.Errorf("CCEL ACPI table is for CC type %d, not TDX", t.CcType)
+	}
+	return t, nil
+}
+
+// BoundEventLog checks a CCEL event log against the log area of the table and returns the log cut
+// to the log area. The firmware fills the unused part of the log area with 0xff, so a log area
+// without any is full and the firmware dropped the events that did not fit.
+func (t *CcelTable) BoundEventLog(data []byte) ([]byte, error) {
+	if uint64(len(data)) < t.LogAreaLength {
+		return nil, fmt.Errorf("%w: the log holds %d bytes of the %d byte CCEL log area, it was cut off when it was copied", ErrEventLogTruncated, len(data), t.LogAreaLength)
+	}
+	data = data[:t.LogAreaLength]
+	if len(data) > 0 && data[len(data)-1] != 0xff {
+		return nil, fmt.Errorf("%w: the %d byte CCEL log area is full, the firmware dropped the events that did not fit", ErrEventLogTruncated, t.LogAreaLength)
+	}
+	return data, nil
+}
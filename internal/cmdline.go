@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// Encoding is the encoding of the kernel command line in the measured event.
+type Encoding string
+
+const (
+	// EncodingOvmf widens every byte of the command line to a UCS-2 character, which is how OVMF
+	// converts the command line it receives from QEMU before measuring it: X86QemuLoadImageLib
+	// formats the fw_cfg command line into the LoadOptions with UnicodeSPrintAsciiFormat("%a"),
+	// which widens CHAR8 to CHAR16 byte by byte. Non-ASCII characters are therefore measured as
+	// their individual UTF-8 bytes.
+	EncodingOvmf Encoding = "ovmf"
+	// EncodingUTF16 encodes the command line as UTF-16LE, as done by loaders that receive a
+	// Unicode command line (e.g. from boot entries).
+	EncodingUTF16 Encoding = "utf-16le"
+)
+
+// MeasureKernelCmdline returns the digest of the kernel command line event extended into RTMR2.
+//
+// QEMU passes the command line to the firmware through fw_cfg as a NUL-terminated string without
+// truncating it, so the measurement always covers the full command line. Kernels only see the
+// first cmdline_size bytes announced by their boot protocol; such command lines are rejected when
+// measuring a kernel image since the measured and effective command lines would differ.
+func MeasureKernelCmdline(cmdline string, encoding Encoding) ([]byte, error) {
+	data, err := encodeKernelCmdline(cmdline, encoding)
+	if err != nil {
+		return nil, err
+	}
+	return measureSha384(data), nil
+}
+
+// encodeKernelCmdline encodes the kernel command line as it is measured, including the
+// terminating NUL character.
+func encodeKernelCmdline(cmdline string, encoding Encoding) ([]byte, error) {
+	if strings.IndexByte(cmdline, 0) >= 0 {
+		// QEMU handles the command line as a C string and would cut it at the first NUL.
+		return nil, fmt.Errorf("kernel command line must not contain NUL characters")
+	}
+	dst := make([]byte, 0, 2*len(cmdline)+2)
+	switch encoding {
+	case EncodingOvmf, "":
+		for i := 0; i < len(cmdline); i++ {
+			dst = append(dst, cmdline[i], 0x00)
+		}
+	case EncodingUTF16:
+		dst = appendUTF16LE(dst, cmdline)
+	default:
+		return nil, fmt.Errorf("unknown kernel command line encoding '%s'", encoding)
+	}
+	return append(dst, 0x00, 0x00), nil
+}
+
+// appendUTF16LE appends the UTF-16LE encoding of s to dst. Invalid UTF-8 sequences are encoded as
+// the Unicode replacement character.
+func appendUTF16LE(dst []byte, s string) []byte {
+	for _, r := range s {
+		if r >= 0x10000 {
+			r1, r2 := utf16.EncodeRune(r)
+			dst = append(dst, byte(r1), byte(r1>>8), byte(r2), byte(r2>>8))
+			continue
+		}
+		dst = append(dst, byte(r), byte(r>>8))
+	}
+	return dst
+}
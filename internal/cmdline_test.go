@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// widen returns the command line with every byte widened to 16 bits and the terminating NUL, as
+// OVMF measures it.
+func widen(s string) []byte {
+	var b []byte
+	for i := 0; i < len(s); i++ {
+		b = append(b, s[i], 0)
+	}
+	return append(b, 0, 0)
+}
+
+func TestEncodeKernelCmdline(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmdline  string
+		encoding Encoding
+		want     []byte
+		wantErr  bool
+	}{
+		{name: "empty", cmdline: "", encoding: EncodingOvmf, want: []byte{0, 0}},
+		{name: "ascii", cmdline: "ro", encoding: EncodingOvmf, want: []byte{'r', 0, 'o', 0, 0, 0}},
+		{name: "default encoding", cmdline: "ro", encoding: "", want: []byte{'r', 0, 'o', 0, 0, 0}},
+		{name: "ascii utf-16le", cmdline: "ro", encoding: EncodingUTF16, want: []byte{'r', 0, 'o', 0, 0, 0}},
+		// OVMF widens the UTF-8 bytes of é (c3 a9) individually.
+		{name: "non-ascii ovmf", cmdline: "é", encoding: EncodingOvmf, want: []byte{0xc3, 0, 0xa9, 0, 0, 0}},
+		{name: "non-ascii utf-16le", cmdline: "é", encoding: EncodingUTF16, want: []byte{0xe9, 0, 0, 0}},
+		{name: "supplementary utf-16le", cmdline: "😀", encoding: EncodingUTF16, want: []byte{0x3d, 0xd8, 0x00, 0xde, 0, 0}},
+		{name: "supplementary ovmf", cmdline: "😀", encoding: EncodingOvmf, want: []byte{0xf0, 0, 0x9f, 0, 0x98, 0, 0x80, 0, 0, 0}},
+		{name: "invalid utf-8 ovmf", cmdline: "\xff", encoding: EncodingOvmf, want: []byte{0xff, 0, 0, 0}},
+		{name: "invalid utf-8 utf-16le", cmdline: "\xff", encoding: EncodingUTF16, want: []byte{0xfd, 0xff, 0, 0}},
+		{name: "nul", cmdline: "a\x00b", encoding: EncodingOvmf, wantErr: true},
+		{name: "unknown encoding", cmdline: "ro", encoding: "latin1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeKernelCmdline(tt.cmdline, tt.encoding)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("encodeKernelCmdline() = %x, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("encodeKernelCmdline() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("encodeKernelCmdline() = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeKernelCmdlineLong(t *testing.T) {
+	// QEMU passes the command line through fw_cfg without truncating it, so every length is
+	// measured in full.
+	for _, n := range []int{255, 256, 2047, 2048, 4096} {
+		cmdline := strings.Repeat("a", n)
+		got, err := encodeKernelCmdline(cmdline, EncodingOvmf)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(got, widen(cmdline)) {
+			t.Errorf("%d bytes: encoded to %d bytes, want %d", n, len(got), 2*n+2)
+		}
+	}
+}
+
+func TestMeasureKernelCmdline(t *testing.T) {
+	// The expected digests are SHA384 over the encodings spelled out byte by byte.
+	tests := []struct {
+		cmdline  string
+		encoding Encoding
+		want     string
+	}{
+		{"", EncodingOvmf, "1dd6f7b457ad880d840d41c961283bab688e94e4b59359ea45686581e90feccea3c624b1226113f824f315eb60ae0a7c"},
+		{"console=ttyS0", EncodingOvmf, "0c55ff8ce3ea9389db345e81fd8f0ef3cd0737c952cfc38197b2c6ac6af0fba4b56f7e027577357654dd00e43a82be63"},
+		{"quiet café", EncodingOvmf, "9c3ef88075f6a8bb41c9a29ed885c3d3ca86904261f1cf7e863c9df7f7d4276f8631f79fa1a560fca093a4d30032a6e2"},
+		{"quiet café", EncodingUTF16, "316201ce4c725a444ca82d92809638a7ba4e7d959083c0d668dd0d11afba373e2a37bf1b55c86a87d36292ab9ff02f78"},
+	}
+	for _, tt := range tests {
+		got, err := MeasureKernelCmdline(tt.cmdline, tt.encoding)
+		if err != nil {
+			t.Fatalf("MeasureKernelCmdline(%q, %s) error = %v", tt.cmdline, tt.encoding, err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("MeasureKernelCmdline(%q, %s) = %x, want %s", tt.cmdline, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestKernelCmdlineLimit(t *testing.T) {
+	kernel := func(protocol uint16, cmdlineSize uint32) []byte {
+		k := syntheticKernel(0x10000)
+		binary.LittleEndian.PutUint16(k[0x206:], protocol)
+		binary.LittleEndian.PutUint32(k[0x238:], cmdlineSize)
+		return k
+	}
+	tests := []struct {
+		name   string
+		kernel []byte
+		length int
+		ok     bool
+	}{
+		// Before boot protocol 2.06 the kernel copies at most 255 bytes.
+		{"protocol 2.05, 255 bytes", kernel(0x205, 0), 255, true},
+		{"protocol 2.05, 256 bytes", kernel(0x205, 0), 256, false},
+		// From 2.06 on the limit is cmdline_size, 2047 for current kernels.
+		{"protocol 2.06, 2047 bytes", kernel(0x206, 2047), 2047, true},
+		{"protocol 2.06, 2048 bytes", kernel(0x206, 2047), 2048, false},
+		{"protocol 2.0f, 256 bytes", kernel(0x20f, 2047), 256, true},
+		{"protocol 2.0f, larger cmdline_size", kernel(0x20f, 4095), 2048, true},
+		// Kernels without a boot header impose no limit the tool knows about.
+		{"pe image", []byte("MZ"), 4096, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateParameters(tt.kernel, 0, 2048, 1, strings.Repeat("a", tt.length), profiles[DefaultProfile])
+			if tt.ok && err != nil {
+				t.Errorf("validateParameters() error = %v", err)
+			}
+			if !tt.ok && (err == nil || !strings.Contains(err.Error(), "kernel command line")) {
+				t.Errorf("validateParameters() error = %v, want command line error", err)
+			}
+		})
+	}
+}
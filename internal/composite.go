@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CompositeFieldMrkp is the composite field of the key provider measurement. All other fields
+// name measurement registers.
+const CompositeFieldMrkp = "mrkp"
+
+// CompositeDefinition defines a composite value as the SHA256 of the concatenation of its fields.
+type CompositeDefinition struct {
+	Name   string   `json:"name"`
+	Fields []string `json:"fields"`
+}
+
+// CompositeSpec is a revision of the composite value definitions.
+type CompositeSpec struct {
+	Version     string                `json:"version"`
+	Description string                `json:"description,omitempty"`
+	Composites  []CompositeDefinition `json:"composites"`
+}
+
+// CompositeValue is a computed composite value.
+type CompositeValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// compositeSpecSet is the file format of composite specs.
+type compositeSpecSet struct {
+	Default string           `json:"default"`
+	Specs   []*CompositeSpec `json:"specs"`
+}
+
+//go:embed composites/specs.json
+var compositeSpecsJSON []byte
+
+var builtinCompositeSpecs = mustParseCompositeSpecs(compositeSpecsJSON)
+
+func mustParseCompositeSpecs(data []byte) *compositeSpecSet {
+	set, err := parseCompositeSpecs(data)
+	if err != nil {
+		panic(fmt.Sprintf("invalid built-in composite specs: %v", err))
+	}
+	return set
+}
+
+// parseCompositeSpecs parses and validates composite specs.
+func parseCompositeSpecs(data []byte) (*compositeSpecSet, error) {
+	var set compositeSpecSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("malformed composite specs: %w", err)
+	}
+	for _, spec := range set.Specs {
+		if spec.Version == "" {
+			return nil, fmt.Errorf("composite spec without version")
+		}
+		for _, c := range spec.Composites {
+			if c.Name == "" || len(c.Fields) == 0 {
+				return nil, fmt.Errorf("composite spec %s has a composite without name or fields", spec.Version)
+			}
+		}
+	}
+	return &set, nil
+}
+
+// lookup returns the spec with the given version.
+func (s *compositeSpecSet) lookup(version string) (*CompositeSpec, error) {
+	for _, spec := range s.Specs {
+		if spec.Version == version {
+			return spec, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown composite spec version '%s'", version)
+}
+
+// DefaultCompositeSpec returns the built-in composite spec used unless another is selected.
+func DefaultCompositeSpec() *CompositeSpec {
+	spec, err := builtinCompositeSpecs.lookup(builtinCompositeSpecs.Default)
+	if err != nil {
+		panic(err)
+	}
+	return spec
+}
+
+// LookupCompositeSpec returns the built-in composite spec with the given version.
+func LookupCompositeSpec(version string) (*CompositeSpec, error) {
+	return builtinCompositeSpecs.lookup(version)
+}
+
+// LoadCompositeSpecs loads composite specs from a JSON file of the form {"specs": [...]}.
+func LoadCompositeSpecs(path string) ([]*CompositeSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set, err := parseCompositeSpecs(data)
+	if err != nil {
+		return nil, err
+	}
+	return set.Specs, nil
+}
+
+// Compute computes all composite values of the spec over the given registers.
+func (s *CompositeSpec) Compute(m RegisterSet, mrKeyProvider string) ([]CompositeValue, error) {
+	values := make([]CompositeValue, 0, len(s.Composites))
+	for _, c := range s.Composites {
+		value, err := computeComposite(m, c, mrKeyProvider)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, CompositeValue{Name: c.Name, Value: value})
+	}
+	return values, nil
+}
+
+// computeComposite computes a single composite value over the registers of the set.
+func computeComposite(m RegisterSet, c CompositeDefinition, mrKeyProvider string) (string, error) {
+	h := sha256.New()
+	for _, field := range c.Fields {
+		if field == CompositeFieldMrkp {
+			mrkp, err := hex.DecodeString(strings.TrimPrefix(mrKeyProvider, "0x"))
+			if err != nil {
+				return "", fmt.Errorf("%w: %w", ErrInvalidKeyProvider, err)
+			}
+			h.Write(mrkp)
+			continue
+		}
+		value, ok := LookupRegister(m, field)
+		if !ok {
+			return "", fmt.Errorf("unknown composite field '%s'", field)
+		}
+		h.Write(value)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// calculateDefaultComposite computes the named composite of the default spec.
+func (m *TdxMeasurements) calculateDefaultComposite(name, mrKeyProvider string) (string, error) {
+	for _, c := range DefaultCompositeSpec().Composites {
+		if c.Name == name {
+			return computeComposite(m, c, mrKeyProvider)
+		}
+	}
+	return "", fmt.Errorf("composite %s is not defined by the default spec", name)
+}
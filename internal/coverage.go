@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+)
+
+// CoverageStatus describes how faithfully a measured event is modeled.
+type CoverageStatus string
+
+const (
+	// CoverageModeled means the event is computed exactly from the inputs.
+	CoverageModeled CoverageStatus = "modeled"
+	// CoverageApproximated means the event relies on assumptions that may not hold for every
+	// firmware build or platform.
+	CoverageApproximated CoverageStatus = "approximated"
+	// CoverageOverridden means the event digest was supplied by the user.
+	CoverageOverridden CoverageStatus = "overridden"
+)
+
+// coverageRank orders coverage statuses from the strongest to the weakest assurance.
+var coverageRank = map[CoverageStatus]int{
+	CoverageModeled:      0,
+	CoverageApproximated: 1,
+	CoverageOverridden:   2,
+}
+
+// DigestSource describes where the digest of a measured event comes from.
+type DigestSource string
+
+const (
+	// SourceComputed means the digest is computed from the inputs.
+	SourceComputed DigestSource = "computed"
+	// SourceConstant means the digest is a hardcoded constant that is trusted without being derived
+	// from the inputs.
+	SourceConstant DigestSource = "constant"
+	// SourceEventLog means the digest is taken from an event log captured from a TD, because the
+	// event cannot be reproduced from the inputs.
+	SourceEventLog DigestSource = "event-log"
+)
+
+// CoverageEntry records the coverage status of a single measured event.
+type CoverageEntry struct {
+	Register string         `json:"register"`
+	Event    string         `json:"event"`
+	Status   CoverageStatus `json:"status"`
+	Source   DigestSource   `json:"source"`
+	Digest   string         `json:"digest,omitempty"`
+	Note     string         `json:"note,omitempty"`
+}
+
+// measuredEvent is an event extended into a measurement register.
+type measuredEvent struct {
+	name string
+	// eventType is the TCG event type recorded in the event log.
+	eventType uint32
+	digest    []byte
+	// data is the data the digest is computed from, nil if it is an input image or not known.
+	data   []byte
+	status CoverageStatus
+	source DigestSource
+	note   string
+}
+
+// RegisterCoverage returns the coverage status of every register, which is the weakest status of
+// any of the events extended into it.
+func (m *TdxMeasurements) RegisterCoverage() map[string]CoverageStatus {
+	result := make(map[string]CoverageStatus)
+	for _, e := range m.Coverage {
+		cur, ok := result[e.Register]
+		if !ok || coverageRank[e.Status] > coverageRank[cur] {
+			result[e.Register] = e.Status
+		}
+	}
+	return result
+}
+
+// ConstantDigests returns the events whose digests are hardcoded constants rather than computed from
+// the inputs. Each of them is a trust assumption of the resulting measurements.
+func (m *TdxMeasurements) ConstantDigests() []CoverageEntry {
+	var result []CoverageEntry
+	for _, e := range m.Coverage {
+		if e.Source == SourceConstant {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// EventTrace explains an event extended into an RTMR: how faithfully it is modeled and the value of
+// the register after it was extended.
+type EventTrace struct {
+	Register string `json:"register"`
+	// Index is the 1-based position of the event among the events extended into the register.
+	Index  int            `json:"index"`
+	Event  string         `json:"event,omitempty"`
+	Type   uint32         `json:"type"`
+	Digest string         `json:"digest"`
+	Status CoverageStatus `json:"status,omitempty"`
+	Source DigestSource   `json:"source,omitempty"`
+	Note   string         `json:"note,omitempty"`
+	Value  string         `json:"value"`
+	// Data is the hex-encoded event data, only set when requested.
+	Data string `json:"data,omitempty"`
+}
+
+// Trace replays the events extended into the RTMRs and explains each of them. The data of the
+// events, which includes entire ACPI tables and configuration files, is only included if withData
+// is set.
+func (m *TdxMeasurements) Trace(withData bool) []EventTrace {
+	// The coverage entries of a register are recorded in the order of its events.
+	coverage := make(map[string][]CoverageEntry)
+	for _, e := range m.Coverage {
+		coverage[e.Register] = append(coverage[e.Register], e)
+	}
+	values := make(map[string][]byte)
+	counts := make(map[string]int)
+	trace := make([]EventTrace, 0, len(m.Events))
+	for _, e := range m.Events {
+		value, ok := values[e.Register]
+		if !ok {
+			value = make([]byte, 48)
+		}
+		h := sha512.New384()
+		h.Write(value)
+		h.Write(padDigest(e.Digest))
+		values[e.Register] = h.Sum(nil)
+		counts[e.Register]++
+
+		t := EventTrace{Register: e.Register, Index: counts[e.Register], Event: e.Name, Type: e.Type, Digest: hex.EncodeToString(e.Digest),
+			Value: hex.EncodeToString(values[e.Register])}
+		if entries := coverage[e.Register]; t.Index <= len(entries) {
+			c := entries[t.Index-1]
+			t.Status, t.Source, t.Note = c.Status, c.Source, c.Note
+		}
+		if withData {
+			t.Data = hex.EncodeToString(e.Data)
+		}
+		trace = append(trace, t)
+	}
+	return trace
+}
@@ -0,0 +1,805 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPcsURL is the Intel Provisioning Certification Service the DCAP collateral is fetched
+	// from.
+	DefaultPcsURL = "https://api.trustedservices.intel.com"
+	// IntelRootCaURL is where Intel publishes the Intel SGX Root CA certificate, which all quotes
+	// and collateral chain up to. The certificate is not downloaded from it, a copy fetched over
+	// the network cannot be told apart from a self-signed certificate of whoever served it.
+	IntelRootCaURL = "https://certificates.trustedservices.intel.com/Intel_SGX_Provisioning_Certification_RootCA.cer"
+	// intelRootCaCrlURL is the CRL of the Intel SGX Root CA.
+	intelRootCaCrlURL = "https://certificates.trustedservices.intel.com/IntelSGXRootCA.der"
+
+	// quoteAttKeyTypeEcdsaP256 is the attestation key type of ECDSA-256-with-P-256 quotes.
+	quoteAttKeyTypeEcdsaP256 = 2
+	// Types of the certification data of a quote.
+	certDataPckChain = 5
+	certDataQeReport = 6
+	// qeReportSize is the size of the SGX report body of the quoting enclave.
+	qeReportSize = 384
+)
+
+// intelQeVendorID is the QE vendor ID of quotes produced by the Intel quoting enclave.
+var intelQeVendorID = []byte{0x93, 0x9a, 0x72, 0x33, 0xf7, 0x9c, 0x4c, 0xa9, 0x94, 0x0a, 0x0d, 0xb3, 0x95, 0x7f, 0x06, 0x07}
+
+// OIDs of the SGX extension of PCK certificates.
+var (
+	oidSgxExtension = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1}
+	oidSgxTcb       = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 2}
+	oidSgxPceID     = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 3}
+	oidSgxFmspc     = asn1.ObjectIdentifier{1, 2, 840, 113741, 1, 13, 1, 4}
+)
+
+// TCB statuses of the TCB info and QE identity collateral, from best to worst.
+var tcbStatusRank = map[string]int{
+	"UpToDate":                          0,
+	"SWHardeningNeeded":                 1,
+	"ConfigurationNeeded":               2,
+	"ConfigurationAndSWHardeningNeeded": 3,
+	"OutOfDate":                         4,
+	"OutOfDateConfigurationNeeded":      5,
+	"Revoked":                           6,
+}
+
+// DcapCollateral is the collateral of the Intel PCS a quote is verified against. It can be saved
+// and reused to verify quotes offline.
+type DcapCollateral struct {
+	// TcbInfo is the TDX TCB info of the FMSPC of the platform, signed by the TCB signing key. The
+	// JSON documents are kept as strings, as their signatures cover their exact encoding.
+	TcbInfo            string `json:"tcb_info"`
+	TcbInfoIssuerChain string `json:"tcb_info_issuer_chain"`
+	// QeIdentity is the identity of the TD quoting enclave, signed by the TCB signing key.
+	QeIdentity            string `json:"qe_identity"`
+	QeIdentityIssuerChain string `json:"qe_identity_issuer_chain"`
+	// PckCrl is the CRL of the PCK CA that issued the PCK certificate of the platform.
+	PckCrl []byte `json:"pck_crl"`
+	// RootCaCrl is the CRL of the Intel SGX Root CA.
+	RootCaCrl []byte `json:"root_ca_crl"`
+}
+
+// DcapResult is the result of verifying a quote with DCAP.
+type DcapResult struct {
+	// TcbStatus is the TCB status of the TD, the worst of the platform, TDX module and QE statuses.
+	TcbStatus         string   `json:"tcb_status"`
+	PlatformTcbStatus string   `json:"platform_tcb_status"`
+	ModuleTcbStatus   string   `json:"module_tcb_status,omitempty"`
+	QeTcbStatus       string   `json:"qe_tcb_status"`
+	TcbDate           string   `json:"tcb_date,omitempty"`
+	AdvisoryIDs       []string `json:"advisory_ids,omitempty"`
+	Fmspc             string   `json:"fmspc"`
+	// RootCa is the hex-encoded SHA256 fingerprint of the root CA certificate the quote chains up to.
+	RootCa string `json:"root_ca"`
+}
+
+// quoteSignature is the signature data of a TDX quote.
+type quoteSignature struct {
+	// signedData is the part of the quote the signature covers, its header and TD report body.
+	signedData  []byte
+	signature   []byte
+	attestKey   []byte
+	qeReport    []byte
+	qeReportSig []byte
+	qeAuthData  []byte
+	pckChain    []*x509.Certificate
+}
+
+// pckInfo is the platform information of the SGX extension of a PCK certificate.
+type pckInfo struct {
+	fmspc  []byte
+	pceID  []byte
+	cpuSvn [16]byte
+	pceSvn int
+}
+
+// dcapError returns an ErrQuoteInvalid error with the given details.
+func dcapError(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrQuoteInvalid, fmt.Sprintf(format, args...))
+}
+
+// parseQuoteSignature decodes the ECDSA signature data of a version 4 or 5 TDX quote, which
+// carries the QE report and the PCK certificate chain as certification data.
+func parseQuoteSignature(quote []byte) (*quoteSignature, error) {
+	offset, bodySize, err := quoteBody(quote)
+	if err != nil {
+		return nil, err
+	}
+	if attKeyType := binary.LittleEndian.Uint16(quote[2:4]); attKeyType != quoteAttKeyTypeEcdsaP256 {
+		return nil, dcapError("unsupported attestation key type %d", attKeyType)
+	}
+	if !bytes.Equal(quote[12:28], intelQeVendorID) {
+		return nil, dcapError("quote was not produced by the Intel quoting enclave")
+	}
+	s := &quoteSignature{signedData: quote[:offset+bodySize]}
+
+	r := bytes.NewReader(quote[offset+bodySize:])
+	var sigSize uint32
+	if err = binary.Read(r, binary.LittleEndian, &sigSize); err != nil || int64(sigSize) > int64(r.Len()) {
+		return nil, dcapError("quote signature data is truncated")
+	}
+	read := func(n int) []byte {
+		if n > r.Len() {
+			return nil
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return b
+	}
+	s.signature, s.attestKey = read(64), read(64)
+	var certType uint16
+	var certSize uint32
+	binary.Read(r, binary.LittleEndian, &certType)
+	if err = binary.Read(r, binary.LittleEndian, &certSize); err != nil || s.attestKey == nil {
+		return nil, dcapError("quote signature data is truncated")
+	}
+	if certType != certDataQeReport {
+		return nil, dcapError("unsupported certification data type %d", certType)
+	}
+	s.qeReport, s.qeReportSig = read(qeReportSize), read(64)
+	var authSize uint16
+	if err = binary.Read(r, binary.LittleEndian, &authSize); err != nil || s.qeReportSig == nil {
+		return nil, dcapError("QE certification data is truncated")
+	}
+	s.qeAuthData = read(int(authSize))
+	binary.Read(r, binary.LittleEndian, &certType)
+	if err = binary.Read(r, binary.LittleEndian, &certSize); err != nil || s.qeAuthData == nil {
+		return nil, dcapError("QE certification data is truncated")
+	}
+	if certType != certDataPckChain {
+		return nil, dcapError("unsupported QE certification data type %d", certType)
+	}
+	chain := read(int(certSize))
+	if chain == nil {
+		return nil, dcapError("PCK certificate chain is truncated")
+	}
+	if s.pckChain, err = parseCertChain(string(chain)); err != nil {
+		return nil, dcapError("PCK certificate chain: %v", err)
+	}
+	if len(s.pckChain) < 2 {
+		return nil, dcapError("PCK certificate chain is incomplete")
+	}
+	return s, nil
+}
+
+// parseCertChain parses the PEM certificates of a chain, leaf first.
+func parseCertChain(chain string) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := []byte(chain)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// verifyCertChain checks that the leaf of the chain chains up to the root CA and returns the
+// certificate of the verified chain that the root CA issued, whose revocation the CRL of the root
+// CA covers.
+func verifyCertChain(chain []*x509.Certificate, root *x509.Certificate, now time.Time) (*x509.Certificate, error) {
+	roots, intermediates := x509.NewCertPool(), x509.NewCertPool()
+	roots.AddCert(root)
+	for _, cert := range chain[1:] {
+		if !cert.Equal(root) {
+			intermediates.AddCert(cert)
+		}
+	}
+	chains, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if verified := chains[0]; len(verified) >= 2 {
+		return verified[len(verified)-2], nil
+	}
+	return nil, fmt.Errorf("certificate '%s' is the root CA itself", chain[0].Subject.CommonName)
+}
+
+// verifyRawEcdsa verifies an ECDSA P-256 signature given as the concatenation of r and s over the
+// SHA256 digest of the message.
+func verifyRawEcdsa(key *ecdsa.PublicKey, message, sig []byte) bool {
+	if len(sig) != 64 {
+		return false
+	}
+	digest := sha256.Sum256(message)
+	return ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:]))
+}
+
+// ecdsaKey returns the ECDSA P-256 public key of a certificate.
+func ecdsaKey(cert *x509.Certificate) (*ecdsa.PublicKey, error) {
+	key, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("certificate '%s' does not have an ECDSA P-256 key", cert.Subject.CommonName)
+	}
+	return key, nil
+}
+
+// parsePckInfo decodes the SGX extension of a PCK certificate.
+func parsePckInfo(cert *x509.Certificate) (*pckInfo, error) {
+	type entry struct {
+		ID    asn1.ObjectIdentifier
+		Value asn1.RawValue
+	}
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(oidSgxExtension) {
+			continue
+		}
+		var entries []entry
+		if _, err := asn1.Unmarshal(ext.Value, &entries); err != nil {
+			return nil, fmt.Errorf("malformed SGX extension: %w", err)
+		}
+		info := &pckInfo{}
+		for _, e := range entries {
+			switch {
+			case e.ID.Equal(oidSgxFmspc):
+				info.fmspc = e.Value.Bytes
+			case e.ID.Equal(oidSgxPceID):
+				info.pceID = e.Value.Bytes
+			case e.ID.Equal(oidSgxTcb):
+				var components []entry
+				if _, err := asn1.Unmarshal(e.Value.FullBytes, &components); err != nil {
+					return nil, fmt.Errorf("malformed SGX TCB extension: %w", err)
+				}
+				for _, c := range components {
+					n := c.ID[len(c.ID)-1]
+					switch {
+					case n >= 1 && n <= 16:
+						var svn int
+						if _, err := asn1.Unmarshal(c.Value.FullBytes, &svn); err != nil {
+							return nil, fmt.Errorf("malformed SGX TCB component: %w", err)
+						}
+						info.cpuSvn[n-1] = byte(svn)
+					case n == 17:
+						if _, err := asn1.Unmarshal(c.Value.FullBytes, &info.pceSvn); err != nil {
+							return nil, fmt.Errorf("malformed PCE SVN: %w", err)
+						}
+					}
+				}
+			}
+		}
+		if len(info.fmspc) != 6 {
+			return nil, fmt.Errorf("SGX extension has no FMSPC")
+		}
+		return info, nil
+	}
+	return nil, fmt.Errorf("certificate has no SGX extension")
+}
+
+// parseCrl parses a CRL given in PEM, DER or hex-encoded DER.
+func parseCrl(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	} else if raw, err := hex.DecodeString(strings.TrimSpace(string(data))); err == nil {
+		data = raw
+	}
+	return x509.ParseRevocationList(data)
+}
+
+// checkRevocation checks that the CRL is signed by the issuer, current and does not revoke the
+// certificate.
+func checkRevocation(crlData []byte, issuer, cert *x509.Certificate, now time.Time) error {
+	crl, err := parseCrl(crlData)
+	if err != nil {
+		return fmt.Errorf("malformed CRL: %w", err)
+	}
+	if err = crl.CheckSignatureFrom(issuer); err != nil {
+		return fmt.Errorf("CRL of '%s' has an invalid signature: %w", issuer.Subject.CommonName, err)
+	}
+	if !crl.NextUpdate.IsZero() && now.After(crl.NextUpdate) {
+		return fmt.Errorf("CRL of '%s' expired on %s", issuer.Subject.CommonName, crl.NextUpdate.Format(time.DateOnly))
+	}
+	for _, revoked := range crl.RevokedCertificateEntries {
+		if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			return fmt.Errorf("certificate '%s' is revoked", cert.Subject.CommonName)
+		}
+	}
+	return nil
+}
+
+// signedCollateral is a TCB info or QE identity document with its signature.
+type signedCollateral struct {
+	TcbInfo         json.RawMessage `json:"tcbInfo"`
+	EnclaveIdentity json.RawMessage `json:"enclaveIdentity"`
+	Signature       string          `json:"signature"`
+}
+
+// verifyCollateral checks the signature of a TCB info or QE identity document with the TCB signing
+// certificate of its issuer chain, which must chain up to the root CA and not be revoked by the
+// CRL of the root CA, and returns the signed body.
+func verifyCollateral(name, data, issuerChain string, root *x509.Certificate, rootCrl []byte, now time.Time) (json.RawMessage, error) {
+	var doc signedCollateral
+	if err := json.Unmarshal([]byte(data), &doc); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", name, err)
+	}
+	body := doc.TcbInfo
+	if body == nil {
+		body = doc.EnclaveIdentity
+	}
+	chain, err := parseCertChain(issuerChain)
+	if err != nil {
+		return nil, fmt.Errorf("%s issuer chain: %w", name, err)
+	}
+	rootIssued, err := verifyCertChain(chain, root, now)
+	if err != nil {
+		return nil, fmt.Errorf("%s issuer chain: %w", name, err)
+	}
+	if err = checkRevocation(rootCrl, root, rootIssued, now); err != nil {
+		return nil, fmt.Errorf("%s issuer chain: %w", name, err)
+	}
+	key, err := ecdsaKey(chain[0])
+	if err != nil {
+		return nil, err
+	}
+	sig, err := hex.DecodeString(doc.Signature)
+	if err != nil || !verifyRawEcdsa(key, body, sig) {
+		return nil, fmt.Errorf("%s has an invalid signature", name)
+	}
+	return body, nil
+}
+
+// tcbInfo is the TDX TCB info of the Intel PCS.
+type tcbInfo struct {
+	ID         string    `json:"id"`
+	Version    int       `json:"version"`
+	NextUpdate time.Time `json:"nextUpdate"`
+	Fmspc      string    `json:"fmspc"`
+	PceID      string    `json:"pceId"`
+	TdxModule  struct {
+		MrSigner       string `json:"mrsigner"`
+		Attributes     string `json:"attributes"`
+		AttributesMask string `json:"attributesMask"`
+	} `json:"tdxModule"`
+	TdxModuleIdentities []struct {
+		ID             string `json:"id"`
+		MrSigner       string `json:"mrsigner"`
+		Attributes     string `json:"attributes"`
+		AttributesMask string `json:"attributesMask"`
+		TcbLevels      []struct {
+			Tcb struct {
+				IsvSvn int `json:"isvsvn"`
+			} `json:"tcb"`
+			TcbStatus string `json:"tcbStatus"`
+		} `json:"tcbLevels"`
+	} `json:"tdxModuleIdentities"`
+	TcbLevels []struct {
+		Tcb struct {
+			SgxTcbComponents []struct {
+				Svn int `json:"svn"`
+			} `json:"sgxtcbcomponents"`
+			PceSvn           int `json:"pcesvn"`
+			TdxTcbComponents []struct {
+				Svn int `json:"svn"`
+			} `json:"tdxtcbcomponents"`
+		} `json:"tcb"`
+		TcbDate     string   `json:"tcbDate"`
+		TcbStatus   string   `json:"tcbStatus"`
+		AdvisoryIDs []string `json:"advisoryIDs"`
+	} `json:"tcbLevels"`
+}
+
+// qeIdentity is the identity of the TD quoting enclave of the Intel PCS.
+type qeIdentity struct {
+	ID             string    `json:"id"`
+	NextUpdate     time.Time `json:"nextUpdate"`
+	MiscSelect     string    `json:"miscselect"`
+	MiscSelectMask string    `json:"miscselectMask"`
+	Attributes     string    `json:"attributes"`
+	AttributesMask string    `json:"attributesMask"`
+	MrSigner       string    `json:"mrsigner"`
+	IsvProdID      uint16    `json:"isvprodid"`
+	TcbLevels      []struct {
+		Tcb struct {
+			IsvSvn uint16 `json:"isvsvn"`
+		} `json:"tcb"`
+		TcbStatus string `json:"tcbStatus"`
+	} `json:"tcbLevels"`
+}
+
+// maskedEqual reports whether value masked with the hex-encoded mask equals the hex-encoded
+// expected value.
+func maskedEqual(value []byte, expected, mask string) bool {
+	e, err1 := hex.DecodeString(expected)
+	m, err2 := hex.DecodeString(mask)
+	if err1 != nil || err2 != nil || len(e) != len(value) || len(m) != len(value) {
+		return false
+	}
+	for i := range value {
+		if value[i]&m[i] != e[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// worseTcbStatus returns the worse of two TCB statuses. Statuses this tool does not know are worse
+// than all others.
+func worseTcbStatus(a, b string) string {
+	rank := func(status string) int {
+		if r, ok := tcbStatusRank[status]; ok || status == "" {
+			return r
+		}
+		return len(tcbStatusRank)
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// VerifyDcapQuote verifies a TDX quote as the Intel DCAP quote verification library does: the PCK
+// certificate chain up to the root CA and its CRLs, the issuer chains of the collateral against the
+// CRL of the root CA, the QE report and its binding to the attestation key, the quote signature,
+// and the TCB status of the platform, the TDX module and the quoting enclave according to the
+// signed collateral.
+func VerifyDcapQuote(quote []byte, collateral *DcapCollateral, root *x509.Certificate, now time.Time) (*DcapResult, error) {
+	sig, err := parseQuoteSignature(quote)
+	if err != nil {
+		return nil, err
+	}
+	report, err := ParseQuote(quote)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(root.Raw)
+	result := &DcapResult{RootCa: hex.EncodeToString(fingerprint[:])}
+
+	// The PCK certificate, which certifies the platform, chains up to the root CA and is not revoked.
+	pck, pckCa := sig.pckChain[0], sig.pckChain[1]
+	rootIssued, err := verifyCertChain(sig.pckChain, root, now)
+	if err != nil {
+		return nil, dcapError("PCK certificate chain: %v", err)
+	}
+	if err = checkRevocation(collateral.RootCaCrl, root, rootIssued, now); err != nil {
+		return nil, dcapError("%v", err)
+	}
+	if err = checkRevocation(collateral.PckCrl, pckCa, pck, now); err != nil {
+		return nil, dcapError("%v", err)
+	}
+	pckKey, err := ecdsaKey(pck)
+	if err != nil {
+		return nil, dcapError("%v", err)
+	}
+	pckInfo, err := parsePckInfo(pck)
+	if err != nil {
+		return nil, dcapError("PCK certificate: %v", err)
+	}
+	result.Fmspc = hex.EncodeToString(pckInfo.fmspc)
+
+	// The QE report, signed with the PCK key, binds the attestation key that signs the quote.
+	if !verifyRawEcdsa(pckKey, sig.qeReport, sig.qeReportSig) {
+		return nil, dcapError("QE report signature does not verify with the PCK key")
+	}
+	binding := sha256.Sum256(append(append([]byte{}, sig.attestKey...), sig.qeAuthData...))
+	if !bytes.Equal(sig.qeReport[320:352], binding[:]) || !bytes.Equal(sig.qeReport[352:384], make([]byte, 32)) {
+		return nil, dcapError("QE report does not bind the attestation key")
+	}
+	attestKey := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(sig.attestKey[:32]),
+		Y:     new(big.Int).SetBytes(sig.attestKey[32:]),
+	}
+	if !verifyRawEcdsa(attestKey, sig.signedData, sig.signature) {
+		return nil, dcapError("quote signature does not verify with the attestation key")
+	}
+
+	// The TCB info gives the TCB status of the platform and the TDX module.
+	body, err := verifyCollateral("TCB info", collateral.TcbInfo, collateral.TcbInfoIssuerChain, root, collateral.RootCaCrl, now)
+	if err != nil {
+		return nil, dcapError("%v", err)
+	}
+	var tcb tcbInfo
+	if err = json.Unmarshal(body, &tcb); err != nil {
+		return nil, dcapError("malformed TCB info: %v", err)
+	}
+	switch {
+	case tcb.ID != "TDX" || tcb.Version != 3:
+		return nil, dcapError("TCB info is not a version 3 TDX TCB info")
+	case now.After(tcb.NextUpdate):
+		return nil, dcapError("TCB info expired on %s", tcb.NextUpdate.Format(time.DateOnly))
+	case !strings.EqualFold(tcb.Fmspc, result.Fmspc) || !strings.EqualFold(tcb.PceID, hex.EncodeToString(pckInfo.pceID)):
+		return nil, dcapError("TCB info is for FMSPC %s, the platform has FMSPC %s", tcb.Fmspc, result.Fmspc)
+	}
+	// With a TDX module major version above 0 the module identity gives the TCB status of the
+	// module, and the first two TEE TCB SVN components, the module SVN and major version, are
+	// not compared against the TCB levels.
+	tdxStart := 0
+	if report.TeeTcbSvn[1] > 0 {
+		tdxStart = 2
+		id := fmt.Sprintf("TDX_%02X", report.TeeTcbSvn[1])
+		for _, module := range tcb.TdxModuleIdentities {
+			if module.ID != id {
+				continue
+			}
+			if !strings.EqualFold(module.MrSigner, hex.EncodeToString(report.MrSignerSeam)) || !maskedEqual(report.SeamAttributes, module.Attributes, module.AttributesMask) {
+				return nil, dcapError("TDX module does not match the module identity %s", id)
+			}
+			for _, level := range module.TcbLevels {
+				if int(report.TeeTcbSvn[0]) >= level.Tcb.IsvSvn {
+					result.ModuleTcbStatus = level.TcbStatus
+					break
+				}
+			}
+		}
+		if result.ModuleTcbStatus == "" {
+			return nil, dcapError("TCB info has no TCB level for TDX module %s SVN %d", id, report.TeeTcbSvn[0])
+		}
+	} else if !strings.EqualFold(tcb.TdxModule.MrSigner, hex.EncodeToString(report.MrSignerSeam)) || !maskedEqual(report.SeamAttributes, tcb.TdxModule.Attributes, tcb.TdxModule.AttributesMask) {
+		return nil, dcapError("TDX module does not match the TCB info")
+	}
+	for _, level := range tcb.TcbLevels {
+		matches := pckInfo.pceSvn >= level.Tcb.PceSvn && len(level.Tcb.SgxTcbComponents) == 16 && len(level.Tcb.TdxTcbComponents) == 16
+		for i := 0; matches && i < 16; i++ {
+			matches = int(pckInfo.cpuSvn[i]) >= level.Tcb.SgxTcbComponents[i].Svn
+			if matches && i >= tdxStart {
+				matches = int(report.TeeTcbSvn[i]) >= level.Tcb.TdxTcbComponents[i].Svn
+			}
+		}
+		if matches {
+			result.PlatformTcbStatus, result.TcbDate, result.AdvisoryIDs = level.TcbStatus, level.TcbDate, level.AdvisoryIDs
+			break
+		}
+	}
+	if result.PlatformTcbStatus == "" {
+		return nil, dcapError("TCB info has no TCB level for the TCB of the platform")
+	}
+
+	// The QE identity gives the TCB status of the quoting enclave.
+	if body, err = verifyCollateral("QE identity", collateral.QeIdentity, collateral.QeIdentityIssuerChain, root, collateral.RootCaCrl, now); err != nil {
+		return nil, dcapError("%v", err)
+	}
+	var qe qeIdentity
+	if err = json.Unmarshal(body, &qe); err != nil {
+		return nil, dcapError("malformed QE identity: %v", err)
+	}
+	switch {
+	case qe.ID != "TD_QE":
+		return nil, dcapError("QE identity is not the identity of the TD quoting enclave")
+	case now.After(qe.NextUpdate):
+		return nil, dcapError("QE identity expired on %s", qe.NextUpdate.Format(time.DateOnly))
+	case !maskedEqual(sig.qeReport[16:20], qe.MiscSelect, qe.MiscSelectMask) ||
+		!maskedEqual(sig.qeReport[48:64], qe.Attributes, qe.AttributesMask) ||
+		!strings.EqualFold(qe.MrSigner, hex.EncodeToString(sig.qeReport[128:160])) ||
+		qe.IsvProdID != binary.LittleEndian.Uint16(sig.qeReport[256:258]):
+		return nil, dcapError("quoting enclave does not match the QE identity")
+	}
+	isvSvn := binary.LittleEndian.Uint16(sig.qeReport[258:260])
+	for _, level := range qe.TcbLevels {
+		if isvSvn >= level.Tcb.IsvSvn {
+			result.QeTcbStatus = level.TcbStatus
+			break
+		}
+	}
+	if result.QeTcbStatus == "" {
+		return nil, dcapError("QE identity has no TCB level for QE SVN %d", isvSvn)
+	}
+
+	result.TcbStatus = worseTcbStatus(worseTcbStatus(result.PlatformTcbStatus, result.ModuleTcbStatus), result.QeTcbStatus)
+	if result.TcbStatus == "Revoked" {
+		return result, dcapError("TCB of the platform is revoked")
+	}
+	return result, nil
+}
+
+// quotePlatform returns the hex-encoded FMSPC of the platform that produced the quote and the PCK
+// CA that certified it, platform or processor, which select the collateral of the quote.
+func quotePlatform(quote []byte) (string, string, error) {
+	sig, err := parseQuoteSignature(quote)
+	if err != nil {
+		return "", "", err
+	}
+	info, err := parsePckInfo(sig.pckChain[0])
+	if err != nil {
+		return "", "", dcapError("PCK certificate: %v", err)
+	}
+	// The PCK certificate is issued by the PCK Platform CA or the PCK Processor CA.
+	ca := "platform"
+	if strings.Contains(sig.pckChain[1].Subject.CommonName, "Processor") {
+		ca = "processor"
+	}
+	return hex.EncodeToString(info.fmspc), ca, nil
+}
+
+// FetchDcapCollateral fetches the collateral to verify a quote from the Intel PCS at pcsURL.
+func FetchDcapCollateral(pcsURL string, quote []byte) (*DcapCollateral, error) {
+	fmspc, ca, err := quotePlatform(quote)
+	if err != nil {
+		return nil, err
+	}
+
+	pcsURL = strings.TrimSuffix(pcsURL, "/")
+	c := &DcapCollateral{}
+	var (
+		doc   []byte
+		chain string
+	)
+	if doc, chain, err = httpGetHeader(pcsURL+"/tdx/certification/v4/tcb?fmspc="+fmspc, "TCB-Info-Issuer-Chain"); err != nil {
+		return nil, fmt.Errorf("failed to fetch TCB info: %w", err)
+	}
+	c.TcbInfo = string(doc)
+	if c.TcbInfoIssuerChain, err = url.PathUnescape(chain); err != nil {
+		return nil, fmt.Errorf("malformed TCB info issuer chain: %w", err)
+	}
+	if doc, chain, err = httpGetHeader(pcsURL+"/tdx/certification/v4/qe/identity", "SGX-Enclave-Identity-Issuer-Chain"); err != nil {
+		return nil, fmt.Errorf("failed to fetch QE identity: %w", err)
+	}
+	c.QeIdentity = string(doc)
+	if c.QeIdentityIssuerChain, err = url.PathUnescape(chain); err != nil {
+		return nil, fmt.Errorf("malformed QE identity issuer chain: %w", err)
+	}
+	if c.PckCrl, err = httpGet(pcsURL + "/sgx/certification/v4/pckcrl?ca=" + ca + "&encoding=der"); err != nil {
+		return nil, fmt.Errorf("failed to fetch PCK CRL: %w", err)
+	}
+	if c.RootCaCrl, err = httpGet(intelRootCaCrlURL); err != nil {
+		return nil, fmt.Errorf("failed to fetch root CA CRL: %w", err)
+	}
+	return c, nil
+}
+
+// nextUpdate returns when the first of the TCB info, QE identity and CRLs of the collateral is due
+// to be updated, or the zero time if none of them can be read.
+func (c *DcapCollateral) nextUpdate() time.Time {
+	var next time.Time
+	earlier := func(t time.Time) {
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	for _, data := range []string{c.TcbInfo, c.QeIdentity} {
+		var doc signedCollateral
+		var body struct {
+			NextUpdate time.Time `json:"nextUpdate"`
+		}
+		if json.Unmarshal([]byte(data), &doc) != nil {
+			continue
+		}
+		if doc.TcbInfo != nil && json.Unmarshal(doc.TcbInfo, &body) == nil {
+			earlier(body.NextUpdate)
+		} else if doc.EnclaveIdentity != nil && json.Unmarshal(doc.EnclaveIdentity, &body) == nil {
+			earlier(body.NextUpdate)
+		}
+	}
+	for _, data := range [][]byte{c.PckCrl, c.RootCaCrl} {
+		if crl, err := parseCrl(data); err == nil {
+			earlier(crl.NextUpdate)
+		}
+	}
+	return next
+}
+
+// maxCachedCollateral bounds the number of platforms a DcapCollateralCache holds collateral for.
+// The FMSPC it is keyed on is read from the quote before it is verified.
+const maxCachedCollateral = 1024
+
+// DcapCollateralCache caches the collateral fetched from the Intel PCS by the FMSPC of the platform
+// and the PCK CA that certified it, which select all collateral of a quote. Entries expire after
+// the TTL, or earlier when a document or CRL in them is due to be updated. The cache is safe for
+// concurrent use.
+type DcapCollateralCache struct {
+	pcsURL string
+	ttl    time.Duration
+	fetch  func(pcsURL string, quote []byte) (*DcapCollateral, error)
+
+	mu      sync.Mutex
+	entries map[string]cachedCollateral
+}
+
+// cachedCollateral is an entry of a DcapCollateralCache.
+type cachedCollateral struct {
+	collateral *DcapCollateral
+	expires    time.Time
+}
+
+// NewDcapCollateralCache returns a cache of the collateral of the Intel PCS at pcsURL whose entries
+// expire after ttl. A ttl of 0 disables caching.
+func NewDcapCollateralCache(pcsURL string, ttl time.Duration) *DcapCollateralCache {
+	return &DcapCollateralCache{pcsURL: pcsURL, ttl: ttl, fetch: FetchDcapCollateral, entries: map[string]cachedCollateral{}}
+}
+
+// Fetch returns the collateral to verify the quote, fetching it from the PCS unless the cache holds
+// collateral of the platform that has not expired at now.
+func (c *DcapCollateralCache) Fetch(quote []byte, now time.Time) (*DcapCollateral, error) {
+	if c.ttl <= 0 {
+		return c.fetch(c.pcsURL, quote)
+	}
+	fmspc, ca, err := quotePlatform(quote)
+	if err != nil {
+		return nil, err
+	}
+	key := fmspc + "/" + ca
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.collateral, nil
+	}
+
+	collateral, err := c.fetch(c.pcsURL, quote)
+	if err != nil {
+		return nil, err
+	}
+	expires := now.Add(c.ttl)
+	if next := collateral.nextUpdate(); !next.IsZero() && next.Before(expires) {
+		expires = next
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	if now.Before(expires) && len(c.entries) < maxCachedCollateral {
+		c.entries[key] = cachedCollateral{collateral: collateral, expires: expires}
+	}
+	return collateral, nil
+}
+
+// LoadDcapCollateral reads collateral saved as JSON.
+func LoadDcapCollateral(path string) (*DcapCollateral, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c DcapCollateral
+	if err = json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("malformed DCAP collateral: %w", err)
+	}
+	return &c, nil
+}
+
+// LoadDcapRootCa reads the root CA certificate quotes are verified against from a PEM or DER file.
+// The file must hold a copy of the Intel SGX Root CA whose fingerprint was checked out of band.
+func LoadDcapRootCa(path string) (*x509.Certificate, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no root CA certificate given, a copy of the Intel SGX Root CA (%s) is required", IntelRootCaURL)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read root CA certificate: %w", err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	root, err := x509.ParseCertificate(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed root CA certificate: %w", err)
+	}
+	if err = root.CheckSignatureFrom(root); err != nil {
+		return nil, fmt.Errorf("root CA certificate is not self-signed: %w", err)
+	}
+	return root, nil
+}
@@ -0,0 +1,357 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The DCAP tests run against a synthetic PKI in the layout of the Intel SGX PKI: a root CA, a PCK
+// Platform CA issuing the PCK certificate of the platform, and a TCB signing certificate signing
+// the TCB info and QE identity. None of it is Intel's, the quote and collateral are generated here.
+
+// testFmspc is the FMSPC of the synthetic platform.
+var testFmspc = []byte{0x00, 0x80, 0x6f, 0x05, 0x00, 0x00}
+
+// dcapPki is a synthetic DCAP PKI.
+type dcapPki struct {
+	root, pckCa, pck, tcbSigning          *x509.Certificate
+	rootKey, pckCaKey, pckKey, tcbSignKey *ecdsa.PrivateKey
+}
+
+// testCert issues a certificate for a new ECDSA P-256 key, self-signed if parent is nil.
+func testCert(t *testing.T, name string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, extensions ...pkix.Extension) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+		ExtraExtensions:       extensions,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// sgxExtension encodes the SGX extension of a PCK certificate with all TCB components at 0.
+func sgxExtension(t *testing.T) pkix.Extension {
+	t.Helper()
+	entry := func(id asn1.ObjectIdentifier, value any) asn1.RawValue {
+		der, err := asn1.Marshal(struct {
+			ID    asn1.ObjectIdentifier
+			Value any
+		}{id, value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asn1.RawValue{FullBytes: der}
+	}
+	var components []asn1.RawValue
+	for i := 1; i <= 17; i++ {
+		components = append(components, entry(append(append(asn1.ObjectIdentifier{}, oidSgxTcb...), i), 0))
+	}
+	value, err := asn1.Marshal([]asn1.RawValue{
+		entry(oidSgxTcb, components),
+		entry(oidSgxPceID, []byte{0, 0}),
+		entry(oidSgxFmspc, testFmspc),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: oidSgxExtension, Value: value}
+}
+
+func newDcapPki(t *testing.T) *dcapPki {
+	p := &dcapPki{}
+	p.root, p.rootKey = testCert(t, "Test SGX Root CA", 1, nil, nil, true)
+	p.pckCa, p.pckCaKey = testCert(t, "Test SGX PCK Platform CA", 2, p.root, p.rootKey, true)
+	p.pck, p.pckKey = testCert(t, "Test SGX PCK Certificate", 3, p.pckCa, p.pckCaKey, false, sgxExtension(t))
+	p.tcbSigning, p.tcbSignKey = testCert(t, "Test SGX TCB Signing", 4, p.root, p.rootKey, false)
+	return p
+}
+
+// rawSign signs the SHA256 digest of message and returns the signature as the concatenation of r
+// and s.
+func rawSign(t *testing.T, key *ecdsa.PrivateKey, message []byte) []byte {
+	t.Helper()
+	digest := sha256.Sum256(message)
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return sig
+}
+
+// testCrl issues a CRL revoking the given serial numbers that expires at nextUpdate.
+func testCrl(t *testing.T, issuer *x509.Certificate, key *ecdsa.PrivateKey, nextUpdate time.Time, revoked ...int64) []byte {
+	t.Helper()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: nextUpdate,
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pemChain(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, cert := range certs {
+		pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return b.String()
+}
+
+// signedDocument signs the JSON encoding of body and wraps it as a TCB info or QE identity.
+func signedDocument(t *testing.T, p *dcapPki, field string, body any) string {
+	t.Helper()
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf(`{"%s":%s,"signature":"%s"}`, field, data, hex.EncodeToString(rawSign(t, p.tcbSignKey, data)))
+}
+
+// testCollateralOptions are the parameters of the synthetic collateral.
+type testCollateralOptions struct {
+	nextUpdate    time.Time
+	pckRevoked    []int64
+	rootRevoked   []int64
+	crlNextUpdate time.Time
+}
+
+func (p *dcapPki) collateral(t *testing.T, o testCollateralOptions) *DcapCollateral {
+	components := make([]map[string]int, 16)
+	for i := range components {
+		components[i] = map[string]int{"svn": 0}
+	}
+	tcb := map[string]any{
+		"id":         "TDX",
+		"version":    3,
+		"nextUpdate": o.nextUpdate,
+		"fmspc":      hex.EncodeToString(testFmspc),
+		"pceId":      "0000",
+		"tdxModule": map[string]string{
+			"mrsigner":       strings.Repeat("00", 48),
+			"attributes":     "0000000000000000",
+			"attributesMask": "FFFFFFFFFFFFFFFF",
+		},
+		"tcbLevels": []any{map[string]any{
+			"tcb":       map[string]any{"sgxtcbcomponents": components, "pcesvn": 0, "tdxtcbcomponents": components},
+			"tcbDate":   "2024-03-13T00:00:00Z",
+			"tcbStatus": "UpToDate",
+		}},
+	}
+	qe := map[string]any{
+		"id":             "TD_QE",
+		"nextUpdate":     o.nextUpdate,
+		"miscselect":     "00000000",
+		"miscselectMask": "FFFFFFFF",
+		"attributes":     strings.Repeat("00", 16),
+		"attributesMask": strings.Repeat("FF", 16),
+		"mrsigner":       strings.Repeat("00", 32),
+		"isvprodid":      2,
+		"tcbLevels":      []any{map[string]any{"tcb": map[string]int{"isvsvn": 0}, "tcbStatus": "UpToDate"}},
+	}
+	issuerChain := pemChain(p.tcbSigning, p.root)
+	return &DcapCollateral{
+		TcbInfo:               signedDocument(t, p, "tcbInfo", tcb),
+		TcbInfoIssuerChain:    issuerChain,
+		QeIdentity:            signedDocument(t, p, "enclaveIdentity", qe),
+		QeIdentityIssuerChain: issuerChain,
+		PckCrl:                testCrl(t, p.pckCa, p.pckCaKey, o.crlNextUpdate, o.pckRevoked...),
+		RootCaCrl:             testCrl(t, p.root, p.rootKey, o.crlNextUpdate, o.rootRevoked...),
+	}
+}
+
+// quote builds a version 4 TDX quote signed by a new attestation key, whose QE report is signed by
+// the PCK key. bindData is hashed into the report data of the QE report in place of the
+// attestation key and QE authentication data if set.
+func (p *dcapPki) quote(t *testing.T, bindData []byte) []byte {
+	attestKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := make([]byte, 64)
+	attestKey.X.FillBytes(publicKey[:32])
+	attestKey.Y.FillBytes(publicKey[32:])
+	authData := []byte("synthetic QE authentication data")
+
+	header := make([]byte, quoteHeaderSize)
+	binary.LittleEndian.PutUint16(header[0:2], 4)
+	binary.LittleEndian.PutUint16(header[2:4], quoteAttKeyTypeEcdsaP256)
+	binary.LittleEndian.PutUint32(header[4:8], quoteTeeTypeTdx)
+	copy(header[12:28], intelQeVendorID)
+	body := make([]byte, tdReportBodySize)
+	for i := 136; i < 184; i++ {
+		body[i] = 0x11 // MRTD
+	}
+	signed := append(header, body...)
+
+	if bindData == nil {
+		bindData = append(append([]byte{}, publicKey...), authData...)
+	}
+	qeReport := make([]byte, qeReportSize)
+	binary.LittleEndian.PutUint16(qeReport[256:258], 2)
+	binding := sha256.Sum256(bindData)
+	copy(qeReport[320:352], binding[:])
+
+	var qeData bytes.Buffer
+	qeData.Write(qeReport)
+	qeData.Write(rawSign(t, p.pckKey, qeReport))
+	binary.Write(&qeData, binary.LittleEndian, uint16(len(authData)))
+	qeData.Write(authData)
+	chain := pemChain(p.pck, p.pckCa, p.root)
+	binary.Write(&qeData, binary.LittleEndian, uint16(certDataPckChain))
+	binary.Write(&qeData, binary.LittleEndian, uint32(len(chain)))
+	qeData.WriteString(chain)
+
+	var sigData bytes.Buffer
+	sigData.Write(rawSign(t, attestKey, signed))
+	sigData.Write(publicKey)
+	binary.Write(&sigData, binary.LittleEndian, uint16(certDataQeReport))
+	binary.Write(&sigData, binary.LittleEndian, uint32(qeData.Len()))
+	sigData.Write(qeData.Bytes())
+
+	quote := append([]byte{}, signed...)
+	quote = binary.LittleEndian.AppendUint32(quote, uint32(sigData.Len()))
+	return append(quote, sigData.Bytes()...)
+}
+
+func TestVerifyDcapQuote(t *testing.T) {
+	p := newDcapPki(t)
+	now := time.Now()
+	current := testCollateralOptions{nextUpdate: now.Add(time.Hour), crlNextUpdate: now.Add(time.Hour)}
+	quote := p.quote(t, nil)
+	tamperedBody := append([]byte{}, quote...)
+	tamperedBody[quoteHeaderSize+136] ^= 1
+	withOptions := func(change func(*testCollateralOptions)) testCollateralOptions {
+		o := current
+		change(&o)
+		return o
+	}
+
+	tests := []struct {
+		name       string
+		quote      []byte
+		collateral testCollateralOptions
+		wantErr    string
+	}{
+		{"valid", quote, current, ""},
+		{"tampered body", tamperedBody, current, "quote signature does not verify"},
+		{"wrong QE report hash", p.quote(t, []byte("another attestation key")), current, "QE report does not bind the attestation key"},
+		{"revoked PCK", quote, withOptions(func(o *testCollateralOptions) { o.pckRevoked = []int64{p.pck.SerialNumber.Int64()} }), "'Test SGX PCK Certificate' is revoked"},
+		{"revoked PCK CA", quote, withOptions(func(o *testCollateralOptions) { o.rootRevoked = []int64{p.pckCa.SerialNumber.Int64()} }), "'Test SGX PCK Platform CA' is revoked"},
+		{"revoked TCB signing certificate", quote, withOptions(func(o *testCollateralOptions) { o.rootRevoked = []int64{p.tcbSigning.SerialNumber.Int64()} }), "TCB info issuer chain: certificate 'Test SGX TCB Signing' is revoked"},
+		{"expired TCB info", quote, withOptions(func(o *testCollateralOptions) { o.nextUpdate = now.Add(-time.Hour) }), "TCB info expired"},
+		{"expired CRL", quote, withOptions(func(o *testCollateralOptions) { o.crlNextUpdate = now.Add(-time.Minute) }), "CRL of 'Test SGX Root CA' expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := VerifyDcapQuote(tt.quote, p.collateral(t, tt.collateral), p.root, now)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("VerifyDcapQuote() error = %v", err)
+				}
+				if result.TcbStatus != "UpToDate" || result.Fmspc != hex.EncodeToString(testFmspc) {
+					t.Errorf("VerifyDcapQuote() = %+v, want UpToDate for FMSPC %x", result, testFmspc)
+				}
+				return
+			}
+			if !errors.Is(err, ErrQuoteInvalid) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("VerifyDcapQuote() error = %v, want ErrQuoteInvalid containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	t.Run("other root CA", func(t *testing.T) {
+		other := newDcapPki(t)
+		if _, err := VerifyDcapQuote(quote, p.collateral(t, current), other.root, now); !errors.Is(err, ErrQuoteInvalid) {
+			t.Errorf("VerifyDcapQuote() error = %v, want ErrQuoteInvalid", err)
+		}
+	})
+}
+
+func TestDcapCollateralCache(t *testing.T) {
+	p := newDcapPki(t)
+	now := time.Now()
+	quote := p.quote(t, nil)
+	collateral := p.collateral(t, testCollateralOptions{nextUpdate: now.Add(2 * time.Hour), crlNextUpdate: now.Add(3 * time.Hour)})
+
+	tests := []struct {
+		name      string
+		ttl       time.Duration
+		after     time.Duration
+		wantFetch int
+	}{
+		{"reused within the TTL", time.Hour, 30 * time.Minute, 1},
+		{"refetched after the TTL", time.Hour, 61 * time.Minute, 2},
+		{"refetched at the next update", 24 * time.Hour, 2 * time.Hour, 2},
+		{"not cached without a TTL", 0, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewDcapCollateralCache("https://pcs.invalid", tt.ttl)
+			fetches := 0
+			cache.fetch = func(pcsURL string, q []byte) (*DcapCollateral, error) {
+				fetches++
+				return collateral, nil
+			}
+			for _, at := range []time.Time{now, now.Add(tt.after)} {
+				c, err := cache.Fetch(quote, at)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if c != collateral {
+					t.Errorf("Fetch() returned other collateral")
+				}
+			}
+			if fetches != tt.wantFetch {
+				t.Errorf("collateral fetched %d times, want %d", fetches, tt.wantFetch)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package internal
+
+import "strings"
+
+// Diagnosis is a known cause of a verification mismatch together with the likely fix.
+type Diagnosis struct {
+	// ID identifies the known cause, e.g. "mrtd-variant".
+	ID         string `json:"id"`
+	Cause      string `json:"cause"`
+	Suggestion string `json:"suggestion"`
+}
+
+// mismatchPattern describes which registers and events of a verification differ.
+type mismatchPattern struct {
+	mismatched map[string]bool
+	first      map[string]*EventMismatch
+}
+
+// only reports whether exactly the given registers mismatch.
+func (p *mismatchPattern) only(registers ...string) bool {
+	if len(p.mismatched) != len(registers) {
+		return false
+	}
+	for _, r := range registers {
+		if !p.mismatched[r] {
+			return false
+		}
+	}
+	return true
+}
+
+// event returns the name of the first differing event of the register, or "" if it is unknown.
+func (p *mismatchPattern) event(register string) string {
+	if m := p.first[register]; m != nil {
+		return m.Event
+	}
+	return ""
+}
+
+// extraEvent reports whether the event log of the register has events beyond the expected ones.
+func (p *mismatchPattern) extraEvent(register string) bool {
+	m := p.first[register]
+	return m != nil && m.Event == ""
+}
+
+// knownCause is an entry of the mismatch knowledge base.
+type knownCause struct {
+	Diagnosis
+	matches func(p *mismatchPattern) bool
+}
+
+// knownCauses is the knowledge base of mismatch patterns, most specific patterns first.
+var knownCauses = []knownCause{
+	{
+		Diagnosis: Diagnosis{ID: "mrtd-variant", Cause: "only MRTD differs, which usually means the MRTD variant of the platform was not selected correctly",
+			Suggestion: "measure with the other TCB version (-tcbver 6 for single pass, 7 for two pass), or with -profile qemu-tdx-zero-extend for QEMU versions zero-extending TDVF sections"},
+		matches: func(p *mismatchPattern) bool { return p.only("MRTD") },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "firmware", Cause: "MRTD and RTMR0 differ, the TD was booted from a different firmware build",
+			Suggestion: "measure the firmware (-fw) the TD was actually booted from, e.g. by taking it from the image metadata with -metadata"},
+		matches: func(p *mismatchPattern) bool { return p.only("MRTD", "RTMR0") },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "missing-separator", Cause: "the event log of RTMR0 contains events after the expected ones",
+			Suggestion: "add measured fw_cfg files with -fw-cfg-measure, or load a profile pack (-profile-pack) whose rtmr0_events list the additional events"},
+		matches: func(p *mismatchPattern) bool {
+			return p.mismatched["RTMR0"] && (p.extraEvent("RTMR0") || p.event("RTMR0") == "Separator")
+		},
+	},
+	{
+		Diagnosis: Diagnosis{ID: "memory-size", Cause: "RTMR0 and RTMR1 differ, both depend on the guest memory size (TD HOB and kernel boot header)",
+			Suggestion: "measure with the memory size the TD was launched with (-memory), including memory hotplug settings (-memory-slots, -maxmem)"},
+		matches: func(p *mismatchPattern) bool {
+			return p.only("RTMR0", "RTMR1") || (p.mismatched["RTMR0"] && p.event("RTMR0") == "TD HOB")
+		},
+	},
+	{
+		Diagnosis: Diagnosis{ID: "acpi-tables", Cause: "the ACPI tables measured into RTMR0 differ, they depend on the CPU count, the memory size and the QEMU version",
+			Suggestion: "check -cpu and -memory, and use ACPI templates captured from the QEMU version that launched the TD (-templates or -templates-url)"},
+		matches: func(p *mismatchPattern) bool {
+			return p.mismatched["RTMR0"] && strings.HasPrefix(p.event("RTMR0"), "ACPI")
+		},
+	},
+	{
+		Diagnosis: Diagnosis{ID: "boot-order", Cause: "the BootOrder event in RTMR0 differs, the varstore of the TD holds a different BootOrder variable",
+			Suggestion: "supply its digest with -event-override boot-order=<hex>"},
+		matches: func(p *mismatchPattern) bool { return p.mismatched["RTMR0"] && p.event("RTMR0") == "BootOrder" },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "firmware-constants", Cause: "the CFV image or Boot0000 event in RTMR0 differs, the TD uses a varstore other than the one in the firmware or its boot option is not the reference one",
+			Suggestion: "supply the digests of the TD with -event-override cfv-image=<hex> or -event-override boot0000=<hex>, or select a profile for the firmware"},
+		matches: func(p *mismatchPattern) bool {
+			return p.mismatched["RTMR0"] && (p.event("RTMR0") == "CFV image" || p.event("RTMR0") == "Boot0000")
+		},
+	},
+	{
+		Diagnosis: Diagnosis{ID: "rtmr0-configuration", Cause: "only RTMR0 differs, which depends on the memory size, the CPU count, the ACPI templates and the profile",
+			Suggestion: "check -memory, -cpu, -templates and -profile against the launch configuration of the TD, and pass -event-log to find the first differing event"},
+		matches: func(p *mismatchPattern) bool { return p.only("RTMR0") && p.first["RTMR0"] == nil },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "initrd", Cause: "RTMR1 and RTMR2 differ, the initrd size is written into the kernel boot header and its contents are measured into RTMR2",
+			Suggestion: "measure the initrd the TD was booted with (-initrd), with the same early microcode (-initrd-microcode, -initrd-mode)"},
+		matches: func(p *mismatchPattern) bool { return p.only("RTMR1", "RTMR2") && p.event("RTMR2") != "Kernel cmdline" },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "kernel", Cause: "only RTMR1 differs, the kernel image or the boot header modifications differ",
+			Suggestion: "measure the kernel the TD was booted with (-kernel), and check -no-kernel-patch, -kernel-prepatched and -initrd-size-align for VMMs that patch the header differently"},
+		matches: func(p *mismatchPattern) bool { return p.only("RTMR1") },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "cmdline", Cause: "the kernel command line differs, e.g. by a trailing space or fragments appended by the VMM at launch",
+			Suggestion: "pass the exact command line of the TD including whitespace (-cmdline), as found in /proc/cmdline of the guest or the image metadata"},
+		matches: func(p *mismatchPattern) bool {
+			return p.event("RTMR2") == "Kernel cmdline" || (p.only("RTMR2") && p.first["RTMR2"] == nil)
+		},
+	},
+	{
+		Diagnosis: Diagnosis{ID: "initrd-contents", Cause: "the initrd measured into RTMR2 differs",
+			Suggestion: "measure the initrd the TD was booted with, with the same early microcode (-initrd-microcode, -initrd-mode)"},
+		matches: func(p *mismatchPattern) bool { return p.only("RTMR2") && p.event("RTMR2") == "Initrd" },
+	},
+	{
+		Diagnosis: Diagnosis{ID: "different-image", Cause: "all compared boot registers differ, the quote is most likely from a different image",
+			Suggestion: "check that the artifacts belong to the image the TD was launched from, e.g. with -metadata and -vm-manifest"},
+		matches: func(p *mismatchPattern) bool { return p.only("MRTD", "RTMR0", "RTMR1", "RTMR2") },
+	},
+}
+
+// DiagnoseMismatch matches the mismatching registers and events of a verification against the
+// knowledge base of known causes and returns the likely causes, most specific first. It returns
+// nil if all registers match.
+func DiagnoseMismatch(results []RegisterResult) []Diagnosis {
+	p := &mismatchPattern{mismatched: map[string]bool{}, first: map[string]*EventMismatch{}}
+	for _, r := range results {
+		if !r.Match {
+			p.mismatched[strings.ToUpper(r.Register)] = true
+			p.first[strings.ToUpper(r.Register)] = r.FirstMismatch
+		}
+	}
+	if len(p.mismatched) == 0 {
+		return nil
+	}
+	var diagnoses []Diagnosis
+	for _, c := range knownCauses {
+		if c.matches(p) {
+			diagnoses = append(diagnoses, c.Diagnosis)
+		}
+	}
+	return diagnoses
+}
@@ -0,0 +1,43 @@
+package internal
+
+import (
+	_ "embed"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/template"
+)
+
+//go:embed docker/Dockerfile.tmpl
+var dockerfileTemplate string
+
+// DockerfileOptions configures the generated Dockerfile of the service image.
+type DockerfileOptions struct {
+	// GoVersion is the version of the Go toolchain image the binary is built with.
+	GoVersion string
+	// Templates is the directory of the ACPI templates within the build context.
+	Templates string
+	// Port is the port the service listens on.
+	Port uint16
+	// Version is the version of the tool recorded in the image labels.
+	Version string
+}
+
+// WriteDockerfile writes a multi-stage Dockerfile building the tool from the source tree in the
+// build context and packaging it into a distroless image that runs the service.
+func WriteDockerfile(w io.Writer, opts DockerfileOptions) error {
+	clean := path.Clean(opts.Templates)
+	if opts.Templates == "" || path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("templates directory '%s' must be relative to the build context", opts.Templates)
+	}
+	opts.Templates = clean
+	if opts.Version == "" {
+		opts.Version = ReadBuildInfo().Version
+	}
+	tpl, err := template.New("Dockerfile").Parse(dockerfileTemplate)
+	if err != nil {
+		return err
+	}
+	return tpl.Execute(w, opts)
+}
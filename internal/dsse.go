@@ -0,0 +1,96 @@
+package internal
+
+import (
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// ReportPayloadType is the DSSE payload type of signed measurement reports, whose payload is the
+// canonical JSON output of the measurement command.
+const ReportPayloadType = "application/vnd.reproduce-mr.report+json"
+
+// DsseEnvelope is a Dead Simple Signing Envelope as specified by the secure-systems-lab.
+type DsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DsseSignature `json:"signatures"`
+}
+
+// DsseSignature is a signature of a DSSE envelope.
+type DsseSignature struct {
+	KeyID string `json:"keyid,omitempty"`
+	Sig   string `json:"sig"`
+}
+
+// dssePae returns the pre-authentication encoding of a payload, which is what DSSE signs.
+func dssePae(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// SignDsse wraps the payload in a DSSE envelope signed with an Ed25519 or ECDSA (P-256/P-384) key.
+func SignDsse(payloadType string, payload []byte, key crypto.Signer) (*DsseEnvelope, error) {
+	env := &DsseEnvelope{PayloadType: payloadType, Payload: base64.StdEncoding.EncodeToString(payload)}
+	if err := env.AddSignature(key); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// AddSignature adds a signature with another key to the envelope, e.g. with the new key while a
+// signing key is rotated.
+func (e *DsseEnvelope) AddSignature(key crypto.Signer) error {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return fmt.Errorf("malformed DSSE payload: %w", err)
+	}
+	keyID, err := KeyID(key)
+	if err != nil {
+		return err
+	}
+	sig, err := signMessage(key, dssePae(e.PayloadType, payload))
+	if err != nil {
+		return err
+	}
+	e.Signatures = append(e.Signatures, DsseSignature{KeyID: keyID, Sig: base64.StdEncoding.EncodeToString(sig)})
+	return nil
+}
+
+// ParseDsseEnvelope parses the JSON encoding of a DSSE envelope.
+func ParseDsseEnvelope(data []byte) (*DsseEnvelope, error) {
+	var env DsseEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("malformed DSSE envelope: %w", err)
+	}
+	if env.PayloadType == "" || len(env.Signatures) == 0 {
+		return nil, fmt.Errorf("malformed DSSE envelope: payload type or signatures missing")
+	}
+	return &env, nil
+}
+
+// Verify checks that a signature of the envelope verifies with a trusted key and returns the
+// payload and the ID of the key. Signatures are tried regardless of their key ID, which is only a
+// hint.
+func (e *DsseEnvelope) Verify(anchors TrustAnchors) ([]byte, string, error) {
+	payload, err := base64.StdEncoding.DecodeString(e.Payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("malformed DSSE payload: %w", err)
+	}
+	var sigs [][]byte
+	for _, s := range e.Signatures {
+		// Some signers use URL-safe base64.
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			if sig, err = base64.URLEncoding.DecodeString(s.Sig); err != nil {
+				continue
+			}
+		}
+		sigs = append(sigs, sig)
+	}
+	keyID, err := anchors.Verify(dssePae(e.PayloadType, payload), sigs)
+	if err != nil {
+		return nil, "", err
+	}
+	return payload, keyID, nil
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// VmManifest is the per-VM configuration stored by dstack-vmm (vm-manifest.json).
+type VmManifest struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name"`
+	AppID  string          `json:"app_id"`
+	Image  string          `json:"image"`
+	Vcpu   uint32          `json:"vcpu"`
+	Memory uint64          `json:"memory"` // In megabytes.
+	Gpus   json.RawMessage `json:"gpus,omitempty"`
+}
+
+// HasGpus returns true when the manifest requests GPU passthrough.
+func (m *VmManifest) HasGpus() bool {
+	var gpus any
+	if len(m.Gpus) == 0 || json.Unmarshal(m.Gpus, &gpus) != nil {
+		return false
+	}
+	switch g := gpus.(type) {
+	case []any:
+		return len(g) > 0
+	case map[string]any:
+		if list, ok := g["gpus"].([]any); ok && len(list) > 0 {
+			return true
+		}
+		attachAll, _ := g["attach_mode"].(string)
+		return attachAll == "all"
+	default:
+		return false
+	}
+}
+
+// LoadVmManifest loads a dstack-vmm VM manifest.
+func LoadVmManifest(path string) (*VmManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m VmManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("malformed VM manifest: %w", err)
+	}
+	if m.Image == "" {
+		return nil, fmt.Errorf("VM manifest does not specify an image")
+	}
+	if m.Vcpu == 0 || m.Memory == 0 {
+		return nil, fmt.Errorf("VM manifest does not specify vcpu and memory")
+	}
+	return &m, nil
+}
+
+// ImageMetadata is the metadata (metadata.json or metadata.yaml) shipped with dstack guest images.
+// File names are relative to the directory containing the metadata.
+type ImageMetadata struct {
+	Bios    string `json:"bios" yaml:"bios"`
+	Kernel  string `json:"kernel" yaml:"kernel"`
+	Cmdline string `json:"cmdline" yaml:"cmdline"`
+	Initrd  string `json:"initrd" yaml:"initrd"`
+	Rootfs  string `json:"rootfs,omitempty" yaml:"rootfs,omitempty"`
+	// Version is the dstack version of the image.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// CmdlineFragments are appended to the kernel command line, separated by spaces.
+	CmdlineFragments []string `json:"cmdline_fragments,omitempty" yaml:"cmdline_fragments,omitempty"`
+	// VmConfig holds the recommended configuration of VMs booting the image.
+	VmConfig *ImageVmConfig `json:"vm_config,omitempty" yaml:"vm_config,omitempty"`
+}
+
+// ImageVmConfig is the recommended configuration of VMs booting a dstack image.
+type ImageVmConfig struct {
+	Vcpu       uint32 `json:"vcpu,omitempty" yaml:"vcpu,omitempty"`
+	Memory     uint64 `json:"memory,omitempty" yaml:"memory,omitempty"` // In megabytes.
+	TcbVersion uint   `json:"tcb_version,omitempty" yaml:"tcb_version,omitempty"`
+	Profile    string `json:"profile,omitempty" yaml:"profile,omitempty"`
+}
+
+// FullCmdline returns the kernel command line including all fragments.
+func (m *ImageMetadata) FullCmdline() string {
+	parts := make([]string, 0, len(m.CmdlineFragments)+1)
+	for _, part := range append([]string{m.Cmdline}, m.CmdlineFragments...) {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// FindImageMetadata returns the path of the metadata in the given image directory, preferring
+// metadata.json over its YAML variants.
+func FindImageMetadata(dir string) string {
+	for _, name := range []string{"metadata.json", "metadata.yaml", "metadata.yml"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join(dir, "metadata.json")
+}
+
+// LoadImageMetadata loads dstack image metadata. Files with a .yaml or .yml extension are parsed
+// as YAML, all others as JSON.
+func LoadImageMetadata(path string) (*ImageMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m ImageMetadata
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &m)
+	default:
+		err = json.Unmarshal(data, &m)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("malformed image metadata: %w", err)
+	}
+	if m.Bios == "" || m.Kernel == "" {
+		return nil, fmt.Errorf("image metadata does not specify bios and kernel")
+	}
+	return &m, nil
+}
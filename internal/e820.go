@@ -0,0 +1,42 @@
+package internal
+
+import "encoding/binary"
+
+// E820 entry types.
+const (
+	e820Ram = 1
+)
+
+// e820EntrySize is the size of an entry of the etc/e820 fw_cfg file.
+const e820EntrySize = 20
+
+// splitGuestMemory returns the amount of guest RAM mapped below and above 4 GiB, following the
+// memory layout of the QEMU q35 machine.
+func splitGuestMemory(memorySize uint64) (uint64, uint64) {
+	memBytes := memorySize * 1024 * 1024
+	lowmem := uint64(0x80000000)
+	if memBytes < 0xb0000000 {
+		lowmem = 0xb0000000
+	}
+	if memBytes >= lowmem {
+		return lowmem, memBytes - lowmem
+	}
+	return memBytes, 0
+}
+
+// buildE820Table synthesizes the etc/e820 fw_cfg file QEMU provides for the given memory size.
+// Each entry consists of the little-endian 64-bit address and length followed by the 32-bit type.
+func buildE820Table(memorySize uint64) []byte {
+	below4g, above4g := splitGuestMemory(memorySize)
+	table := make([]byte, 0, 2*e820EntrySize)
+	appendEntry := func(address, length uint64, entryType uint32) {
+		table = binary.LittleEndian.AppendUint64(table, address)
+		table = binary.LittleEndian.AppendUint64(table, length)
+		table = binary.LittleEndian.AppendUint32(table, entryType)
+	}
+	appendEntry(0, below4g, e820Ram)
+	if above4g > 0 {
+		appendEntry(0x100000000, above4g, e820Ram)
+	}
+	return table
+}
@@ -0,0 +1,252 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// EarProfile is the EAT profile of EAR attestation results.
+const EarProfile = "tag:github.com,2023:veraison/ear"
+
+// EarStatus is the trust tier of an appraisal in an attestation result.
+type EarStatus string
+
+const (
+	EarAffirming       EarStatus = "affirming"
+	EarWarning         EarStatus = "warning"
+	EarContraindicated EarStatus = "contraindicated"
+)
+
+// AR4SI "executables" trustworthiness claim values.
+const (
+	arExecutablesApproved        = 2
+	arExecutablesUnrecognized    = 33
+	arExecutablesContraindicated = 96
+)
+
+// AR4SI "configuration" trustworthiness claim values.
+const (
+	arConfigurationApproved        = 2
+	arConfigurationContraindicated = 96
+)
+
+// AR4SI "hardware" trustworthiness claim values.
+const (
+	arHardwareNoClaim         = 0
+	arHardwareGenuine         = 2
+	arHardwareUnsafe          = 32
+	arHardwareContraindicated = 96
+)
+
+// EarVerifierID identifies the verifier that produced an attestation result.
+type EarVerifierID struct {
+	Developer string `json:"developer"`
+	Build     string `json:"build"`
+}
+
+// EarAppraisal is the appraisal of a single submodule of the attester.
+type EarAppraisal struct {
+	Status          EarStatus      `json:"ear.status"`
+	Trustworthiness map[string]int `json:"ear.trustworthiness-vector,omitempty"`
+}
+
+// AttestationResult is an EAR (EAT Attestation Result) claims set.
+type AttestationResult struct {
+	Profile    string                  `json:"eat_profile"`
+	IssuedAt   int64                   `json:"iat"`
+	VerifierID EarVerifierID           `json:"ear.verifier-id"`
+	Submods    map[string]EarAppraisal `json:"submods"`
+}
+
+// EarStatus returns the trust tier of the register comparison. Registers that match but rely on
+// approximated or overridden events only yield a warning.
+func (r RegisterResult) EarStatus() EarStatus {
+	switch {
+	case !r.Match:
+		return EarContraindicated
+	case r.Coverage != CoverageModeled:
+		return EarWarning
+	default:
+		return EarAffirming
+	}
+}
+
+// NewAttestationResult summarizes register comparisons as an attestation result with one
+// submodule per register.
+func NewAttestationResult(results []RegisterResult, issuedAt time.Time) *AttestationResult {
+	ar := &AttestationResult{
+		Profile:    EarProfile,
+		IssuedAt:   issuedAt.Unix(),
+		VerifierID: EarVerifierID{Developer: "scrtlabs", Build: "reproduce-mr"},
+		Submods:    make(map[string]EarAppraisal),
+	}
+	for _, r := range results {
+		status := r.EarStatus()
+		executables := arExecutablesApproved
+		switch status {
+		case EarWarning:
+			executables = arExecutablesUnrecognized
+		case EarContraindicated:
+			executables = arExecutablesContraindicated
+		}
+		ar.Submods[strings.ToLower(r.Register)] = EarAppraisal{
+			Status:          status,
+			Trustworthiness: map[string]int{"executables": executables},
+		}
+	}
+	return ar
+}
+
+// AddAllowlistAppraisal adds the result of checking the quote against an allowlist source as the
+// "allowlist" submodule.
+func (ar *AttestationResult) AddAllowlistAppraisal(allowed bool) {
+	status, executables := EarAffirming, arExecutablesApproved
+	if !allowed {
+		status, executables = EarContraindicated, arExecutablesContraindicated
+	}
+	ar.Submods["allowlist"] = EarAppraisal{
+		Status:          status,
+		Trustworthiness: map[string]int{"executables": executables},
+	}
+}
+
+// AddConfigurationAppraisal adds the comparison of the TD attributes, XFAM and owner-controlled
+// fields of the quote as the "configuration" submodule, contraindicated if any checked field
+// differs. Nothing is added if no field was checked.
+func (ar *AttestationResult) AddConfigurationAppraisal(fields []FieldResult) {
+	checked := false
+	status, configuration := EarAffirming, arConfigurationApproved
+	for _, f := range fields {
+		checked = checked || f.Expected != ""
+		if !f.Match {
+			status, configuration = EarContraindicated, arConfigurationContraindicated
+		}
+	}
+	if !checked {
+		return
+	}
+	ar.Submods["configuration"] = EarAppraisal{
+		Status:          status,
+		Trustworthiness: map[string]int{"configuration": configuration},
+	}
+}
+
+// AddPlatformAppraisal adds the result of verifying the quote with DCAP as the "platform"
+// submodule: a TCB status that is up to date affirms the hardware, other accepted statuses only
+// yield a warning.
+func (ar *AttestationResult) AddPlatformAppraisal(tcbStatus string, accepted bool) {
+	status, hardware := EarAffirming, arHardwareGenuine
+	switch {
+	case !accepted:
+		status, hardware = EarContraindicated, arHardwareContraindicated
+	case tcbStatus != "UpToDate":
+		status, hardware = EarWarning, arHardwareUnsafe
+	}
+	ar.Submods["platform"] = EarAppraisal{
+		Status:          status,
+		Trustworthiness: map[string]int{"hardware": hardware},
+	}
+}
+
+// AddUnverifiedPlatformAppraisal adds a "platform" submodule with a warning for evidence whose
+// signature was not verified with DCAP, which makes no claim about the hardware.
+func (ar *AttestationResult) AddUnverifiedPlatformAppraisal() {
+	ar.Submods["platform"] = EarAppraisal{
+		Status:          EarWarning,
+		Trustworthiness: map[string]int{"hardware": arHardwareNoClaim},
+	}
+}
+
+// LoadSigningKey loads a PEM encoded PKCS #8 Ed25519 or ECDSA private key.
+func LoadSigningKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		return k, nil
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+}
+
+// Sign encodes the attestation result as a JWT signed with the given Ed25519 or ECDSA (P-256 or
+// P-384) key.
+func (ar *AttestationResult) Sign(key crypto.Signer) (string, error) {
+	var alg string
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		alg = "EdDSA"
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			alg = "ES256"
+		case elliptic.P384():
+			alg = "ES384"
+		default:
+			return "", fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", key)
+	}
+
+	// The claims are canonicalized so that the signed token does not depend on the Go version.
+	header, err := MarshalCanonical(map[string]string{"alg": alg, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := MarshalCanonical(ar)
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	signingInput := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+
+	var sig []byte
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(signingInput))
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		if alg == "ES256" {
+			d := sha256.Sum256([]byte(signingInput))
+			digest = d[:]
+		} else {
+			d := sha512.Sum384([]byte(signingInput))
+			digest = d[:]
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest)
+		if err != nil {
+			return "", err
+		}
+		// JWS uses the fixed-size concatenation of r and s.
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	}
+	return signingInput + "." + enc.EncodeToString(sig), nil
+}
@@ -0,0 +1,34 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAddConfigurationAppraisal(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []FieldResult
+		want   EarStatus
+	}{
+		{"nothing checked", []FieldResult{{Field: "mr_owner", Quoted: "00", Match: true}}, ""},
+		{"checked fields match", []FieldResult{{Field: "xfam", Quoted: "e7", Expected: "e7", Match: true}, {Field: "mr_owner", Quoted: "00", Match: true}}, EarAffirming},
+		{"checked field differs", []FieldResult{{Field: "xfam", Quoted: "e7", Expected: "e7", Match: true}, {Field: "mr_owner", Quoted: "00", Expected: "01", Match: false}}, EarContraindicated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ar := NewAttestationResult(nil, time.Unix(0, 0))
+			ar.AddConfigurationAppraisal(tt.fields)
+			appraisal, ok := ar.Submods["configuration"]
+			if tt.want == "" {
+				if ok {
+					t.Errorf("configuration submodule = %v, want none", appraisal)
+				}
+				return
+			}
+			if appraisal.Status != tt.want {
+				t.Errorf("configuration status = %s, want %s", appraisal.Status, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors the measurement engine wraps with the details of a failure, so that callers can branch on
+// its cause with errors.Is.
+var (
+	// ErrMalformedTDVF means the firmware has no valid OVMF table footer or TDVF metadata.
+	ErrMalformedTDVF = errors.New("malformed TDVF firmware")
+	// ErrUnsupportedKernel means the kernel image is not an x86_64 kernel TDVF can boot.
+	ErrUnsupportedKernel = errors.New("unsupported kernel")
+	// ErrKernelTooOld means the boot protocol of the kernel cannot load an initrd.
+	ErrKernelTooOld = errors.New("kernel boot protocol too old")
+	// ErrInitrdTooLarge means the initrd does not fit below the highest address the kernel and the
+	// guest memory allow.
+	ErrInitrdTooLarge = errors.New("initrd is too large")
+	// ErrInvalidParameters means the measurement parameters describe a guest that cannot boot.
+	ErrInvalidParameters = errors.New("invalid parameters")
+	// ErrTemplateNotFound means there is no ACPI table template for the CPU count and profile.
+	ErrTemplateNotFound = errors.New("template for ACPI tables is not available")
+	// ErrUnknownProfile means no profile of the given name is known.
+	ErrUnknownProfile = errors.New("unknown profile")
+	// ErrBadGUID means a GUID is not in the canonical 8-4-4-4-12 hex form.
+	ErrBadGUID = errors.New("malformed GUID")
+	// ErrInvalidKeyProvider means the measurement of the key provider is not a hex-encoded digest.
+	ErrInvalidKeyProvider = errors.New("invalid mr_key_provider")
+)
+
+// ErrSignatureInvalid means no signature of a signed report or profile pack verifies with a trusted
+// key.
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// ErrQuoteInvalid means the signature of a quote, its certificate chain or its collateral does not
+// verify, or the collateral does not describe the platform that produced it.
+var ErrQuoteInvalid = errors.New("quote verification failed")
+
+// ErrEventLogTruncated means an event log does not hold all events of the log area the CCEL ACPI
+// table describes.
+var ErrEventLogTruncated = errors.New("event log is truncated")
+
+// engineErrors are the errors above, which keep their identity when they are returned by a sandbox
+// worker.
+var engineErrors = []error{
+	ErrMalformedTDVF, ErrUnsupportedKernel, ErrKernelTooOld, ErrInitrdTooLarge, ErrInvalidParameters,
+	ErrTemplateNotFound, ErrUnknownProfile, ErrBadGUID, ErrInvalidKeyProvider,
+}
+
+// remoteError is an error received from another process, which matches the engine error it was
+// caused by.
+type remoteError struct {
+	message string
+	cause   error
+}
+
+func (e *remoteError) Error() string { return e.message }
+func (e *remoteError) Unwrap() error { return e.cause }
+
+// engineErrorKind returns the index of the engine error err matches in engineErrors, -1 if none.
+func engineErrorKind(err error) int {
+	for i, target := range engineErrors {
+		if errors.Is(err, target) {
+			return i
+		}
+	}
+	return -1
+}
+
+// newRemoteError returns the error with the given message received from another process, matching
+// the engine error of the given kind.
+func newRemoteError(message string, kind int) error {
+	e := &remoteError{message: message}
+	if kind >= 0 && kind < len(engineErrors) {
+		e.cause = engineErrors[kind]
+	}
+	return e
+}
+
+// malformedTdvf returns an ErrMalformedTDVF error with the given details.
+func malformedTdvf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", ErrMalformedTDVF, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,393 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const (
+	// evNoAction is the type of TCG events that are not extended into any register.
+	evNoAction = 0x3
+
+	// Types of the TCG events extended into the RTMRs by TDVF and the kernel.
+	evSeparator                  = 0x4
+	evEventTag                   = 0x6
+	evPlatformConfigFlags        = 0xa
+	evEfiVariableDriverConfig    = 0x80000001
+	evEfiVariableBoot            = 0x80000002
+	evEfiBootServicesApplication = 0x80000003
+	evEfiAction                  = 0x80000007
+	evEfiPlatformFirmwareBlob2   = 0x8000000a
+	evEfiHandoffTables2          = 0x8000000b
+	// TCG algorithm identifiers of the digest banks of event logs.
+	tpmAlgSha1   = 0x0004
+	tpmAlgSha256 = 0x000b
+	tpmAlgSha384 = 0x000c
+	tpmAlgSha512 = 0x000d
+	tpmAlgSm3    = 0x0012
+)
+
+// DigestBanks maps the names of the digest banks of TCG event logs to their algorithm identifiers.
+var DigestBanks = map[string]uint16{
+	"sha1":    tpmAlgSha1,
+	"sha256":  tpmAlgSha256,
+	"sha384":  tpmAlgSha384,
+	"sha512":  tpmAlgSha512,
+	"sm3_256": tpmAlgSm3,
+}
+
+// bankName returns the name of the digest bank of an algorithm identifier.
+func bankName(alg uint16) string {
+	for name, id := range DigestBanks {
+		if id == alg {
+			return name
+		}
+	}
+	return fmt.Sprintf("0x%04x", alg)
+}
+
+// LogEvent is an event extended into a register as recorded in a TD event log.
+type LogEvent struct {
+	Register string
+	Type     uint32
+	// Digest is the SHA384 digest extended into the RTMR, nil if the log does not record one.
+	Digest []byte
+	// Banks are the digests of all banks of a TCG event log by algorithm identifier, nil for logs
+	// that only record the SHA384 digest.
+	Banks map[uint16][]byte
+	// Name is the name of a runtime event as recorded by the dstack guest agent, empty otherwise.
+	Name string
+	// Data is the event data recorded with the event.
+	Data []byte
+}
+
+// Event log formats.
+const (
+	// EventLogCcel is the binary TCG event log from the CCEL ACPI table.
+	EventLogCcel = "ccel"
+	// EventLogDstack is the JSON event log returned by the dstack guest agent.
+	EventLogDstack = "dstack-json"
+	// EventLogTcg2 is a binary TCG event log of a TPM, whose PCRs are mapped to the RTMRs.
+	EventLogTcg2 = "tcg2"
+	// EventLogCelCbor is the CBOR encoding of the TCG Canonical Event Log.
+	EventLogCelCbor = "cel-cbor"
+	// EventLogJSON is the JSON event log representation of this tool.
+	EventLogJSON = "json"
+	// EventLogDigests is a plain list of the digests extended into one register, one hex-encoded
+	// SHA384 digest per line optionally followed by a name. It is not among the EventLogFormats as
+	// it does not record the register.
+	EventLogDigests = "digests"
+)
+
+// EventLogFormats are the supported event log formats.
+var EventLogFormats = []string{EventLogCcel, EventLogTcg2, EventLogCelCbor, EventLogJSON, EventLogDstack}
+
+// ParseEventLog parses a TD event log in one of the EventLogFormats and returns the events
+// extended into the RTMRs.
+func ParseEventLog(data []byte, format string) ([]LogEvent, error) {
+	switch format {
+	case EventLogCcel:
+		return parseCryptoAgileEventLog(data, ccelRegister)
+	case EventLogTcg2:
+		return parseCryptoAgileEventLog(data, pcrRegister)
+	case EventLogCelCbor:
+		return parseCelCborEventLog(data)
+	case EventLogJSON:
+		return parseJSONEventLog(data)
+	case EventLogDstack:
+		return parseDstackEventLog(data)
+	default:
+		return nil, fmt.Errorf("unknown event log format '%s'", format)
+	}
+}
+
+// DetectEventLogFormat guesses the format of an event log from its contents. Binary TCG event logs
+// are assumed to come from the CCEL, as TPM event logs cannot be told apart by their contents.
+func DetectEventLogFormat(data []byte) string {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var entries []map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &entries) == nil && len(entries) > 0 && entries[0]["register"] != nil {
+			return EventLogJSON
+		}
+		return EventLogDstack
+	}
+	if len(data) > 0 && data[0]>>5 == cborArray {
+		return EventLogCelCbor
+	}
+	return EventLogCcel
+}
+
+// IsDigestList reports whether data is a digest list in the EventLogDigests format.
+func IsDigestList(data []byte) bool {
+	_, err := ParseDigestList(data, "RTMR0")
+	return err == nil && len(bytes.TrimSpace(data)) > 0
+}
+
+// ParseDigestList parses a list of the digests extended into a register. Every line holds a
+// hex-encoded SHA384 digest, optionally followed by the name of the event; empty lines and lines
+// starting with # are skipped.
+func ParseDigestList(data []byte, register string) ([]LogEvent, error) {
+	if _, err := rtmrIndex(register); err != nil {
+		return nil, err
+	}
+	var events []LogEvent
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		value, name := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			value, name = line[:i], line[i+1:]
+		}
+		digest, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || len(digest) != 48 {
+			return nil, fmt.Errorf("line %d is not a hex-encoded SHA384 digest", n+1)
+		}
+		events = append(events, LogEvent{Register: register, Digest: digest, Name: strings.TrimSpace(name)})
+	}
+	return events, nil
+}
+
+// ccelRegister maps the register index of a CCEL event log to the register name. Index 0 refers to
+// MRTD, whose events are skipped, and indices 1 to 4 to RTMR0 to RTMR3.
+func ccelRegister(index uint32) (string, error) {
+	if index > 4 {
+		return "", fmt.Errorf("invalid register index %d", index)
+	}
+	if index == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("RTMR%d", index-1), nil
+}
+
+// pcrRegister maps a TPM PCR index to the register name following the mapping of PCRs to TD
+// measurement registers of the UEFI specification: PCR 0 to MRTD, whose events are skipped, PCRs 1
+// and 7 to RTMR0, PCRs 2 to 6 to RTMR1 and PCRs 8 to 15 to RTMR2.
+func pcrRegister(index uint32) (string, error) {
+	switch {
+	case index == 0:
+		return "", nil
+	case index == 1 || index == 7:
+		return "RTMR0", nil
+	case index <= 6:
+		return "RTMR1", nil
+	case index <= 15:
+		return "RTMR2", nil
+	default:
+		return "", fmt.Errorf("PCR %d is not mapped to a TD measurement register", index)
+	}
+}
+
+// parseCryptoAgileEventLog parses a crypto agile TCG event log as found in the CCEL ACPI table or
+// written by a TPM. The register function maps register indices to register names, returning ""
+// for registers whose events are skipped.
+func parseCryptoAgileEventLog(data []byte, register func(index uint32) (string, error)) ([]LogEvent, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Index, Type uint32
+		Digest      [20]byte
+		EventSize   uint32
+	}
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("event log header is truncated")
+	}
+	specID := make([]byte, header.EventSize)
+	if _, err := io.ReadFull(r, specID); err != nil || !bytes.HasPrefix(specID, []byte("Spec ID Event03\x00")) {
+		return nil, fmt.Errorf("event log is not in the crypto agile format")
+	}
+	digestSizes, err := parseSpecIDEvent(specID)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []LogEvent
+	for r.Len() >= 8 {
+		var index, typ, count uint32
+		binary.Read(r, binary.LittleEndian, &index)
+		if index == 0xffffffff {
+			// The remainder of the CCEL area is unused.
+			break
+		}
+		binary.Read(r, binary.LittleEndian, &typ)
+		if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return nil, fmt.Errorf("event %d is truncated", len(events))
+		}
+		var digest []byte
+		banks := make(map[uint16][]byte, count)
+		for range count {
+			var alg uint16
+			if err := binary.Read(r, binary.LittleEndian, &alg); err != nil {
+				return nil, fmt.Errorf("event %d is truncated", len(events))
+			}
+			size, ok := digestSizes[alg]
+			if !ok {
+				return nil, fmt.Errorf("event %d uses unknown digest algorithm 0x%04x", len(events), alg)
+			}
+			d := make([]byte, size)
+			if _, err := io.ReadFull(r, d); err != nil {
+				return nil, fmt.Errorf("event %d is truncated", len(events))
+			}
+			banks[alg] = d
+			if alg == tpmAlgSha384 {
+				digest = d
+			}
+		}
+		var eventSize uint32
+		if err := binary.Read(r, binary.LittleEndian, &eventSize); err != nil || int64(eventSize) > int64(r.Len()) {
+			return nil, fmt.Errorf("event %d is truncated", len(events))
+		}
+		eventData := make([]byte, eventSize)
+		io.ReadFull(r, eventData)
+
+		if typ == evNoAction {
+			continue
+		}
+		name, err := register(index)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", len(events), err)
+		}
+		if name == "" {
+			continue
+		}
+		// Events without a SHA384 digest, e.g. of SHA256-only logs, can only be replayed into
+		// another bank.
+		events = append(events, LogEvent{Register: name, Type: typ, Digest: digest, Banks: banks, Data: eventData})
+	}
+	return events, nil
+}
+
+// parseSpecIDEvent returns the digest sizes by algorithm listed in the Spec ID event.
+func parseSpecIDEvent(event []byte) (map[uint16]int, error) {
+	// Signature (16), platform class (4), version and errata (3), uintn size (1).
+	const algorithmsOffset = 24
+	if len(event) < algorithmsOffset+4 {
+		return nil, fmt.Errorf("spec ID event is truncated")
+	}
+	count := int(binary.LittleEndian.Uint32(event[algorithmsOffset:]))
+	if len(event) < algorithmsOffset+4+count*4 {
+		return nil, fmt.Errorf("spec ID event is truncated")
+	}
+	sizes := make(map[uint16]int, count)
+	for i := range count {
+		off := algorithmsOffset + 4 + i*4
+		sizes[binary.LittleEndian.Uint16(event[off:])] = int(binary.LittleEndian.Uint16(event[off+2:]))
+	}
+	return sizes, nil
+}
+
+// parseDstackEventLog parses the JSON event log returned by the dstack guest agent.
+func parseDstackEventLog(data []byte) ([]LogEvent, error) {
+	var entries []struct {
+		Imr       uint32 `json:"imr"`
+		EventType uint32 `json:"event_type"`
+		Digest    string `json:"digest"`
+		Event     string `json:"event"`
+		Payload   string `json:"event_payload"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("malformed event log: %w", err)
+	}
+	events := make([]LogEvent, 0, len(entries))
+	for i, e := range entries {
+		if e.EventType == evNoAction {
+			continue
+		}
+		if e.Imr > 3 {
+			return nil, fmt.Errorf("event %d has an invalid register index %d", i, e.Imr)
+		}
+		digest, err := hex.DecodeString(strings.TrimPrefix(e.Digest, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("event %d has a malformed digest: %w", i, err)
+		}
+		payload, err := hex.DecodeString(e.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("event %d has a malformed payload: %w", i, err)
+		}
+		events = append(events, LogEvent{Register: fmt.Sprintf("RTMR%d", e.Imr), Type: e.EventType, Digest: digest,
+			Name: e.Event, Data: payload})
+	}
+	return events, nil
+}
+
+// EventMismatch describes the first event of a register that differs from the expected event log.
+type EventMismatch struct {
+	// Index is the position of the event among the events extended into the register.
+	Index int `json:"index"`
+	// Event is the name of the expected event, or empty if the event log has additional events.
+	Event    string `json:"event,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// EventDiff compares an event of a register in the event log with the expected event.
+type EventDiff struct {
+	// Index is the position of the event among the events extended into the register.
+	Index int `json:"index"`
+	// Event is the name of the expected event, or empty if the event log has additional events.
+	Event    string `json:"event,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// Type is the type of the event in the event log.
+	Type  uint32 `json:"type,omitempty"`
+	Match bool   `json:"match"`
+	// Value is the value of the register after replaying the event log up to the event.
+	Value string `json:"value,omitempty"`
+}
+
+// DiffEventLog compares the events of the register in the event log one by one with the events
+// the computed measurements expect. It returns nil if no events are known for the register (MRTD
+// and the runtime events of RTMR3).
+func DiffEventLog(m *TdxMeasurements, log []LogEvent, register string) []EventDiff {
+	var expected []CoverageEntry
+	for _, e := range m.Coverage {
+		if e.Register == register && e.Digest != "" {
+			expected = append(expected, e)
+		}
+	}
+	if len(expected) == 0 {
+		return nil
+	}
+	var actual []LogEvent
+	for _, e := range log {
+		if e.Register == register {
+			actual = append(actual, e)
+		}
+	}
+
+	diffs := make([]EventDiff, 0, max(len(expected), len(actual)))
+	value := make([]byte, 48)
+	for i := 0; i < max(len(expected), len(actual)); i++ {
+		d := EventDiff{Index: i}
+		if i < len(expected) {
+			d.Event = expected[i].Event
+			d.Expected = expected[i].Digest
+		}
+		if i < len(actual) {
+			d.Actual = hex.EncodeToString(actual[i].Digest)
+			d.Type = actual[i].Type
+			value = ExtendRegister(value, actual[i].Digest)
+			d.Value = hex.EncodeToString(value)
+		}
+		d.Match = d.Expected == d.Actual
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// FirstEventMismatch returns the first event of the register whose digest in the event log differs
+// from the computed measurements. It returns nil if all events match or if no events are known for
+// the register (MRTD and the runtime events of RTMR3).
+func FirstEventMismatch(m *TdxMeasurements, log []LogEvent, register string) *EventMismatch {
+	for _, d := range DiffEventLog(m, log, register) {
+		if !d.Match {
+			return &EventMismatch{Index: d.Index, Event: d.Event, Expected: d.Expected, Actual: d.Actual}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Record and content types of the TCG Canonical Event Log.
+const (
+	celRecnum      = 0
+	celPcr         = 1
+	celNvIndex     = 2
+	celDigests     = 3
+	celPcClientStd = 5
+
+	// Fields of the pcclient_std content.
+	celEventType = 0
+	celEventData = 1
+)
+
+// jsonLogEvent is an event of the JSON event log representation of this tool.
+type jsonLogEvent struct {
+	Register string `json:"register"`
+	Type     uint32 `json:"type"`
+	Digest   string `json:"digest"`
+	Name     string `json:"name,omitempty"`
+	Data     string `json:"data,omitempty"`
+}
+
+// dstackLogEvent is an event of the JSON event log of the dstack guest agent.
+type dstackLogEvent struct {
+	Imr       uint32 `json:"imr"`
+	EventType uint32 `json:"event_type"`
+	Digest    string `json:"digest"`
+	Event     string `json:"event"`
+	Payload   string `json:"event_payload"`
+}
+
+// EncodeEventLog encodes events in one of the EventLogFormats. The binary formats record only
+// SHA384 digests, and the names of dstack runtime events are only kept by the JSON formats.
+func EncodeEventLog(events []LogEvent, format string) ([]byte, error) {
+	for i, e := range events {
+		if e.Digest == nil {
+			if err := CheckSha384Bank(events, e.Register); err != nil {
+				return nil, err
+			}
+		}
+		if len(e.Digest) != 48 {
+			return nil, fmt.Errorf("event %d has a %d byte digest instead of a SHA384 digest", i, len(e.Digest))
+		}
+		if _, err := rtmrIndex(e.Register); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+	}
+	switch format {
+	case EventLogCcel:
+		return encodeCryptoAgileEventLog(events, func(rtmr uint32) (uint32, error) { return rtmr + 1, nil })
+	case EventLogTcg2:
+		return encodeCryptoAgileEventLog(events, rtmrPcr)
+	case EventLogCelCbor:
+		return encodeCelCborEventLog(events), nil
+	case EventLogJSON:
+		entries := make([]jsonLogEvent, 0, len(events))
+		for _, e := range events {
+			entries = append(entries, jsonLogEvent{Register: e.Register, Type: e.Type, Digest: hex.EncodeToString(e.Digest),
+				Name: e.Name, Data: hex.EncodeToString(e.Data)})
+		}
+		return marshalEventLogJSON(entries)
+	case EventLogDstack:
+		entries := make([]dstackLogEvent, 0, len(events))
+		for _, e := range events {
+			rtmr, _ := rtmrIndex(e.Register)
+			entries = append(entries, dstackLogEvent{Imr: rtmr, EventType: e.Type, Digest: hex.EncodeToString(e.Digest),
+				Event: e.Name, Payload: hex.EncodeToString(e.Data)})
+		}
+		return marshalEventLogJSON(entries)
+	default:
+		return nil, fmt.Errorf("unknown event log format '%s'", format)
+	}
+}
+
+// marshalEventLogJSON encodes a JSON event log as an indented document ending with a newline.
+func marshalEventLogJSON(entries any) ([]byte, error) {
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// rtmrIndex returns the index of an RTMR given by its name.
+func rtmrIndex(register string) (uint32, error) {
+	var index uint32
+	if _, err := fmt.Sscanf(register, "RTMR%d", &index); err != nil || index > 3 || register != fmt.Sprintf("RTMR%d", index) {
+		return 0, fmt.Errorf("invalid register '%s'", register)
+	}
+	return index, nil
+}
+
+// rtmrPcr returns the TPM PCR an RTMR is written to in TCG2 event logs, the lowest PCR mapped to
+// the RTMR by pcrRegister.
+func rtmrPcr(rtmr uint32) (uint32, error) {
+	switch rtmr {
+	case 0:
+		return 1, nil
+	case 1:
+		return 2, nil
+	case 2:
+		return 8, nil
+	default:
+		return 0, fmt.Errorf("RTMR%d is not mapped to a TPM PCR", rtmr)
+	}
+}
+
+// encodeCryptoAgileEventLog writes a crypto agile TCG event log with SHA384 digests. The index
+// function maps RTMR indices to the register indices of the log.
+func encodeCryptoAgileEventLog(events []LogEvent, index func(rtmr uint32) (uint32, error)) ([]byte, error) {
+	var specID bytes.Buffer
+	specID.WriteString("Spec ID Event03\x00")
+	// Version 2.0 with 64-bit UINTN and SHA384 as the only algorithm.
+	binary.Write(&specID, binary.LittleEndian, struct {
+		PlatformClass                      uint32
+		VersionMinor, VersionMajor, Errata uint8
+		UintnSize                          uint8
+		AlgorithmCount                     uint32
+		AlgorithmID, DigestSize            uint16
+		VendorInfoSize                     uint8
+	}{VersionMajor: 2, UintnSize: 2, AlgorithmCount: 1, AlgorithmID: tpmAlgSha384, DigestSize: 48})
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, struct {
+		Index, Type uint32
+		Digest      [20]byte
+		EventSize   uint32
+	}{Type: evNoAction, EventSize: uint32(specID.Len())})
+	b.Write(specID.Bytes())
+
+	for i, e := range events {
+		rtmr, _ := rtmrIndex(e.Register)
+		idx, err := index(rtmr)
+		if err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		binary.Write(&b, binary.LittleEndian, []uint32{idx, e.Type, 1})
+		binary.Write(&b, binary.LittleEndian, uint16(tpmAlgSha384))
+		b.Write(e.Digest)
+		binary.Write(&b, binary.LittleEndian, uint32(len(e.Data)))
+		b.Write(e.Data)
+	}
+	return b.Bytes(), nil
+}
+
+// encodeCelCborEventLog writes the events as a CEL-CBOR log: an array of records holding the record
+// number, the register in the pcr field (numbered as in the CCEL, 1 to 4 for RTMR0 to RTMR3), the
+// SHA384 digest and the event type and data as pcclient_std content.
+func encodeCelCborEventLog(events []LogEvent) []byte {
+	b := appendCborHead(nil, cborArray, uint64(len(events)))
+	for i, e := range events {
+		rtmr, _ := rtmrIndex(e.Register)
+		b = appendCborHead(b, cborMap, 4)
+		b = appendCborHead(b, cborUint, celRecnum)
+		b = appendCborHead(b, cborUint, uint64(i))
+		b = appendCborHead(b, cborUint, celPcr)
+		b = appendCborHead(b, cborUint, uint64(rtmr+1))
+		b = appendCborHead(b, cborUint, celDigests)
+		b = appendCborHead(b, cborMap, 1)
+		b = appendCborHead(b, cborUint, tpmAlgSha384)
+		b = appendCborBytes(b, e.Digest)
+		b = appendCborHead(b, cborUint, celPcClientStd)
+		b = appendCborHead(b, cborMap, 2)
+		b = appendCborHead(b, cborUint, celEventType)
+		b = appendCborHead(b, cborUint, uint64(e.Type))
+		b = appendCborHead(b, cborUint, celEventData)
+		b = appendCborBytes(b, e.Data)
+	}
+	return b
+}
+
+// parseCelCborEventLog parses a CEL-CBOR log as written by encodeCelCborEventLog. Records without
+// pcclient_std content, such as CEL management records, are skipped.
+func parseCelCborEventLog(data []byte) ([]LogEvent, error) {
+	doc, err := decodeCbor(data)
+	if err != nil {
+		return nil, fmt.Errorf("malformed event log: %w", err)
+	}
+	records, ok := doc.([]any)
+	if !ok {
+		return nil, fmt.Errorf("malformed event log: not an array of records")
+	}
+	var events []LogEvent
+	for i, rec := range records {
+		fields, ok := rec.(map[any]any)
+		if !ok {
+			return nil, fmt.Errorf("record %d is not a map", i)
+		}
+		content, ok := fields[uint64(celPcClientStd)].(map[any]any)
+		if !ok {
+			continue
+		}
+		typ, ok := content[uint64(celEventType)].(uint64)
+		if !ok || typ > 0xffffffff {
+			return nil, fmt.Errorf("record %d has no valid event type", i)
+		}
+		if typ == evNoAction {
+			continue
+		}
+		if _, ok := fields[uint64(celNvIndex)]; ok {
+			return nil, fmt.Errorf("record %d is extended into an NV index", i)
+		}
+		index, ok := fields[uint64(celPcr)].(uint64)
+		if !ok || index > 0xffffffff {
+			return nil, fmt.Errorf("record %d has no valid register index", i)
+		}
+		register, err := ccelRegister(uint32(index))
+		if err != nil {
+			return nil, fmt.Errorf("record %d: %w", i, err)
+		}
+		if register == "" {
+			continue
+		}
+		digests, _ := fields[uint64(celDigests)].(map[any]any)
+		banks := make(map[uint16][]byte, len(digests))
+		for alg, d := range digests {
+			alg, ok1 := alg.(uint64)
+			d, ok2 := d.([]byte)
+			if !ok1 || !ok2 || alg > 0xffff {
+				return nil, fmt.Errorf("record %d has a malformed digest", i)
+			}
+			banks[uint16(alg)] = d
+		}
+		eventData, _ := content[uint64(celEventData)].([]byte)
+		events = append(events, LogEvent{Register: register, Type: uint32(typ), Digest: banks[tpmAlgSha384], Banks: banks, Data: eventData})
+	}
+	return events, nil
+}
+
+// parseJSONEventLog parses the JSON event log representation of this tool.
+func parseJSONEventLog(data []byte) ([]LogEvent, error) {
+	var entries []jsonLogEvent
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("malformed event log: %w", err)
+	}
+	events := make([]LogEvent, 0, len(entries))
+	for i, e := range entries {
+		if _, err := rtmrIndex(e.Register); err != nil {
+			return nil, fmt.Errorf("event %d: %w", i, err)
+		}
+		digest, err := hex.DecodeString(strings.TrimPrefix(e.Digest, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("event %d has a malformed digest: %w", i, err)
+		}
+		eventData, err := hex.DecodeString(e.Data)
+		if err != nil {
+			return nil, fmt.Errorf("event %d has malformed data: %w", i, err)
+		}
+		// Runtime events may be given by their name and payload only, their digest is computed
+		// as the dstack guest agent does.
+		if len(digest) == 0 && e.Name != "" {
+			if e.Type == 0 {
+				e.Type = DstackRuntimeEventType
+			}
+			digest = runtimeEventDigest(e.Type, e.Name, eventData)
+		}
+		events = append(events, LogEvent{Register: e.Register, Type: e.Type, Digest: digest, Name: e.Name, Data: eventData})
+	}
+	return events, nil
+}
@@ -0,0 +1,279 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	tsmReportDir = "/sys/kernel/config/tsm/report"
+	ccelDataPath = "/sys/firmware/acpi/tables/data/CCEL"
+
+	reportDataSize = 64
+)
+
+// Evidence is a quote together with the event log of the TD that produced it.
+type Evidence struct {
+	// Source describes where the evidence was collected from.
+	Source string `json:"source"`
+	// ReportData is the hex-encoded report data requested for the quote.
+	ReportData string `json:"report_data"`
+	// EventLogFormat is the format of the event log.
+	EventLogFormat string `json:"event_log_format"`
+	// FetchedAt is the time the evidence was collected.
+	FetchedAt time.Time `json:"fetched_at"`
+	// QuoteFile and EventLogFile are the file names of the quote and event log in the evidence
+	// directory.
+	QuoteFile    string `json:"quote_file"`
+	EventLogFile string `json:"event_log_file"`
+
+	Quote    []byte `json:"-"`
+	EventLog []byte `json:"-"`
+}
+
+// padReportData zero-pads the report data to its full size.
+func padReportData(reportData []byte) ([]byte, error) {
+	if len(reportData) > reportDataSize {
+		return nil, fmt.Errorf("report data must be at most %d bytes", reportDataSize)
+	}
+	padded := make([]byte, reportDataSize)
+	copy(padded, reportData)
+	return padded, nil
+}
+
+// FetchLocalEvidence collects evidence from within the TD, reading the CCEL event log and
+// requesting a quote through the configfs-tsm interface.
+func FetchLocalEvidence(reportData []byte) (*Evidence, error) {
+	reportData, err := padReportData(reportData)
+	if err != nil {
+		return nil, err
+	}
+	eventLog, err := os.ReadFile(ccelDataPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CCEL event log: %w", err)
+	}
+
+	entry, err := os.MkdirTemp(tsmReportDir, "reproduce-mr-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TSM report: %w", err)
+	}
+	defer os.Remove(entry)
+	if err = os.WriteFile(filepath.Join(entry, "inblob"), reportData, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write TSM report data: %w", err)
+	}
+	quote, err := os.ReadFile(filepath.Join(entry, "outblob"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TSM quote: %w", err)
+	}
+	return newEvidence("local", reportData, quote, eventLog, EventLogCcel)
+}
+
+// sshCommand returns the command running the shell script on the target over ssh. The target is
+// preceded by "--", so that a target starting with "-" cannot pass options to ssh.
+func sshCommand(target, script string) *exec.Cmd {
+	return exec.Command("ssh", "--", target, "sh", "-c", shellQuote(script))
+}
+
+// FetchSSHEvidence collects evidence from a TD over SSH. The remote user must be allowed to use
+// the configfs-tsm interface and to read the CCEL ACPI table.
+func FetchSSHEvidence(target string, reportData []byte) (*Evidence, error) {
+	reportData, err := padReportData(reportData)
+	if err != nil {
+		return nil, err
+	}
+	script := fmt.Sprintf(`set -e
+d=$(mktemp -d %s/reproduce-mr-XXXXXX)
+trap 'rmdir "$d"' EXIT
+echo %s | base64 -d > "$d/inblob"
+base64 -w0 "$d/outblob"
+echo
+base64 -w0 %s
+echo
+`, tsmReportDir, base64.StdEncoding.EncodeToString(reportData), ccelDataPath)
+
+	var stderr bytes.Buffer
+	cmd := sshCommand(target, script)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("ssh failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	lines := strings.Fields(string(out))
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected output from %s", target)
+	}
+	quote, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed quote from %s: %w", target, err)
+	}
+	eventLog, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil {
+		return nil, fmt.Errorf("malformed event log from %s: %w", target, err)
+	}
+	return newEvidence("ssh://"+target, reportData, quote, eventLog, EventLogCcel)
+}
+
+// shellQuote quotes a string for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// FetchAgentEvidence collects evidence through the GetQuote API of the dstack guest agent. The
+// agent is addressed by an http(s) URL or by unix:<path> for its socket.
+func FetchAgentEvidence(agent string, reportData []byte) (*Evidence, error) {
+	reportData, err := padReportData(reportData)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Quote    string `json:"quote"`
+		EventLog string `json:"event_log"`
+	}
+	if err = callAgent(agent, "GetQuote", map[string]string{"report_data": hex.EncodeToString(reportData)}, &result); err != nil {
+		return nil, fmt.Errorf("failed to request quote from guest agent: %w", err)
+	}
+	quote, err := hex.DecodeString(strings.TrimPrefix(result.Quote, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("malformed quote from guest agent: %w", err)
+	}
+	return newEvidence(agent, reportData, quote, []byte(result.EventLog), EventLogDstack)
+}
+
+// callAgent calls a method of the dstack guest agent API with a JSON request and decodes the JSON
+// response into result. The agent is addressed by an http(s) URL or by unix:<path> for its socket.
+func callAgent(agent, method string, request, result any) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	baseURL := strings.TrimSuffix(agent, "/")
+	if socket, ok := strings.CutPrefix(agent, "unix:"); ok {
+		socket = strings.TrimPrefix(socket, "//")
+		client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		baseURL = "http://localhost"
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(baseURL+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("guest agent returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if err = json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("malformed guest agent response: %w", err)
+	}
+	return nil
+}
+
+// AgentEventLog is the event log of a TD as reported by the Info API of the dstack guest agent.
+type AgentEventLog struct {
+	// Events are the events extended into the RTMRs.
+	Events []LogEvent
+	// RTMR3 is the value of RTMR3 reported by the agent, nil if it is not reported.
+	RTMR3 []byte
+}
+
+// FetchAgentEventLog fetches the event log of a TD through the Info API of the dstack guest agent,
+// addressed by an http(s) URL or by unix:<path> for its socket.
+func FetchAgentEventLog(agent string) (*AgentEventLog, error) {
+	var info struct {
+		TcbInfo json.RawMessage `json:"tcb_info"`
+	}
+	if err := callAgent(agent, "Info", map[string]string{}, &info); err != nil {
+		return nil, fmt.Errorf("failed to request info from guest agent: %w", err)
+	}
+	// The TCB info is a JSON document encoded as a string by most agent versions.
+	tcbInfo := []byte(info.TcbInfo)
+	var encoded string
+	if json.Unmarshal(tcbInfo, &encoded) == nil {
+		tcbInfo = []byte(encoded)
+	}
+	var tcb struct {
+		RTMR3    string          `json:"rtmr3"`
+		EventLog json.RawMessage `json:"event_log"`
+	}
+	if err := json.Unmarshal(tcbInfo, &tcb); err != nil || tcb.EventLog == nil {
+		return nil, fmt.Errorf("guest agent did not return an event log in its TCB info")
+	}
+	events, err := parseDstackEventLog(tcb.EventLog)
+	if err != nil {
+		return nil, err
+	}
+	log := &AgentEventLog{Events: events}
+	if tcb.RTMR3 != "" {
+		if log.RTMR3, err = hex.DecodeString(strings.TrimPrefix(tcb.RTMR3, "0x")); err != nil {
+			return nil, fmt.Errorf("malformed RTMR3 from guest agent: %w", err)
+		}
+	}
+	return log, nil
+}
+
+// newEvidence checks that the quote is a TDX quote over the requested report data.
+func newEvidence(source string, reportData, quote, eventLog []byte, format string) (*Evidence, error) {
+	report, err := ParseQuote(quote)
+	if err != nil {
+		return nil, fmt.Errorf("collected quote is invalid: %w", err)
+	}
+	if !bytes.Equal(report.ReportData, reportData) {
+		return nil, fmt.Errorf("collected quote does not contain the requested report data")
+	}
+	return &Evidence{
+		Source:         source,
+		ReportData:     hex.EncodeToString(reportData),
+		EventLogFormat: format,
+		FetchedAt:      time.Now().UTC(),
+		Quote:          quote,
+		EventLog:       eventLog,
+	}, nil
+}
+
+// Write stores the evidence in the given directory as quote.bin, the event log and evidence.json
+// describing them.
+func (e *Evidence) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create evidence directory: %w", err)
+	}
+	e.QuoteFile = "quote.bin"
+	e.EventLogFile = "event_log.bin"
+	if e.EventLogFormat == EventLogDstack {
+		e.EventLogFile = "event_log.json"
+	}
+	if err := os.WriteFile(filepath.Join(dir, e.QuoteFile), e.Quote, 0o644); err != nil {
+		return fmt.Errorf("failed to write quote: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, e.EventLogFile), e.EventLog, 0o644); err != nil {
+		return fmt.Errorf("failed to write event log: %w", err)
+	}
+	data, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(dir, "evidence.json"), append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write evidence description: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSSHCommandTarget(t *testing.T) {
+	for _, target := range []string{"root@td", "-oProxyCommand=touch /tmp/pwned"} {
+		args := sshCommand(target, "true").Args
+		// ssh stops parsing options at "--", so the target is always taken as the destination.
+		if i := slices.Index(args, target); i < 2 || args[i-1] != "--" || slices.Index(args, "--") != 1 {
+			t.Errorf("sshCommand(%q) args = %q, want the target right after --", target, args)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fwCfgEventPrefix prefixes event identifiers of fw_cfg files measured into RTMR0.
+const fwCfgEventPrefix = "fw-cfg:"
+
+// FwCfgEvent returns the RTMR0 event identifier of the given fw_cfg file.
+func FwCfgEvent(name string) string {
+	return fwCfgEventPrefix + name
+}
+
+// fwCfgGenerators synthesize the contents of fw_cfg files generated by QEMU.
+var fwCfgGenerators = map[string]func(memorySize uint64, cpuCount uint32, profile *Profile) []byte{
+	// Reboot timeout in milliseconds as a 32-bit signed integer, -1 (disabled) by default.
+	"etc/boot-fail-wait": func(uint64, uint32, *Profile) []byte {
+		return []byte{0xff, 0xff, 0xff, 0xff}
+	},
+	// E820 memory map derived from the memory size.
+	"etc/e820": func(memorySize uint64, _ uint32, _ *Profile) []byte {
+		return buildE820Table(memorySize)
+	},
+	// End of the device memory region reserved for hotplugged memory.
+	"etc/reserved-memory-end": func(memorySize uint64, _ uint32, profile *Profile) []byte {
+		return buildReservedMemoryEnd(memorySize, profile.MemoryHotplug)
+	},
+	// ACPI sleep states S0-S5 of the chipset power management device.
+	"etc/system-states": func(_ uint64, _ uint32, profile *Profile) []byte {
+		return buildSystemStates(profile.FwCfg)
+	},
+	// Number of extra PCI root buses.
+	"etc/extra-pci-roots": func(_ uint64, _ uint32, profile *Profile) []byte {
+		return buildExtraPciRoots(profile.FwCfg)
+	},
+	// Boot menu timeout.
+	"etc/boot-menu-wait": func(_ uint64, _ uint32, profile *Profile) []byte {
+		return buildBootMenuWait(profile.FwCfg)
+	},
+}
+
+// fwCfgEvents computes the events of all fw_cfg files referenced by the RTMR0 events of the profile
+// and records their contents as intermediates. User-supplied file contents take precedence over
+// generated ones.
+func (m *TdxMeasurements) fwCfgEvents(profile *Profile, files map[string][]byte, memorySize uint64, cpuCount uint32) (map[string]measuredEvent, error) {
+	events := make(map[string]measuredEvent)
+	for _, id := range profile.Rtmr0Events {
+		name, ok := strings.CutPrefix(id, fwCfgEventPrefix)
+		if !ok {
+			continue
+		}
+
+		data, supplied := files[name]
+		note := "supplied contents"
+		if !supplied {
+			generator, ok := fwCfgGenerators[name]
+			if !ok {
+				return nil, fmt.Errorf("no contents supplied for fw_cfg file '%s' and no generator available", name)
+			}
+			if data = generator(memorySize, cpuCount, profile); data == nil {
+				return nil, fmt.Errorf("fw_cfg file '%s' is not provided by QEMU for this configuration", name)
+			}
+			note = "generated contents"
+		}
+		digest := m.measureIntermediate("fw_cfg_"+strings.ReplaceAll(name, "/", "_")+".bin", data)
+		events[id] = measuredEvent{name: "fw_cfg " + name, eventType: evPlatformConfigFlags, digest: digest, data: data, status: CoverageModeled, note: note}
+	}
+	return events, nil
+}
+
+// WithFwCfgEvents returns a copy of the profile that additionally measures the given fw_cfg files
+// into RTMR0, right before the BootOrder variable.
+func (p *Profile) WithFwCfgEvents(names []string) *Profile {
+	if len(names) == 0 {
+		return p
+	}
+
+	var extra []string
+	for _, name := range names {
+		extra = append(extra, FwCfgEvent(name))
+	}
+
+	cp := *p
+	cp.Rtmr0Events = nil
+	inserted := false
+	for _, id := range p.Rtmr0Events {
+		if id == EventBootOrder && !inserted {
+			cp.Rtmr0Events = append(cp.Rtmr0Events, extra...)
+			inserted = true
+		}
+		cp.Rtmr0Events = append(cp.Rtmr0Events, id)
+	}
+	if !inserted {
+		cp.Rtmr0Events = append(cp.Rtmr0Events, extra...)
+	}
+	return &cp
+}
+
+// WithInitrdSizeAlignment returns a copy of the profile that rounds the initrd size written into
+// the kernel boot header up to the given alignment.
+func (p *Profile) WithInitrdSizeAlignment(alignment uint32) *Profile {
+	cp := *p
+	cp.InitrdSizeAlignment = alignment
+	return &cp
+}
+
+// WithKernelPatch returns a copy of the profile that modifies the kernel boot header according to
+// the given mode before measuring it.
+func (p *Profile) WithKernelPatch(mode KernelPatchMode) *Profile {
+	cp := *p
+	cp.KernelPatch = mode
+	return &cp
+}
+
+// WithInitrdDelivery returns a copy of the profile that measures the initrd as delivered in the
+// given way.
+func (p *Profile) WithInitrdDelivery(delivery InitrdDelivery) *Profile {
+	cp := *p
+	cp.InitrdDelivery = delivery
+	return &cp
+}
@@ -0,0 +1,69 @@
+package internal
+
+import "encoding/binary"
+
+// qemuDefaultS4Value is the S4 sleep type value QEMU reports for the ICH9 and PIIX4 chipsets.
+const qemuDefaultS4Value = 2
+
+// FwCfgSettings configures miscellaneous fw_cfg files whose presence and contents depend on the
+// machine type and the QEMU command line. Each file is only generated for profiles that configure
+// it; measuring an unconfigured file is an error rather than a guess.
+type FwCfgSettings struct {
+	// SystemStates configures the ACPI sleep states in etc/system-states.
+	SystemStates *SystemStates `json:"system_states,omitempty"`
+	// ExtraPciRoots is the number of extra PCI root buses (pxb devices) in etc/extra-pci-roots.
+	// QEMU only provides the file when there is at least one.
+	ExtraPciRoots uint64 `json:"extra_pci_roots,omitempty"`
+	// BootMenuWait is the boot menu timeout in milliseconds in etc/boot-menu-wait (-boot
+	// menu=on,splash-time=N). QEMU only provides the file when a timeout is set.
+	BootMenuWait *uint16 `json:"boot_menu_wait,omitempty"`
+}
+
+// SystemStates is the ACPI sleep state configuration of the chipset power management device
+// (-global ICH9-LPC.disable_s3=1 and similar).
+type SystemStates struct {
+	DisableS3 bool `json:"disable_s3,omitempty"`
+	DisableS4 bool `json:"disable_s4,omitempty"`
+	// S4Value is the sleep type value of S4, zero selects the QEMU default of 2.
+	S4Value uint8 `json:"s4_value,omitempty"`
+}
+
+// buildSystemStates synthesizes the etc/system-states fw_cfg file. Each of the six bytes describes
+// the sleep states S0 to S5: bit 7 marks the state as enabled and the low bits hold its sleep type
+// value, as set up by acpi_pm1_cnt_init in QEMU.
+func buildSystemStates(settings *FwCfgSettings) []byte {
+	if settings == nil || settings.SystemStates == nil {
+		return nil
+	}
+	s := settings.SystemStates
+	s4Value := s.S4Value
+	if s4Value == 0 {
+		s4Value = qemuDefaultS4Value
+	}
+	states := []byte{128, 0, 0, 1, s4Value, 128}
+	if !s.DisableS3 {
+		states[3] |= 0x80
+	}
+	if !s.DisableS4 {
+		states[4] |= 0x80
+	}
+	return states
+}
+
+// buildExtraPciRoots synthesizes the etc/extra-pci-roots fw_cfg file, the little-endian 64-bit
+// number of extra PCI root buses.
+func buildExtraPciRoots(settings *FwCfgSettings) []byte {
+	if settings == nil || settings.ExtraPciRoots == 0 {
+		return nil
+	}
+	return binary.LittleEndian.AppendUint64(nil, settings.ExtraPciRoots)
+}
+
+// buildBootMenuWait synthesizes the etc/boot-menu-wait fw_cfg file, the little-endian 16-bit boot
+// menu timeout in milliseconds.
+func buildBootMenuWait(settings *FwCfgSettings) []byte {
+	if settings == nil || settings.BootMenuWait == nil {
+		return nil
+	}
+	return binary.LittleEndian.AppendUint16(nil, *settings.BootMenuWait)
+}
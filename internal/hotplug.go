@@ -0,0 +1,62 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// maxMemorySlots is the maximum number of memory hotplug slots supported by QEMU.
+const maxMemorySlots = 256
+
+// MemoryHotplug is the memory hotplug configuration of a guest (-m size,slots=N,maxmem=X).
+type MemoryHotplug struct {
+	// Slots is the number of hotplug memory slots.
+	Slots uint32
+	// MaxMemory is the maximum amount of guest memory in megabytes.
+	MaxMemory uint64
+}
+
+// validate checks the hotplug configuration against the initial memory size in megabytes.
+func (h *MemoryHotplug) validate(memorySize uint64) error {
+	if h.Slots == 0 || h.Slots > maxMemorySlots {
+		return fmt.Errorf("memory hotplug slots must be between 1 and %d", maxMemorySlots)
+	}
+	if h.MaxMemory <= memorySize {
+		return fmt.Errorf("maximum memory %dM must be larger than the memory size %dM", h.MaxMemory, memorySize)
+	}
+	return nil
+}
+
+// reservedMemoryEnd returns the end of the device memory region reserved for hotplugged memory
+// above the guest RAM, as QEMU reports it in the etc/reserved-memory-end fw_cfg file. The region
+// starts at the first 1 GiB boundary after the RAM above 4 GiB and is sized for the memory that
+// can be added plus 1 GiB of alignment per slot.
+func (h *MemoryHotplug) reservedMemoryEnd(memorySize uint64) uint64 {
+	const gib = 1 << 30
+	_, above4g := splitGuestMemory(memorySize)
+	base := alignUp(0x100000000+above4g, gib)
+	size := (h.MaxMemory-memorySize)*1024*1024 + uint64(h.Slots)*gib
+	return alignUp(base+size, gib)
+}
+
+// alignUp rounds v up to the given power of two alignment.
+func alignUp(v, alignment uint64) uint64 {
+	return (v + alignment - 1) &^ (alignment - 1)
+}
+
+// WithMemoryHotplug returns a copy of the profile for guests with the given memory hotplug
+// configuration.
+func (p *Profile) WithMemoryHotplug(hotplug *MemoryHotplug) *Profile {
+	cp := *p
+	cp.MemoryHotplug = hotplug
+	return &cp
+}
+
+// buildReservedMemoryEnd synthesizes the etc/reserved-memory-end fw_cfg file, which QEMU only
+// provides when memory hotplug is configured.
+func buildReservedMemoryEnd(memorySize uint64, hotplug *MemoryHotplug) []byte {
+	if hotplug == nil {
+		return nil
+	}
+	return binary.LittleEndian.AppendUint64(nil, hotplug.reservedMemoryEnd(memorySize))
+}
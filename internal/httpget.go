@@ -0,0 +1,33 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+func httpGet(url string) ([]byte, error) {
+	data, _, err := httpGetHeader(url, "")
+	return data, err
+}
+
+// httpGetHeader downloads url and also returns the value of the given response header.
+func httpGetHeader(url, header string) ([]byte, string, error) {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get(header), nil
+}
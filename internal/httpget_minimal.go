@@ -0,0 +1,16 @@
+//go:build minimal
+
+package internal
+
+import "fmt"
+
+// httpGet fails in minimal builds, which do not include an HTTP client. Templates and fixture
+// archives have to be provided locally.
+func httpGet(url string) ([]byte, error) {
+	return nil, fmt.Errorf("cannot download %s: minimal builds do not support downloads", url)
+}
+
+// httpGetHeader fails in minimal builds like httpGet.
+func httpGetHeader(url, header string) ([]byte, string, error) {
+	return nil, "", fmt.Errorf("cannot download %s: minimal builds do not support downloads", url)
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// Evidence formats accepted by ParseEvidence.
+const (
+	// EvidenceTdxQuote is a TDX quote generated by the quoting enclave.
+	EvidenceTdxQuote = "tdx-quote"
+	// EvidenceTdReport is a raw TDREPORT as returned by TDG.MR.REPORT.
+	EvidenceTdReport = "td-report"
+	// EvidenceHclReport is the attestation report of the Hyper-V paravisor (HCL), read from the
+	// vTPM NV index 0x01400001 of Hyper-V and Azure TD guests. It holds the TDREPORT of the L1 TD
+	// and the runtime claims bound to it by its report data.
+	EvidenceHclReport = "hcl-report"
+)
+
+const (
+	tdReportSize        = 1024
+	tdReportTypeTdx     = 0x81
+	tdReportTdInfoStart = 512
+
+	hclSignature        = 0x414C4348 // "HCLA"
+	hclHeaderSize       = 32
+	hclHwReportSize     = 1184
+	hclRequestDataSize  = 20
+	hclReportTypeTdx    = 4
+	hclHashTypeSha256   = 1
+	hclHashTypeSha384   = 2
+	hclHashTypeSha512   = 3
+	hclRequestDataStart = hclHeaderSize + hclHwReportSize
+)
+
+// ParsedEvidence is the TD report of a piece of attestation evidence together with the data bound
+// to it.
+type ParsedEvidence struct {
+	Format string
+	Report *TdReport
+	// RuntimeClaims are the JSON runtime claims of an HCL report, e.g. the vTPM attestation key and
+	// the VM configuration, nil for other formats.
+	RuntimeClaims json.RawMessage
+}
+
+// ParseEvidence decodes a TDX quote, a raw TDREPORT or a Hyper-V HCL report. The formats are told
+// apart by their leading bytes. Signatures are not verified, but the binding of the runtime claims
+// of HCL reports to their TD report is.
+func ParseEvidence(data []byte) (*ParsedEvidence, error) {
+	switch {
+	case len(data) >= 4 && binary.LittleEndian.Uint32(data[0:4]) == hclSignature:
+		return parseHclReport(data)
+	case len(data) >= tdReportSize && data[0] == tdReportTypeTdx:
+		report, err := ParseTdReport(data)
+		if err != nil {
+			return nil, err
+		}
+		return &ParsedEvidence{Format: EvidenceTdReport, Report: report}, nil
+	default:
+		report, err := ParseQuote(data)
+		if err != nil {
+			return nil, err
+		}
+		return &ParsedEvidence{Format: EvidenceTdxQuote, Report: report}, nil
+	}
+}
+
+// ParseTdReport decodes a raw TDREPORT. The MAC of the report is not verified, which is only
+// possible on the platform that produced it.
+func ParseTdReport(data []byte) (*TdReport, error) {
+	if len(data) < tdReportSize {
+		return nil, fmt.Errorf("TD report too short (%d bytes)", len(data))
+	}
+	if data[0] != tdReportTypeTdx {
+		return nil, fmt.Errorf("not a TDX TD report (report type 0x%x)", data[0])
+	}
+	// REPORTMACSTRUCT, TEE_TCB_INFO at offset 256 and TDINFO at offset 512.
+	info := data[tdReportTdInfoStart:tdReportSize]
+	report := &TdReport{
+		TeeTcbSvn:      data[264:280],
+		MrSeam:         data[280:328],
+		MrSignerSeam:   data[328:376],
+		SeamAttributes: data[376:384],
+		TdAttributes:   info[0:8],
+		Xfam:           info[8:16],
+		MRTD:           info[16:64],
+		MrConfigId:     info[64:112],
+		MrOwner:        info[112:160],
+		MrOwnerConfig:  info[160:208],
+		RTMR0:          info[208:256],
+		RTMR1:          info[256:304],
+		RTMR2:          info[304:352],
+		RTMR3:          info[352:400],
+		ReportData:     data[128:192],
+	}
+	// Version 1 reports are produced by TDX 1.5 modules.
+	if data[2] >= 1 {
+		report.TeeTcbSvn2 = data[384:400]
+		report.MrServiceTd = info[400:448]
+	}
+	return report, nil
+}
+
+// parseHclReport decodes a Hyper-V HCL report and checks that its report data is the digest of its
+// runtime claims.
+func parseHclReport(data []byte) (*ParsedEvidence, error) {
+	if len(data) < hclRequestDataStart+hclRequestDataSize {
+		return nil, fmt.Errorf("HCL report too short (%d bytes)", len(data))
+	}
+	request := data[hclRequestDataStart:]
+	if reportType := binary.LittleEndian.Uint32(request[8:12]); reportType != hclReportTypeTdx {
+		return nil, fmt.Errorf("HCL report is not of a TD (report type %d)", reportType)
+	}
+	claimsSize := uint64(binary.LittleEndian.Uint32(request[16:20]))
+	if claimsSize > uint64(len(request)-hclRequestDataSize) {
+		return nil, fmt.Errorf("HCL runtime claims exceed the report")
+	}
+	claims := request[hclRequestDataSize : hclRequestDataSize+claimsSize]
+
+	report, err := ParseTdReport(data[hclHeaderSize : hclHeaderSize+hclHwReportSize])
+	if err != nil {
+		return nil, err
+	}
+	var digest []byte
+	switch hashType := binary.LittleEndian.Uint32(request[12:16]); hashType {
+	case hclHashTypeSha256:
+		h := sha256.Sum256(claims)
+		digest = h[:]
+	case hclHashTypeSha384:
+		h := sha512.Sum384(claims)
+		digest = h[:]
+	case hclHashTypeSha512:
+		h := sha512.Sum512(claims)
+		digest = h[:]
+	default:
+		return nil, fmt.Errorf("unsupported HCL report data hash type %d", hashType)
+	}
+	if !bytes.Equal(report.ReportData[:len(digest)], digest) {
+		return nil, fmt.Errorf("HCL runtime claims are not bound to the TD report, its report data does not match their digest")
+	}
+	if !json.Valid(claims) {
+		return nil, fmt.Errorf("malformed HCL runtime claims")
+	}
+	return &ParsedEvidence{Format: EvidenceHclReport, Report: report, RuntimeClaims: claims}, nil
+}
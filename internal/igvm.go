@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+)
+
+// IGVM is the Independent Guest Virtual Machine file format in which Hyper-V and OpenVMM ship the
+// paravisor that runs as the L1 VMM of partitioned TDs. The file lists the pages the loader adds to
+// the TD before it starts, in the order they are added.
+// See: https://github.com/microsoft/igvm
+const (
+	igvmMagic = 0x4D564749 // "IGVM"
+
+	igvmFixedHeaderSizeV1 = 24
+	igvmFixedHeaderSizeV2 = 32
+
+	igvmVhtSupportedPlatform = 0x001
+	igvmVhtParameterArea     = 0x301
+	igvmVhtPageData          = 0x302
+	igvmVhtParameterInsert   = 0x303
+	// igvmVhtOptional marks variable headers a loader may ignore if it does not know them.
+	igvmVhtOptional = 0x80000000
+
+	igvmPlatformTdx = 0x03
+
+	igvmPageDataIs2MB       = 1 << 0
+	igvmPageDataUnmeasured  = 1 << 1
+	igvmPageDataShared      = 1 << 2
+	igvmLargePageSize       = 0x200000
+	igvmVariableHeaderAlign = 8
+)
+
+// igvmPage is a page the IGVM loader adds to the TD.
+type igvmPage struct {
+	gpa uint64
+	// data is the page content, nil for zero pages.
+	data     []byte
+	measured bool
+	// parameter is set for pages of parameter areas, whose content the loader fills in at launch.
+	parameter bool
+}
+
+// parseIgvmTdxPages returns the pages an IGVM file adds to a TDX TD in the order they are added.
+// Shared pages are not part of the TD and are skipped.
+func parseIgvmTdxPages(data []byte) ([]igvmPage, error) {
+	if len(data) < igvmFixedHeaderSizeV1 || binary.LittleEndian.Uint32(data[0:4]) != igvmMagic {
+		return nil, fmt.Errorf("not an IGVM file")
+	}
+	version := binary.LittleEndian.Uint32(data[4:8])
+	if version != 1 && version != 2 {
+		return nil, fmt.Errorf("unsupported IGVM format version %d", version)
+	}
+	if version == 2 && len(data) < igvmFixedHeaderSizeV2 {
+		return nil, fmt.Errorf("truncated IGVM fixed header")
+	}
+	offset := uint64(binary.LittleEndian.Uint32(data[8:12]))
+	size := uint64(binary.LittleEndian.Uint32(data[12:16]))
+	if offset+size > uint64(len(data)) {
+		return nil, fmt.Errorf("IGVM variable headers exceed the file")
+	}
+	headers := data[offset : offset+size]
+
+	var (
+		tdxMask        uint32
+		pages          []igvmPage
+		parameterAreas = make(map[uint32]uint64)
+	)
+	for pos := 0; pos < len(headers); {
+		if pos+8 > len(headers) {
+			return nil, fmt.Errorf("truncated IGVM variable header at offset %d", pos)
+		}
+		typ := binary.LittleEndian.Uint32(headers[pos:]) &^ igvmVhtOptional
+		length := int(binary.LittleEndian.Uint32(headers[pos+4:]))
+		if length > len(headers)-pos-8 {
+			return nil, fmt.Errorf("IGVM variable header at offset %d exceeds the headers", pos)
+		}
+		body := headers[pos+8 : pos+8+length]
+		pos += 8 + (length+igvmVariableHeaderAlign-1)/igvmVariableHeaderAlign*igvmVariableHeaderAlign
+
+		switch typ {
+		case igvmVhtSupportedPlatform:
+			if len(body) < 16 {
+				return nil, fmt.Errorf("malformed IGVM supported platform header")
+			}
+			if body[5] == igvmPlatformTdx {
+				tdxMask |= binary.LittleEndian.Uint32(body[0:4])
+			}
+		case igvmVhtParameterArea:
+			if len(body) < 16 {
+				return nil, fmt.Errorf("malformed IGVM parameter area header")
+			}
+			parameterAreas[binary.LittleEndian.Uint32(body[8:12])] = binary.LittleEndian.Uint64(body[0:8])
+		case igvmVhtPageData:
+			if len(body) < 24 {
+				return nil, fmt.Errorf("malformed IGVM page data header")
+			}
+			gpa := binary.LittleEndian.Uint64(body[0:8])
+			mask := binary.LittleEndian.Uint32(body[8:12])
+			fileOffset := uint64(binary.LittleEndian.Uint32(body[12:16]))
+			flags := binary.LittleEndian.Uint32(body[16:20])
+			if mask&tdxMask == 0 || flags&igvmPageDataShared != 0 {
+				continue
+			}
+			length := uint64(pageSize)
+			if flags&igvmPageDataIs2MB != 0 {
+				length = igvmLargePageSize
+			}
+			var content []byte
+			if fileOffset != 0 {
+				if fileOffset+length > uint64(len(data)) {
+					return nil, fmt.Errorf("IGVM page data for GPA 0x%x exceeds the file", gpa)
+				}
+				content = data[fileOffset : fileOffset+length]
+			}
+			// Large pages are added to the TD as 4 KiB pages.
+			for page := uint64(0); page < length/pageSize; page++ {
+				p := igvmPage{gpa: gpa + page*pageSize, measured: flags&igvmPageDataUnmeasured == 0}
+				if content != nil {
+					p.data = content[page*pageSize : (page+1)*pageSize]
+				}
+				pages = append(pages, p)
+			}
+		case igvmVhtParameterInsert:
+			if len(body) < 16 {
+				return nil, fmt.Errorf("malformed IGVM parameter insert header")
+			}
+			gpa := binary.LittleEndian.Uint64(body[0:8])
+			if binary.LittleEndian.Uint32(body[8:12])&tdxMask == 0 {
+				continue
+			}
+			index := binary.LittleEndian.Uint32(body[12:16])
+			areaSize, ok := parameterAreas[index]
+			if !ok {
+				return nil, fmt.Errorf("IGVM parameter insert references unknown parameter area %d", index)
+			}
+			for page := uint64(0); page < (areaSize+pageSize-1)/pageSize; page++ {
+				pages = append(pages, igvmPage{gpa: gpa + page*pageSize, parameter: true})
+			}
+		}
+	}
+	if tdxMask == 0 {
+		return nil, fmt.Errorf("IGVM file does not support the TDX platform")
+	}
+	return pages, nil
+}
+
+// MeasureIgvmMrtd computes the MRTD of a TD built from an IGVM file. Every page is added with
+// TDH.MEM.PAGE.ADD and measured pages are extended with TDH.MR.EXTEND right after they are added.
+// Parameter area pages are added without being extended, as their contents are only known at
+// launch; the returned flag reports whether the file has any.
+func MeasureIgvmMrtd(data []byte) ([]byte, bool, error) {
+	pages, err := parseIgvmTdxPages(data)
+	if err != nil {
+		return nil, false, err
+	}
+
+	h := sha512.New384()
+	var (
+		pageAdd    [128]byte
+		record     [128]byte
+		zero       [mrExtendGranularity]byte
+		parameters bool
+	)
+	copy(pageAdd[:12], "MEM.PAGE.ADD")
+	copy(record[:9], "MR.EXTEND")
+	for _, p := range pages {
+		binary.LittleEndian.PutUint64(pageAdd[16:24], p.gpa)
+		_, _ = h.Write(pageAdd[:])
+		parameters = parameters || p.parameter
+		if !p.measured {
+			continue
+		}
+		for i := uint64(0); i < pageSize; i += mrExtendGranularity {
+			binary.LittleEndian.PutUint64(record[16:24], p.gpa+i)
+			_, _ = h.Write(record[:])
+			if p.data != nil {
+				_, _ = h.Write(p.data[i : i+mrExtendGranularity])
+			} else {
+				_, _ = h.Write(zero[:])
+			}
+		}
+	}
+	return h.Sum(nil), parameters, nil
+}
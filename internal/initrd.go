@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"bytes"
+	"debug/pe"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Initrd measurement modes.
+const (
+	// InitrdModeConcat measures the early CPIO archives and the main archive as one blob, which is
+	// what QEMU loads when given a combined initrd.
+	InitrdModeConcat = "concat"
+	// InitrdModeMain measures only the main archive without any early CPIO archives.
+	InitrdModeMain = "main"
+)
+
+// InitrdDelivery describes how the initrd reaches the kernel, which determines where it is measured.
+type InitrdDelivery string
+
+const (
+	// InitrdDeliveryFwCfg is the QEMU default: the initrd is given with -initrd and loaded through
+	// fw_cfg, its size is written into the kernel boot header and OVMF measures it into RTMR2.
+	InitrdDeliveryFwCfg InitrdDelivery = "fw-cfg"
+	// InitrdDeliveryCmdline loads the initrd named by an initrd= argument of the kernel command line
+	// in the kernel EFI stub. QEMU does not know about it, so the boot header carries no initrd
+	// size, and the stub measures it into RTMR2 after the command line.
+	InitrdDeliveryCmdline InitrdDelivery = "cmdline"
+	// InitrdDeliveryUki embeds the initrd in the .initrd section of a unified kernel image. It is
+	// covered by the Authenticode hash of the kernel image in RTMR1 and not measured separately.
+	InitrdDeliveryUki InitrdDelivery = "uki"
+)
+
+// ParseInitrdDelivery parses an initrd delivery name. The empty name selects fw_cfg delivery.
+func ParseInitrdDelivery(name string) (InitrdDelivery, error) {
+	switch d := InitrdDelivery(name); d {
+	case "":
+		return InitrdDeliveryFwCfg, nil
+	case InitrdDeliveryFwCfg, InitrdDeliveryCmdline, InitrdDeliveryUki:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unsupported initrd delivery '%s'", name)
+	}
+}
+
+// validateInitrdDelivery checks that the inputs match the initrd delivery. The kernel is only
+// checked when it is given.
+func validateInitrdDelivery(delivery InitrdDelivery, kernelData []byte, initrdSize uint64, kernelCmdline string) error {
+	switch delivery {
+	case InitrdDeliveryCmdline:
+		if !hasInitrdArgument(kernelCmdline) {
+			return fmt.Errorf("initrd delivery '%s' requires an initrd= argument on the kernel command line", delivery)
+		}
+	case InitrdDeliveryUki:
+		if initrdSize > 0 {
+			return fmt.Errorf("initrd delivery '%s' takes the initrd from the kernel image, no separate initrd can be given", delivery)
+		}
+		if len(kernelData) > 0 && !hasUkiInitrd(kernelData) {
+			return fmt.Errorf("initrd delivery '%s' requires a unified kernel image with an .initrd section", delivery)
+		}
+	}
+	return nil
+}
+
+// hasInitrdArgument returns whether the kernel command line names an initrd to load.
+func hasInitrdArgument(cmdline string) bool {
+	for _, arg := range strings.Fields(cmdline) {
+		if strings.HasPrefix(arg, "initrd=") && len(arg) > len("initrd=") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUkiInitrd returns whether the kernel is a PE image with an embedded initrd.
+func hasUkiInitrd(kernelData []byte) bool {
+	f, err := pe.NewFile(bytes.NewReader(kernelData))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	return f.Section(".initrd") != nil
+}
+
+// SplitInitrd splits an initrd into its leading uncompressed CPIO archives (e.g. early microcode)
+// and the main archive, which is empty if the initrd consists of uncompressed CPIO archives only.
+func SplitInitrd(data []byte) ([]byte, []byte, error) {
+	var offset int
+	for offset+6 <= len(data) && (string(data[offset:offset+6]) == "070701" || string(data[offset:offset+6]) == "070702") {
+		end, err := skipCpioArchive(data, offset)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Archives are padded with zeros, usually to a 512 byte boundary.
+		for end < len(data) && data[end] == 0x00 {
+			end++
+		}
+		offset = end
+	}
+	return data[:offset], data[offset:], nil
+}
+
+// skipCpioArchive returns the offset right after the trailer of the newc CPIO archive starting at
+// the given offset.
+func skipCpioArchive(data []byte, offset int) (int, error) {
+	const headerSize = 110
+	align4 := func(v int) int { return (v + 3) &^ 3 }
+
+	for {
+		if offset+headerSize > len(data) {
+			return 0, fmt.Errorf("truncated CPIO header at offset %d", offset)
+		}
+		hdr := data[offset : offset+headerSize]
+		if !bytes.HasPrefix(hdr, []byte("07070")) {
+			return 0, fmt.Errorf("malformed CPIO header at offset %d", offset)
+		}
+		fileSize, err1 := strconv.ParseUint(string(hdr[54:62]), 16, 32)
+		nameSize, err2 := strconv.ParseUint(string(hdr[94:102]), 16, 32)
+		if err1 != nil || err2 != nil || nameSize == 0 {
+			return 0, fmt.Errorf("malformed CPIO header at offset %d", offset)
+		}
+
+		nameEnd := offset + headerSize + int(nameSize)
+		dataStart := align4(nameEnd)
+		dataEnd := align4(dataStart + int(fileSize))
+		if nameEnd > len(data) || dataStart+int(fileSize) > len(data) {
+			return 0, fmt.Errorf("truncated CPIO entry at offset %d", offset)
+		}
+		name := string(bytes.TrimRight(data[offset+headerSize:nameEnd], "\x00"))
+		if name == "TRAILER!!!" {
+			return min(dataEnd, len(data)), nil
+		}
+		offset = dataEnd
+	}
+}
+
+// PrepareInitrd assembles the initrd blob that is measured from an optional separate early
+// microcode CPIO archive and the initrd according to the given mode. It also returns a description
+// of the measured layout.
+func PrepareInitrd(microcode, initrd []byte, mode string) ([]byte, string, error) {
+	early, main, err := SplitInitrd(initrd)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse initrd: %w", err)
+	}
+	early = append(append([]byte{}, microcode...), early...)
+
+	switch mode {
+	case InitrdModeConcat:
+		if len(early) == 0 {
+			return initrd, "main archive only (no early CPIO present)", nil
+		}
+		blob := append(early, main...)
+		return blob, fmt.Sprintf("early CPIO (%d bytes) concatenated with main archive (%d bytes)", len(early), len(main)), nil
+	case InitrdModeMain:
+		if len(main) == 0 {
+			return nil, "", fmt.Errorf("initrd mode '%s' found no compressed main archive after the uncompressed CPIO archives (%d bytes), use '%s' to measure the whole initrd", mode, len(early), InitrdModeConcat)
+		}
+		return main, fmt.Sprintf("main archive only (%d bytes), early CPIO (%d bytes) excluded", len(main), len(early)), nil
+	default:
+		return nil, "", fmt.Errorf("unsupported initrd mode '%s'", mode)
+	}
+}
@@ -0,0 +1,52 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// cpioArchive returns a newc CPIO archive holding a single file, padded to 512 bytes as the kernel
+// build does for early CPIO archives.
+func cpioArchive(name string, data []byte) []byte {
+	var b []byte
+	entry := func(name string, data []byte) {
+		b = fmt.Appendf(b, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x", 0, 0o100644, 0, 0, 1, 0, len(data), 0, 0, 0, 0, len(name)+1, 0)
+		b = append(append(b, name...), 0)
+		b = append(b, make([]byte, (4-len(b)%4)%4)...)
+		b = append(b, data...)
+		b = append(b, make([]byte, (4-len(b)%4)%4)...)
+	}
+	entry(name, data)
+	entry("TRAILER!!!", nil)
+	return append(b, make([]byte, (512-len(b)%512)%512)...)
+}
+
+func TestPrepareInitrd(t *testing.T) {
+	early := cpioArchive("kernel/x86/microcode/GenuineIntel.bin", []byte("microcode"))
+	main := []byte{0x1f, 0x8b, 0x08, 0x00, 'm', 'a', 'i', 'n'}
+	initrd := append(append([]byte{}, early...), main...)
+
+	blob, _, err := PrepareInitrd(nil, initrd, InitrdModeMain)
+	if err != nil || !bytes.Equal(blob, main) {
+		t.Errorf("main mode = %x, %v, want the main archive", blob, err)
+	}
+	blob, _, err = PrepareInitrd(nil, initrd, InitrdModeConcat)
+	if err != nil || !bytes.Equal(blob, initrd) {
+		t.Errorf("concat mode = %x, %v, want the whole initrd", blob, err)
+	}
+	blob, _, err = PrepareInitrd(early, main, InitrdModeConcat)
+	if err != nil || !bytes.Equal(blob, initrd) {
+		t.Errorf("concat mode with separate microcode = %x, %v, want the microcode followed by the initrd", blob, err)
+	}
+
+	// An initrd of uncompressed CPIO archives only has no main archive to measure on its own.
+	uncompressed := append(append([]byte{}, early...), cpioArchive("init", []byte("#!/bin/sh"))...)
+	if blob, _, err = PrepareInitrd(nil, uncompressed, InitrdModeMain); err == nil {
+		t.Errorf("main mode of an uncompressed initrd = %x, want error", blob)
+	}
+	blob, _, err = PrepareInitrd(nil, uncompressed, InitrdModeConcat)
+	if err != nil || !bytes.Equal(blob, uncompressed) {
+		t.Errorf("concat mode of an uncompressed initrd = %x, %v, want the whole initrd", blob, err)
+	}
+}
@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// InputsManifest pins all inputs of a measurement, so that it can be repeated later with the
+// guarantee that nothing changed.
+type InputsManifest struct {
+	ToolVersion string `json:"tool_version"`
+	Profile     string `json:"profile"`
+	// Parameters maps the names of measurement flags to their values. Repeatable flags hold their
+	// values comma separated.
+	Parameters map[string]string `json:"parameters"`
+	Artifacts  []InputArtifact   `json:"artifacts"`
+}
+
+// InputArtifact is a file read for a measurement. Its name is the flag it was given with, or
+// fw-cfg:<name> for fw_cfg files.
+type InputArtifact struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Size   int    `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// NewInputArtifact describes an artifact read from path.
+func NewInputArtifact(name, path string, data []byte) InputArtifact {
+	digest := sha256.Sum256(data)
+	return InputArtifact{Name: name, Path: path, Size: len(data), Sha256: hex.EncodeToString(digest[:])}
+}
+
+// LoadInputsManifest loads an inputs manifest from a JSON file.
+func LoadInputsManifest(path string) (*InputsManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m InputsManifest
+	if err = json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("malformed inputs manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// Write writes the manifest to path with its artifacts sorted by name.
+func (m *InputsManifest) Write(path string) error {
+	sort.Slice(m.Artifacts, func(i, j int) bool { return m.Artifacts[i].Name < m.Artifacts[j].Name })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// Check compares the artifacts read for a measurement with those pinned by the manifest. It fails
+// if an artifact changed, or if an artifact was read that the manifest does not pin or vice versa.
+func (m *InputsManifest) Check(artifacts []InputArtifact) error {
+	pinned := make(map[string]InputArtifact, len(m.Artifacts))
+	for _, a := range m.Artifacts {
+		pinned[a.Name] = a
+	}
+	var changed []string
+	for _, a := range artifacts {
+		p, ok := pinned[a.Name]
+		if !ok {
+			return fmt.Errorf("artifact %s (%s) is not pinned by the inputs manifest", a.Name, a.Path)
+		}
+		delete(pinned, a.Name)
+		if !strings.EqualFold(p.Sha256, a.Sha256) || p.Size != a.Size {
+			changed = append(changed, fmt.Sprintf("%s (%s)", a.Name, a.Path))
+		}
+	}
+	if len(changed) > 0 {
+		return fmt.Errorf("artifacts changed since the inputs manifest was written: %s", strings.Join(changed, ", "))
+	}
+	if len(pinned) > 0 {
+		missing := make([]string, 0, len(pinned))
+		for name := range pinned {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("artifacts pinned by the inputs manifest were not read: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
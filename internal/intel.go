@@ -0,0 +1,63 @@
+package internal
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IntelMeasurements are register values in the JSON format of Intel's TDX measurement tool: an
+// object mapping upper case register names to hex-encoded values. Registers the tool did not
+// compute are absent.
+type IntelMeasurements struct {
+	registers []Register
+}
+
+// ParseIntelMeasurements parses the JSON output of Intel's TDX measurement tool. Register names
+// are matched case-insensitively and values may carry a 0x prefix; other fields are ignored.
+func ParseIntelMeasurements(data []byte) (*IntelMeasurements, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("malformed measurement tool output: %w", err)
+	}
+	m := &IntelMeasurements{}
+	for _, r := range tdxRegisters(nil, nil, nil, nil, nil) {
+		for key, raw := range doc {
+			if !strings.EqualFold(key, r.Name) {
+				continue
+			}
+			var value string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return nil, fmt.Errorf("register %s is not a string", r.Name)
+			}
+			v, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+			if err != nil || len(v) != 48 {
+				return nil, fmt.Errorf("register %s is not a hex-encoded 48 byte value", r.Name)
+			}
+			m.registers = append(m.registers, Register{Name: r.Name, Value: v})
+			break
+		}
+	}
+	if len(m.registers) == 0 {
+		return nil, fmt.Errorf("measurement tool output contains no registers")
+	}
+	return m, nil
+}
+
+// Registers returns the registers present in the measurement tool output.
+func (m *IntelMeasurements) Registers() []Register {
+	return m.registers
+}
+
+// MarshalIntelMeasurements encodes the computed registers of the set in the JSON format of Intel's
+// TDX measurement tool.
+func MarshalIntelMeasurements(set RegisterSet) ([]byte, error) {
+	doc := make(map[string]string)
+	for _, r := range set.Registers() {
+		if r.Value != nil {
+			doc[r.Name] = hex.EncodeToString(r.Value)
+		}
+	}
+	return json.MarshalIndent(doc, "", "  ")
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Intermediate is a structure synthesized during measurement whose digest is extended into a
+// register.
+type Intermediate struct {
+	// Name is the file name the structure is written to.
+	Name string
+	// Data is the measured contents of the structure.
+	Data []byte
+}
+
+// addIntermediate records a synthesized structure.
+func (m *TdxMeasurements) addIntermediate(name string, data []byte) {
+	m.Intermediates = append(m.Intermediates, Intermediate{Name: name, Data: data})
+}
+
+// measureIntermediate records a synthesized structure and returns its SHA384 digest.
+func (m *TdxMeasurements) measureIntermediate(name string, data []byte) []byte {
+	m.addIntermediate(name, data)
+	return measureSha384(data)
+}
+
+// WriteIntermediates writes all synthesized structures into the given directory together with a
+// SHA384SUMS file listing their digests in the format of sha384sum.
+func (m *TdxMeasurements) WriteIntermediates(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create intermediate output directory: %w", err)
+	}
+	var sums strings.Builder
+	for _, im := range m.Intermediates {
+		if err := os.WriteFile(filepath.Join(dir, im.Name), im.Data, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", im.Name, err)
+		}
+		fmt.Fprintf(&sums, "%s  %s\n", hex.EncodeToString(measureSha384(im.Data)), im.Name)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "SHA384SUMS"), []byte(sums.String()), 0o644); err != nil {
+		return fmt.Errorf("failed to write SHA384SUMS: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// KernelFormat identifies the layout of a kernel image.
+type KernelFormat int
+
+const (
+	KernelFormatUnknown KernelFormat = iota
+	// KernelFormatBzImage is an x86 bzImage with a PE/COFF (EFI stub) header.
+	KernelFormatBzImage
+	// KernelFormatBzImageNoStub is an x86 bzImage without an EFI stub.
+	KernelFormatBzImageNoStub
+	// KernelFormatPE is an x86_64 PE/COFF image without a Linux boot header (e.g. a UKI).
+	KernelFormatPE
+	// KernelFormatPEForeign is a PE/COFF image built for a non-x86_64 machine.
+	KernelFormatPEForeign
+	// KernelFormatArm64Image is an ARM64 "Image" kernel.
+	KernelFormatArm64Image
+)
+
+func (f KernelFormat) String() string {
+	switch f {
+	case KernelFormatBzImage:
+		return "bzImage"
+	case KernelFormatBzImageNoStub:
+		return "bzImage (no EFI stub)"
+	case KernelFormatPE:
+		return "PE/COFF (x86_64)"
+	case KernelFormatPEForeign:
+		return "PE/COFF (foreign machine)"
+	case KernelFormatArm64Image:
+		return "ARM64 Image"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	peMachineAmd64 = 0x8664
+	peMachineArm64 = 0xaa64
+)
+
+// DetectKernelFormat inspects the kernel image headers and returns its format together with the
+// PE machine type (zero when the image has no PE header).
+func DetectKernelFormat(kd []byte) (KernelFormat, uint16) {
+	var machine uint16
+	if len(kd) >= 0x40 && kd[0] == 'M' && kd[1] == 'Z' {
+		peOffset := int(binary.LittleEndian.Uint32(kd[0x3c:0x40]))
+		if peOffset >= 0 && peOffset+6 <= len(kd) && string(kd[peOffset:peOffset+4]) == "PE\x00\x00" {
+			machine = binary.LittleEndian.Uint16(kd[peOffset+4 : peOffset+6])
+		}
+	}
+	hasBootHeader := len(kd) >= 0x206 && string(kd[0x202:0x206]) == "HdrS"
+
+	switch {
+	case len(kd) >= 0x40 && string(kd[0x38:0x3c]) == "ARM\x64":
+		return KernelFormatArm64Image, machine
+	case hasBootHeader && machine == peMachineAmd64:
+		return KernelFormatBzImage, machine
+	case hasBootHeader && machine == 0:
+		return KernelFormatBzImageNoStub, machine
+	case machine == peMachineAmd64:
+		return KernelFormatPE, machine
+	case machine != 0:
+		return KernelFormatPEForeign, machine
+	default:
+		return KernelFormatUnknown, machine
+	}
+}
+
+// KernelPatchMode controls how the kernel boot header is modified before the image is measured.
+type KernelPatchMode string
+
+const (
+	// KernelPatchQemu applies the same header modifications as QEMU does when loading the kernel.
+	KernelPatchQemu KernelPatchMode = "qemu"
+	// KernelPatchNone measures the kernel image as is, for VMMs which do not patch the header.
+	KernelPatchNone KernelPatchMode = "none"
+	// KernelPatchPrepatched measures the kernel image as is, after checking that its header was
+	// already patched by a boot loader.
+	KernelPatchPrepatched KernelPatchMode = "prepatched"
+)
+
+// checkPatchedKernelImage verifies that the boot header of a bzImage was filled in by a boot loader
+// and is consistent with the given initrd size.
+func checkPatchedKernelImage(kd []byte, initRdSize uint32) error {
+	format, _ := DetectKernelFormat(kd)
+	if format != KernelFormatBzImage && format != KernelFormatBzImageNoStub {
+		// Images without a Linux boot header are never patched.
+		return nil
+	}
+	if len(kd) < 0x220 || binary.LittleEndian.Uint16(kd[0x206:0x208]) < 0x200 {
+		return nil
+	}
+	if kd[0x210] == 0 {
+		return fmt.Errorf("kernel boot header was not patched by a boot loader (type_of_loader is not set)")
+	}
+	if size := binary.LittleEndian.Uint32(kd[0x21c:0x220]); size != initRdSize {
+		return fmt.Errorf("patched kernel boot header describes an initrd of %d bytes, but the initrd has %d bytes", size, initRdSize)
+	}
+	return nil
+}
+
+// CheckKernelImage verifies that the kernel image can be measured for a TDX guest booted by QEMU
+// and returns a descriptive error otherwise.
+func CheckKernelImage(kd []byte) error {
+	format, machine := DetectKernelFormat(kd)
+	switch format {
+	case KernelFormatBzImage, KernelFormatPE:
+		return nil
+	case KernelFormatBzImageNoStub:
+		return fmt.Errorf("%w: kernel is a bzImage without an EFI stub, TDVF requires an EFI-bootable kernel", ErrUnsupportedKernel)
+	case KernelFormatArm64Image:
+		return fmt.Errorf("%w: kernel is an ARM64 Image, only x86_64 kernels can be measured for TDX", ErrUnsupportedKernel)
+	case KernelFormatPEForeign:
+		return fmt.Errorf("%w: kernel is a PE image for machine type 0x%04x, only x86_64 (0x%04x) is supported", ErrUnsupportedKernel, machine, peMachineAmd64)
+	default:
+		return fmt.Errorf("%w: kernel format not recognized (no bzImage or PE header found)", ErrUnsupportedKernel)
+	}
+}
@@ -0,0 +1,190 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// KubeClient is a minimal Kubernetes API client for the custom resources used by the operator mode.
+type KubeClient struct {
+	host   string
+	token  string
+	client *http.Client
+}
+
+// NewInClusterKubeClient creates a client using the service account of the pod it runs in.
+func NewInClusterKubeClient() (*KubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster")
+	}
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("malformed cluster CA")
+	}
+	return &KubeClient{
+		host:   "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}},
+	}, nil
+}
+
+// NewKubeClient creates a client for the API server at host authenticating with a bearer token,
+// e.g. through `kubectl proxy` during development.
+func NewKubeClient(host, token string) *KubeClient {
+	return &KubeClient{host: strings.TrimSuffix(host, "/"), token: token, client: http.DefaultClient}
+}
+
+// InClusterNamespace returns the namespace of the pod the client runs in.
+func InClusterNamespace() string {
+	ns, err := os.ReadFile(serviceAccountDir + "/namespace")
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(ns))
+}
+
+// KubeObject is a generic Kubernetes object.
+type KubeObject struct {
+	APIVersion string          `json:"apiVersion"`
+	Kind       string          `json:"kind"`
+	Metadata   KubeMetadata    `json:"metadata"`
+	Spec       json.RawMessage `json:"spec,omitempty"`
+	Status     json.RawMessage `json:"status,omitempty"`
+}
+
+// KubeMetadata is the metadata of a Kubernetes object.
+type KubeMetadata struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	Generation      int64  `json:"generation,omitempty"`
+}
+
+// KubeEvent is a watch event.
+type KubeEvent struct {
+	Type   string     `json:"type"`
+	Object KubeObject `json:"object"`
+}
+
+func (c *KubeClient) do(ctx context.Context, method, path, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.host+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// List lists the objects at the given collection path and returns them with the resource version
+// of the list.
+func (c *KubeClient) List(ctx context.Context, path string) ([]KubeObject, string, error) {
+	resp, err := c.do(ctx, http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	var list struct {
+		Metadata KubeMetadata `json:"metadata"`
+		Items    []KubeObject `json:"items"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, "", err
+	}
+	return list.Items, list.Metadata.ResourceVersion, nil
+}
+
+// Watch watches the objects at the given collection path starting at resourceVersion and calls fn
+// for every event until the watch ends or ctx is canceled.
+func (c *KubeClient) Watch(ctx context.Context, path, resourceVersion string, fn func(KubeEvent)) error {
+	resp, err := c.do(ctx, http.MethodGet, path+"?watch=true&allowWatchBookmarks=false&resourceVersion="+resourceVersion, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var ev KubeEvent
+		if err = dec.Decode(&ev); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if ev.Type == "ERROR" {
+			return fmt.Errorf("watch error: %s", ev.Object.Status)
+		}
+		fn(ev)
+	}
+}
+
+// PatchStatus replaces the status of the object at the given path using a merge patch.
+func (c *KubeClient) PatchStatus(ctx context.Context, path string, status any) error {
+	body, err := json.Marshal(map[string]any{"status": status})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, http.MethodPatch, path+"/status", "application/merge-patch+json", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ApplyConfigMap creates or updates the config map with the given data using server-side apply.
+func (c *KubeClient) ApplyConfigMap(ctx context.Context, namespace, name, fieldManager string, data map[string]string) error {
+	body, err := json.Marshal(map[string]any{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]string{"name": name, "namespace": namespace},
+		"data":       data,
+	})
+	if err != nil {
+		return err
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/configmaps/%s?fieldManager=%s&force=true", namespace, name, fieldManager)
+	resp, err := c.do(ctx, http.MethodPatch, path, "application/apply-patch+yaml", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
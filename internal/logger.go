@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+)
+
+// discardLogger drops all records. It is used when no logger is given, so that library code never
+// writes to stdout or stderr on its own.
+var discardLogger = slog.New(discardHandler{})
+
+// discardHandler is a slog handler that is disabled for all levels.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// loggerOrDiscard returns the logger, or one that drops all records if it is nil.
+func loggerOrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return discardLogger
+	}
+	return logger
+}
@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+)
+
+// defaultAcpiDataSize is the size of the ACPI data QEMU reserves at the top of the memory below
+// 4 GiB, which the highest initrd address written into the kernel boot header stays below.
+const defaultAcpiDataSize = 0x28000
+
+// MrtdVariant is the order in which QEMU adds and extends the firmware pages into MRTD.
+type MrtdVariant string
+
+const (
+	// MrtdVariantAuto derives the variant from the TCB version: single pass for 6, two pass for 7.
+	MrtdVariantAuto MrtdVariant = ""
+	// MrtdVariantTwoPass adds all pages with MEM.PAGE.ADD before extending them with MR.EXTEND.
+	MrtdVariantTwoPass MrtdVariant = "two-pass"
+	// MrtdVariantSinglePass extends every page with MR.EXTEND right after adding it.
+	MrtdVariantSinglePass MrtdVariant = "single-pass"
+)
+
+// ExtraEvent is an event extended into a register after the modeled events, e.g. by a boot
+// component the profile does not model.
+type ExtraEvent struct {
+	// Register is the register the event is extended into (RTMR0-3).
+	Register string
+	Name     string
+	// Type is the TCG event type recorded for the event in the Events of the measurements.
+	Type uint32
+	// Digest is the extended digest. Digests shorter than 48 bytes are zero-padded.
+	Digest []byte
+}
+
+// MeasureOptions are the inputs and parameters of a measurement of a TD guest booted by QEMU.
+// Fields added in the future default to the behavior of their zero value.
+type MeasureOptions struct {
+	Firmware      []byte
+	Kernel        []byte
+	Initrd        []byte
+	Rootfs        []byte
+	DockerCompose []byte
+	DockerFiles   []byte
+	// InitrdDigest and RootfsDigest stand in for Initrd and Rootfs when those are nil, so that the
+	// images can be hashed while they are read instead of being held in memory.
+	InitrdDigest *StreamDigest
+	RootfsDigest *StreamDigest
+	// MemoryMB is the guest memory size in megabytes.
+	MemoryMB uint64
+	CPUs     uint32
+	Cmdline  string
+	// TemplatesPath is the directory of the ACPI table templates.
+	TemplatesPath string
+	// TcbVersion is the TCB version of the platform (6 or 7).
+	TcbVersion uint8
+	// MrtdVariant overrides the MRTD variant derived from TcbVersion.
+	MrtdVariant MrtdVariant
+	// AcpiDataSize is the size of the ACPI data QEMU reserves below 4 GiB, 0x28000 when zero.
+	AcpiDataSize uint32
+	// Profile is the QEMU/firmware profile, the default profile when nil.
+	Profile *Profile
+	// FwCfgFiles holds the contents of fw_cfg files measured into RTMR0 by name.
+	FwCfgFiles map[string][]byte
+	// Registers restricts the measurement to the given registers (e.g. "RTMR2"), all registers are
+	// computed when it is empty.
+	Registers []string
+	// ExtraEvents are extended into the registers after the modeled events, in order.
+	ExtraEvents []ExtraEvent
+	// Logger receives diagnostics such as every emulated RTMR extension at debug level. They are
+	// dropped when it is nil.
+	Logger *slog.Logger
+}
+
+// MeasureOption adjusts the options of a measurement.
+type MeasureOption func(*MeasureOptions)
+
+// WithRegisters restricts a measurement to the given registers.
+func WithRegisters(registers ...string) MeasureOption {
+	return func(o *MeasureOptions) { o.Registers = registers }
+}
+
+// WithMrtdVariant overrides the MRTD variant derived from the TCB version.
+func WithMrtdVariant(variant MrtdVariant) MeasureOption {
+	return func(o *MeasureOptions) { o.MrtdVariant = variant }
+}
+
+// WithAcpiDataSize sets the size of the ACPI data QEMU reserves below 4 GiB.
+func WithAcpiDataSize(size uint32) MeasureOption {
+	return func(o *MeasureOptions) { o.AcpiDataSize = size }
+}
+
+// WithExtraEvents appends events extended into the registers after the modeled events.
+func WithExtraEvents(events ...ExtraEvent) MeasureOption {
+	return func(o *MeasureOptions) { o.ExtraEvents = append(o.ExtraEvents, events...) }
+}
+
+// WithLogger sets the logger receiving the diagnostics of a measurement.
+func WithLogger(logger *slog.Logger) MeasureOption {
+	return func(o *MeasureOptions) { o.Logger = logger }
+}
+
+// mrtdVariant returns the MRTD variant of the measurement.
+func (o *MeasureOptions) mrtdVariant() (int, error) {
+	switch o.MrtdVariant {
+	case MrtdVariantAuto:
+		switch o.TcbVersion {
+		case 6:
+			return mrtdVariantSinglePass, nil
+		case 7:
+			return mrtdVariantTwoPass, nil
+		}
+		return 0, fmt.Errorf("Unsupported tcbver: %d", o.TcbVersion)
+	case MrtdVariantTwoPass:
+		return mrtdVariantTwoPass, nil
+	case MrtdVariantSinglePass:
+		return mrtdVariantSinglePass, nil
+	}
+	return 0, fmt.Errorf("unknown MRTD variant '%s'", o.MrtdVariant)
+}
+
+// acpiDataSize returns the size of the ACPI data reserved below 4 GiB.
+func (o *MeasureOptions) acpiDataSize() uint32 {
+	if o.AcpiDataSize == 0 {
+		return defaultAcpiDataSize
+	}
+	return o.AcpiDataSize
+}
+
+// initrdSize returns the size of the initrd.
+func (o *MeasureOptions) initrdSize() uint64 {
+	if o.Initrd == nil && o.InitrdDigest != nil {
+		return o.InitrdDigest.Size
+	}
+	return uint64(len(o.Initrd))
+}
+
+// initrdSha384 returns the SHA384 digest of the initrd.
+func (o *MeasureOptions) initrdSha384() []byte {
+	if o.Initrd == nil && o.InitrdDigest != nil {
+		return o.InitrdDigest.Sha384
+	}
+	return measureSha384(o.Initrd)
+}
+
+// rootfsSha256 returns the SHA256 digest of the rootfs.
+func (o *MeasureOptions) rootfsSha256() []byte {
+	if o.Rootfs == nil && o.RootfsDigest != nil {
+		return o.RootfsDigest.Sha256
+	}
+	return measureSha256(o.Rootfs)
+}
+
+// extendExtraEvents extends the extra events into the computed registers they target.
+func (m *TdxMeasurements) extendExtraEvents(events []ExtraEvent) error {
+	for _, e := range events {
+		// Extra events are extended into RTMRs only, MRTD is not extended by the guest.
+		register := m.register(e.Register)
+		if register == nil || register == &m.MRTD {
+			return fmt.Errorf("extra event '%s' targets unknown register '%s'", e.Name, e.Register)
+		}
+		if len(e.Digest) > 48 {
+			return fmt.Errorf("extra event '%s' digest is longer than 48 bytes", e.Name)
+		}
+		if *register == nil {
+			continue
+		}
+		h := sha512.New384()
+		h.Write(*register)
+		h.Write(padDigest(e.Digest))
+		*register = h.Sum(nil)
+		m.Coverage = append(m.Coverage, CoverageEntry{Register: e.Register, Event: e.Name, Status: CoverageOverridden, Source: SourceConstant,
+			Digest: hex.EncodeToString(padDigest(e.Digest)), Note: "digest supplied by the user"})
+		m.Events = append(m.Events, LogEvent{Register: e.Register, Type: e.Type, Digest: padDigest(e.Digest), Name: e.Name})
+	}
+	return nil
+}
@@ -2,18 +2,21 @@ package internal
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"crypto"
 	"crypto/sha256"
 	"crypto/sha512"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
-	"io"
+	"log/slog"
+	"math"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/foxboron/go-uefi/authenticode"
-	"golang.org/x/text/encoding/unicode"
-	"golang.org/x/text/transform"
 )
 
 // measureSha256 computes a SHA256 of the given blob.
@@ -28,112 +31,43 @@ func measureSha384(data []byte) []byte {
 	return h[:]
 }
 
-// measureTdxKernelCmdline measures the kernel cmdline.
-func measureTdxKernelCmdline(cmdline string) []byte {
-	// Add a NUL byte at the end.
-	d := append([]byte(cmdline), 0x00)
-	// Convert to UTF-16LE.
-	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
-	xr := transform.NewReader(bytes.NewReader(d), utf16le)
-	converted, _ := io.ReadAll(xr)
-	return measureSha384(converted)
-}
-
 // measureTdxQemuTdHob measures the TD HOB.
-func measureTdxQemuTdHob(memorySize uint64, meta *tdvfMetadata) []byte {
-	// Construct a TD hob in the same way as QEMU does. Note that all fields are little-endian.
-	// See: https://github.com/intel-staging/qemu-tdx/blob/tdx-qemu-next/hw/i386/tdvf-hob.c
-	var tdHob []byte
-	// Discover the TD HOB base address from TDVF metadata.
-	tdHobBaseAddr := uint64(0x809000) // TD HOB base address.
-	if meta != nil {
-		for _, s := range meta.sections {
-			if s.secType == tdvfSectionTdHob {
-				tdHobBaseAddr = s.memoryAddress
-				break
-			}
-		}
-	}
-
-	// Start with EFI_HOB_TYPE_HANDOFF.
-	tdHob = append(tdHob,
-		0x01, 0x00, // Header.HobType (EFI_HOB_TYPE_HANDOFF)
-		0x38, 0x00, // Header.HobLength (56 bytes)
-		0x00, 0x00, 0x00, 0x00, // Header.Reserved
-		0x09, 0x00, 0x00, 0x00, // Version (EFI_HOB_HANDOFF_TABLE_VERSION)
-		0x00, 0x00, 0x00, 0x00, // BootMode
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryTop
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryBottom
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryTop
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryBottom
-		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiEndOfHobList (filled later)
-	)
+func measureTdxQemuTdHob(memorySize uint64, meta *tdvfMetadata, profile *Profile) []byte {
+	return measureSha384(buildTdxQemuTdHob(memorySize, meta, profile))
+}
 
-	// The rest of the HOBs are EFI_HOB_TYPE_RESOURCE_DESCRIPTOR.
-	remainingMemory := memorySize * 1024 * 1024 // Convert to bytes.
-	addMemoryResourceHob := func(resourceType uint8, start, length uint64) {
-		tdHob = append(tdHob,
-			0x03, 0x00, // Header.HobType (EFI_HOB_TYPE_RESOURCE_DESCRIPTOR)
-			0x30, 0x00, // Header.HobLength (48 bytes)
-			0x00, 0x00, 0x00, 0x00, // Header.Reserved
-			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Owner
-			resourceType, 0x00, 0x00, 0x00, // ResourceType
-			0x07, 0x00, 0x00, 0x00, // ResourceAttribute
-		)
-
-		var val [8]byte
-		binary.LittleEndian.PutUint64(val[:], start)
-		tdHob = append(tdHob, val[:]...) // PhysicalStart
-		binary.LittleEndian.PutUint64(val[:], length)
-		tdHob = append(tdHob, val[:]...) // Length
-
-		// Subtract from remaining memory.
-		remainingMemory -= length
-	}
-
-	addMemoryResourceHob(0x07, 0x0000000000000000, 0x0000000000800000)
-	addMemoryResourceHob(0x00, 0x0000000000800000, 0x0000000000006000)
-	addMemoryResourceHob(0x07, 0x0000000000806000, 0x0000000000003000)
-	addMemoryResourceHob(0x00, 0x0000000000809000, 0x0000000000002000)
-	addMemoryResourceHob(0x00, 0x000000000080B000, 0x0000000000002000)
-	addMemoryResourceHob(0x07, 0x000000000080D000, 0x0000000000004000)
-	addMemoryResourceHob(0x00, 0x0000000000811000, 0x000000000000f000)
-
-	// Handle memory split at 2816 MiB (0xB0000000).
-	if memorySize >= 2816 {
-		addMemoryResourceHob(0x07, 0x0000000000820000, 0x000000007F7E0000)
-		addMemoryResourceHob(0x07, 0x0000000100000000, remainingMemory)
-	} else {
-		addMemoryResourceHob(0x07, 0x0000000000820000, remainingMemory)
+// buildTdxQemuTdHob constructs the TD HOB in the same way as QEMU does.
+// See: https://github.com/intel-staging/qemu-tdx/blob/tdx-qemu-next/hw/i386/tdvf-hob.c
+func buildTdxQemuTdHob(memorySize uint64, meta *tdvfMetadata, profile *Profile) []byte {
+	resources := profile.HobResources
+	if resources == nil {
+		resources = QemuTdHobResources(memorySize, profile)
 	}
-
-	// Update EfiEndOfHobList.
-	var val [8]byte
-	binary.LittleEndian.PutUint64(val[:], tdHobBaseAddr+uint64(len(tdHob))+8)
-	copy(tdHob[48:56], val[:])
-
-	// Measure the TD HOB.
-	return measureSha384(tdHob)
+	return BuildTdHob(meta.tdHobBase(), resources)
 }
 
-// measureLog computes a measurement of the given RTMR event log by simulating extending the RTMR.
-func measureLog(RTMR int, log [][]byte) []byte {
-	var mr [48]byte // Initialize to zero.
+// measureLog computes a measurement of the given RTMR event log by simulating extending the RTMR,
+// logging every extension at debug level.
+func measureLog(logger *slog.Logger, RTMR int, log [][]byte) []byte {
+	mr := make([]byte, 48) // Initialize to zero.
+	h := sha512.New384()
+	debug := logger.Enabled(context.Background(), slog.LevelDebug)
 	for i, entry := range log {
-		fmt.Printf("RTMR#%d [ %d] [Emul. ] %x\n", RTMR, i+1, entry)
-		h := sha512.New384()
-		_, _ = h.Write(mr[:])
+		if debug {
+			logger.Debug("extend", "register", fmt.Sprintf("RTMR%d", RTMR), "index", i+1, "digest", hex.EncodeToString(entry))
+		}
+		h.Reset()
+		_, _ = h.Write(mr)
 		_, _ = h.Write(entry)
-		copy(mr[:], h.Sum([]byte{}))
+		h.Sum(mr[:0])
 	}
-	return mr[:]
-
+	return mr
 }
 
 // measureTdxQemuAcpiTables measures QEMU-generated ACPI tables for TDX.
-func measureTdxQemuAcpiTables(templatesPath string, memorySize uint64, cpuCount uint8) ([]byte, []byte, []byte, error) {
+func measureTdxQemuAcpiTables(templatesPath string, memorySize uint64, cpuCount uint32, profile *Profile) ([]byte, []byte, []byte, error) {
 	// Generate ACPI tables
-	tables, rsdp, loader, err := GenerateTablesQemu(templatesPath, memorySize, cpuCount)
+	tables, rsdp, loader, err := GenerateTablesQemu(templatesPath, memorySize, cpuCount, profile)
 
 	if err != nil {
 		return nil, nil, nil, fmt.Errorf("failed to generate ACPI tables: %w", err)
@@ -143,17 +77,43 @@ func measureTdxQemuAcpiTables(templatesPath string, memorySize uint64, cpuCount
 	return measureSha384(tables), measureSha384(rsdp), measureSha384(loader), nil
 }
 
-// measureTdxQemuKernelImage measures QEMU-patched TDX kernel image.
-func measureTdxQemuKernelImage(kernelData []byte, initRdSize uint32, memSize uint64, acpiDataSize uint32) ([]byte, error) {
-	return MeasureTdxQemuKernelImageData(kernelData, initRdSize, memSize, acpiDataSize)
+// MeasureTdxQemuKernelImageData measures QEMU-patched TDX kernel image.
+func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize uint64, acpiDataSize uint32, patch KernelPatchMode) ([]byte, error) {
+	kd, err := prepareTdxKernelImage(kernelData, initRdSize, memSize, acpiDataSize, patch)
+	if err != nil {
+		return nil, err
+	}
+	return authenticodeHash(kd)
+}
+
+// prepareTdxKernelImage returns the kernel image in the form it is measured in.
+func prepareTdxKernelImage(kernelData []byte, initRdSize uint32, memSize uint64, acpiDataSize uint32, patch KernelPatchMode) ([]byte, error) {
+	switch patch {
+	case KernelPatchQemu, "":
+		return patchTdxQemuKernelImage(kernelData, initRdSize, memSize, acpiDataSize)
+	case KernelPatchNone:
+		return kernelData, nil
+	case KernelPatchPrepatched:
+		if err := checkPatchedKernelImage(kernelData, initRdSize); err != nil {
+			return nil, err
+		}
+		return kernelData, nil
+	default:
+		return nil, fmt.Errorf("unsupported kernel patch mode '%s'", patch)
+	}
 }
 
-func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize uint64, acpiDataSize uint32) ([]byte, error) {
-	memSizeBytes := memSize * 1024 * 1024 // Convert to bytes.
+// patchTdxQemuKernelImage returns the kernel image as patched by QEMU before it is measured.
+func patchTdxQemuKernelImage(kernelData []byte, initRdSize uint32, memSize uint64, acpiDataSize uint32) ([]byte, error) {
 	// Check if kernel data is long enough for all required fields
 	const minKernelLength = 0x1000
 	if len(kernelData) < minKernelLength {
-		return nil, fmt.Errorf("kernel data too short: need at least %d bytes, got %d", minKernelLength, len(kernelData))
+		return nil, fmt.Errorf("%w: kernel data too short, need at least %d bytes, got %d", ErrUnsupportedKernel, minKernelLength, len(kernelData))
+	}
+
+	// Images without a Linux boot header (e.g. UKIs) are not patched by QEMU.
+	if format, _ := DetectKernelFormat(kernelData); format == KernelFormatPE {
+		return kernelData, nil
 	}
 
 	// Create a mutable copy of the kernel data
@@ -201,7 +161,7 @@ func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize
 	if initRdSize > 0 {
 		// Check protocol version - must be >= 0x200 to support initrd
 		if protocol < 0x200 {
-			return nil, fmt.Errorf("linux kernel too old to load a ram disk (protocol version 0x%x)", protocol)
+			return nil, fmt.Errorf("%w: protocol version 0x%x cannot load a ram disk", ErrKernelTooOld, protocol)
 		}
 
 		// Determine initrd_max based on protocol version
@@ -224,16 +184,8 @@ func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize
 		}
 
 		// Calculate below_4g_mem_size
-		var below4gMemSize uint32
-		lowmem := uint32(0x80000000)
-		if memSizeBytes < 0xb0000000 {
-			lowmem = 0xb0000000
-		}
-		if memSizeBytes >= uint64(lowmem) {
-			below4gMemSize = lowmem
-		} else {
-			below4gMemSize = uint32(memSizeBytes)
-		}
+		below4g, _ := splitGuestMemory(memSize)
+		below4gMemSize := uint32(below4g)
 
 		// Adjust initrd_max based on memory size and ACPI data size
 		if initrdMax >= below4gMemSize-acpiDataSize {
@@ -241,7 +193,7 @@ func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize
 		}
 
 		if initRdSize >= initrdMax {
-			return nil, fmt.Errorf("initrd is too large (max: %d, need: %d)", initrdMax, initRdSize)
+			return nil, fmt.Errorf("%w (max: %d, need: %d)", ErrInitrdTooLarge, initrdMax, initRdSize)
 		}
 
 		initrdAddr := (initrdMax - initRdSize) & ^uint32(4095)
@@ -251,7 +203,12 @@ func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize
 		binary.LittleEndian.PutUint32(kd[0x21c:0x21c+4], initRdSize)
 	}
 
-	parsed, err := authenticode.Parse(bytes.NewReader(kd))
+	return kd, nil
+}
+
+// authenticodeHash computes the SHA384 Authenticode hash of the given PE image.
+func authenticodeHash(data []byte) ([]byte, error) {
+	parsed, err := authenticode.Parse(bytes.NewReader(data))
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse PE file: %w", err)
 	}
@@ -259,46 +216,64 @@ func MeasureTdxQemuKernelImageData(kernelData []byte, initRdSize uint32, memSize
 }
 
 // encodeGUID encodes an UEFI GUID into binary form.
-func encodeGUID(guid string) []byte {
-	var data []byte
-	atoms := strings.Split(guid, "-")
-	for idx, atom := range atoms {
-		raw, err := hex.DecodeString(atom)
-		if err != nil {
-			panic("bad GUID")
-		}
+func encodeGUID(guid string) ([]byte, error) {
+	return appendGUID(make([]byte, 0, 16), guid)
+}
 
-		if idx <= 2 {
-			// Little-endian.
-			for i := range raw {
-				data = append(data, raw[len(raw)-1-i])
-			}
-		} else {
-			// Big-endian.
-			data = append(data, raw...)
+// appendGUID appends the binary form of an UEFI GUID to dst.
+func appendGUID(dst []byte, guid string) ([]byte, error) {
+	// Offsets of the hex digits of each byte in the canonical form; the first three groups are
+	// little-endian while the last two are big-endian.
+	offsets := [16]int{6, 4, 2, 0, 11, 9, 16, 14, 19, 21, 24, 26, 28, 30, 32, 34}
+	if len(guid) != 36 || guid[8] != '-' || guid[13] != '-' || guid[18] != '-' || guid[23] != '-' {
+		return nil, fmt.Errorf("%w '%s'", ErrBadGUID, guid)
+	}
+	for _, off := range offsets {
+		hi, ok1 := fromHexChar(guid[off])
+		lo, ok2 := fromHexChar(guid[off+1])
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%w '%s'", ErrBadGUID, guid)
 		}
+		dst = append(dst, hi<<4|lo)
+	}
+	return dst, nil
+}
+
+// fromHexChar converts a hex character into its value.
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
 	}
-	return data
+	return 0, false
 }
 
 // measureTdxEfiVariable measures an EFI variable event.
-func measureTdxEfiVariable(vendorGUID string, varName string) []byte {
-	var data []byte
-	data = append(data, encodeGUID(vendorGUID)...)
+func measureTdxEfiVariable(vendorGUID string, varName string) ([]byte, error) {
+	var buf [128]byte
+	data, err := appendTdxEfiVariable(buf[:0], vendorGUID, varName)
+	if err != nil {
+		return nil, err
+	}
+	return measureSha384(data), nil
+}
 
-	var encLen [8]byte
-	binary.LittleEndian.PutUint64(encLen[:], uint64(len(varName)))
-	data = append(data, encLen[:]...)
-	binary.LittleEndian.PutUint64(encLen[:], 0)
-	data = append(data, encLen[:]...)
+// appendTdxEfiVariable appends the encoded EFI variable event data to dst.
+func appendTdxEfiVariable(dst []byte, vendorGUID string, varName string) ([]byte, error) {
+	data, err := appendGUID(dst, vendorGUID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Convert varName to UTF-16LE.
-	utf16le := unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM).NewEncoder()
-	xr := transform.NewReader(bytes.NewReader([]byte(varName)), utf16le)
-	converted, _ := io.ReadAll(xr)
-	data = append(data, converted...)
+	data = binary.LittleEndian.AppendUint64(data, uint64(len(varName)))
+	data = binary.LittleEndian.AppendUint64(data, 0)
 
-	return measureSha384(data)
+	// Convert varName to UTF-16LE.
+	return appendUTF16LE(data, varName), nil
 }
 
 const (
@@ -307,6 +282,8 @@ const (
 	pageSize            = 0x1000
 	mrExtendGranularity = 0x100
 
+	tdvfSectionBfv   = 0x00
+	tdvfSectionCfv   = 0x01
 	tdvfSectionTdHob = 0x02
 )
 
@@ -320,7 +297,9 @@ type tdvfSection struct {
 }
 
 type tdvfMetadata struct {
-	sections []*tdvfSection
+	// descriptorOffset is the offset of the TDVF metadata descriptor in the firmware.
+	descriptorOffset uint64
+	sections         []*tdvfSection
 }
 
 const (
@@ -328,40 +307,104 @@ const (
 	mrtdVariantSinglePass = 1
 )
 
-func (m *tdvfMetadata) computeMrtd(fw []byte, variant int) []byte {
+// cfvImage returns the raw data of the first CFV section, which OVMF measures into RTMR0 as the
+// CFV image, or nil if the firmware has no CFV section.
+func (m *tdvfMetadata) cfvImage(fw []byte) []byte {
+	for _, s := range m.sections {
+		if s.secType == tdvfSectionCfv {
+			return fw[s.dataOffset : uint64(s.dataOffset)+uint64(s.rawDataSize)]
+		}
+	}
+	return nil
+}
+
+// checkMrExtend verifies that all sections extended into MRTD have enough raw data to cover their
+// memory data size, unless the raw data is to be zero-extended.
+func (m *tdvfMetadata) checkMrExtend(zeroExtend bool) error {
+	for i, s := range m.sections {
+		if !zeroExtend && s.attributes&attributeMrExtend != 0 && uint64(s.rawDataSize) < s.memoryDataSize {
+			return malformedTdvf("TDVF metadata section %d raw data size is less than memory data size", i)
+		}
+	}
+	return nil
+}
+
+// mrtdChunkSize is the size of the hashed transcript of an MR.EXTEND chunk: the 128-byte
+// TDH.MR.EXTEND record followed by the 256 bytes of chunk content.
+const mrtdChunkSize = 128 + mrExtendGranularity
+
+// mrtdPageBatch holds the transcript of all MR.EXTEND chunks of a page, which is hashed with a
+// single write. The static prefixes of the records are written once when a batch is allocated and
+// only the GPAs and chunk contents change from page to page.
+type mrtdPageBatch [pageSize / mrExtendGranularity * mrtdChunkSize]byte
+
+var mrtdBatchPool = sync.Pool{New: func() any {
+	batch := new(mrtdPageBatch)
+	for i := 0; i < len(batch); i += mrtdChunkSize {
+		copy(batch[i:], "MR.EXTEND")
+	}
+	return batch
+}}
+
+// mrtdCancelInterval is the number of pages after which computeMrtd checks whether its context
+// was canceled.
+const mrtdCancelInterval = 256
+
+// computeMrtd computes the MRTD for the given firmware. When zeroExtend is set, raw data of
+// sections shorter than their memory data size is zero-extended during MR.EXTEND. The computation
+// stops with the error of the context once it is canceled.
+func (m *tdvfMetadata) computeMrtd(ctx context.Context, fw []byte, variant int, zeroExtend bool) ([]byte, error) {
 	h := sha512.New384()
 
+	// Byte 0 through 11 of a TDH.MEM.PAGE.ADD record contain the ASCII string 'MEM.PAGE.ADD'.
+	// Byte 16 through 23 contain the GPA (in little-endian format). All the other bytes contain 0.
+	var pageAdd [128]byte
+	copy(pageAdd[:12], "MEM.PAGE.ADD")
+	batch := mrtdBatchPool.Get().(*mrtdPageBatch)
+	defer mrtdBatchPool.Put(batch)
+
 	memPageAdd := func(s *tdvfSection, page uint64) {
 		if s.attributes&attributePageAug == 0 {
 			// Use TDCALL [TDH.MEM.PAGE.ADD].
-			//
-			// Byte 0 through 11 contain the ASCII string 'MEM.PAGE.ADD'.
-			// Byte 16 through 23 contain the GPA (in little-endian format).
-			// All the other bytes contain 0.
-			var buf [128]byte
-			copy(buf[:12], []byte("MEM.PAGE.ADD"))
-			binary.LittleEndian.PutUint64(buf[16:24], s.memoryAddress+page*pageSize)
-			_, _ = h.Write(buf[:])
-		}
-	}
-
-	mrExtend := func(s *tdvfSection, page uint64) {
-		if s.attributes&attributeMrExtend != 0 {
-			// Need TDCALL [TDH.MR.EXTEND].
-			for i := range pageSize / mrExtendGranularity {
-				// Byte 0 through 8 contain the ASCII string 'MR.EXTEND'.
-				// Byte 16 through 23 contain the GPA (in little-endian format).
-				// All the other bytes contain 0.
-				var buf [128]byte
-				copy(buf[:9], []byte("MR.EXTEND"))
-				binary.LittleEndian.PutUint64(buf[16:24], s.memoryAddress+page*pageSize+uint64(i*mrExtendGranularity))
-				_, _ = h.Write(buf[:])
-
-				// The other two extension buffers contain the chunk’s content.
-				chunkOffset := int(s.dataOffset) + int(page*pageSize) + i*mrExtendGranularity
-				_, _ = h.Write(fw[chunkOffset : chunkOffset+mrExtendGranularity])
+			binary.LittleEndian.PutUint64(pageAdd[16:24], s.memoryAddress+page*pageSize)
+			_, _ = h.Write(pageAdd[:])
+		}
+	}
+
+	mrExtend := func(s *tdvfSection, page uint64) error {
+		if s.attributes&attributeMrExtend == 0 {
+			return nil
+		}
+		// Need TDCALL [TDH.MR.EXTEND] for every chunk of the page. Byte 0 through 8 of a record
+		// contain the ASCII string 'MR.EXTEND' and byte 16 through 23 the GPA (in little-endian
+		// format), all the other bytes contain 0. The two extension buffers following a record
+		// contain the chunk's content.
+		for i := range pageSize / mrExtendGranularity {
+			record := batch[i*mrtdChunkSize : (i+1)*mrtdChunkSize]
+			binary.LittleEndian.PutUint64(record[16:24], s.memoryAddress+page*pageSize+uint64(i*mrExtendGranularity))
+			chunk := record[128:]
+
+			// Offsets are computed in 64 bits, the raw data is validated to lie within the
+			// firmware so that they never exceed its length.
+			chunkOffset := page*pageSize + uint64(i*mrExtendGranularity)
+			if chunkOffset+mrExtendGranularity <= uint64(s.rawDataSize) {
+				dataOffset := uint64(s.dataOffset) + chunkOffset
+				copy(chunk, fw[dataOffset:dataOffset+mrExtendGranularity])
+				continue
+			}
+			if !zeroExtend {
+				return malformedTdvf("TDVF metadata section raw data does not cover the extended memory at offset 0x%x", chunkOffset)
+			}
+
+			// Zero-extend the raw data up to the memory data size.
+			n := 0
+			if chunkOffset < uint64(s.rawDataSize) {
+				n = copy(chunk, fw[uint64(s.dataOffset)+chunkOffset:uint64(s.dataOffset)+uint64(s.rawDataSize)])
 			}
+			clear(chunk[n:])
 		}
+		_, _ = h.Write(batch[:])
+		return nil
 	}
 
 	for _, s := range m.sections {
@@ -382,18 +425,28 @@ func (m *tdvfMetadata) computeMrtd(fw []byte, variant int) []byte {
 				memPageAdd(s, page)
 			}
 			for page := range numPages {
-				mrExtend(s, page)
+				if page%mrtdCancelInterval == 0 && ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				if err := mrExtend(s, page); err != nil {
+					return nil, err
+				}
 			}
 		case mrtdVariantSinglePass:
 			for page := range numPages {
+				if page%mrtdCancelInterval == 0 && ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
 				memPageAdd(s, page)
-				mrExtend(s, page)
+				if err := mrExtend(s, page); err != nil {
+					return nil, err
+				}
 			}
 		default:
-			panic("unknown MRTD variant")
+			return nil, fmt.Errorf("unknown MRTD variant %d", variant)
 		}
 	}
-	return h.Sum(nil)
+	return h.Sum(nil), nil
 }
 
 // parseTdvfMetadata parses the TDVF metadata from the firmware blob.
@@ -401,32 +454,39 @@ func (m *tdvfMetadata) computeMrtd(fw []byte, variant int) []byte {
 // See Section 11 of "Intel TDX Virtual Firmware Design Guide" for details.
 func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 	const (
-		tdxMetadataOffsetGUID = "e47a6535-984a-4798-865e-4685a7bf8ec2"
 		tdxMetadataVersion    = 1
 		tdvfSignature         = "TDVF"
-		tableFooterGUID       = "96b582de-1fb2-45f7-baea-a366c55a082d"
 		bytesAfterTableFooter = 32
 	)
 
+	if len(fw) < bytesAfterTableFooter+16+2 {
+		return nil, malformedTdvf("firmware of %d bytes is too small to hold an OVMF table footer", len(fw))
+	}
 	offset := len(fw) - bytesAfterTableFooter
-	encodedFooterGUID := encodeGUID(tableFooterGUID)
+	encodedFooterGUID, err := encodeGUID(ovmfTableFooterGUID)
+	if err != nil {
+		return nil, err
+	}
 	guid := fw[offset-16 : offset]
 	tablesLen := int(binary.LittleEndian.Uint16(fw[offset-16-2 : offset-16]))
 	if !bytes.Equal(guid, encodedFooterGUID) {
-		return nil, fmt.Errorf("malformed OVMF table footer")
+		return nil, malformedTdvf("malformed OVMF table footer")
 	}
 	if tablesLen == 0 || tablesLen > offset-16-2 {
-		return nil, fmt.Errorf("malformed OVMF table footer")
+		return nil, malformedTdvf("malformed OVMF table footer")
 	}
 	tables := fw[offset-16-2-tablesLen : offset-16-2]
 	offset = len(tables)
 
 	// Find TDVF metadata table in OVMF, starting at the end.
 	var data []byte
-	encodedGUID := encodeGUID(tdxMetadataOffsetGUID)
+	encodedGUID, err := encodeGUID(tdvfMetadataOffsetGUID)
+	if err != nil {
+		return nil, err
+	}
 	for {
 		if offset < 18 {
-			return nil, fmt.Errorf("missing TDVF metadata in firmware")
+			return nil, malformedTdvf("missing TDVF metadata in firmware")
 		}
 
 		// The data structure is:
@@ -438,7 +498,7 @@ func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 		guid = tables[offset-16 : offset]
 		entryLen := int(binary.LittleEndian.Uint16(tables[offset-16-2 : offset-16]))
 		if offset < 18+entryLen {
-			return nil, fmt.Errorf("malformed OVMF table in firmware at offset %d", offset)
+			return nil, malformedTdvf("malformed OVMF table in firmware at offset %d", offset)
 		}
 
 		if bytes.Equal(guid, encodedGUID) {
@@ -449,7 +509,7 @@ func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 		offset -= entryLen
 	}
 	if data == nil {
-		return nil, fmt.Errorf("missing TDVF metadata in firmware")
+		return nil, malformedTdvf("missing TDVF metadata in firmware")
 	}
 
 	// Extract and parse TDVF metadata descriptor:
@@ -460,20 +520,29 @@ func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 	//   4 byte number of section entries
 	//   32 byte each section * number of sections
 	//
-	tdvfMetaOffset := int(binary.LittleEndian.Uint32(data[len(data)-4:]))
-	tdvfMetaOffset = len(fw) - tdvfMetaOffset
+	if len(data) < 4 {
+		return nil, malformedTdvf("malformed TDVF metadata offset in firmware")
+	}
+	tdvfMetaOffset := uint64(binary.LittleEndian.Uint32(data[len(data)-4:]))
+	if tdvfMetaOffset < 16 || tdvfMetaOffset > uint64(len(fw)) {
+		return nil, malformedTdvf("TDVF metadata descriptor offset is outside of the firmware")
+	}
+	tdvfMetaOffset = uint64(len(fw)) - tdvfMetaOffset
 	tdvfMetaDesc := fw[tdvfMetaOffset : tdvfMetaOffset+16]
 	if string(tdvfMetaDesc[:4]) != tdvfSignature {
-		return nil, fmt.Errorf("malformed TDVF metadata descriptor in firmware")
+		return nil, malformedTdvf("malformed TDVF metadata descriptor in firmware")
 	}
 	tdvfVersion := binary.LittleEndian.Uint32(tdvfMetaDesc[8:12])
-	tdvfNumberOfSectionEntries := int(binary.LittleEndian.Uint32(tdvfMetaDesc[12:16]))
+	tdvfNumberOfSectionEntries := uint64(binary.LittleEndian.Uint32(tdvfMetaDesc[12:16]))
 	if tdvfVersion != 1 {
-		return nil, fmt.Errorf("unsupported TDVF metadata descriptor version in firmware")
+		return nil, malformedTdvf("unsupported TDVF metadata descriptor version in firmware")
+	}
+	if tdvfMetaOffset+16+32*tdvfNumberOfSectionEntries > uint64(len(fw)) {
+		return nil, malformedTdvf("TDVF metadata section entries extend beyond the end of the firmware")
 	}
 
 	// Parse section entries.
-	var meta tdvfMetadata
+	meta := tdvfMetadata{descriptorOffset: tdvfMetaOffset}
 	for section := range tdvfNumberOfSectionEntries {
 		secOffset := tdvfMetaOffset + 16 + 32*section
 		secData := fw[secOffset : secOffset+32]
@@ -489,16 +558,19 @@ func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 
 		// Sanity check section.
 		if s.memoryAddress%pageSize != 0 {
-			return nil, fmt.Errorf("TDVF metadata section %d has non-aligned memory address", section)
+			return nil, malformedTdvf("TDVF metadata section %d has non-aligned memory address", section)
 		}
 		if s.memoryDataSize < uint64(s.rawDataSize) {
-			return nil, fmt.Errorf("TDVF metadata section %d memory data size is less than raw data size", section)
+			return nil, malformedTdvf("TDVF metadata section %d memory data size is less than raw data size", section)
 		}
 		if s.memoryDataSize%pageSize != 0 {
-			return nil, fmt.Errorf("TDVF metadata section %d has non-aligned memory data size", section)
+			return nil, malformedTdvf("TDVF metadata section %d has non-aligned memory data size", section)
 		}
-		if s.attributes&attributeMrExtend != 0 && uint64(s.rawDataSize) < s.memoryDataSize {
-			return nil, fmt.Errorf("TDVF metadata section %d raw data size is less than memory data size", section)
+		if s.memoryAddress != 0 && s.memoryDataSize > math.MaxUint64-s.memoryAddress+1 {
+			return nil, malformedTdvf("TDVF metadata section %d memory range exceeds the 64-bit address space", section)
+		}
+		if uint64(s.dataOffset)+uint64(s.rawDataSize) > uint64(len(fw)) {
+			return nil, malformedTdvf("TDVF metadata section %d raw data extends beyond the end of the firmware", section)
 		}
 
 		meta.sections = append(meta.sections, s)
@@ -506,6 +578,54 @@ func parseTdvfMetadata(fw []byte) (*tdvfMetadata, error) {
 	return &meta, nil
 }
 
+// Firmware sections that can be extracted with ExtractFirmwareSection.
+const (
+	FirmwareSectionBfv   = "bfv"
+	FirmwareSectionCfv   = "cfv"
+	FirmwareSectionTdHob = "td_hob"
+)
+
+// ExtractFirmwareSection returns the contents of the index-th firmware section of the given type
+// as located by the TDVF metadata. For the TD HOB section, which has no raw data in the firmware
+// image, the HOB that QEMU would generate for the given memory size is returned instead.
+func ExtractFirmwareSection(fw []byte, section string, index int, memorySize uint64, profile *Profile) ([]byte, error) {
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return nil, err
+	}
+
+	var secType uint32
+	switch section {
+	case FirmwareSectionBfv:
+		secType = tdvfSectionBfv
+	case FirmwareSectionCfv:
+		secType = tdvfSectionCfv
+	case FirmwareSectionTdHob:
+		if profile == nil {
+			profile = profiles[DefaultProfile]
+		}
+		return buildTdxQemuTdHob(memorySize, meta, profile), nil
+	default:
+		return nil, fmt.Errorf("unsupported firmware section type '%s'", section)
+	}
+
+	var found int
+	for _, s := range meta.sections {
+		if s.secType != secType {
+			continue
+		}
+		if found == index {
+			end := uint64(s.dataOffset) + uint64(s.rawDataSize)
+			if end > uint64(len(fw)) {
+				return nil, fmt.Errorf("firmware section %s extends beyond the end of the firmware", section)
+			}
+			return fw[s.dataOffset:end], nil
+		}
+		found++
+	}
+	return nil, fmt.Errorf("firmware section %s #%d not found (%d present)", section, index, found)
+}
+
 // TdxMeasurements contains all the measurement values for TDX
 type TdxMeasurements struct {
 	MRTD  []byte
@@ -513,34 +633,87 @@ type TdxMeasurements struct {
 	RTMR1 []byte
 	RTMR2 []byte
 	RTMR3 []byte
+
+	// Coverage lists how faithfully each measured event is modeled.
+	Coverage []CoverageEntry
+	// Warnings lists conditions that may make the measurements inaccurate.
+	Warnings []Warning
+	// Intermediates lists the synthesized structures whose digests were measured.
+	Intermediates []Intermediate
+	// Events lists the events extended into the RTMRs in order, as they would be recorded in the
+	// event log of the TD. Data is set for events measured from synthesized structures or constant
+	// data, and nil for events measured from an input image or with a constant digest.
+	Events []LogEvent
+
+	// logger receives the diagnostics of the measurement, nil to drop them.
+	logger *slog.Logger
 }
 
-// CalculateMrAggregated calculates mr_aggregated = sha256(mrtd+rtmr0+rtmr1+rtmr2+mr_key_provider)
-func (m *TdxMeasurements) CalculateMrAggregated(mrKeyProvider string) string {
-	// Strip "0x" prefix if present
-	mrKeyProvider = strings.TrimPrefix(mrKeyProvider, "0x")
-	mrKeyProviderBytes, err := hex.DecodeString(mrKeyProvider)
-	if err != nil {
-		panic("invalid mr_key_provider")
+// measureEvents computes the RTMR value from the given events and records their coverage.
+func (m *TdxMeasurements) measureEvents(rtmr int, events []measuredEvent) []byte {
+	log := make([][]byte, 0, len(events))
+	for _, ev := range events {
+		log = append(log, ev.digest)
+		m.Events = append(m.Events, LogEvent{Register: fmt.Sprintf("RTMR%d", rtmr), Type: ev.eventType, Digest: ev.digest, Name: ev.name, Data: ev.data})
+		source := ev.source
+		if source == "" {
+			source = SourceComputed
+		}
+		m.Coverage = append(m.Coverage, CoverageEntry{
+			Register: fmt.Sprintf("RTMR%d", rtmr),
+			Event:    ev.name,
+			Status:   ev.status,
+			Source:   source,
+			Digest:   hex.EncodeToString(ev.digest),
+			Note:     ev.note,
+		})
 	}
-	h := sha256.New()
-	h.Write(m.MRTD)
-	h.Write(m.RTMR0)
-	h.Write(m.RTMR1)
-	h.Write(m.RTMR2)
-	h.Write(m.RTMR3)
-	h.Write(mrKeyProviderBytes)
-	return hex.EncodeToString(h.Sum(nil))
+	return measureLog(loggerOrDiscard(m.logger), rtmr, log)
 }
 
-// CalculateMrImage calculates mr_image = sha256(mrtd+rtmr1+rtmr2)
+// CalculateMrAggregated calculates mr_aggregated as defined by the default composite spec. It fails
+// with ErrInvalidKeyProvider if the key provider measurement is not hex-encoded.
+func (m *TdxMeasurements) CalculateMrAggregated(mrKeyProvider string) (string, error) {
+	return m.calculateDefaultComposite("mr_aggregated", mrKeyProvider)
+}
+
+// CalculateMrSystem calculates mr_system as defined by the default composite spec. It fails with
+// ErrInvalidKeyProvider if the key provider measurement is not hex-encoded.
+func (m *TdxMeasurements) CalculateMrSystem(mrKeyProvider string) (string, error) {
+	return m.calculateDefaultComposite("mr_system", mrKeyProvider)
+}
+
+// CalculateMrImage calculates mr_image as defined by the default composite spec, which does not
+// depend on the key provider.
 func (m *TdxMeasurements) CalculateMrImage() string {
-	h := sha256.New()
-	h.Write(m.MRTD)
-	h.Write(m.RTMR1)
-	h.Write(m.RTMR2)
-	h.Write(m.RTMR3)
-	return hex.EncodeToString(h.Sum(nil))
+	value, _ := m.calculateDefaultComposite("mr_image", "")
+	return value
+}
+
+// MeasureRuntime computes RTMR3 from the runtime events of the docker compose file, the rootfs
+// and the optional docker files.
+func MeasureRuntime(dockerCompose, rootfsData, dockerFiles []byte) ([]byte, error) {
+	var dockerFilesDigest []byte
+	if len(dockerFiles) > 0 {
+		dockerFilesDigest = measureSha256(dockerFiles)
+	}
+	return MeasureRuntimeDigests(measureSha256(dockerCompose), measureSha256(rootfsData), dockerFilesDigest)
+}
+
+// MeasureRuntimeDigests computes RTMR3 like MeasureRuntime from the SHA256 digests of the runtime
+// inputs. The docker files digest is nil when there are no docker files.
+func MeasureRuntimeDigests(dockerCompose, rootfs, dockerFiles []byte) ([]byte, error) {
+	log := make([]string, 0, 3)
+	log = append(log, hex.EncodeToString(dockerCompose))
+	log = append(log, hex.EncodeToString(rootfs))
+	if dockerFiles != nil {
+		log = append(log, hex.EncodeToString(dockerFiles))
+	}
+	logHashStr, err := replayRTMR(log)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(logHashStr)
 }
 
 const INIT_MR = "000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"
@@ -550,111 +723,369 @@ func replayRTMR(history []string) (string, error) {
 		return INIT_MR, nil
 	}
 
-	mr := make([]byte, 48)
-
+	var (
+		mr  [48]byte
+		buf [128]byte
+	)
+	h := sha512.New384()
 	for _, content := range history {
-		contentBytes, err := hex.DecodeString(content)
-		if err != nil {
-			return "", err
+		if len(content)%2 != 0 {
+			return "", hex.ErrLength
 		}
 
-		if len(contentBytes) < 48 {
-			padding := make([]byte, 48-len(contentBytes))
-			contentBytes = append(contentBytes, padding...)
+		// Decode into the scratch buffer when possible, zero-padding digests shorter than 48 bytes.
+		contentBytes := buf[:0]
+		if len(content)/2 > len(buf) {
+			contentBytes = make([]byte, 0, len(content)/2)
+		}
+		for i := 0; i < len(content); i += 2 {
+			hi, ok1 := fromHexChar(content[i])
+			lo, ok2 := fromHexChar(content[i+1])
+			if !ok1 || !ok2 {
+				return "", fmt.Errorf("invalid hex digest '%s'", content)
+			}
+			contentBytes = append(contentBytes, hi<<4|lo)
+		}
+		for len(contentBytes) < 48 {
+			contentBytes = append(contentBytes, 0x00)
 		}
 
-		h := sha512.New384()
-		h.Write(append(mr, contentBytes...))
-		mr = h.Sum(nil)
+		h.Reset()
+		h.Write(mr[:])
+		h.Write(contentBytes)
+		h.Sum(mr[:0])
 	}
 
-	return hex.EncodeToString(mr), nil
+	return hex.EncodeToString(mr[:]), nil
+}
+
+// MeasureTdxQemu computes the measurement registers of a TD guest booted by QEMU from the given
+// options, adjusted by the functional options. Registers that are not computed are left nil. The
+// firmware is only parsed for MRTD and RTMR0 and the kernel only measured for RTMR1, so inputs that
+// no selected register depends on may be omitted.
+func MeasureTdxQemu(opts MeasureOptions, options ...MeasureOption) (*TdxMeasurements, error) {
+	return MeasureTdxQemuContext(context.Background(), opts, options...)
 }
 
-func MeasureTdxQemu(fwData, kernelData, initrdData, rootfsData, dockerCompose, dockerFiles []byte, memorySize uint64, cpuCount uint8, kernelCmdline, templatesPath string, tcbver uint8) (*TdxMeasurements, error) {
-	// Parse TDVF metadata.
-	tdvfMeta, err := parseTdvfMetadata(fwData)
+// MeasureTdxQemuContext computes the measurements like MeasureTdxQemu. Once the context is
+// canceled or its deadline passes, the measurement stops and returns the error of the context.
+func MeasureTdxQemuContext(ctx context.Context, opts MeasureOptions, options ...MeasureOption) (*TdxMeasurements, error) {
+	for _, option := range options {
+		option(&opts)
+	}
+	profile := opts.Profile
+	if profile == nil {
+		profile = profiles[DefaultProfile]
+	}
+	fwData, kernelData, initrdSize := opts.Firmware, opts.Kernel, opts.initrdSize()
+	memorySize, cpuCount, kernelCmdline, tcbver := opts.MemoryMB, opts.CPUs, opts.Cmdline, opts.TcbVersion
+	selected, err := selectRegisters(opts.Registers)
 	if err != nil {
 		return nil, err
 	}
+	if err := validateParameters(kernelData, initrdSize, memorySize, cpuCount, kernelCmdline, profile); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidParameters, err)
+	}
+
+	measurements := &TdxMeasurements{logger: opts.Logger}
+	if selected["MRTD"] || selected["RTMR0"] {
+		tdvfMeta, err := parseTdvfMetadata(fwData)
+		if err != nil {
+			return nil, err
+		}
+		if selected["MRTD"] {
+			variant, err := opts.mrtdVariant()
+			if err != nil {
+				return nil, err
+			}
+			if err = measurements.measureMrtd(ctx, fwData, tdvfMeta, variant, profile); err != nil {
+				return nil, err
+			}
+		}
+		if selected["RTMR0"] {
+			if err = measurements.measureRtmr0(ctx, fwData, tdvfMeta, memorySize, cpuCount, opts.TemplatesPath, profile, opts.FwCfgFiles); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if memorySize < 1024 || memorySize%2 != 0 {
+		measurements.addWarning(WarningUnusualMemorySize, "memory size of %dM is unusual for a TD guest", memorySize)
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if selected["RTMR1"] {
+		if err = measurements.measureRtmr1(kernelData, int(initrdSize), memorySize, opts.acpiDataSize(), profile); err != nil {
+			return nil, err
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if selected["RTMR2"] {
+		if err = measurements.measureRtmr2(kernelCmdline, opts.initrdSha384(), profile); err != nil {
+			return nil, err
+		}
+	}
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+	if selected["RTMR3"] {
+		if err = measurements.measureRtmr3(opts.DockerCompose, opts.rootfsSha256(), opts.DockerFiles); err != nil {
+			return nil, err
+		}
+	}
+
+	if profile.Unvalidated {
+		measurements.addWarning(WarningApproximatedEvent, "profile '%s' was not validated against captured quotes", profile.Name)
+	}
+	if len(profile.TcbVersions) > 0 && !bytes.Contains(profile.TcbVersions, []byte{tcbver}) {
+		measurements.addWarning(WarningProfileMismatch, "profile '%s' only applies to TCB versions %v, not %d", profile.Name, profile.TcbVersions, tcbver)
+	}
+	if err = measurements.extendExtraEvents(opts.ExtraEvents); err != nil {
+		return nil, err
+	}
+	measurements.addTdFeatureWarnings(profile)
+	for _, e := range measurements.Coverage {
+		switch {
+		case e.Status != CoverageApproximated:
+		case e.Source == SourceConstant:
+			// Constant digests are known trust assumptions of a profile, which CI can accept
+			// separately from other approximations.
+			measurements.addWarning(WarningConstantDigest, "%s event '%s' uses a constant digest: %s", e.Register, e.Event, e.Note)
+		default:
+			measurements.addWarning(WarningApproximatedEvent, "%s event '%s' is approximated: %s", e.Register, e.Event, e.Note)
+		}
+	}
 
-	measurements := &TdxMeasurements{}
+	return measurements, nil
+}
 
-	// Calculate MRTD
-	switch tcbver {
-	case 6:
-		measurements.MRTD = tdvfMeta.computeMrtd(fwData, mrtdVariantSinglePass)
-	case 7:
-		measurements.MRTD = tdvfMeta.computeMrtd(fwData, mrtdVariantTwoPass)
-	default:
-		return nil, fmt.Errorf("Unsupported tcbver: %d", tcbver)
+// selectRegisters returns the set of registers to compute by canonical name, all of them if no
+// registers are given.
+func selectRegisters(registers []string) (map[string]bool, error) {
+	selected := make(map[string]bool)
+	for _, name := range tdxRegisterNames {
+		selected[name] = len(registers) == 0
+	}
+	for _, name := range registers {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		if _, ok := selected[name]; !ok {
+			return nil, fmt.Errorf("unknown register '%s'", name)
+		}
+		selected[name] = true
 	}
+	return selected, nil
+}
 
-	// RTMR0 calculation (existing code)
-	tdHobHash := measureTdxQemuTdHob(memorySize, tdvfMeta)
-	cfvImageHash, _ := hex.DecodeString("344BC51C980BA621AAA00DA3ED7436F7D6E549197DFE699515DFA2C6583D95E6412AF21C097D473155875FFD561D6790")
-	boot000Hash, _ := hex.DecodeString("23ADA07F5261F12F34A0BD8E46760962D6B4D576A416F1FEA1C64BC656B1D28EACF7047AE6E967C58FD2A98BFA74C298")
-	acpiTablesHash, acpiRsdpHash, acpiLoaderHash, err := measureTdxQemuAcpiTables(templatesPath, memorySize, cpuCount)
+// measureMrtd computes MRTD from the TDVF sections of the firmware.
+func (measurements *TdxMeasurements) measureMrtd(ctx context.Context, fwData []byte, tdvfMeta *tdvfMetadata, variant int, profile *Profile) error {
+	if err := tdvfMeta.checkMrExtend(profile.ZeroExtendRawData); err != nil {
+		return err
+	}
+	var err error
+	if measurements.MRTD, err = tdvfMeta.computeMrtd(ctx, fwData, variant, profile.ZeroExtendRawData); err != nil {
+		return err
+	}
+	measurements.Coverage = append(measurements.Coverage, CoverageEntry{Register: "MRTD", Event: "TDVF sections", Status: CoverageModeled, Source: SourceComputed})
+	return nil
+}
+
+// measureRtmr0 computes RTMR0 from the event sequence of the profile.
+func (measurements *TdxMeasurements) measureRtmr0(ctx context.Context, fwData []byte, tdvfMeta *tdvfMetadata, memorySize uint64, cpuCount uint32, templatesPath string, profile *Profile, fwCfgFiles map[string][]byte) error {
+	tdHob := buildTdxQemuTdHob(memorySize, tdvfMeta, profile)
+	tdHobHash := measurements.measureIntermediate("td_hob.bin", tdHob)
+	cfv := tdvfMeta.cfvImage(fwData)
+	cfvImageHash, err := constantDigest(profile.CfvImageDigest, defaultCfvImageDigest)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	boot000Hash, err := constantDigest(profile.Boot0000Digest, defaultBoot0000Digest)
+	if err != nil {
+		return err
+	}
+	// The CFV image is hashed from the firmware unless the profile pins its digest, e.g. for a
+	// varstore that differs from the one built into the firmware.
+	cfvEvent := measuredEvent{name: "CFV image", eventType: evEfiPlatformFirmwareBlob2, digest: cfvImageHash, status: CoverageApproximated, source: SourceConstant}
+	boot0000Note := "hardcoded digest of a reference boot option"
+	switch {
+	case profile.CfvImageDigest != "":
+		cfvEvent.note = "constant digest supplied by profile"
+	case cfv != nil:
+		cfvEvent.digest = measureSha384(cfv)
+		cfvEvent.status, cfvEvent.source = CoverageModeled, SourceComputed
+	default:
+		cfvEvent.note = "hardcoded digest of a reference OVMF build"
+		measurements.addWarning(WarningUnknownFirmware, "firmware has no CFV section, the CFV image event in RTMR0 uses the digest of the reference OVMF build")
 	}
+	if profile.Boot0000Digest != "" {
+		boot0000Note = "constant digest supplied by profile"
+	}
+	// The BootOrder variable lists the single boot option 0000.
+	bootOrder := []byte{0x00, 0x00}
+	acpiTables, acpiRsdp, acpiLoader, err := GenerateTablesQemuContext(ctx, measurements.logger, templatesPath, memorySize, cpuCount, profile)
+	if err != nil {
+		return fmt.Errorf("failed to generate ACPI tables: %w", err)
+	}
+	acpiTablesHash := measurements.measureIntermediate("acpi_tables.bin", acpiTables)
+	acpiRsdpHash := measurements.measureIntermediate("acpi_rsdp.bin", acpiRsdp)
+	acpiLoaderHash := measurements.measureIntermediate("acpi_loader.bin", acpiLoader)
+	// efiVariable keeps the first error encoding a variable, which is checked once all events are
+	// built.
+	var efiVariableErr error
+	efiVariable := func(vendorGUID, varName string) measuredEvent {
+		data, err := appendTdxEfiVariable(nil, vendorGUID, varName)
+		if err != nil {
+			efiVariableErr = cmp.Or(efiVariableErr, err)
+			return measuredEvent{}
+		}
+		digest := measurements.measureIntermediate("efi_var_"+varName+".bin", data)
+		return measuredEvent{name: varName, eventType: evEfiVariableDriverConfig, digest: digest, data: data, status: CoverageModeled}
+	}
+	separator := []byte{0x00, 0x00, 0x00, 0x00}
 
-	rtmr0Log := append([][]byte{},
-		tdHobHash,
-		cfvImageHash,
-		measureTdxEfiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "SecureBoot"),
-		measureTdxEfiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "PK"),
-		measureTdxEfiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "KEK"),
-		measureTdxEfiVariable("D719B2CB-3D3A-4596-A3BC-DAD00E67656F", "db"),
-		measureTdxEfiVariable("D719B2CB-3D3A-4596-A3BC-DAD00E67656F", "dbx"),
-		measureSha384([]byte{0x00, 0x00, 0x00, 0x00}), // Separator
-		acpiLoaderHash,
-		acpiRsdpHash,
-		acpiTablesHash,
-		measureSha384([]byte{0x00, 0x00}), // BootOrder
-		boot000Hash,                       // Boot000
-		//		measureSha384([]byte{0x00, 0x00, 0x00, 0x00}), // Separator, only present in TCB_SVN 6
-	)
-	measurements.RTMR0 = measureLog(0, rtmr0Log)
-
-	// RTMR1 calculation
-	var err2 error
-	kernelAuthHash, err2 := measureTdxQemuKernelImage(kernelData, uint32(len(initrdData)), memorySize, 0x28000)
-	if err2 != nil {
-		return nil, err2
-	}
-	rtmr1Log := append([][]byte{},
-		kernelAuthHash,
-		measureSha384([]byte("Calling EFI Application from Boot Option")),
-		measureSha384([]byte{0x00, 0x00, 0x00, 0x00}), // Separator.
-		measureSha384([]byte("Exit Boot Services Invocation")),
-		measureSha384([]byte("Exit Boot Services Returned with Success")),
-	)
-	measurements.RTMR1 = measureLog(1, rtmr1Log)
+	// ACPI tables are only validated against captures for the RSDT layout.
+	acpiStatus, acpiNote := CoverageModeled, "generated from template"
+	if profile.RsdpRevision != 0 {
+		acpiStatus, acpiNote = CoverageApproximated, "XSDT loader sequence not validated against captures"
+	}
 
-	// RTMR2 calculation
-	rtmr2Log := append([][]byte{},
-		measureTdxKernelCmdline(kernelCmdline),
-		measureSha384(initrdData),
-	)
-	measurements.RTMR2 = measureLog(2, rtmr2Log)
+	hobStatus, hobNote := CoverageModeled, ""
+	switch {
+	case profile.HobResources != nil:
+		hobNote = "memory map supplied by the user"
+	case profile.Unvalidated:
+		hobStatus, hobNote = CoverageApproximated, "profile memory map not validated against captures"
+	}
 
-	// RTMR3 calculation
-	log := make([]string, 0)
-	log = append(log, hex.EncodeToString(measureSha256(dockerCompose)))
-	log = append(log, hex.EncodeToString(measureSha256(rootfsData)))
-	if len(dockerFiles) > 0 {
-		log = append(log, hex.EncodeToString(measureSha256(dockerFiles)))
+	rtmr0Events := map[string]measuredEvent{
+		EventTdHob:      {name: "TD HOB", eventType: evEfiHandoffTables2, digest: tdHobHash, data: tdHob, status: hobStatus, note: hobNote},
+		EventCfvImage:   cfvEvent,
+		EventSecureBoot: efiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "SecureBoot"),
+		EventPK:         efiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "PK"),
+		EventKEK:        efiVariable("8BE4DF61-93CA-11D2-AA0D-00E098032B8C", "KEK"),
+		EventDb:         efiVariable("D719B2CB-3D3A-4596-A3BC-DAD00E67656F", "db"),
+		EventDbx:        efiVariable("D719B2CB-3D3A-4596-A3BC-DAD00E67656F", "dbx"),
+		EventSeparator:  {name: "Separator", eventType: evSeparator, digest: measureSha384(separator), data: separator, status: CoverageModeled},
+		EventAcpiLoader: {name: "ACPI loader", eventType: evPlatformConfigFlags, digest: acpiLoaderHash, data: acpiLoader, status: acpiStatus, note: acpiNote},
+		EventAcpiRsdp:   {name: "ACPI RSDP", eventType: evPlatformConfigFlags, digest: acpiRsdpHash, data: acpiRsdp, status: acpiStatus, note: acpiNote},
+		EventAcpiTables: {name: "ACPI tables", eventType: evPlatformConfigFlags, digest: acpiTablesHash, data: acpiTables, status: acpiStatus, note: acpiNote},
+		EventBootOrder:  {name: "BootOrder", eventType: evEfiVariableBoot, digest: measureSha384(bootOrder), data: bootOrder, status: CoverageModeled},
+		EventBoot0000:   {name: "Boot0000", eventType: evEfiVariableBoot, digest: boot000Hash, status: CoverageApproximated, source: SourceConstant, note: boot0000Note},
 	}
-	logHashStr, err := replayRTMR(log)
+	if efiVariableErr != nil {
+		return efiVariableErr
+	}
+	extraEvents, err := measurements.fwCfgEvents(profile, fwCfgFiles, memorySize, cpuCount)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	for id, ev := range extraEvents {
+		rtmr0Events[id] = ev
 	}
-	logHash, err := hex.DecodeString(logHashStr)
+	overridden := make([]string, 0, len(profile.EventOverrides))
+	for id := range profile.EventOverrides {
+		overridden = append(overridden, id)
+	}
+	sort.Strings(overridden)
+	for _, id := range overridden {
+		ev, ok := rtmr0Events[id]
+		if !ok {
+			return fmt.Errorf("cannot override unknown RTMR0 event '%s'", id)
+		}
+		if len(profile.EventOverrides[id]) != sha512.Size384 {
+			return fmt.Errorf("override digest of RTMR0 event '%s' must be %d bytes", id, sha512.Size384)
+		}
+		rtmr0Events[id] = measuredEvent{name: ev.name, eventType: ev.eventType, digest: profile.EventOverrides[id], status: CoverageOverridden, source: SourceConstant, note: "digest supplied by the user"}
+		measurements.addWarning(WarningOverrideInEffect, "RTMR0 event '%s' uses a digest supplied by the user", ev.name)
+	}
+	rtmr0Log, err := assembleEvents(profile.Rtmr0Events, rtmr0Events)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	measurements.RTMR3 = logHash
+	measurements.RTMR0 = measurements.measureEvents(0, rtmr0Log)
 
-	return measurements, nil
+	if cfv != nil && profile.CfvImageDigest != "" && profile.EventOverrides[EventCfvImage] == nil && !bytes.Equal(measureSha384(cfv), cfvImageHash) {
+		measurements.addWarning(WarningUnknownFirmware, "firmware CFV does not match the CFV image digest of the profile, the CFV image event in RTMR0 is likely wrong")
+	}
+	return nil
+}
+
+// measureRtmr1 computes RTMR1 from the kernel image as patched by QEMU.
+func (measurements *TdxMeasurements) measureRtmr1(kernelData []byte, initrdSize int, memorySize uint64, acpiDataSize uint32, profile *Profile) error {
+	patchedKernel, err := prepareTdxKernelImage(kernelData, profile.initrdHeaderSize(initrdSize), memorySize, acpiDataSize, profile.KernelPatch)
+	if err != nil {
+		return err
+	}
+	measurements.addIntermediate("kernel_patched.bin", patchedKernel)
+	kernelAuthHash, err := authenticodeHash(patchedKernel)
+	if err != nil {
+		return err
+	}
+	kernelEvent := measuredEvent{name: "Kernel image", eventType: evEfiBootServicesApplication, digest: kernelAuthHash, status: CoverageModeled}
+	if profile.initrdDelivery() == InitrdDeliveryUki {
+		kernelEvent.note = "covers the initrd embedded in the unified kernel image"
+	}
+	action := func(name string) measuredEvent {
+		return measuredEvent{name: name, eventType: evEfiAction, digest: measureSha384([]byte(name)), data: []byte(name), status: CoverageModeled}
+	}
+	separator := []byte{0x00, 0x00, 0x00, 0x00}
+	rtmr1Log := []measuredEvent{
+		kernelEvent,
+		action("Calling EFI Application from Boot Option"),
+		{name: "Separator", eventType: evSeparator, digest: measureSha384(separator), data: separator, status: CoverageModeled},
+		action("Exit Boot Services Invocation"),
+		action("Exit Boot Services Returned with Success"),
+	}
+	measurements.RTMR1 = measurements.measureEvents(1, rtmr1Log)
+	return nil
+}
+
+// measureRtmr2 computes RTMR2 from the kernel command line and the digest of the initrd, which is
+// measured after the command line unless it is embedded in the kernel image.
+func (measurements *TdxMeasurements) measureRtmr2(kernelCmdline string, initrdDigest []byte, profile *Profile) error {
+	cmdline, err := encodeKernelCmdline(kernelCmdline, EncodingOvmf)
+	if err != nil {
+		return err
+	}
+	rtmr2Log := []measuredEvent{
+		{name: "Kernel cmdline", eventType: evEventTag, digest: measurements.measureIntermediate("cmdline_utf16.bin", cmdline), data: cmdline, status: CoverageModeled},
+	}
+	switch profile.initrdDelivery() {
+	case InitrdDeliveryFwCfg:
+		rtmr2Log = append(rtmr2Log, measuredEvent{name: "Initrd", eventType: evEventTag, digest: initrdDigest, status: CoverageModeled})
+	case InitrdDeliveryCmdline:
+		rtmr2Log = append(rtmr2Log, measuredEvent{name: "Initrd", eventType: evEventTag, digest: initrdDigest, status: CoverageModeled, note: "loaded and measured by the kernel EFI stub"})
+	}
+	measurements.RTMR2 = measurements.measureEvents(2, rtmr2Log)
+	if profile.initrdDelivery() == InitrdDeliveryUki {
+		measurements.Coverage = append(measurements.Coverage, CoverageEntry{Register: "RTMR2", Event: "UKI sections", Status: CoverageApproximated, Source: SourceComputed,
+			Note: "measurements of the UKI sections by the UKI stub are not modeled"})
+	}
+	return nil
+}
+
+// measureRtmr3 computes RTMR3 from the runtime inputs and the digest of the rootfs.
+func (measurements *TdxMeasurements) measureRtmr3(dockerCompose, rootfsDigest, dockerFiles []byte) error {
+	var dockerFilesDigest []byte
+	if len(dockerFiles) > 0 {
+		dockerFilesDigest = measureSha256(dockerFiles)
+	}
+	var err error
+	if measurements.RTMR3, err = MeasureRuntimeDigests(measureSha256(dockerCompose), rootfsDigest, dockerFilesDigest); err != nil {
+		return err
+	}
+	measurements.Coverage = append(measurements.Coverage,
+		CoverageEntry{Register: "RTMR3", Event: "Docker compose", Status: CoverageModeled, Source: SourceComputed, Digest: hex.EncodeToString(measureSha256(dockerCompose))},
+		CoverageEntry{Register: "RTMR3", Event: "Rootfs", Status: CoverageModeled, Source: SourceComputed, Digest: hex.EncodeToString(rootfsDigest)},
+	)
+	measurements.Events = append(measurements.Events,
+		LogEvent{Register: "RTMR3", Type: evEventTag, Digest: measureSha256(dockerCompose), Name: "Docker compose", Data: dockerCompose},
+		LogEvent{Register: "RTMR3", Type: evEventTag, Digest: rootfsDigest, Name: "Rootfs"},
+	)
+	if len(dockerFiles) > 0 {
+		measurements.Coverage = append(measurements.Coverage, CoverageEntry{Register: "RTMR3", Event: "Docker files", Status: CoverageModeled, Source: SourceComputed, Digest: hex.EncodeToString(dockerFilesDigest)})
+		measurements.Events = append(measurements.Events, LogEvent{Register: "RTMR3", Type: evEventTag, Digest: dockerFilesDigest, Name: "Docker files", Data: dockerFiles})
+	}
+	return nil
 }
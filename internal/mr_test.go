@@ -0,0 +1,142 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// tdvfImage returns a firmware of fwSize bytes followed by TDVF metadata with the given sections,
+// which are not validated.
+func tdvfImage(t *testing.T, fwSize int, sections ...tdvfSection) []byte {
+	t.Helper()
+	block, err := tdvfMetadataBlock(sections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(make([]byte, fwSize), block...)
+}
+
+func TestParseTdvfMetadataLimits(t *testing.T) {
+	const fwSize = 0x2000
+	tests := []struct {
+		name    string
+		section tdvfSection
+		wantErr bool
+	}{
+		{"raw data within firmware", tdvfSection{dataOffset: 0x1000, rawDataSize: 0x1000, memoryDataSize: 0x1000}, false},
+		{"raw data beyond firmware", tdvfSection{dataOffset: 0x1001, rawDataSize: 0x2000, memoryDataSize: 0x2000}, true},
+		{"data offset at uint32 limit", tdvfSection{dataOffset: 0xffffffff, rawDataSize: 1, memoryDataSize: 0x1000}, true},
+		{"raw data size at uint32 limit", tdvfSection{dataOffset: 0, rawDataSize: 0xffffffff, memoryDataSize: 0x100000000}, true},
+		// The sum overflows 32 bits and would wrap around to a small end offset.
+		{"offset plus size overflows uint32", tdvfSection{dataOffset: 0xfffff000, rawDataSize: 0x2000, memoryDataSize: 0x2000}, true},
+		{"offset and size at uint32 limit", tdvfSection{dataOffset: 0xffffffff, rawDataSize: 0xffffffff, memoryDataSize: 0x100000000}, true},
+		{"memory range at top of address space", tdvfSection{memoryAddress: 0xfffffffffffff000, memoryDataSize: 0x1000}, false},
+		{"memory range beyond address space", tdvfSection{memoryAddress: 0xfffffffffffff000, memoryDataSize: 0x2000}, true},
+		{"memory data size smaller than raw data", tdvfSection{rawDataSize: 0x1000}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fw := tdvfImage(t, fwSize, tt.section)
+			meta, err := parseTdvfMetadata(fw)
+			if !tt.wantErr {
+				if err != nil {
+					t.Fatalf("parseTdvfMetadata() error = %v", err)
+				}
+				if _, err = meta.computeMrtd(context.Background(), fw, mrtdVariantTwoPass, false); err != nil {
+					t.Errorf("computeMrtd() error = %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrMalformedTDVF) {
+				t.Errorf("parseTdvfMetadata() error = %v, want %v", err, ErrMalformedTDVF)
+			}
+		})
+	}
+}
+
+func TestParseTdvfMetadataTruncated(t *testing.T) {
+	fw := tdvfImage(t, 0x1000, tdvfSection{rawDataSize: 0x1000, memoryDataSize: 0x1000})
+	// Every truncation of the trailing metadata must be rejected without a panic.
+	for n := 0; n < len(fw)-0x1000; n++ {
+		if _, err := parseTdvfMetadata(fw[len(fw)-n:]); err == nil {
+			t.Errorf("parseTdvfMetadata() of the last %d bytes succeeded", n)
+		}
+	}
+}
+
+func TestComputeMrtdRawDataShort(t *testing.T) {
+	fw := tdvfImage(t, 0x1000, tdvfSection{dataOffset: 0, rawDataSize: 0x800, memoryDataSize: 0x1000, attributes: attributeMrExtend})
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = meta.checkMrExtend(false); !errors.Is(err, ErrMalformedTDVF) {
+		t.Errorf("checkMrExtend() error = %v, want %v", err, ErrMalformedTDVF)
+	}
+	// computeMrtd must fail on its own when called without checkMrExtend.
+	for _, variant := range []int{mrtdVariantTwoPass, mrtdVariantSinglePass} {
+		if _, err = meta.computeMrtd(context.Background(), fw, variant, false); !errors.Is(err, ErrMalformedTDVF) {
+			t.Errorf("computeMrtd(variant %d) error = %v, want %v", variant, err, ErrMalformedTDVF)
+		}
+	}
+	if _, err = meta.computeMrtd(context.Background(), fw, mrtdVariantTwoPass, true); err != nil {
+		t.Errorf("computeMrtd() with zero extension error = %v", err)
+	}
+}
+
+func TestCfvImageDigest(t *testing.T) {
+	cfv := bytes.Repeat([]byte("synthetic varstore"), 64)
+	fw := tdvfImage(t, 0x2000,
+		tdvfSection{dataOffset: 0, rawDataSize: 0x1000, memoryDataSize: 0x1000, secType: tdvfSectionBfv},
+		tdvfSection{dataOffset: 0x1000, rawDataSize: uint32(len(cfv)), memoryDataSize: 0x1000, secType: tdvfSectionCfv},
+	)
+	copy(fw[0x1000:], cfv)
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sha512.Sum384(cfv)
+	if got := measureSha384(meta.cfvImage(fw)); !bytes.Equal(got, want[:]) {
+		t.Errorf("CFV image digest = %x, want %x", got, want)
+	}
+
+	fw = tdvfImage(t, 0x1000, tdvfSection{rawDataSize: 0x1000, memoryDataSize: 0x1000, secType: tdvfSectionBfv})
+	if meta, err = parseTdvfMetadata(fw); err != nil {
+		t.Fatal(err)
+	}
+	if image := meta.cfvImage(fw); image != nil {
+		t.Errorf("CFV image of a firmware without CFV section = %d bytes, want none", len(image))
+	}
+}
+
+// TestCfvImageDigestReference checks that the CFV image digest computed from the reference OVMF
+// build equals defaultCfvImageDigest, the constant the CFV image event used before it was
+// computed. The firmware is not part of the repository; the test runs when
+// REPRODUCE_MR_REFERENCE_OVMF gives its path.
+func TestCfvImageDigestReference(t *testing.T) {
+	path := os.Getenv("REPRODUCE_MR_REFERENCE_OVMF")
+	if path == "" {
+		t.Skip("REPRODUCE_MR_REFERENCE_OVMF is not set")
+	}
+	fw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfv := meta.cfvImage(fw)
+	if cfv == nil {
+		t.Fatal("reference firmware has no CFV section")
+	}
+	if got := hex.EncodeToString(measureSha384(cfv)); !strings.EqualFold(got, defaultCfvImageDigest) {
+		t.Errorf("CFV image digest = %s, want %s", got, strings.ToLower(defaultCfvImageDigest))
+	}
+}
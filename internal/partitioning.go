@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+)
+
+// MeasureTdxPartitioned computes the measurements of a partitioned TD, whose quote reports the L1
+// TD running a paravisor (e.g. OpenHCL on Hyper-V) rather than the L2 guest booted under it.
+//
+// The MRTD is that of the L1 TD, built from the paravisor IGVM file. The paravisor extends the
+// RTMRs with its own events first, which are taken from its event log as they depend on the host,
+// and then forwards the extensions of the L2 guest into the RTMR of the same index. l2 are the
+// measurements of the guest, whose per-event digests are replayed after those of the paravisor.
+// Registers not computed for the guest are left nil.
+func MeasureTdxPartitioned(igvmData []byte, paravisorEvents []LogEvent, l2 *TdxMeasurements, profile *Profile) (*TdxMeasurements, error) {
+	m := &TdxMeasurements{Intermediates: l2.Intermediates, logger: l2.logger}
+	var (
+		parameters bool
+		err        error
+	)
+	if m.MRTD, parameters, err = MeasureIgvmMrtd(igvmData); err != nil {
+		return nil, fmt.Errorf("failed to measure paravisor: %w", err)
+	}
+
+	unmodeled := fmt.Sprintf(unmodeledPartitioningWarning, profile.Name)
+	for _, w := range l2.Warnings {
+		if w.Code != WarningTdFeature || w.Message != unmodeled {
+			m.Warnings = append(m.Warnings, w)
+		}
+	}
+	if parameters {
+		m.addWarning(WarningApproximatedEvent, "paravisor parameter areas are assumed to be added to the L1 TD without being extended into MRTD")
+	}
+
+	// The RTMRs follow MRTD in the register table.
+	l2Registers := l2.registerFields()[1:]
+	registers := m.registerFields()[1:]
+	paravisorCount := 0
+	for i := range registers {
+		if *l2Registers[i] == nil {
+			continue
+		}
+		register := tdxRegisterNames[i+1]
+		if err := CheckSha384Bank(paravisorEvents, register); err != nil {
+			return nil, fmt.Errorf("paravisor event log: %w", err)
+		}
+		var events []measuredEvent
+		for _, e := range paravisorEvents {
+			if e.Register == register {
+				events = append(events, measuredEvent{
+					name:      fmt.Sprintf("Paravisor event 0x%x", e.Type),
+					eventType: e.Type,
+					digest:    padDigest(e.Digest),
+					data:      e.Data,
+					status:    CoverageApproximated,
+					source:    SourceEventLog,
+					note:      "taken from the paravisor event log",
+				})
+				paravisorCount++
+			}
+		}
+		// The events of the guest are in the order of the coverage entries with a digest.
+		var l2Events []LogEvent
+		for _, e := range l2.Events {
+			if e.Register == register {
+				l2Events = append(l2Events, e)
+			}
+		}
+		for _, e := range l2.Coverage {
+			if e.Register != register {
+				continue
+			}
+			digest, err := hex.DecodeString(e.Digest)
+			if err != nil || len(digest) == 0 {
+				return nil, fmt.Errorf("%s event '%s' of the L2 guest has no digest to forward", register, e.Event)
+			}
+			event := measuredEvent{name: "L2 " + e.Event, digest: padDigest(digest), status: e.Status, source: e.Source, note: e.Note}
+			if len(l2Events) > 0 {
+				event.eventType, event.data = l2Events[0].Type, l2Events[0].Data
+				l2Events = l2Events[1:]
+			}
+			events = append(events, event)
+		}
+		*registers[i] = m.measureEvents(i, events)
+	}
+	if paravisorCount > 0 {
+		m.addWarning(WarningApproximatedEvent, "%d paravisor events are taken from its event log and not reproduced from the inputs", paravisorCount)
+	}
+	return m, nil
+}
+
+// padDigest zero-pads a digest to the 48 bytes of a SHA384 digest, as done when extending RTMRs
+// with shorter digests.
+func padDigest(digest []byte) []byte {
+	if len(digest) >= 48 {
+		return digest
+	}
+	padded := make([]byte, 48)
+	copy(padded, digest)
+	return padded
+}
@@ -0,0 +1,371 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultProfile is the name of the profile used when none is selected.
+const DefaultProfile = "qemu-tdx"
+
+// TD HOB resource types.
+const (
+	HobResourceSystemMemory     = 0x00
+	HobResourceMemoryUnaccepted = 0x07
+)
+
+// TD HOB resource attributes.
+const (
+	HobAttributePresent     = 0x00000001
+	HobAttributeInitialized = 0x00000002
+	HobAttributeTested      = 0x00000004
+	HobAttributeEncrypted   = 0x04000000
+
+	hobAttributesDefault = HobAttributePresent | HobAttributeInitialized | HobAttributeTested
+)
+
+// HobRange is a range of guest memory described by a resource descriptor in the TD HOB.
+type HobRange struct {
+	Start  uint64
+	Length uint64
+	// Accepted is true for memory that is added by the VMM before the TD starts.
+	Accepted bool
+}
+
+// RTMR0 event identifiers used in profile event sequences.
+const (
+	EventTdHob      = "td-hob"
+	EventCfvImage   = "cfv-image"
+	EventSecureBoot = "secure-boot"
+	EventPK         = "pk"
+	EventKEK        = "kek"
+	EventDb         = "db"
+	EventDbx        = "dbx"
+	EventSeparator  = "separator"
+	EventAcpiLoader = "acpi-loader"
+	EventAcpiRsdp   = "acpi-rsdp"
+	EventAcpiTables = "acpi-tables"
+	EventBootOrder  = "boot-order"
+	EventBoot0000   = "boot0000"
+)
+
+// Profile describes the behavior of a particular QEMU/firmware combination that affects the
+// resulting measurements.
+type Profile struct {
+	// Name is the unique name of the profile.
+	Name string
+	// Description is a human readable description of the profile.
+	Description string
+	// Unvalidated is set for profiles whose parameters were not validated against captured quotes.
+	Unvalidated bool
+
+	// RsdpRevision is the revision of the generated RSDP. Revision 0 (ACPI 1.0) points to an RSDT
+	// while revision 2 points to an XSDT.
+	RsdpRevision uint8
+	// ZeroExtendRawData zero-extends the raw data of TDVF sections which are extended into MRTD
+	// but whose raw data is shorter than their memory data size, as some QEMU versions do.
+	ZeroExtendRawData bool
+
+	// HobFirmwareRanges is the memory map below the start of guest RAM as described in the TD HOB.
+	HobFirmwareRanges []HobRange
+	// HobRamStart is the address where guest RAM described in the TD HOB starts.
+	HobRamStart uint64
+	// HobUnacceptedType is the resource type used for memory that is not accepted.
+	HobUnacceptedType uint8
+	// HobAcceptedAttributes are the resource attributes of accepted memory.
+	HobAcceptedAttributes uint32
+	// HobUnacceptedAttributes are the resource attributes of memory that is not accepted.
+	HobUnacceptedAttributes uint32
+	// HobResources replaces the memory map described in the TD HOB, which is derived from the memory
+	// size and the fields above when it is nil.
+	HobResources []HobResource
+
+	// Rtmr0Events is the sequence of events extended into RTMR0.
+	Rtmr0Events []string
+	// TcbVersions lists the TCB versions of the platforms the profile applies to. It applies to all
+	// of them when empty.
+	TcbVersions []uint8
+
+	// MinDstackVersion and MaxDstackVersion bound the dstack image versions (inclusive and
+	// exclusive respectively) booted by the QEMU/firmware combination of the profile. Profiles that
+	// are not tied to dstack releases leave them empty.
+	MinDstackVersion string
+	MaxDstackVersion string
+
+	// InitrdSizeAlignment is the alignment the initrd size written into the kernel boot header is
+	// rounded up to. The load address in the header is computed from that size and aligned down to
+	// 4 KiB; the measured initrd itself is not padded. The exact size is written when it is zero,
+	// as QEMU's x86_load_linux does, so no built-in profile sets it; it is meant for profile packs
+	// of VMMs that round the size.
+	InitrdSizeAlignment uint32
+
+	// KernelPatch controls how the kernel boot header is modified before measurement. The QEMU
+	// modifications are applied when it is empty.
+	KernelPatch KernelPatchMode
+	// InitrdDelivery is how the initrd reaches the kernel, fw_cfg when it is empty.
+	InitrdDelivery InitrdDelivery
+	// TdFeatures are the TD features (e.g. migration, partitioning) enabled for the TDs of the
+	// profile. Features unknown to this version of the tool are kept and reported as warnings.
+	TdFeatures []string
+
+	// CfvImageDigest and Boot0000Digest are the hex-encoded constant digests of the CFV image and
+	// Boot0000 events. When they are empty, the CFV image is hashed from the CFV section of the
+	// firmware and Boot0000 uses the digest of the reference OVMF build.
+	CfvImageDigest string
+	Boot0000Digest string
+	// TemplatesPath is the directory of the ACPI table templates shipped with the profile, used
+	// when no templates are given explicitly.
+	TemplatesPath string
+
+	// MemoryHotplug is the memory hotplug configuration of the guest, nil when memory hotplug is
+	// not configured.
+	MemoryHotplug *MemoryHotplug
+
+	// FwCfg configures the miscellaneous fw_cfg files QEMU provides for the machine type of the
+	// profile. Files it does not configure cannot be generated.
+	FwCfg *FwCfgSettings
+
+	// EventOverrides maps RTMR0 event identifiers to digests supplied by the user, which replace
+	// the modeled digests of these events.
+	EventOverrides map[string][]byte
+
+	// fromPack is set for profiles loaded from a profile pack.
+	fromPack bool
+}
+
+// Constant digests of the reference OVMF build. The CFV image digest is only used for firmware
+// without a CFV section.
+const (
+	defaultCfvImageDigest = "344BC51C980BA621AAA00DA3ED7436F7D6E549197DFE699515DFA2C6583D95E6412AF21C097D473155875FFD561D6790"
+	defaultBoot0000Digest = "23ADA07F5261F12F34A0BD8E46760962D6B4D576A416F1FEA1C64BC656B1D28EACF7047AE6E967C58FD2A98BFA74C298"
+)
+
+// constantDigest decodes a hex-encoded constant event digest, falling back to the given default.
+func constantDigest(value, fallback string) ([]byte, error) {
+	if value == "" {
+		value = fallback
+	}
+	digest, err := hex.DecodeString(value)
+	if err != nil || len(digest) != 48 {
+		return nil, fmt.Errorf("invalid constant digest '%s', expected 48 hex-encoded bytes", value)
+	}
+	return digest, nil
+}
+
+// defaultHobFirmwareRanges is the memory map of the firmware region as set up by QEMU for the
+// TDVF metadata layout of the reference OVMF build.
+var defaultHobFirmwareRanges = []HobRange{
+	{Start: 0x0000000000000000, Length: 0x0000000000800000},
+	{Start: 0x0000000000800000, Length: 0x0000000000006000, Accepted: true},
+	{Start: 0x0000000000806000, Length: 0x0000000000003000},
+	{Start: 0x0000000000809000, Length: 0x0000000000002000, Accepted: true},
+	{Start: 0x000000000080B000, Length: 0x0000000000002000, Accepted: true},
+	{Start: 0x000000000080D000, Length: 0x0000000000004000},
+	{Start: 0x0000000000811000, Length: 0x000000000000f000, Accepted: true},
+}
+
+// defaultRtmr0Events is the sequence of RTMR0 events measured by OVMF when booted by QEMU.
+var defaultRtmr0Events = []string{
+	EventTdHob,
+	EventCfvImage,
+	EventSecureBoot,
+	EventPK,
+	EventKEK,
+	EventDb,
+	EventDbx,
+	EventSeparator,
+	EventAcpiLoader,
+	EventAcpiRsdp,
+	EventAcpiTables,
+	EventBootOrder,
+	EventBoot0000,
+}
+
+// defaultFwCfgSettings are the miscellaneous fw_cfg files of the QEMU q35 machine without
+// additional devices: both S3 and S4 enabled, no extra PCI roots and no boot menu.
+var defaultFwCfgSettings = &FwCfgSettings{SystemStates: &SystemStates{}}
+
+var profiles = map[string]*Profile{
+	"qemu-tdx": {
+		Name:                    "qemu-tdx",
+		Description:             "QEMU with TDX support, ACPI 1.0 RSDP pointing to an RSDT",
+		RsdpRevision:            0,
+		HobFirmwareRanges:       defaultHobFirmwareRanges,
+		HobRamStart:             0x820000,
+		HobUnacceptedType:       HobResourceMemoryUnaccepted,
+		HobAcceptedAttributes:   hobAttributesDefault,
+		HobUnacceptedAttributes: hobAttributesDefault,
+		Rtmr0Events:             defaultRtmr0Events,
+		FwCfg:                   defaultFwCfgSettings,
+		MinDstackVersion:        "0.4.0",
+	},
+	"qemu-tdx-xsdt": {
+		Name:                    "qemu-tdx-xsdt",
+		Description:             "QEMU with TDX support, revision 2 RSDP pointing to an XSDT",
+		RsdpRevision:            2,
+		HobFirmwareRanges:       defaultHobFirmwareRanges,
+		HobRamStart:             0x820000,
+		HobUnacceptedType:       HobResourceMemoryUnaccepted,
+		HobAcceptedAttributes:   hobAttributesDefault,
+		HobUnacceptedAttributes: hobAttributesDefault,
+		Rtmr0Events:             defaultRtmr0Events,
+		FwCfg:                   defaultFwCfgSettings,
+	},
+	"qemu-tdx-zero-extend": {
+		Name:                    "qemu-tdx-zero-extend",
+		Description:             "QEMU with TDX support, zero-extending short TDVF section raw data during MR.EXTEND",
+		RsdpRevision:            0,
+		ZeroExtendRawData:       true,
+		HobFirmwareRanges:       defaultHobFirmwareRanges,
+		HobRamStart:             0x820000,
+		HobUnacceptedType:       HobResourceMemoryUnaccepted,
+		HobAcceptedAttributes:   hobAttributesDefault,
+		HobUnacceptedAttributes: hobAttributesDefault,
+		Rtmr0Events:             defaultRtmr0Events,
+		FwCfg:                   defaultFwCfgSettings,
+	},
+}
+
+// LookupProfile returns the built-in profile with the given name.
+func LookupProfile(name string) (*Profile, error) {
+	p, ok := profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownProfile, name)
+	}
+	return p, nil
+}
+
+// Profiles returns all built-in profiles sorted by name.
+func Profiles() []*Profile {
+	var list []*Profile
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// initrdDelivery returns how the initrd reaches the kernel.
+func (p *Profile) initrdDelivery() InitrdDelivery {
+	if p.InitrdDelivery == "" {
+		return InitrdDeliveryFwCfg
+	}
+	return p.InitrdDelivery
+}
+
+// initrdHeaderSize returns the initrd size written into the kernel boot header. It is zero unless
+// QEMU loads the initrd.
+func (p *Profile) initrdHeaderSize(size int) uint32 {
+	if p.initrdDelivery() != InitrdDeliveryFwCfg {
+		return 0
+	}
+	if a := p.InitrdSizeAlignment; a > 1 && size > 0 {
+		return (uint32(size) + a - 1) / a * a
+	}
+	return uint32(size)
+}
+
+// SupportsDstackVersion returns whether the profile applies to dstack images of the given version.
+// The second return value is false when the profile or the version carry no version information.
+func (p *Profile) SupportsDstackVersion(version string) (bool, bool) {
+	if p.MinDstackVersion == "" && p.MaxDstackVersion == "" {
+		return false, false
+	}
+	v, ok := parseDstackVersion(version)
+	if !ok {
+		return false, false
+	}
+	if p.MinDstackVersion != "" {
+		if min, _ := parseDstackVersion(p.MinDstackVersion); compareVersions(v, min) < 0 {
+			return false, true
+		}
+	}
+	if p.MaxDstackVersion != "" {
+		if max, _ := parseDstackVersion(p.MaxDstackVersion); compareVersions(v, max) >= 0 {
+			return false, true
+		}
+	}
+	return true, true
+}
+
+// ProfileForDstackVersion returns the built-in profile for dstack images of the given version.
+func ProfileForDstackVersion(version string) (*Profile, error) {
+	for _, p := range Profiles() {
+		if ok, _ := p.SupportsDstackVersion(version); ok {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no profile known for dstack version '%s'", version)
+}
+
+// parseDstackVersion parses a dstack version such as "v0.5.3" or "0.5.3-dev" into its numeric
+// components.
+func parseDstackVersion(version string) ([3]int, bool) {
+	var v [3]int
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if version == "" || len(parts) > len(v) {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+// compareVersions compares two parsed versions.
+func compareVersions(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// WithEventOverrides returns a copy of the profile that uses the given digests for the RTMR0 events
+// with the given identifiers instead of modeling them.
+func (p *Profile) WithEventOverrides(overrides map[string][]byte) *Profile {
+	cp := *p
+	cp.EventOverrides = make(map[string][]byte, len(p.EventOverrides)+len(overrides))
+	for id, digest := range p.EventOverrides {
+		cp.EventOverrides[id] = digest
+	}
+	for id, digest := range overrides {
+		cp.EventOverrides[id] = digest
+	}
+	return &cp
+}
+
+// WithHobResources returns a copy of the profile describing the given memory map in the TD HOB.
+func (p *Profile) WithHobResources(resources []HobResource) *Profile {
+	cp := *p
+	cp.HobResources = resources
+	return &cp
+}
+
+// assembleEvents orders the available events according to the given event sequence.
+func assembleEvents(sequence []string, available map[string]measuredEvent) ([]measuredEvent, error) {
+	events := make([]measuredEvent, 0, len(sequence))
+	for _, id := range sequence {
+		ev, ok := available[id]
+		if !ok {
+			return nil, fmt.Errorf("profile references unknown event '%s'", id)
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestInitrdHeaderSize(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		alignment uint32
+		delivery  InitrdDelivery
+		want      uint32
+	}{
+		{"no initrd", 0, 0, "", 0},
+		{"no initrd, aligned", 0, 4096, "", 0},
+		{"page minus one", 4095, 0, "", 4095},
+		{"page", 4096, 0, "", 4096},
+		{"page plus one", 4097, 0, "", 4097},
+		{"page minus one, aligned", 4095, 4096, "", 4096},
+		{"page, aligned", 4096, 4096, "", 4096},
+		{"page plus one, aligned", 4097, 4096, "", 8192},
+		{"alignment of one", 4097, 1, "", 4097},
+		{"loaded by the kernel", 4097, 4096, InitrdDeliveryCmdline, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := profiles[DefaultProfile].WithInitrdSizeAlignment(tt.alignment)
+			profile.InitrdDelivery = tt.delivery
+			if got := profile.initrdHeaderSize(tt.size); got != tt.want {
+				t.Errorf("initrdHeaderSize(%d) = %d, want %d", tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitrdBootHeader(t *testing.T) {
+	kernel := syntheticKernel(0x10000)
+	// The load address is the highest page aligned address below initrd_addr_max (0x37ffffff for
+	// the synthetic kernel) at which the initrd fits.
+	tests := []struct {
+		size uint32
+		addr uint32
+	}{
+		{4095, 0x37fff000},
+		{4096, 0x37ffe000},
+		{4097, 0x37ffe000},
+		{8191, 0x37ffe000},
+		{8192, 0x37ffd000},
+	}
+	for _, tt := range tests {
+		kd, err := prepareTdxKernelImage(kernel, tt.size, 2048, 0x20000, KernelPatchQemu)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := binary.LittleEndian.Uint32(kd[0x21c:]); got != tt.size {
+			t.Errorf("size %d: header size = %d", tt.size, got)
+		}
+		if got := binary.LittleEndian.Uint32(kd[0x218:]); got != tt.addr {
+			t.Errorf("size %d: load address = 0x%x, want 0x%x", tt.size, got, tt.addr)
+		}
+	}
+}
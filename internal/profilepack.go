@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// profilePackIndex is the name of the file listing the profiles of a profile pack.
+const profilePackIndex = "profiles.json"
+
+// packProfile is the definition of a profile in a profile pack.
+type packProfile struct {
+	Name                    string         `json:"name"`
+	Description             string         `json:"description"`
+	Unvalidated             bool           `json:"unvalidated,omitempty"`
+	RsdpRevision            uint8          `json:"rsdp_revision"`
+	ZeroExtendRawData       bool           `json:"zero_extend_raw_data,omitempty"`
+	HobFirmwareRanges       []packHobRange `json:"hob_firmware_ranges,omitempty"`
+	HobRamStart             uint64         `json:"hob_ram_start"`
+	HobUnacceptedType       uint8          `json:"hob_unaccepted_type"`
+	HobAcceptedAttributes   uint32         `json:"hob_accepted_attributes"`
+	HobUnacceptedAttributes uint32         `json:"hob_unaccepted_attributes"`
+	Rtmr0Events             []string       `json:"rtmr0_events,omitempty"`
+	TcbVersions             []int          `json:"tcb_versions,omitempty"`
+	MinDstackVersion        string         `json:"min_dstack_version,omitempty"`
+	MaxDstackVersion        string         `json:"max_dstack_version,omitempty"`
+	InitrdSizeAlignment     uint32         `json:"initrd_size_alignment,omitempty"`
+	KernelPatch             string         `json:"kernel_patch,omitempty"`
+	InitrdDelivery          string         `json:"initrd_delivery,omitempty"`
+	TdFeatures              []string       `json:"td_features,omitempty"`
+	CfvImageDigest          string         `json:"cfv_image_digest,omitempty"`
+	Boot0000Digest          string         `json:"boot0000_digest,omitempty"`
+	FwCfg                   *FwCfgSettings `json:"fw_cfg,omitempty"`
+	// Templates is the directory of ACPI table templates within the pack.
+	Templates string `json:"templates,omitempty"`
+}
+
+// packHobRange is a TD HOB memory range in a profile pack.
+type packHobRange struct {
+	Start    uint64 `json:"start"`
+	Length   uint64 `json:"length"`
+	Accepted bool   `json:"accepted,omitempty"`
+}
+
+// knownRtmr0Events are the event identifiers profiles can reference besides fw_cfg files.
+var knownRtmr0Events = map[string]bool{
+	EventTdHob: true, EventCfvImage: true, EventSecureBoot: true, EventPK: true, EventKEK: true,
+	EventDb: true, EventDbx: true, EventSeparator: true, EventAcpiLoader: true, EventAcpiRsdp: true,
+	EventAcpiTables: true, EventBootOrder: true, EventBoot0000: true,
+}
+
+// LoadProfilePack verifies a profile pack (a tar archive with detached hex-encoded signatures in
+// <pack>.sig, one per line) against the trusted keys, extracts it into a subdirectory of cacheDir
+// and registers its profiles so they can be selected by name. A pack is accepted when any of its
+// signatures verifies with any trusted key, so packs can be signed with the old and the new key
+// while the signing key is rotated. Profiles must not replace built-in ones.
+func LoadProfilePack(packPath string, anchors TrustAnchors, cacheDir string) ([]*Profile, error) {
+	data, err := os.ReadFile(packPath)
+	if err != nil {
+		return nil, err
+	}
+	sigHex, err := os.ReadFile(packPath + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read profile pack signature: %w", err)
+	}
+	var sigs [][]byte
+	for _, line := range strings.Fields(string(sigHex)) {
+		sig, err := hex.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("profile pack %s has a malformed signature: %w", filepath.Base(packPath), err)
+		}
+		sigs = append(sigs, sig)
+	}
+	if _, err = anchors.Verify(data, sigs); err != nil {
+		return nil, fmt.Errorf("profile pack %s: %w", filepath.Base(packPath), err)
+	}
+
+	packHash := sha256.Sum256(data)
+	dir := filepath.Join(cacheDir, hex.EncodeToString(packHash[:8]))
+	if err = extractTar(bytes.NewReader(data), dir); err != nil {
+		return nil, fmt.Errorf("failed to extract profile pack: %w", err)
+	}
+
+	index, err := os.ReadFile(filepath.Join(dir, profilePackIndex))
+	if err != nil {
+		return nil, fmt.Errorf("profile pack does not contain %s", profilePackIndex)
+	}
+	var pack struct {
+		Profiles []packProfile `json:"profiles"`
+	}
+	if err = json.Unmarshal(index, &pack); err != nil {
+		return nil, fmt.Errorf("malformed %s: %w", profilePackIndex, err)
+	}
+
+	var loaded []*Profile
+	for _, pp := range pack.Profiles {
+		p, err := pp.profile(dir)
+		if err != nil {
+			return nil, fmt.Errorf("profile '%s': %w", pp.Name, err)
+		}
+		loaded = append(loaded, p)
+	}
+	for _, p := range loaded {
+		if existing, ok := profiles[p.Name]; ok && !existing.fromPack {
+			return nil, fmt.Errorf("profile pack must not replace built-in profile '%s'", p.Name)
+		}
+		profiles[p.Name] = p
+	}
+	return loaded, nil
+}
+
+// profile converts and validates a profile pack definition. Template paths are resolved relative
+// to the extracted pack directory.
+func (pp *packProfile) profile(dir string) (*Profile, error) {
+	if pp.Name == "" {
+		return nil, fmt.Errorf("profile without name")
+	}
+	p := &Profile{
+		Name:                    pp.Name,
+		Description:             pp.Description,
+		Unvalidated:             pp.Unvalidated,
+		RsdpRevision:            pp.RsdpRevision,
+		ZeroExtendRawData:       pp.ZeroExtendRawData,
+		HobFirmwareRanges:       defaultHobFirmwareRanges,
+		HobRamStart:             pp.HobRamStart,
+		HobUnacceptedType:       pp.HobUnacceptedType,
+		HobAcceptedAttributes:   pp.HobAcceptedAttributes,
+		HobUnacceptedAttributes: pp.HobUnacceptedAttributes,
+		Rtmr0Events:             defaultRtmr0Events,
+		MinDstackVersion:        pp.MinDstackVersion,
+		MaxDstackVersion:        pp.MaxDstackVersion,
+		InitrdSizeAlignment:     pp.InitrdSizeAlignment,
+		KernelPatch:             KernelPatchMode(pp.KernelPatch),
+		InitrdDelivery:          InitrdDelivery(pp.InitrdDelivery),
+		TdFeatures:              pp.TdFeatures,
+		CfvImageDigest:          pp.CfvImageDigest,
+		Boot0000Digest:          pp.Boot0000Digest,
+		FwCfg:                   defaultFwCfgSettings,
+		fromPack:                true,
+	}
+	if p.RsdpRevision != 0 && p.RsdpRevision != 2 {
+		return nil, fmt.Errorf("unsupported RSDP revision %d", p.RsdpRevision)
+	}
+	if p.HobRamStart == 0 {
+		return nil, fmt.Errorf("guest RAM start is required")
+	}
+	if pp.FwCfg != nil {
+		p.FwCfg = pp.FwCfg
+	}
+	if len(pp.HobFirmwareRanges) > 0 {
+		p.HobFirmwareRanges = nil
+		for _, r := range pp.HobFirmwareRanges {
+			p.HobFirmwareRanges = append(p.HobFirmwareRanges, HobRange{Start: r.Start, Length: r.Length, Accepted: r.Accepted})
+		}
+	}
+	if len(pp.Rtmr0Events) > 0 {
+		for _, id := range pp.Rtmr0Events {
+			if !knownRtmr0Events[id] && !strings.HasPrefix(id, fwCfgEventPrefix) {
+				return nil, fmt.Errorf("unknown RTMR0 event '%s'", id)
+			}
+		}
+		p.Rtmr0Events = pp.Rtmr0Events
+	}
+	for _, v := range pp.TcbVersions {
+		if v < 0 || v > 255 {
+			return nil, fmt.Errorf("invalid TCB version %d", v)
+		}
+		p.TcbVersions = append(p.TcbVersions, uint8(v))
+	}
+	switch p.KernelPatch {
+	case "", KernelPatchQemu, KernelPatchNone, KernelPatchPrepatched:
+	default:
+		return nil, fmt.Errorf("unsupported kernel patch mode '%s'", p.KernelPatch)
+	}
+	if _, err := ParseInitrdDelivery(pp.InitrdDelivery); err != nil {
+		return nil, err
+	}
+	for _, d := range []string{p.CfvImageDigest, p.Boot0000Digest} {
+		if d != "" {
+			if _, err := constantDigest(d, ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, v := range []string{p.MinDstackVersion, p.MaxDstackVersion} {
+		if _, ok := parseDstackVersion(v); v != "" && !ok {
+			return nil, fmt.Errorf("invalid dstack version '%s'", v)
+		}
+	}
+	if pp.Templates != "" {
+		clean := path.Clean(pp.Templates)
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("templates directory '%s' is outside of the pack", pp.Templates)
+		}
+		p.TemplatesPath = filepath.Join(dir, filepath.FromSlash(clean))
+	}
+	return p, nil
+}
+
+// DefaultProfilePacksCacheDir returns the directory profile packs are extracted into.
+func DefaultProfilePacksCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "reproduce-mr", "profile-packs"), nil
+}
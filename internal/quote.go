@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	quoteHeaderSize      = 48
+	quoteTeeTypeTdx      = 0x81
+	tdReportBodySize     = 584
+	tdReportBody15Size   = 648
+	quoteBodyTypeTdx10   = 2
+	quoteBodyTypeTdx15   = 3
+	tdAttributeDebugMask = 0x1
+)
+
+// TdReport contains the TD report body of a TDX quote.
+type TdReport struct {
+	// QuoteVersion is the version of the quote the report was taken from.
+	QuoteVersion uint16
+
+	TeeTcbSvn      []byte
+	MrSeam         []byte
+	MrSignerSeam   []byte
+	SeamAttributes []byte
+	TdAttributes   []byte
+	Xfam           []byte
+	MRTD           []byte
+	MrConfigId     []byte
+	MrOwner        []byte
+	MrOwnerConfig  []byte
+	RTMR0          []byte
+	RTMR1          []byte
+	RTMR2          []byte
+	RTMR3          []byte
+	ReportData     []byte
+
+	// TeeTcbSvn2 and MrServiceTd are only present in TDX 1.5 report bodies.
+	TeeTcbSvn2  []byte
+	MrServiceTd []byte
+}
+
+// Debug returns whether the report was produced by a debuggable TD.
+func (r *TdReport) Debug() bool {
+	return len(r.TdAttributes) > 0 && r.TdAttributes[0]&tdAttributeDebugMask != 0
+}
+
+// quoteBody returns the offset and size of the TD report body of a version 4 or 5 TDX quote.
+func quoteBody(quote []byte) (int, int, error) {
+	if len(quote) < quoteHeaderSize {
+		return 0, 0, fmt.Errorf("quote too short (%d bytes)", len(quote))
+	}
+	version := binary.LittleEndian.Uint16(quote[0:2])
+	teeType := binary.LittleEndian.Uint32(quote[4:8])
+	if teeType != quoteTeeTypeTdx {
+		return 0, 0, fmt.Errorf("not a TDX quote (TEE type 0x%x)", teeType)
+	}
+
+	offset := quoteHeaderSize
+	bodySize := tdReportBodySize
+	switch version {
+	case 4:
+	case 5:
+		if len(quote) < offset+6 {
+			return 0, 0, fmt.Errorf("quote too short (%d bytes)", len(quote))
+		}
+		switch bodyType := binary.LittleEndian.Uint16(quote[offset : offset+2]); bodyType {
+		case quoteBodyTypeTdx10:
+		case quoteBodyTypeTdx15:
+			bodySize = tdReportBody15Size
+		default:
+			return 0, 0, fmt.Errorf("unsupported quote body type %d", bodyType)
+		}
+		offset += 6 // Body type and size.
+	default:
+		return 0, 0, fmt.Errorf("unsupported quote version %d", version)
+	}
+	if len(quote) < offset+bodySize {
+		return 0, 0, fmt.Errorf("quote too short (%d bytes)", len(quote))
+	}
+	return offset, bodySize, nil
+}
+
+// ParseQuote decodes the TD report body of a version 4 or 5 TDX quote. The quote signature is not
+// verified.
+func ParseQuote(quote []byte) (*TdReport, error) {
+	offset, bodySize, err := quoteBody(quote)
+	if err != nil {
+		return nil, err
+	}
+	body := quote[offset : offset+bodySize]
+
+	report := &TdReport{
+		QuoteVersion:   binary.LittleEndian.Uint16(quote[0:2]),
+		TeeTcbSvn:      body[0:16],
+		MrSeam:         body[16:64],
+		MrSignerSeam:   body[64:112],
+		SeamAttributes: body[112:120],
+		TdAttributes:   body[120:128],
+		Xfam:           body[128:136],
+		MRTD:           body[136:184],
+		MrConfigId:     body[184:232],
+		MrOwner:        body[232:280],
+		MrOwnerConfig:  body[280:328],
+		RTMR0:          body[328:376],
+		RTMR1:          body[376:424],
+		RTMR2:          body[424:472],
+		RTMR3:          body[472:520],
+		ReportData:     body[520:584],
+	}
+	if bodySize == tdReportBody15Size {
+		report.TeeTcbSvn2 = body[584:600]
+		report.MrServiceTd = body[600:648]
+	}
+	return report, nil
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"encoding/hex"
+	"slices"
+	"strings"
+)
+
+// Register is the value of a named measurement register, e.g. MRTD or RTMR0 for TDX.
+type Register struct {
+	Name  string
+	Value []byte
+}
+
+// RegisterSet is a set of measurement registers, either computed or taken from attestation
+// evidence. Register names are unique within a set and compared case-insensitively.
+type RegisterSet interface {
+	// Registers returns all registers in their canonical order.
+	Registers() []Register
+}
+
+// RegisterList is a register set of registers given in canonical order.
+type RegisterList []Register
+
+// Registers returns the registers of the list.
+func (l RegisterList) Registers() []Register {
+	return l
+}
+
+// tdxRegisterNames are the names of the TDX measurement registers in canonical order.
+var tdxRegisterNames = []string{"MRTD", "RTMR0", "RTMR1", "RTMR2", "RTMR3"}
+
+// TdxRegisterNames returns the names of the TDX measurement registers in canonical order.
+func TdxRegisterNames() []string {
+	return slices.Clone(tdxRegisterNames)
+}
+
+// registerFields returns pointers to the registers of the measurements in the order of
+// tdxRegisterNames.
+func (m *TdxMeasurements) registerFields() []*[]byte {
+	return []*[]byte{&m.MRTD, &m.RTMR0, &m.RTMR1, &m.RTMR2, &m.RTMR3}
+}
+
+// register returns a pointer to the named register of the measurements, nil if TDX has no register
+// of that name. Names are the canonical upper case names.
+func (m *TdxMeasurements) register(name string) *[]byte {
+	for i, field := range m.registerFields() {
+		if tdxRegisterNames[i] == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// Registers returns the TDX measurement registers MRTD and RTMR0-3.
+func (m *TdxMeasurements) Registers() []Register {
+	fields := m.registerFields()
+	values := make([][]byte, len(fields))
+	for i, field := range fields {
+		values[i] = *field
+	}
+	return tdxRegisters(values...)
+}
+
+// Registers returns the TDX measurement registers MRTD and RTMR0-3 of the report.
+func (r *TdReport) Registers() []Register {
+	return tdxRegisters(r.MRTD, r.RTMR0, r.RTMR1, r.RTMR2, r.RTMR3)
+}
+
+// tdxRegisters names the register values, which are given in the order of tdxRegisterNames.
+func tdxRegisters(values ...[]byte) []Register {
+	registers := make([]Register, len(tdxRegisterNames))
+	for i, name := range tdxRegisterNames {
+		registers[i] = Register{Name: name, Value: values[i]}
+	}
+	return registers
+}
+
+// TdxMeasurementsFromRegisters returns TDX measurements holding the TDX registers of the set, e.g.
+// to compute the composites of registers taken from evidence.
+func TdxMeasurementsFromRegisters(set RegisterSet) *TdxMeasurements {
+	m := &TdxMeasurements{}
+	for _, r := range set.Registers() {
+		if field := m.register(strings.ToUpper(r.Name)); field != nil {
+			*field = r.Value
+		}
+	}
+	return m
+}
+
+// IsTdxRegister reports whether name, compared case-insensitively, is a TDX measurement register.
+func IsTdxRegister(name string) bool {
+	for _, n := range tdxRegisterNames {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// LookupRegister returns the value of the named register of the set.
+func LookupRegister(set RegisterSet, name string) ([]byte, bool) {
+	for _, r := range set.Registers() {
+		if strings.EqualFold(r.Name, name) {
+			return r.Value, true
+		}
+	}
+	return nil, false
+}
+
+// RegisterValues returns the hex-encoded register values of the set keyed by lowercase register
+// name, as used in JSON output. Registers that were not computed are left out.
+func RegisterValues(set RegisterSet) map[string]string {
+	result := make(map[string]string)
+	for _, r := range set.Registers() {
+		if r.Value == nil {
+			continue
+		}
+		result[strings.ToLower(r.Name)] = hex.EncodeToString(r.Value)
+	}
+	return result
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTdxRegisterTable(t *testing.T) {
+	m := &TdxMeasurements{}
+	for i, field := range m.registerFields() {
+		*field = []byte{byte(i)}
+	}
+	registers := m.Registers()
+	if len(registers) != len(tdxRegisterNames) {
+		t.Fatalf("Registers() returned %d registers, want %d", len(registers), len(tdxRegisterNames))
+	}
+	for i, r := range registers {
+		if r.Name != tdxRegisterNames[i] || !bytes.Equal(r.Value, []byte{byte(i)}) {
+			t.Errorf("register %d = %s %x, want %s %x", i, r.Name, r.Value, tdxRegisterNames[i], i)
+		}
+		if field := m.register(r.Name); field == nil || !bytes.Equal(*field, r.Value) {
+			t.Errorf("register(%s) does not return the field of the register", r.Name)
+		}
+	}
+	if m.register("RTMR4") != nil || m.register("rtmr0") != nil {
+		t.Error("register() returned a field for a name that is not a canonical TDX register")
+	}
+
+	copied := TdxMeasurementsFromRegisters(RegisterList{{Name: "rtmr2", Value: []byte{2}}, {Name: "RTMR4", Value: []byte{4}}})
+	if !bytes.Equal(copied.RTMR2, []byte{2}) || copied.MRTD != nil || copied.RTMR3 != nil {
+		t.Errorf("TdxMeasurementsFromRegisters() = %v", copied.Registers())
+	}
+}
+
+func TestExtraEventRegister(t *testing.T) {
+	for _, register := range []string{"MRTD", "RTMR4", "rtmr1"} {
+		m := &TdxMeasurements{MRTD: make([]byte, 48), RTMR1: make([]byte, 48)}
+		if err := m.extendExtraEvents([]ExtraEvent{{Name: "extra", Register: register}}); err == nil {
+			t.Errorf("extra event targeting %s was accepted", register)
+		}
+	}
+	m := &TdxMeasurements{RTMR1: make([]byte, 48)}
+	if err := m.extendExtraEvents([]ExtraEvent{{Name: "extra", Register: "RTMR1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(m.RTMR1, make([]byte, 48)) {
+		t.Error("extra event was not extended into RTMR1")
+	}
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Registry is a file of reference values for released images, which is appended to as new images
+// are measured.
+type Registry struct {
+	Entries []RegistryEntry `json:"entries"`
+}
+
+// RegistryEntry holds the reference values of an image together with the parameters and artifacts
+// they were computed from.
+type RegistryEntry struct {
+	// Image is the name of the image directory.
+	Image string `json:"image"`
+	// Version is the dstack version declared by the image metadata.
+	Version    string `json:"version,omitempty"`
+	MeasuredAt string `json:"measured_at"`
+	Profile    string `json:"profile"`
+	MemoryMB   uint64 `json:"memory_mb"`
+	Cpus       uint32 `json:"cpus"`
+	TcbVersion uint8  `json:"tcb_version"`
+	// Values maps the register names as in RegisterValues and the composite names (mr_aggregated,
+	// mr_image) to hex values.
+	Values    map[string]string `json:"values"`
+	Artifacts []InputArtifact   `json:"artifacts"`
+	Warnings  []Warning         `json:"warnings,omitempty"`
+	// Provenance records where an imported entry came from, nil for entries measured locally.
+	Provenance *RegistryProvenance `json:"provenance,omitempty"`
+}
+
+// RegistryProvenance is the origin of a registry entry that was imported from another registry.
+type RegistryProvenance struct {
+	// Source names the registry the entry was first imported from.
+	Source     string `json:"source"`
+	ImportedAt string `json:"imported_at"`
+}
+
+// LoadRegistry loads a registry from a JSON file. A missing file is an empty registry.
+func LoadRegistry(path string) (*Registry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Registry{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var r Registry
+	if err = json.Unmarshal(data, &r); err != nil {
+		return nil, fmt.Errorf("malformed registry: %w", err)
+	}
+	return &r, nil
+}
+
+// Lookup returns the entry of the given image, nil if it was not measured yet.
+func (r *Registry) Lookup(image string) *RegistryEntry {
+	for i := range r.Entries {
+		if r.Entries[i].Image == image {
+			return &r.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Write writes the registry to path. The file is replaced atomically, so that readers never see a
+// partially written registry.
+func (r *Registry) Write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
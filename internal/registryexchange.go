@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Strategies for resolving conflicts when importing a registry, i.e. images that are in both
+// registries with different reference values.
+const (
+	// ImportFailOnConflict imports nothing if any image conflicts.
+	ImportFailOnConflict = "fail-on-conflict"
+	// ImportPreferNewer keeps the entry that was measured last.
+	ImportPreferNewer = "prefer-newer"
+)
+
+// RegistryImport is the outcome of importing a registry, listing the images by what happened to
+// them.
+type RegistryImport struct {
+	// Added are the images that were not in the registry.
+	Added []string `json:"added"`
+	// Replaced are the conflicting images whose imported entry was newer.
+	Replaced []string `json:"replaced"`
+	// Kept are the conflicting images whose existing entry was newer.
+	Kept []string `json:"kept"`
+	// Unchanged are the images that were in both registries with the same reference values.
+	Unchanged []string `json:"unchanged"`
+}
+
+// ImportRegistry adds the entries of another registry to the store, resolving conflicts with the
+// given strategy. Imported entries without provenance are marked as coming from source; entries
+// that were imported before keep their original provenance.
+func ImportRegistry(store RegistryStore, entries []RegistryEntry, source, strategy string) (*RegistryImport, error) {
+	if strategy != ImportFailOnConflict && strategy != ImportPreferNewer {
+		return nil, fmt.Errorf("unknown import strategy '%s', expected %s or %s", strategy, ImportPreferNewer, ImportFailOnConflict)
+	}
+	existing, err := store.Entries()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]*RegistryEntry, len(existing))
+	for i := range existing {
+		known[existing[i].Image] = &existing[i]
+	}
+
+	// Conflicts are collected before anything is written, so that a failed import leaves the store
+	// untouched.
+	var conflicts []string
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		if seen[e.Image] {
+			return nil, fmt.Errorf("image '%s' appears more than once in the imported registry", e.Image)
+		}
+		seen[e.Image] = true
+		if old := known[e.Image]; old != nil && !reflect.DeepEqual(old.Values, e.Values) {
+			conflicts = append(conflicts, e.Image)
+		}
+	}
+	if len(conflicts) > 0 && strategy == ImportFailOnConflict {
+		return nil, fmt.Errorf("reference values differ for images %s", strings.Join(conflicts, ", "))
+	}
+
+	result := &RegistryImport{Added: []string{}, Replaced: []string{}, Kept: []string{}, Unchanged: []string{}}
+	importedAt := time.Now().UTC().Format(time.RFC3339)
+	for _, e := range entries {
+		if e.Provenance == nil {
+			e.Provenance = &RegistryProvenance{Source: source, ImportedAt: importedAt}
+		}
+		old := known[e.Image]
+		switch {
+		case old == nil:
+			if err = store.Add(e); err != nil {
+				return result, err
+			}
+			result.Added = append(result.Added, e.Image)
+		case reflect.DeepEqual(old.Values, e.Values):
+			result.Unchanged = append(result.Unchanged, e.Image)
+		default:
+			newer, err := measuredAfter(e, *old)
+			if err != nil {
+				return result, err
+			}
+			if !newer {
+				result.Kept = append(result.Kept, e.Image)
+				continue
+			}
+			if err = store.Replace(e); err != nil {
+				return result, err
+			}
+			result.Replaced = append(result.Replaced, e.Image)
+		}
+	}
+	return result, nil
+}
+
+// measuredAfter returns whether entry a was measured after entry b.
+func measuredAfter(a, b RegistryEntry) (bool, error) {
+	ta, err := time.Parse(time.RFC3339, a.MeasuredAt)
+	if err != nil {
+		return false, fmt.Errorf("invalid measurement time of image '%s': %w", a.Image, err)
+	}
+	tb, err := time.Parse(time.RFC3339, b.MeasuredAt)
+	if err != nil {
+		return false, fmt.Errorf("invalid measurement time of image '%s': %w", b.Image, err)
+	}
+	return ta.After(tb), nil
+}
+
+// RegistryEntriesSince returns the entries of images whose dstack version is at least since, e.g.
+// "v0.5". Entries without a version are left out.
+func RegistryEntriesSince(entries []RegistryEntry, since string) ([]RegistryEntry, error) {
+	min, ok := parseDstackVersion(since)
+	if !ok {
+		return nil, fmt.Errorf("invalid version '%s'", since)
+	}
+	var selected []RegistryEntry
+	for _, e := range entries {
+		if v, ok := parseDstackVersion(e.Version); ok && compareVersions(v, min) >= 0 {
+			selected = append(selected, e)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RegistryStore stores the entries of a registry, e.g. in a local JSON file or in a SQL database
+// shared by several services.
+type RegistryStore interface {
+	// Entries returns all entries in the order they were added.
+	Entries() ([]RegistryEntry, error)
+	// Lookup returns the entry of the given image, nil if it was not measured yet.
+	Lookup(image string) (*RegistryEntry, error)
+	// Add adds the entry of an image that is not in the registry yet.
+	Add(entry RegistryEntry) error
+	// Replace replaces the entry of an image that is in the registry.
+	Replace(entry RegistryEntry) error
+	// Close releases the resources of the store.
+	Close() error
+	// String describes the store.
+	String() string
+}
+
+// OpenRegistryStore opens the registry store at location, either sql:<driver>:<dsn> for a table in
+// a SQL database or the path of a JSON file. The driver must be registered with database/sql by the
+// program; the command line tool only registers postgres when built with the postgres tag.
+func OpenRegistryStore(location string) (RegistryStore, error) {
+	spec, ok := strings.CutPrefix(location, "sql:")
+	if !ok {
+		return &fileRegistryStore{path: location}, nil
+	}
+	driver, dsn, ok := strings.Cut(spec, ":")
+	if !ok || driver == "" || dsn == "" {
+		return nil, fmt.Errorf("malformed registry location '%s', expected sql:<driver>:<dsn>", location)
+	}
+	if !slices.Contains(sql.Drivers(), driver) {
+		return nil, fmt.Errorf("SQL driver '%s' is not registered, the command line tool only includes the postgres driver when built with -tags postgres", driver)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open registry database: %w", err)
+	}
+	store, err := NewSQLRegistryStore(db, driver)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// fileRegistryStore stores the registry in a JSON file, which is replaced atomically on every
+// change.
+type fileRegistryStore struct {
+	path string
+}
+
+func (s *fileRegistryStore) String() string {
+	return s.path
+}
+
+func (s *fileRegistryStore) Entries() ([]RegistryEntry, error) {
+	r, err := LoadRegistry(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return r.Entries, nil
+}
+
+func (s *fileRegistryStore) Lookup(image string) (*RegistryEntry, error) {
+	r, err := LoadRegistry(s.path)
+	if err != nil {
+		return nil, err
+	}
+	return r.Lookup(image), nil
+}
+
+func (s *fileRegistryStore) Add(entry RegistryEntry) error {
+	r, err := LoadRegistry(s.path)
+	if err != nil {
+		return err
+	}
+	if r.Lookup(entry.Image) != nil {
+		return fmt.Errorf("image '%s' is already in the registry", entry.Image)
+	}
+	r.Entries = append(r.Entries, entry)
+	return r.Write(s.path)
+}
+
+func (s *fileRegistryStore) Replace(entry RegistryEntry) error {
+	r, err := LoadRegistry(s.path)
+	if err != nil {
+		return err
+	}
+	e := r.Lookup(entry.Image)
+	if e == nil {
+		return fmt.Errorf("image '%s' is not in the registry", entry.Image)
+	}
+	*e = entry
+	return r.Write(s.path)
+}
+
+func (s *fileRegistryStore) Close() error {
+	return nil
+}
+
+// sqlRegistryStore stores the registry in the table registry_entries of a SQL database, one row
+// per image holding the JSON encoded entry.
+type sqlRegistryStore struct {
+	db     *sql.DB
+	driver string
+	// placeholder returns the bind parameter with the given 1-based index.
+	placeholder func(int) string
+}
+
+// NewSQLRegistryStore returns a registry store backed by the database, creating its table if it
+// does not exist yet. The driver name selects the SQL dialect of bind parameters: $1 for the
+// PostgreSQL drivers postgres and pgx, ? for all others such as SQLite and MySQL.
+func NewSQLRegistryStore(db *sql.DB, driver string) (RegistryStore, error) {
+	s := &sqlRegistryStore{db: db, driver: driver, placeholder: func(int) string { return "?" }}
+	if driver == "postgres" || driver == "pgx" {
+		s.placeholder = func(i int) string { return fmt.Sprintf("$%d", i) }
+	}
+	if _, err := db.Exec(`CREATE TABLE IF NOT EXISTS registry_entries (
+	image VARCHAR(255) PRIMARY KEY,
+	measured_at VARCHAR(64) NOT NULL,
+	entry TEXT NOT NULL
+)`); err != nil {
+		return nil, fmt.Errorf("failed to create registry table: %w", err)
+	}
+	return s, nil
+}
+
+func (s *sqlRegistryStore) String() string {
+	return "sql:" + s.driver
+}
+
+func (s *sqlRegistryStore) Entries() ([]RegistryEntry, error) {
+	rows, err := s.db.Query("SELECT entry FROM registry_entries ORDER BY measured_at, image")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query registry: %w", err)
+	}
+	defer rows.Close()
+	var entries []RegistryEntry
+	for rows.Next() {
+		var data string
+		if err = rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to query registry: %w", err)
+		}
+		var e RegistryEntry
+		if err = json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, fmt.Errorf("malformed registry entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *sqlRegistryStore) Lookup(image string) (*RegistryEntry, error) {
+	var data string
+	err := s.db.QueryRow("SELECT entry FROM registry_entries WHERE image = "+s.placeholder(1), image).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query registry: %w", err)
+	}
+	var e RegistryEntry
+	if err = json.Unmarshal([]byte(data), &e); err != nil {
+		return nil, fmt.Errorf("malformed registry entry of image '%s': %w", image, err)
+	}
+	return &e, nil
+}
+
+func (s *sqlRegistryStore) Add(entry RegistryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// The primary key rejects images that are already in the registry, also when they were added
+	// concurrently by another service.
+	if _, err = s.db.Exec(fmt.Sprintf("INSERT INTO registry_entries (image, measured_at, entry) VALUES (%s, %s, %s)",
+		s.placeholder(1), s.placeholder(2), s.placeholder(3)), entry.Image, entry.MeasuredAt, string(data)); err != nil {
+		return fmt.Errorf("failed to add registry entry of image '%s': %w", entry.Image, err)
+	}
+	return nil
+}
+
+func (s *sqlRegistryStore) Replace(entry RegistryEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	result, err := s.db.Exec(fmt.Sprintf("UPDATE registry_entries SET measured_at = %s, entry = %s WHERE image = %s",
+		s.placeholder(1), s.placeholder(2), s.placeholder(3)), entry.MeasuredAt, string(data), entry.Image)
+	if err != nil {
+		return fmt.Errorf("failed to replace registry entry of image '%s': %w", entry.Image, err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("image '%s' is not in the registry", entry.Image)
+	}
+	return nil
+}
+
+func (s *sqlRegistryStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,170 @@
+package internal
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DstackRuntimeEventType is the event type of the runtime events the dstack guest agent extends
+// into RTMR3.
+const DstackRuntimeEventType = 0x08000001
+
+// ReplayEventLog returns the value of the register after extending it with the digests of the
+// events of the log recorded for the register, starting from zero.
+func ReplayEventLog(events []LogEvent, register string) ([]byte, error) {
+	if err := CheckSha384Bank(events, register); err != nil {
+		return nil, err
+	}
+	history := []string{}
+	for _, e := range events {
+		if e.Register == register {
+			history = append(history, hex.EncodeToString(e.Digest))
+		}
+	}
+	value, err := replayRTMR(history)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(value)
+}
+
+// ReplayStep is an event replayed into a register with the value of the register after it.
+type ReplayStep struct {
+	Event LogEvent
+	// Digest is the digest of the event in the replayed bank.
+	Digest []byte
+	Value  []byte
+}
+
+// ReplayEventLogSteps replays the events of the log recorded for the register like
+// ReplayEventLog, and also returns the value of the register after each of them.
+func ReplayEventLogSteps(events []LogEvent, register string) ([]byte, []ReplayStep, error) {
+	if _, err := rtmrIndex(register); err != nil {
+		return nil, nil, err
+	}
+	if err := CheckSha384Bank(events, register); err != nil {
+		return nil, nil, err
+	}
+	value := make([]byte, 48)
+	var steps []ReplayStep
+	for _, e := range events {
+		if e.Register != register {
+			continue
+		}
+		value = ExtendRegister(value, e.Digest)
+		steps = append(steps, ReplayStep{Event: e, Digest: e.Digest, Value: value})
+	}
+	return value, steps, nil
+}
+
+// CheckSha384Bank checks that all events of the register record the SHA384 digest the RTMR is
+// extended with. Logs written for a TPM may only record other banks, which cannot be compared with
+// the RTMRs.
+func CheckSha384Bank(events []LogEvent, register string) error {
+	n := 0
+	for _, e := range events {
+		if e.Register != register {
+			continue
+		}
+		if e.Digest == nil {
+			var banks []string
+			for alg := range e.Banks {
+				banks = append(banks, bankName(alg))
+			}
+			sort.Strings(banks)
+			return fmt.Errorf("event %d of %s has no SHA384 digest, the log only records the %s bank; the RTMRs are extended with SHA384 digests, other banks can only be replayed on their own", n, register, strings.Join(banks, ", "))
+		}
+		n++
+	}
+	return nil
+}
+
+// ReplayEventLogBank replays the digests of another bank of a TCG event log, e.g. the SHA256 bank
+// of a legacy log, into a register of the bank's digest size. The result cannot be compared with
+// the RTMRs, which are extended with SHA384 digests.
+func ReplayEventLogBank(events []LogEvent, register string, alg uint16) ([]byte, []ReplayStep, error) {
+	if alg == tpmAlgSha384 {
+		return ReplayEventLogSteps(events, register)
+	}
+	if _, err := rtmrIndex(register); err != nil {
+		return nil, nil, err
+	}
+	var h crypto.Hash
+	switch alg {
+	case tpmAlgSha1:
+		h = crypto.SHA1
+	case tpmAlgSha256:
+		h = crypto.SHA256
+	case tpmAlgSha512:
+		h = crypto.SHA512
+	default:
+		return nil, nil, fmt.Errorf("replaying the %s bank is not supported", bankName(alg))
+	}
+	value := make([]byte, h.Size())
+	var steps []ReplayStep
+	for _, e := range events {
+		if e.Register != register {
+			continue
+		}
+		digest, ok := e.Banks[alg]
+		if !ok || len(digest) != h.Size() {
+			return nil, nil, fmt.Errorf("event %d of %s has no %s digest", len(steps), register, bankName(alg))
+		}
+		hash := h.New()
+		hash.Write(value)
+		hash.Write(digest)
+		value = hash.Sum(nil)
+		steps = append(steps, ReplayStep{Event: e, Digest: digest, Value: value})
+	}
+	return value, steps, nil
+}
+
+// CheckRuntimeEvents checks that the digests of the dstack runtime events match their names and
+// payloads, and returns a warning for each event whose digest does not.
+func CheckRuntimeEvents(events []LogEvent) []Warning {
+	var warnings []Warning
+	for i, e := range events {
+		if e.Type != DstackRuntimeEventType {
+			continue
+		}
+		if !bytes.Equal(runtimeEventDigest(e.Type, e.Name, e.Data), e.Digest) {
+			warnings = append(warnings, Warning{
+				Code:    WarningRuntimeEvent,
+				Message: fmt.Sprintf("digest of runtime event %d (%s) does not match its name and payload", i, e.Name),
+			})
+		}
+	}
+	return warnings
+}
+
+// RuntimeEvent returns the event the dstack guest agent extends into RTMR3 for a runtime event with
+// the given type, name and payload.
+func RuntimeEvent(eventType uint32, name string, payload []byte) LogEvent {
+	return LogEvent{Register: "RTMR3", Type: eventType, Digest: runtimeEventDigest(eventType, name, payload), Name: name, Data: payload}
+}
+
+// ExtendRegister returns the value of a register after extending it with the digest, which is
+// zero-padded to 48 bytes.
+func ExtendRegister(value, digest []byte) []byte {
+	h := sha512.New384()
+	h.Write(value)
+	h.Write(padDigest(digest))
+	return h.Sum(nil)
+}
+
+// runtimeEventDigest computes the digest of a runtime event over its type, name and payload.
+func runtimeEventDigest(eventType uint32, name string, payload []byte) []byte {
+	h := sha512.New384()
+	binary.Write(h, binary.LittleEndian, eventType)
+	h.Write([]byte(":" + name + ":"))
+	h.Write(payload)
+	return h.Sum(nil)
+}
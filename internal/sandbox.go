@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SandboxWorkerCommand is the hidden command of the executable that runs the sandbox worker.
+const SandboxWorkerCommand = "sandbox-worker"
+
+// maxSandboxStderr is the amount of worker stderr output kept for error messages.
+const maxSandboxStderr = 4096
+
+// SandboxLimits are the resource limits of a sandboxed measurement.
+type SandboxLimits struct {
+	// Memory is the maximum address space of the worker in bytes.
+	Memory uint64
+	// CPUTime is the maximum CPU time of the worker in seconds.
+	CPUTime uint64
+	// Timeout is the maximum wall clock time of the worker, after which it is killed.
+	Timeout time.Duration
+}
+
+// SandboxRequest holds the inputs of a sandboxed measurement. Only built-in profiles can be used,
+// as the worker cannot read profile packs.
+type SandboxRequest struct {
+	Firmware, Kernel, Initrd           []byte
+	Rootfs, DockerCompose, DockerFiles []byte
+	MemorySize                         uint64
+	CPUCount                           uint32
+	Cmdline                            string
+	TemplatesPath                      string
+	TcbVersion                         uint8
+	Profile                            string
+	Limits                             SandboxLimits
+}
+
+// sandboxResponse is the result returned by the worker.
+type sandboxResponse struct {
+	Measurements *TdxMeasurements
+	Error        string
+	// ErrorKind is the index of the engine error the error matches, -1 if none.
+	ErrorKind int
+}
+
+// MeasureSandboxed computes the measurements of the request in a worker process running the given
+// executable with SandboxWorkerCommand. The worker reads the ACPI template, then restricts itself
+// with the resource limits and a seccomp filter that denies all file, network and process system
+// calls before it parses any of the inputs. The request and the result are exchanged over pipes.
+// The worker is killed when the context is canceled.
+func MeasureSandboxed(ctx context.Context, executable string, req *SandboxRequest) (*TdxMeasurements, error) {
+	if !sandboxSupported {
+		return nil, restrictSyscalls()
+	}
+	parent := ctx
+	if req.Limits.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.Limits.Timeout)
+		defer cancel()
+	}
+
+	resultReader, resultWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer resultReader.Close()
+	cmd := exec.CommandContext(ctx, executable, SandboxWorkerCommand)
+	// The worker gets an empty environment and writes its result to file descriptor 3, so that
+	// nothing else the worker prints can corrupt it.
+	cmd.Env = []string{}
+	cmd.ExtraFiles = []*os.File{resultWriter}
+	stderr := &limitedBuffer{max: maxSandboxStderr}
+	cmd.Stderr = stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		resultWriter.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	resultWriter.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start sandbox worker: %w", err)
+	}
+	go func() {
+		gob.NewEncoder(stdin).Encode(req)
+		stdin.Close()
+	}()
+
+	var resp sandboxResponse
+	decodeErr := gob.NewDecoder(resultReader).Decode(&resp)
+	waitErr := cmd.Wait()
+	switch {
+	case parent.Err() != nil:
+		return nil, parent.Err()
+	case ctx.Err() != nil:
+		return nil, fmt.Errorf("sandbox worker timed out after %s", req.Limits.Timeout)
+	case decodeErr != nil || waitErr != nil:
+		if waitErr == nil {
+			waitErr = decodeErr
+		}
+		return nil, fmt.Errorf("sandbox worker failed: %w: %s", waitErr, strings.TrimSpace(stderr.String()))
+	case resp.Error != "":
+		return nil, newRemoteError(resp.Error, resp.ErrorKind)
+	}
+	return resp.Measurements, nil
+}
+
+// RunSandboxWorker serves a single sandboxed measurement, reading the request from in and writing
+// the result to out.
+func RunSandboxWorker(in io.Reader, out io.Writer) error {
+	var req SandboxRequest
+	if err := gob.NewDecoder(in).Decode(&req); err != nil {
+		return fmt.Errorf("failed to read sandbox request: %w", err)
+	}
+	resp := sandboxResponse{}
+	measurements, err := measureInSandbox(&req)
+	if err != nil {
+		resp.Error, resp.ErrorKind = err.Error(), engineErrorKind(err)
+	} else {
+		// Intermediates include a copy of the kernel and are not needed by the caller.
+		measurements.Intermediates = nil
+		resp.Measurements = measurements
+	}
+	return gob.NewEncoder(out).Encode(&resp)
+}
+
+// measureInSandbox reads the files the measurement needs, restricts the process and computes the
+// measurements.
+func measureInSandbox(req *SandboxRequest) (*TdxMeasurements, error) {
+	profile, err := LookupProfile(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	tplPath := filepath.Join(req.TemplatesPath, templateFileName(req.CPUCount, profile.MemoryHotplug))
+	tpl, err := os.ReadFile(tplPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w (templates cover %s CPUs)", ErrTemplateNotFound, err, templateCoverage(req.TemplatesPath, profile.MemoryHotplug))
+	}
+	preloadedTemplates[tplPath] = tpl
+
+	if err = limitResources(req.Limits.Memory, req.Limits.CPUTime); err != nil {
+		return nil, err
+	}
+	if err = restrictSyscalls(); err != nil {
+		return nil, err
+	}
+	return MeasureTdxQemu(MeasureOptions{
+		Firmware: req.Firmware, Kernel: req.Kernel, Initrd: req.Initrd, Rootfs: req.Rootfs, DockerCompose: req.DockerCompose, DockerFiles: req.DockerFiles,
+		MemoryMB: req.MemorySize, CPUs: req.CPUCount, Cmdline: req.Cmdline, TemplatesPath: req.TemplatesPath, TcbVersion: req.TcbVersion, Profile: profile,
+	})
+}
+
+// limitedBuffer keeps the first max bytes written to it.
+type limitedBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
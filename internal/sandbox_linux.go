@@ -0,0 +1,89 @@
+//go:build linux && (amd64 || arm64)
+
+package internal
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Seccomp constants from linux/seccomp.h, linux/filter.h and linux/prctl.h.
+const (
+	prSetNoNewPrivs        = 38
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTsync = 1
+	seccompRetKillProcess  = 0x80000000
+	seccompRetErrno        = 0x00050000
+	seccompRetAllow        = 0x7fff0000
+	seccompDataNrOffset    = 0
+	seccompDataArchOffset  = 4
+	bpfLdWAbs              = syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS
+	bpfJeqK                = syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K
+	bpfRetK                = syscall.BPF_RET | syscall.BPF_K
+)
+
+// sandboxSupported is true on platforms with a seccomp filter for the sandbox.
+const sandboxSupported = true
+
+// sandboxSyscalls are the system calls the Go runtime needs to compute measurements from data in
+// memory and to write the result to an already open pipe. Everything else, in particular opening
+// files, creating sockets and executing programs, fails with EPERM.
+var sandboxSyscalls = append([]uintptr{
+	syscall.SYS_READ, syscall.SYS_WRITE, syscall.SYS_CLOSE, syscall.SYS_FSTAT, syscall.SYS_FCNTL,
+	syscall.SYS_MMAP, syscall.SYS_MUNMAP, syscall.SYS_MPROTECT, syscall.SYS_MADVISE, syscall.SYS_MINCORE, syscall.SYS_BRK,
+	syscall.SYS_RT_SIGACTION, syscall.SYS_RT_SIGPROCMASK, syscall.SYS_RT_SIGRETURN, syscall.SYS_SIGALTSTACK,
+	syscall.SYS_CLONE, syscall.SYS_FUTEX, syscall.SYS_SCHED_YIELD, syscall.SYS_SCHED_GETAFFINITY,
+	syscall.SYS_NANOSLEEP, syscall.SYS_CLOCK_GETTIME, syscall.SYS_CLOCK_NANOSLEEP, syscall.SYS_RESTART_SYSCALL,
+	syscall.SYS_GETTID, syscall.SYS_GETPID, syscall.SYS_TGKILL, syscall.SYS_TKILL,
+	syscall.SYS_EPOLL_CREATE1, syscall.SYS_EPOLL_CTL, syscall.SYS_EPOLL_PWAIT, syscall.SYS_EVENTFD2, syscall.SYS_PIPE2,
+	syscall.SYS_GETRLIMIT, syscall.SYS_PRLIMIT64, syscall.SYS_EXIT, syscall.SYS_EXIT_GROUP,
+}, archSandboxSyscalls...)
+
+// restrictSyscalls installs a seccomp filter on all threads of the process that only permits
+// sandboxSyscalls. The filter cannot be removed and is inherited by new threads.
+func restrictSyscalls() error {
+	// Jump offsets are 8 bits wide.
+	n := len(sandboxSyscalls)
+	if n > 255 {
+		return fmt.Errorf("too many system calls in the sandbox filter")
+	}
+	filter := []syscall.SockFilter{
+		{Code: bpfLdWAbs, K: seccompDataArchOffset},
+		{Code: bpfJeqK, Jt: 1, K: auditArch},
+		{Code: bpfRetK, K: seccompRetKillProcess},
+		{Code: bpfLdWAbs, K: seccompDataNrOffset},
+	}
+	for i, nr := range sandboxSyscalls {
+		// Jump over the remaining comparisons and the errno return to the allow return.
+		filter = append(filter, syscall.SockFilter{Code: bpfJeqK, Jt: uint8(n - i), K: uint32(nr)})
+	}
+	filter = append(filter,
+		syscall.SockFilter{Code: bpfRetK, K: seccompRetErrno | uint32(syscall.EPERM)},
+		syscall.SockFilter{Code: bpfRetK, K: seccompRetAllow},
+	)
+	prog := syscall.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("failed to set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall(sysSeccomp, seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog))); errno != 0 {
+		return fmt.Errorf("failed to install seccomp filter: %w", errno)
+	}
+	return nil
+}
+
+// limitResources limits the address space (in bytes) and CPU time (in seconds) of the process.
+func limitResources(memory, cpuTime uint64) error {
+	if memory > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_AS, &syscall.Rlimit{Cur: memory, Max: memory}); err != nil {
+			return fmt.Errorf("failed to limit memory: %w", err)
+		}
+	}
+	if cpuTime > 0 {
+		if err := syscall.Setrlimit(syscall.RLIMIT_CPU, &syscall.Rlimit{Cur: cpuTime, Max: cpuTime}); err != nil {
+			return fmt.Errorf("failed to limit CPU time: %w", err)
+		}
+	}
+	return nil
+}
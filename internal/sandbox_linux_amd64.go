@@ -0,0 +1,17 @@
+package internal
+
+import "syscall"
+
+const (
+	// auditArch is AUDIT_ARCH_X86_64.
+	auditArch = 0xc000003e
+	// sysSeccomp is the number of the seccomp system call.
+	sysSeccomp = 317
+)
+
+// archSandboxSyscalls are the architecture specific system calls of the sandbox filter.
+var archSandboxSyscalls = []uintptr{
+	syscall.SYS_ARCH_PRCTL, syscall.SYS_EPOLL_WAIT,
+	318, // getrandom
+	334, // rseq
+}
@@ -0,0 +1,16 @@
+package internal
+
+import "syscall"
+
+const (
+	// auditArch is AUDIT_ARCH_AARCH64.
+	auditArch = 0xc00000b7
+	// sysSeccomp is the number of the seccomp system call.
+	sysSeccomp = syscall.SYS_SECCOMP
+)
+
+// archSandboxSyscalls are the architecture specific system calls of the sandbox filter.
+var archSandboxSyscalls = []uintptr{
+	syscall.SYS_GETRANDOM,
+	293, // rseq
+}
@@ -0,0 +1,16 @@
+//go:build !linux || !(amd64 || arm64)
+
+package internal
+
+import "fmt"
+
+// sandboxSupported is false on platforms without a seccomp filter for the sandbox.
+const sandboxSupported = false
+
+func restrictSyscalls() error {
+	return fmt.Errorf("the sandbox is only supported on Linux on amd64 and arm64")
+}
+
+func limitResources(memory, cpuTime uint64) error {
+	return restrictSyscalls()
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+//go:embed scaffold/*.tmpl
+var scaffoldFS embed.FS
+
+// ScaffoldOptions configures the generated verifier project.
+type ScaffoldOptions struct {
+	// Module is the Go module path of the project.
+	Module string
+	// Name is the name of the project, derived from the module path when empty.
+	Name string
+}
+
+// ScaffoldProject writes a small Go verifier project into dir. Existing files are never
+// overwritten. It returns the paths of the written files.
+func ScaffoldProject(dir string, opts ScaffoldOptions) ([]string, error) {
+	if opts.Module == "" {
+		return nil, fmt.Errorf("module path is required")
+	}
+	if opts.Name == "" {
+		opts.Name = path.Base(opts.Module)
+	}
+
+	entries, err := fs.ReadDir(scaffoldFS, "scaffold")
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for _, e := range entries {
+		tpl, err := template.ParseFS(scaffoldFS, "scaffold/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		out := filepath.Join(dir, strings.TrimSuffix(e.Name(), ".tmpl"))
+		f, err := os.OpenFile(out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+		if err != nil {
+			return written, fmt.Errorf("failed to create %s: %w", out, err)
+		}
+		err = tpl.Execute(f, opts)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return written, fmt.Errorf("failed to write %s: %w", out, err)
+		}
+		written = append(written, out)
+	}
+	return written, nil
+}
@@ -0,0 +1,300 @@
+package internal
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Fixture is a set of inputs together with the measurements they are expected to produce.
+type Fixture struct {
+	Name string `json:"name"`
+	// Archive is the URL of a tar.gz archive containing the image, which is fetched on demand and
+	// verified against Sha256. It is empty for built-in synthetic fixtures.
+	Archive string `json:"archive,omitempty"`
+	Sha256  string `json:"sha256,omitempty"`
+	// Metadata is the path of the image metadata (metadata.json) within the archive.
+	Metadata string `json:"metadata,omitempty"`
+
+	MemoryMB   uint64 `json:"memory_mb"`
+	Cpus       uint32 `json:"cpus"`
+	TcbVersion uint8  `json:"tcb_version"`
+	Profile    string `json:"profile"`
+	Cmdline    string `json:"cmdline,omitempty"`
+	// InitrdSize overrides the size of the synthetic initrd.
+	InitrdSize *int `json:"initrd_size,omitempty"`
+	// InitrdSizeAlignment overrides the initrd size alignment of the profile.
+	InitrdSizeAlignment uint32 `json:"initrd_size_alignment,omitempty"`
+	// InitrdDelivery overrides how the initrd reaches the kernel.
+	InitrdDelivery string `json:"initrd_delivery,omitempty"`
+	// BfvAddress overrides the guest physical address of the synthetic firmware BFV section.
+	BfvAddress uint64 `json:"bfv_address,omitempty"`
+
+	// Expected maps register and composite names (mrtd, rtmr0-3, mr_image) to hex values.
+	Expected map[string]string `json:"expected"`
+}
+
+// FixtureResult is the outcome of checking a single fixture.
+type FixtureResult struct {
+	Name   string `json:"name"`
+	Passed bool   `json:"passed"`
+	// Synthetic is set for fixtures over synthetic inputs, whose expected values were produced by
+	// this tool. They catch regressions but cannot show that a computation is correct.
+	Synthetic  bool              `json:"synthetic"`
+	Mismatches []string          `json:"mismatches,omitempty"`
+	Actual     map[string]string `json:"actual,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+//go:embed selfcheck/synthetic.json
+var syntheticFixturesJSON []byte
+
+// SyntheticFixtures returns the built-in fixtures over synthetic inputs, which cover every
+// built-in profile and MRTD variant. Their expected values pin the behavior of released builds.
+func SyntheticFixtures() ([]Fixture, error) {
+	return parseFixtures(syntheticFixturesJSON)
+}
+
+// LoadFixtures loads fixtures from a JSON file of the form {"fixtures": [...]}.
+func LoadFixtures(path string) ([]Fixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFixtures(data)
+}
+
+func parseFixtures(data []byte) ([]Fixture, error) {
+	var doc struct {
+		Fixtures []Fixture `json:"fixtures"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("malformed fixtures: %w", err)
+	}
+	return doc.Fixtures, nil
+}
+
+// RunSelfCheck measures every fixture and compares the results against the expected values.
+// Synthetic fixtures are generated in memory; image fixtures are downloaded into cacheDir and
+// measured with the ACPI templates in templatesPath.
+func RunSelfCheck(fixtures []Fixture, templatesPath, cacheDir string) ([]FixtureResult, error) {
+	synthDir, err := os.MkdirTemp("", "reproduce-mr-selfcheck")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(synthDir)
+	if err = writeSyntheticTemplates(synthDir); err != nil {
+		return nil, err
+	}
+
+	results := make([]FixtureResult, 0, len(fixtures))
+	for _, f := range fixtures {
+		result := FixtureResult{Name: f.Name, Synthetic: f.Archive == ""}
+		actual, err := measureFixture(&f, synthDir, templatesPath, cacheDir)
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.Actual = actual
+		keys := make([]string, 0, len(f.Expected))
+		for key := range f.Expected {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if !strings.EqualFold(f.Expected[key], actual[key]) {
+				result.Mismatches = append(result.Mismatches, key)
+			}
+		}
+		result.Passed = len(f.Expected) > 0 && len(result.Mismatches) == 0
+		if len(f.Expected) == 0 {
+			result.Error = "fixture has no expected values"
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// writeSyntheticTemplates writes ACPI templates for the synthetic fixtures into the rsdt and xsdt
+// subdirectories of dir.
+func writeSyntheticTemplates(dir string) error {
+	for _, rootSig := range []string{"RSDT", "XSDT"} {
+		tplDir := filepath.Join(dir, strings.ToLower(rootSig))
+		if err := os.MkdirAll(tplDir, 0o755); err != nil {
+			return err
+		}
+		tpl := []byte(hex.EncodeToString(syntheticAcpiTables(rootSig)))
+		for _, cpus := range []uint32{1, 2, 4} {
+			if err := os.WriteFile(filepath.Join(tplDir, templateFileName(cpus, nil)), tpl, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func measureFixture(f *Fixture, synthDir, templatesPath, cacheDir string) (map[string]string, error) {
+	profile, err := LookupProfile(f.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if f.InitrdSizeAlignment != 0 {
+		profile = profile.WithInitrdSizeAlignment(f.InitrdSizeAlignment)
+	}
+	if f.InitrdDelivery != "" {
+		delivery, err := ParseInitrdDelivery(f.InitrdDelivery)
+		if err != nil {
+			return nil, err
+		}
+		profile = profile.WithInitrdDelivery(delivery)
+	}
+
+	var (
+		fw, kernel, initrd []byte
+		cmdline            = f.Cmdline
+	)
+	if f.Archive == "" {
+		var err error
+		if fw, err = syntheticFirmware(f.BfvAddress); err != nil {
+			return nil, err
+		}
+		kernel, initrd = syntheticKernel(0x10000), []byte(strings.Repeat("initrd", 1000))
+		if f.InitrdSize != nil {
+			initrd = syntheticInitrd(*f.InitrdSize)
+		}
+		templatesPath = filepath.Join(synthDir, "rsdt")
+		if profile.RsdpRevision != 0 {
+			templatesPath = filepath.Join(synthDir, "xsdt")
+		}
+	} else {
+		if templatesPath == "" {
+			return nil, fmt.Errorf("templates path is required for image fixtures")
+		}
+		dir, err := fetchFixtureArchive(f, cacheDir)
+		if err != nil {
+			return nil, err
+		}
+		metaPath := filepath.Join(dir, f.Metadata)
+		meta, err := LoadImageMetadata(metaPath)
+		if err != nil {
+			return nil, err
+		}
+		imageDir := filepath.Dir(metaPath)
+		if fw, err = os.ReadFile(filepath.Join(imageDir, meta.Bios)); err != nil {
+			return nil, err
+		}
+		if kernel, err = os.ReadFile(filepath.Join(imageDir, meta.Kernel)); err != nil {
+			return nil, err
+		}
+		if meta.Initrd != "" {
+			if initrd, err = os.ReadFile(filepath.Join(imageDir, meta.Initrd)); err != nil {
+				return nil, err
+			}
+		}
+		if cmdline == "" {
+			cmdline = meta.FullCmdline()
+		}
+	}
+
+	m, err := MeasureTdxQemu(MeasureOptions{
+		Firmware: fw, Kernel: kernel, Initrd: initrd, MemoryMB: f.MemoryMB, CPUs: f.Cpus, Cmdline: cmdline,
+		TemplatesPath: templatesPath, TcbVersion: f.TcbVersion, Profile: profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	values := RegisterValues(m)
+	values["mr_image"] = m.CalculateMrImage()
+	return values, nil
+}
+
+// fetchFixtureArchive downloads and extracts the archive of an image fixture into the cache unless
+// it is already present, and returns the extraction directory.
+func fetchFixtureArchive(f *Fixture, cacheDir string) (string, error) {
+	if f.Sha256 == "" {
+		return "", fmt.Errorf("image fixture %s has no archive digest", f.Name)
+	}
+	dir := filepath.Join(cacheDir, strings.ToLower(f.Sha256))
+	if _, err := os.Stat(dir); err == nil {
+		return dir, nil
+	}
+
+	data, err := httpGet(f.Archive)
+	if err != nil {
+		return "", fmt.Errorf("failed to download %s: %w", f.Archive, err)
+	}
+	digest := sha256.Sum256(data)
+	if !strings.EqualFold(hex.EncodeToString(digest[:]), f.Sha256) {
+		return "", fmt.Errorf("digest mismatch for %s", f.Archive)
+	}
+
+	tmpDir, err := os.MkdirTemp(cacheDir, ".fetch-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmpDir)
+	if err = extractTarGz(data, tmpDir); err != nil {
+		return "", fmt.Errorf("failed to extract %s: %w", f.Archive, err)
+	}
+	if err = os.Rename(tmpDir, dir); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// extractTarGz extracts the regular files and directories of a tar.gz archive into dir.
+func extractTarGz(data []byte, dir string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	return extractTar(gz, dir)
+}
+
+// extractTar extracts the regular files and directories of a tar archive into dir.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Entries must not escape the extraction directory.
+		path := filepath.Join(dir, filepath.Clean("/"+hdr.Name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(path, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			if _, err = io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err = out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"io"
+)
+
+// StreamDigest holds the size and digests of an input that was hashed while it was read, so that
+// large images such as initrds and root filesystems need not be held in memory.
+type StreamDigest struct {
+	Size   uint64
+	Sha256 []byte
+	Sha384 []byte
+}
+
+// HashStream reads r to the end and returns the size and digests of its contents.
+func HashStream(r io.Reader) (*StreamDigest, error) {
+	h256, h384 := sha256.New(), sha512.New384()
+	n, err := io.Copy(io.MultiWriter(h256, h384), r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return &StreamDigest{Size: uint64(n), Sha256: h256.Sum(nil), Sha384: h384.Sum(nil)}, nil
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"encoding/binary"
+)
+
+// syntheticAcpiTables returns a minimal set of ACPI tables accepted by GenerateTablesQemu, with
+// either an RSDT or an XSDT as the root table.
+func syntheticAcpiTables(rootSig string) []byte {
+	var tables []byte
+	for _, t := range []struct {
+		sig    string
+		length int
+	}{{"DSDT", 100}, {"FACP", 244}, {"APIC", 120}, {"MCFG", 60}, {"WAET", 40}, {rootSig, 52}} {
+		tbl := make([]byte, t.length)
+		copy(tbl[:4], t.sig)
+		binary.LittleEndian.PutUint32(tbl[4:8], uint32(t.length))
+		tables = append(tables, tbl...)
+	}
+	return tables
+}
+
+// syntheticKernel returns a minimal x86_64 bzImage with a PE/COFF header of the given size.
+func syntheticKernel(size int) []byte {
+	const (
+		peOffset      = 0x40
+		optHdrOffset  = peOffset + 24
+		optHdrSize    = 240
+		sectionOffset = optHdrOffset + optHdrSize
+		headersSize   = 0x1000
+	)
+	k := make([]byte, size)
+	copy(k, "MZ")
+	binary.LittleEndian.PutUint32(k[0x3c:], peOffset)
+
+	// COFF header.
+	copy(k[peOffset:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(k[peOffset+4:], peMachineAmd64)
+	binary.LittleEndian.PutUint16(k[peOffset+6:], 1) // NumberOfSections
+	binary.LittleEndian.PutUint16(k[peOffset+20:], optHdrSize)
+	binary.LittleEndian.PutUint16(k[peOffset+22:], 0x22) // Characteristics
+
+	// PE32+ optional header.
+	binary.LittleEndian.PutUint16(k[optHdrOffset:], 0x20b)
+	binary.LittleEndian.PutUint32(k[optHdrOffset+32:], 0x1000)                    // SectionAlignment
+	binary.LittleEndian.PutUint32(k[optHdrOffset+36:], 0x200)                     // FileAlignment
+	binary.LittleEndian.PutUint32(k[optHdrOffset+56:], uint32(size))              // SizeOfImage
+	binary.LittleEndian.PutUint32(k[optHdrOffset+60:], headersSize)               // SizeOfHeaders
+	binary.LittleEndian.PutUint16(k[optHdrOffset+68:], 10)                        // Subsystem (EFI application)
+	binary.LittleEndian.PutUint32(k[optHdrOffset+108:], 16)                       // NumberOfRvaAndSizes
+	copy(k[sectionOffset:], ".text")                                              // Name
+	binary.LittleEndian.PutUint32(k[sectionOffset+8:], uint32(size-headersSize))  // VirtualSize
+	binary.LittleEndian.PutUint32(k[sectionOffset+12:], headersSize)              // VirtualAddress
+	binary.LittleEndian.PutUint32(k[sectionOffset+16:], uint32(size-headersSize)) // SizeOfRawData
+	binary.LittleEndian.PutUint32(k[sectionOffset+20:], headersSize)              // PointerToRawData
+	binary.LittleEndian.PutUint32(k[sectionOffset+36:], 0x60000020)               // Characteristics
+
+	// Linux boot protocol header.
+	copy(k[0x202:], "HdrS")
+	binary.LittleEndian.PutUint16(k[0x206:], 0x020f)
+	k[0x211] = 0x01                                // LOADED_HIGH
+	binary.LittleEndian.PutUint32(k[0x238:], 2047) // cmdline_size
+
+	for i := headersSize; i < size; i++ {
+		k[i] = byte(i)
+	}
+	return k
+}
+
+// syntheticInitrd returns an initrd of the given size.
+func syntheticInitrd(size int) []byte {
+	initrd := make([]byte, size)
+	for i := range initrd {
+		initrd[i] = byte(i % 251)
+	}
+	return initrd
+}
+
+// syntheticFirmware returns a minimal firmware image with TDVF metadata describing a BFV, a CFV, a
+// TD HOB and a temporary memory section, laid out like OVMF. The BFV is mapped at bfvAddress, or
+// below 4 GiB like OVMF if it is zero.
+func syntheticFirmware(bfvAddress uint64) ([]byte, error) {
+	const (
+		fwSize     = 0x20000
+		metaOffset = 0x1E000
+	)
+	if bfvAddress == 0 {
+		bfvAddress = 0xFFFF0000
+	}
+	fw := make([]byte, fwSize)
+	for i := range fw {
+		fw[i] = 0xff
+	}
+	for i := 0x10000; i < fwSize; i++ {
+		fw[i] = byte(i * 7) // BFV
+	}
+	for i := 0; i < 0x8000; i++ {
+		fw[i] = byte(i * 3) // CFV
+	}
+
+	sections := []tdvfSection{
+		{dataOffset: 0x10000, rawDataSize: 0x10000, memoryAddress: bfvAddress, memoryDataSize: 0x10000, secType: tdvfSectionBfv, attributes: attributeMrExtend},
+		{dataOffset: 0, rawDataSize: 0x8000, memoryAddress: 0xFFC00000, memoryDataSize: 0x8000, secType: tdvfSectionCfv},
+		{memoryAddress: 0x809000, memoryDataSize: 0x2000, secType: tdvfSectionTdHob},
+		{memoryAddress: 0x800000, memoryDataSize: 0x6000, secType: 0x03},
+	}
+	meta := appendTdvfDescriptor(nil, sections)
+	copy(fw[metaOffset:], meta)
+
+	// OVMF GUIDed table with the TDVF metadata offset entry, followed by the table footer.
+	tables := make([]byte, 18)
+	tables = binary.LittleEndian.AppendUint32(tables, fwSize-metaOffset)
+	tables = binary.LittleEndian.AppendUint16(tables, 22)
+	tables, err := appendGUID(tables, tdvfMetadataOffsetGUID)
+	if err != nil {
+		return nil, err
+	}
+	footer := binary.LittleEndian.AppendUint16(tables, uint16(len(tables)))
+	if footer, err = appendGUID(footer, ovmfTableFooterGUID); err != nil {
+		return nil, err
+	}
+	footer = append(footer, make([]byte, 32)...)
+	copy(fw[fwSize-len(footer):], footer)
+	return fw, nil
+}
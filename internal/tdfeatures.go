@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// TD features a profile can declare. Profiles may list features unknown to this version of the
+// tool, e.g. in profile packs written for a later version; they are reported as warnings.
+const (
+	// TdFeatureMigration declares that the TDs of the profile are migratable and bound to a
+	// migration TD.
+	TdFeatureMigration = "migration"
+	// TdFeaturePartitioning declares that the TDs of the profile are partitioned into an L1 VMM
+	// and L2 guests. The measurements are those of the L1 TD.
+	TdFeaturePartitioning = "partitioning"
+)
+
+// knownTdFeatures are the TD features modeled by this version of the tool.
+var knownTdFeatures = map[string]bool{TdFeatureMigration: true, TdFeaturePartitioning: true}
+
+// TD attribute bits defined by the TDX module ABI.
+const (
+	tdAttributeSeptVeDisable = 1 << 28
+	tdAttributeMigratable    = 1 << 29
+	tdAttributePks           = 1 << 30
+	tdAttributeKl            = 1 << 31
+	tdAttributePerfmon       = 1 << 63
+	tdAttributesKnown        = tdAttributeDebugMask | tdAttributeSeptVeDisable | tdAttributeMigratable | tdAttributePks | tdAttributeKl | tdAttributePerfmon
+)
+
+// TdFeatures are the features of a TD as reported in its TD report.
+type TdFeatures struct {
+	Debug         bool `json:"debug"`
+	SeptVeDisable bool `json:"sept_ve_disable"`
+	Migratable    bool `json:"migratable"`
+	Pks           bool `json:"pks"`
+	Kl            bool `json:"kl"`
+	Perfmon       bool `json:"perfmon"`
+	// ServiceTdBound is set when a service TD, e.g. a migration TD, is bound to the TD. Only TDX
+	// 1.5 report bodies carry the hash of bound service TDs.
+	ServiceTdBound bool `json:"service_td_bound"`
+	// UnknownAttributes are the TD attribute bits not defined by the TDX module versions known to
+	// this tool, zero if there are none.
+	UnknownAttributes uint64 `json:"unknown_attributes,omitempty"`
+}
+
+// Features decodes the TD features of the report.
+func (r *TdReport) Features() TdFeatures {
+	var attributes uint64
+	if len(r.TdAttributes) == 8 {
+		attributes = binary.LittleEndian.Uint64(r.TdAttributes)
+	}
+	return TdFeatures{
+		Debug:             attributes&tdAttributeDebugMask != 0,
+		SeptVeDisable:     attributes&tdAttributeSeptVeDisable != 0,
+		Migratable:        attributes&tdAttributeMigratable != 0,
+		Pks:               attributes&tdAttributePks != 0,
+		Kl:                attributes&tdAttributeKl != 0,
+		Perfmon:           attributes&tdAttributePerfmon != 0,
+		ServiceTdBound:    len(r.MrServiceTd) > 0 && !bytes.Equal(r.MrServiceTd, make([]byte, len(r.MrServiceTd))),
+		UnknownAttributes: attributes &^ tdAttributesKnown,
+	}
+}
+
+// HasTdFeature returns whether the profile declares the given TD feature.
+func (p *Profile) HasTdFeature(feature string) bool {
+	for _, f := range p.TdFeatures {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// unmodeledPartitioningWarning is the warning format for measurements of a profile with
+// partitioned TDs that were computed without modeling the L1 paravisor.
+const unmodeledPartitioningWarning = "profile '%s' describes partitioned TDs, but the measurements are of a TD booted without an L1 paravisor"
+
+// addTdFeatureWarnings warns about TD features of the profile that change what the measurements
+// mean, or that this version of the tool does not know.
+func (m *TdxMeasurements) addTdFeatureWarnings(profile *Profile) {
+	for _, f := range profile.TdFeatures {
+		switch {
+		case f == TdFeaturePartitioning:
+			m.addWarning(WarningTdFeature, unmodeledPartitioningWarning, profile.Name)
+		case !knownTdFeatures[f]:
+			m.addWarning(WarningTdFeature, "profile '%s' declares TD feature '%s', which this version of the tool does not model", profile.Name, f)
+		}
+	}
+}
+
+// CheckTdFeatures compares the TD features reported in a TD report with those declared by the
+// profile the measurements were computed for, and returns warnings for any differences.
+func CheckTdFeatures(report *TdReport, profile *Profile) []Warning {
+	var warnings []Warning
+	warn := func(format string, args ...any) {
+		warnings = append(warnings, Warning{Code: WarningTdFeature, Message: fmt.Sprintf(format, args...)})
+	}
+	features := report.Features()
+	migration := profile.HasTdFeature(TdFeatureMigration)
+	switch {
+	case features.Migratable && !migration:
+		warn("quote is of a migratable TD, but profile '%s' does not declare migration", profile.Name)
+	case !features.Migratable && migration:
+		warn("profile '%s' declares migration, but the quote is of a TD that is not migratable", profile.Name)
+	}
+	if features.ServiceTdBound && !migration {
+		warn("quote is of a TD bound to a service TD (MRSERVICETD %x), which profile '%s' does not declare", report.MrServiceTd, profile.Name)
+	}
+	if features.UnknownAttributes != 0 {
+		warn("quote has unknown TD attribute bits 0x%x set, which may change what the measurements mean", features.UnknownAttributes)
+	}
+	return warnings
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+)
+
+// DefaultTdHobBase is the address of the TD HOB used when the firmware has no TD HOB section.
+const DefaultTdHobBase = 0x809000
+
+// HobResource is a resource descriptor in the TD HOB, describing a range of guest memory.
+type HobResource struct {
+	// Type is the resource type, e.g. HobResourceSystemMemory for accepted memory or
+	// HobResourceMemoryUnaccepted.
+	Type uint8 `json:"type"`
+	// Attributes are the resource attributes, e.g. HobAttributePresent.
+	Attributes uint32 `json:"attributes"`
+	Start      uint64 `json:"start"`
+	Length     uint64 `json:"length"`
+}
+
+// ParseHobResources parses a TD HOB memory map from the JSON encoding of a list of resource
+// descriptors.
+func ParseHobResources(data []byte) ([]HobResource, error) {
+	var resources []HobResource
+	if err := json.Unmarshal(data, &resources); err != nil {
+		return nil, fmt.Errorf("malformed TD HOB memory map: %w", err)
+	}
+	if len(resources) == 0 {
+		return nil, fmt.Errorf("TD HOB memory map has no resource descriptors")
+	}
+	return resources, nil
+}
+
+// QemuTdHobResources returns the memory map QEMU describes in the TD HOB of a guest with the given
+// memory size in megabytes: the firmware ranges of the profile followed by guest RAM, which is
+// split at 2 GiB when the guest has at least 2816 MiB.
+func QemuTdHobResources(memorySize uint64, profile *Profile) []HobResource {
+	resources := make([]HobResource, 0, len(profile.HobFirmwareRanges)+2)
+	remainingMemory := memorySize * 1024 * 1024 // Convert to bytes.
+	add := func(accepted bool, start, length uint64) {
+		r := HobResource{Type: profile.HobUnacceptedType, Attributes: profile.HobUnacceptedAttributes, Start: start, Length: length}
+		if accepted {
+			r.Type, r.Attributes = HobResourceSystemMemory, profile.HobAcceptedAttributes
+		}
+		resources = append(resources, r)
+		remainingMemory -= length
+	}
+
+	for _, r := range profile.HobFirmwareRanges {
+		add(r.Accepted, r.Start, r.Length)
+	}
+	if memorySize >= 2816 {
+		add(false, profile.HobRamStart, 0x0000000080000000-profile.HobRamStart)
+		add(false, 0x0000000100000000, remainingMemory)
+	} else {
+		add(false, profile.HobRamStart, remainingMemory)
+	}
+	return resources
+}
+
+// BuildTdHob constructs a TD HOB at the given guest physical address: the handoff HOB followed by
+// the resource descriptors. Its SHA384 digest is the TD HOB event measured into RTMR0.
+func BuildTdHob(base uint64, resources []HobResource) []byte {
+	// All fields are little-endian.
+	tdHob := make([]byte, 0, 56+len(resources)*48)
+
+	// Start with EFI_HOB_TYPE_HANDOFF.
+	tdHob = append(tdHob,
+		0x01, 0x00, // Header.HobType (EFI_HOB_TYPE_HANDOFF)
+		0x38, 0x00, // Header.HobLength (56 bytes)
+		0x00, 0x00, 0x00, 0x00, // Header.Reserved
+		0x09, 0x00, 0x00, 0x00, // Version (EFI_HOB_HANDOFF_TABLE_VERSION)
+		0x00, 0x00, 0x00, 0x00, // BootMode
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryTop
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiMemoryBottom
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryTop
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiFreeMemoryBottom
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // EfiEndOfHobList (filled later)
+	)
+
+	// The rest of the HOBs are EFI_HOB_TYPE_RESOURCE_DESCRIPTOR.
+	for _, r := range resources {
+		tdHob = append(tdHob,
+			0x03, 0x00, // Header.HobType (EFI_HOB_TYPE_RESOURCE_DESCRIPTOR)
+			0x30, 0x00, // Header.HobLength (48 bytes)
+			0x00, 0x00, 0x00, 0x00, // Header.Reserved
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Owner
+			r.Type, 0x00, 0x00, 0x00, // ResourceType
+		)
+		tdHob = binary.LittleEndian.AppendUint32(tdHob, r.Attributes) // ResourceAttribute
+		tdHob = binary.LittleEndian.AppendUint64(tdHob, r.Start)      // PhysicalStart
+		tdHob = binary.LittleEndian.AppendUint64(tdHob, r.Length)     // Length
+	}
+
+	// Update EfiEndOfHobList.
+	binary.LittleEndian.PutUint64(tdHob[48:56], base+uint64(len(tdHob))+8)
+	return tdHob
+}
+
+// tdHobBase returns the address of the TD HOB from the TDVF metadata.
+func (m *tdvfMetadata) tdHobBase() uint64 {
+	if m != nil {
+		for _, s := range m.sections {
+			if s.secType == tdvfSectionTdHob {
+				return s.memoryAddress
+			}
+		}
+	}
+	return DefaultTdHobBase
+}
+
+// TdHobBase returns the address of the TD HOB as declared by the TD HOB section of the TDVF
+// metadata of a firmware, DefaultTdHobBase if it has none.
+func TdHobBase(fw []byte) (uint64, error) {
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return 0, err
+	}
+	return meta.tdHobBase(), nil
+}
@@ -0,0 +1,65 @@
+package internal
+
+import "fmt"
+
+// TdvfMetadata is the TDVF metadata of a firmware, located through the OVMF GUIDed table at the
+// end of the image.
+type TdvfMetadata struct {
+	// DescriptorOffset is the offset of the TDVF metadata descriptor in the firmware.
+	DescriptorOffset uint64        `json:"descriptor_offset"`
+	Sections         []TdvfSection `json:"sections"`
+}
+
+// TdvfSection is a section of the TDVF metadata. Its layout fields can be given to
+// MakeTdvfMetadata as they are to synthesize the same section again.
+type TdvfSection struct {
+	TdvfLayoutSection
+	// TypeValue is the section type as stored in the metadata. Type is its name, or the value in
+	// hex for types that are not known.
+	TypeValue uint32 `json:"type_value"`
+	// Attributes are the raw section attributes, of which MrExtend and PageAug are decoded.
+	Attributes uint32 `json:"attributes"`
+	// EntryOffset is the offset of the section entry in the firmware.
+	EntryOffset uint64 `json:"entry_offset"`
+}
+
+// MemoryEnd returns the guest physical address just past the memory range of the section.
+func (s *TdvfSection) MemoryEnd() uint64 {
+	return s.MemoryAddress + s.MemoryDataSize
+}
+
+// ParseTdvfMetadata parses the TDVF metadata of a firmware, applying the same checks as the
+// measurement of MRTD.
+func ParseTdvfMetadata(fw []byte) (*TdvfMetadata, error) {
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return nil, err
+	}
+	typeNames := make(map[uint32]string, len(tdvfSectionTypes))
+	for name, value := range tdvfSectionTypes {
+		typeNames[value] = name
+	}
+
+	result := &TdvfMetadata{DescriptorOffset: meta.descriptorOffset, Sections: make([]TdvfSection, 0, len(meta.sections))}
+	for i, s := range meta.sections {
+		name, ok := typeNames[s.secType]
+		if !ok {
+			name = fmt.Sprintf("0x%02x", s.secType)
+		}
+		result.Sections = append(result.Sections, TdvfSection{
+			TdvfLayoutSection: TdvfLayoutSection{
+				Type:           name,
+				DataOffset:     s.dataOffset,
+				RawDataSize:    s.rawDataSize,
+				MemoryAddress:  s.memoryAddress,
+				MemoryDataSize: s.memoryDataSize,
+				MrExtend:       s.attributes&attributeMrExtend != 0,
+				PageAug:        s.attributes&attributePageAug != 0,
+			},
+			TypeValue:   s.secType,
+			Attributes:  s.attributes,
+			EntryOffset: meta.descriptorOffset + 16 + 32*uint64(i),
+		})
+	}
+	return result, nil
+}
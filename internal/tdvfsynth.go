@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	tdvfMetadataOffsetGUID = "e47a6535-984a-4798-865e-4685a7bf8ec2"
+	ovmfTableFooterGUID    = "96b582de-1fb2-45f7-baea-a366c55a082d"
+)
+
+// tdvfSectionTypes maps the names of TDVF section types in layout descriptions to their values.
+var tdvfSectionTypes = map[string]uint32{
+	"bfv":           tdvfSectionBfv,
+	"cfv":           tdvfSectionCfv,
+	"td_hob":        tdvfSectionTdHob,
+	"temp_mem":      0x03,
+	"perm_mem":      0x04,
+	"payload":       0x05,
+	"payload_param": 0x06,
+}
+
+// TdvfLayout describes the sections of a firmware build for which TDVF metadata is synthesized.
+type TdvfLayout struct {
+	// FirmwareSize is the size of the firmware build the metadata is appended to. It is only used
+	// when the firmware itself is not given.
+	FirmwareSize uint64              `json:"firmware_size,omitempty"`
+	Sections     []TdvfLayoutSection `json:"sections"`
+}
+
+// TdvfLayoutSection is a section of a TDVF layout. Offsets are relative to the start of the
+// firmware build.
+type TdvfLayoutSection struct {
+	Type           string `json:"type"`
+	DataOffset     uint32 `json:"data_offset"`
+	RawDataSize    uint32 `json:"raw_data_size"`
+	MemoryAddress  uint64 `json:"memory_address"`
+	MemoryDataSize uint64 `json:"memory_data_size"`
+	MrExtend       bool   `json:"mr_extend,omitempty"`
+	PageAug        bool   `json:"page_aug,omitempty"`
+}
+
+// LoadTdvfLayout loads a TDVF layout description from a JSON file.
+func LoadTdvfLayout(path string) (*TdvfLayout, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var layout TdvfLayout
+	if err = json.Unmarshal(data, &layout); err != nil {
+		return nil, fmt.Errorf("malformed TDVF layout: %w", err)
+	}
+	return &layout, nil
+}
+
+// sections returns the TDVF sections of the layout.
+func (l *TdvfLayout) sections() ([]tdvfSection, error) {
+	if len(l.Sections) == 0 {
+		return nil, fmt.Errorf("TDVF layout has no sections")
+	}
+	sections := make([]tdvfSection, 0, len(l.Sections))
+	for i, s := range l.Sections {
+		secType, ok := tdvfSectionTypes[s.Type]
+		if !ok {
+			return nil, fmt.Errorf("TDVF layout section %d has unknown type '%s'", i, s.Type)
+		}
+		var attributes uint32
+		if s.MrExtend {
+			attributes |= attributeMrExtend
+		}
+		if s.PageAug {
+			attributes |= attributePageAug
+		}
+		sections = append(sections, tdvfSection{
+			dataOffset:     s.DataOffset,
+			rawDataSize:    s.RawDataSize,
+			memoryAddress:  s.MemoryAddress,
+			memoryDataSize: s.MemoryDataSize,
+			secType:        secType,
+			attributes:     attributes,
+		})
+	}
+	return sections, nil
+}
+
+// appendTdvfDescriptor appends the TDVF metadata descriptor with the given sections to dst.
+func appendTdvfDescriptor(dst []byte, sections []tdvfSection) []byte {
+	dst = append(dst, "TDVF"...)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(16+32*len(sections)))
+	dst = binary.LittleEndian.AppendUint32(dst, 1)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(sections)))
+	for _, s := range sections {
+		dst = binary.LittleEndian.AppendUint32(dst, s.dataOffset)
+		dst = binary.LittleEndian.AppendUint32(dst, s.rawDataSize)
+		dst = binary.LittleEndian.AppendUint64(dst, s.memoryAddress)
+		dst = binary.LittleEndian.AppendUint64(dst, s.memoryDataSize)
+		dst = binary.LittleEndian.AppendUint32(dst, s.secType)
+		dst = binary.LittleEndian.AppendUint32(dst, s.attributes)
+	}
+	return dst
+}
+
+// tdvfMetadataBlock returns the TDVF metadata descriptor with the given sections followed by an
+// OVMF GUIDed table with the metadata offset entry, the table footer and the 32 bytes that follow
+// it in OVMF. The sections are not validated.
+func tdvfMetadataBlock(sections []tdvfSection) ([]byte, error) {
+	block := appendTdvfDescriptor(nil, sections)
+	// The metadata offset entry holds the offset of the descriptor from the end of the firmware.
+	// Entry lengths include the length and GUID, and the footer length covers the footer itself.
+	const entrySize, footerSize = 4 + 2 + 16, 2 + 16
+	offset := len(block) + entrySize + footerSize + 32
+	block = binary.LittleEndian.AppendUint32(block, uint32(offset))
+	block = binary.LittleEndian.AppendUint16(block, entrySize)
+	block, err := appendGUID(block, tdvfMetadataOffsetGUID)
+	if err != nil {
+		return nil, err
+	}
+	block = binary.LittleEndian.AppendUint16(block, entrySize+footerSize)
+	if block, err = appendGUID(block, ovmfTableFooterGUID); err != nil {
+		return nil, err
+	}
+	return append(block, make([]byte, 32)...), nil
+}
+
+// MakeTdvfMetadata returns a block to append to a firmware build, containing the TDVF metadata
+// descriptor for the layout, an OVMF GUIDed table with the metadata offset entry, the table footer
+// and the 32 bytes that follow it in OVMF. The block is parsed back, appended to fw or to a zeroed
+// firmware of the layout's firmware size, to check that the parser reads the layout unchanged.
+func MakeTdvfMetadata(layout *TdvfLayout, fw []byte) ([]byte, error) {
+	sections, err := layout.sections()
+	if err != nil {
+		return nil, err
+	}
+	block, err := tdvfMetadataBlock(sections)
+	if err != nil {
+		return nil, err
+	}
+
+	if fw == nil {
+		fw = make([]byte, layout.FirmwareSize)
+	}
+	image := append(append(make([]byte, 0, len(fw)+len(block)), fw...), block...)
+	meta, err := parseTdvfMetadata(image)
+	if err != nil {
+		return nil, fmt.Errorf("synthesized TDVF metadata does not parse: %w", err)
+	}
+	if len(meta.sections) != len(sections) {
+		return nil, fmt.Errorf("synthesized TDVF metadata has %d sections instead of %d", len(meta.sections), len(sections))
+	}
+	for i, s := range meta.sections {
+		if *s != sections[i] {
+			return nil, fmt.Errorf("synthesized TDVF metadata section %d does not round-trip", i)
+		}
+	}
+	return block, nil
+}
+
+// ComputeFirmwareMrtd computes the MRTD of a firmware for the given TCB version, zero-extending
+// the raw data of sections shorter than their memory data size.
+func ComputeFirmwareMrtd(fw []byte, tcbver uint8) ([]byte, error) {
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		return nil, err
+	}
+	switch tcbver {
+	case 6:
+		return meta.computeMrtd(context.Background(), fw, mrtdVariantSinglePass, true)
+	case 7:
+		return meta.computeMrtd(context.Background(), fw, mrtdVariantTwoPass, true)
+	default:
+		return nil, fmt.Errorf("Unsupported tcbver: %d", tcbver)
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import "testing"
+
+func TestMakeTdvfMetadataRoundTrip(t *testing.T) {
+	layout := &TdvfLayout{
+		FirmwareSize: 0x40000,
+		Sections: []TdvfLayoutSection{
+			{Type: "bfv", DataOffset: 0x20000, RawDataSize: 0x1f000, MemoryAddress: 0xfffe0000, MemoryDataSize: 0x1f000, MrExtend: true},
+			{Type: "cfv", DataOffset: 0, RawDataSize: 0x20000, MemoryAddress: 0xfffc0000, MemoryDataSize: 0x20000, MrExtend: true},
+			{Type: "td_hob", MemoryAddress: 0x809000, MemoryDataSize: 0x2000},
+			{Type: "temp_mem", MemoryAddress: 0x80b000, MemoryDataSize: 0x2000},
+			{Type: "perm_mem", MemoryAddress: 0x1000000, MemoryDataSize: 0x1000, PageAug: true},
+			{Type: "payload", DataOffset: 0x1000, RawDataSize: 0x800, MemoryAddress: 0x2000000, MemoryDataSize: 0x1000, MrExtend: true, PageAug: true},
+			{Type: "payload_param", MemoryAddress: 0x3000000, MemoryDataSize: 0x1000},
+		},
+	}
+	block, err := MakeTdvfMetadata(layout, nil)
+	if err != nil {
+		t.Fatalf("MakeTdvfMetadata() error = %v", err)
+	}
+	fw := append(make([]byte, layout.FirmwareSize), block...)
+	meta, err := parseTdvfMetadata(fw)
+	if err != nil {
+		t.Fatalf("parseTdvfMetadata() error = %v", err)
+	}
+	if meta.descriptorOffset != layout.FirmwareSize {
+		t.Errorf("descriptor offset = 0x%x, want 0x%x", meta.descriptorOffset, layout.FirmwareSize)
+	}
+	if len(meta.sections) != len(layout.Sections) {
+		t.Fatalf("parsed %d sections, want %d", len(meta.sections), len(layout.Sections))
+	}
+	for i, want := range layout.Sections {
+		got := meta.sections[i]
+		if got.secType != tdvfSectionTypes[want.Type] {
+			t.Errorf("section %d: type = %d, want %d (%s)", i, got.secType, tdvfSectionTypes[want.Type], want.Type)
+		}
+		if got.dataOffset != want.DataOffset {
+			t.Errorf("section %d: data offset = 0x%x, want 0x%x", i, got.dataOffset, want.DataOffset)
+		}
+		if got.rawDataSize != want.RawDataSize {
+			t.Errorf("section %d: raw data size = 0x%x, want 0x%x", i, got.rawDataSize, want.RawDataSize)
+		}
+		if got.memoryAddress != want.MemoryAddress {
+			t.Errorf("section %d: memory address = 0x%x, want 0x%x", i, got.memoryAddress, want.MemoryAddress)
+		}
+		if got.memoryDataSize != want.MemoryDataSize {
+			t.Errorf("section %d: memory data size = 0x%x, want 0x%x", i, got.memoryDataSize, want.MemoryDataSize)
+		}
+		if mrExtend := got.attributes&attributeMrExtend != 0; mrExtend != want.MrExtend {
+			t.Errorf("section %d: mr_extend = %v, want %v", i, mrExtend, want.MrExtend)
+		}
+		if pageAug := got.attributes&attributePageAug != 0; pageAug != want.PageAug {
+			t.Errorf("section %d: page_aug = %v, want %v", i, pageAug, want.PageAug)
+		}
+		if got.attributes&^(attributeMrExtend|attributePageAug) != 0 {
+			t.Errorf("section %d: unexpected attributes 0x%x", i, got.attributes)
+		}
+	}
+}
+
+func TestMakeTdvfMetadataWithFirmware(t *testing.T) {
+	fw, err := syntheticFirmware(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layout := &TdvfLayout{Sections: []TdvfLayoutSection{
+		{Type: "bfv", DataOffset: 0, RawDataSize: 0x1000, MemoryAddress: 0xfffff000, MemoryDataSize: 0x1000, MrExtend: true},
+	}}
+	block, err := MakeTdvfMetadata(layout, fw)
+	if err != nil {
+		t.Fatalf("MakeTdvfMetadata() error = %v", err)
+	}
+	meta, err := parseTdvfMetadata(append(fw, block...))
+	if err != nil {
+		t.Fatalf("parseTdvfMetadata() error = %v", err)
+	}
+	if len(meta.sections) != 1 || meta.descriptorOffset != uint64(len(fw)) {
+		t.Errorf("parsed %d sections at 0x%x, want 1 at 0x%x", len(meta.sections), meta.descriptorOffset, len(fw))
+	}
+}
+
+func TestMakeTdvfMetadataInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout TdvfLayout
+	}{
+		{"no sections", TdvfLayout{FirmwareSize: 0x1000}},
+		{"unknown type", TdvfLayout{FirmwareSize: 0x1000, Sections: []TdvfLayoutSection{{Type: "rom", MemoryDataSize: 0x1000}}}},
+		{"raw data beyond firmware", TdvfLayout{FirmwareSize: 0x1000, Sections: []TdvfLayoutSection{{Type: "bfv", DataOffset: 0x800, RawDataSize: 0x1000, MemoryDataSize: 0x1000}}}},
+		{"unaligned memory address", TdvfLayout{FirmwareSize: 0x1000, Sections: []TdvfLayoutSection{{Type: "td_hob", MemoryAddress: 0x800, MemoryDataSize: 0x1000}}}},
+		{"memory smaller than raw data", TdvfLayout{FirmwareSize: 0x2000, Sections: []TdvfLayoutSection{{Type: "bfv", RawDataSize: 0x2000, MemoryDataSize: 0x1000}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MakeTdvfMetadata(&tt.layout, nil); err == nil {
+				t.Error("MakeTdvfMetadata() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// templateFileName returns the name of the ACPI table template for the given CPU count and memory
+// hotplug configuration.
+func templateFileName(cpuCount uint32, hotplug *MemoryHotplug) string {
+	if hotplug != nil {
+		return fmt.Sprintf("template_qemu_cpu%d_slots%d_maxmem%dM.hex", cpuCount, hotplug.Slots, hotplug.MaxMemory)
+	}
+	return fmt.Sprintf("template_qemu_cpu%d.hex", cpuCount)
+}
+
+// CountTemplates returns the number of ACPI table templates in dir.
+func CountTemplates(dir string) (int, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return 0, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "template_qemu_cpu*.hex"))
+	return len(matches), err
+}
+
+// TemplateCombination is a guest configuration an ACPI table template covers.
+type TemplateCombination struct {
+	CPUs uint32 `json:"cpus"`
+	// Slots and MaxMemoryMB are the memory hotplug configuration, zero without memory hotplug.
+	Slots       uint32 `json:"slots,omitempty"`
+	MaxMemoryMB uint64 `json:"max_memory_mb,omitempty"`
+	// Signed reports whether the template has a detached signature as in downloaded template sets.
+	Signed bool   `json:"signed"`
+	File   string `json:"file"`
+}
+
+// ListTemplates returns the combinations the ACPI table templates in dir cover, ordered by memory
+// hotplug configuration and CPU count.
+func ListTemplates(dir string) ([]TemplateCombination, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(filepath.Join(dir, "template_qemu_cpu*.hex"))
+	if err != nil {
+		return nil, err
+	}
+	var combinations []TemplateCombination
+	for _, path := range matches {
+		c := TemplateCombination{File: filepath.Base(path)}
+		if _, err := fmt.Sscanf(c.File, "template_qemu_cpu%d_slots%d_maxmem%dM.hex", &c.CPUs, &c.Slots, &c.MaxMemoryMB); err != nil {
+			c.Slots, c.MaxMemoryMB = 0, 0
+			if _, err = fmt.Sscanf(c.File, "template_qemu_cpu%d.hex", &c.CPUs); err != nil {
+				continue
+			}
+		}
+		// Skip files that only share the prefix, e.g. backups.
+		var hotplug *MemoryHotplug
+		if c.Slots != 0 {
+			hotplug = &MemoryHotplug{Slots: c.Slots, MaxMemory: c.MaxMemoryMB}
+		}
+		if templateFileName(c.CPUs, hotplug) != c.File {
+			continue
+		}
+		_, err := os.Stat(path + ".sig")
+		c.Signed = err == nil
+		combinations = append(combinations, c)
+	}
+	sort.Slice(combinations, func(i, j int) bool {
+		a, b := combinations[i], combinations[j]
+		if a.Slots != b.Slots || a.MaxMemoryMB != b.MaxMemoryMB {
+			return a.Slots < b.Slots || a.Slots == b.Slots && a.MaxMemoryMB < b.MaxMemoryMB
+		}
+		return a.CPUs < b.CPUs
+	})
+	return combinations, nil
+}
+
+// templateCoverage describes the CPU counts the templates in dir cover for the memory hotplug
+// configuration, e.g. "1-8, 16", for the error about a missing template.
+func templateCoverage(dir string, hotplug *MemoryHotplug) string {
+	combinations, err := ListTemplates(dir)
+	if err != nil {
+		return "none"
+	}
+	var cpus []uint32
+	for _, c := range combinations {
+		if hotplug == nil && c.Slots == 0 || hotplug != nil && c.Slots == hotplug.Slots && c.MaxMemoryMB == hotplug.MaxMemory {
+			cpus = append(cpus, c.CPUs)
+		}
+	}
+	var ranges []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, fmt.Sprint(cpus[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	if len(ranges) == 0 {
+		return "none"
+	}
+	return strings.Join(ranges, ", ")
+}
+
+// DefaultTemplatesCacheDir returns the directory used to cache downloaded template sets.
+func DefaultTemplatesCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "reproduce-mr", "templates"), nil
+}
+
+// FetchTemplates makes sure the ACPI table template for the given CPU count and memory hotplug
+// configuration from the template set of the given release (e.g. qemu-8.2) hosted at baseURL is
+// available in the cache and returns the cache directory holding it.
+//
+// Every template is accompanied by a detached hex-encoded Ed25519 signature (<template>.sig) over
+// the release, its file name and contents, see SignTemplates. It is verified against pubKey both
+// after download and on every cache hit, so a template of another release is rejected even if it
+// is served at baseURL. A cached template that fails verification is downloaded again.
+func FetchTemplates(baseURL, release, cacheDir string, pubKey ed25519.PublicKey, cpuCount uint32, hotplug *MemoryHotplug) (string, error) {
+	if len(pubKey) != ed25519.PublicKeySize {
+		return "", fmt.Errorf("invalid template signing key size %d", len(pubKey))
+	}
+	if err := checkTemplateRelease(release); err != nil {
+		return "", err
+	}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	// Each template set gets its own cache directory so releases never mix.
+	setHash := sha256.Sum256([]byte(baseURL + "\x00" + release))
+	dir := filepath.Join(cacheDir, hex.EncodeToString(setHash[:8]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create template cache: %w", err)
+	}
+
+	fn := templateFileName(cpuCount, hotplug)
+	tplPath := filepath.Join(dir, fn)
+	sigPath := tplPath + ".sig"
+
+	if tpl, sig, err := readCachedTemplate(tplPath, sigPath); err == nil {
+		if verifyTemplate(pubKey, release, fn, tpl, sig) == nil {
+			return dir, nil
+		}
+		// The cached copy was modified or signed differently, e.g. with an older signing key.
+		if err = errors.Join(os.Remove(tplPath), os.Remove(sigPath)); err != nil {
+			return "", fmt.Errorf("failed to evict cached template: %w", err)
+		}
+	}
+
+	tpl, err := httpGet(baseURL + "/" + fn)
+	if err != nil {
+		return "", fmt.Errorf("failed to download template %s: %w", fn, err)
+	}
+	sig, err := httpGet(baseURL + "/" + fn + ".sig")
+	if err != nil {
+		return "", fmt.Errorf("failed to download template signature %s.sig: %w", fn, err)
+	}
+	if err = verifyTemplate(pubKey, release, fn, tpl, sig); err != nil {
+		return "", err
+	}
+	if err = os.WriteFile(tplPath, tpl, 0o644); err != nil {
+		return "", fmt.Errorf("failed to cache template: %w", err)
+	}
+	if err = os.WriteFile(sigPath, sig, 0o644); err != nil {
+		return "", fmt.Errorf("failed to cache template signature: %w", err)
+	}
+	return dir, nil
+}
+
+func readCachedTemplate(tplPath, sigPath string) ([]byte, []byte, error) {
+	tpl, err := os.ReadFile(tplPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err := os.ReadFile(sigPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tpl, sig, nil
+}
+
+// checkTemplateRelease checks that a template set release can be bound into template signatures.
+func checkTemplateRelease(release string) error {
+	if release == "" {
+		return fmt.Errorf("the release of the template set is required")
+	}
+	if strings.ContainsRune(release, 0) {
+		return fmt.Errorf("invalid template set release %q", release)
+	}
+	return nil
+}
+
+// templateSignedMessage returns the message a template signature covers: the release of the
+// template set, the file name of the template, which encodes the guest configuration, and the
+// template, separated by NUL bytes. Binding the release and name prevents a validly signed template
+// from being served in place of another one, from the same or another release.
+func templateSignedMessage(release, name string, tpl []byte) []byte {
+	return append([]byte(release+"\x00"+name+"\x00"), tpl...)
+}
+
+func verifyTemplate(pubKey ed25519.PublicKey, release, name string, tpl, sigHex []byte) error {
+	sig, err := hex.DecodeString(strings.TrimSpace(string(sigHex)))
+	if err != nil {
+		return fmt.Errorf("template %s has a malformed signature: %w", name, err)
+	}
+	if !ed25519.Verify(pubKey, templateSignedMessage(release, name, tpl), sig) {
+		return fmt.Errorf("template %s signature verification failed for release %s", name, release)
+	}
+	return nil
+}
+
+// SignTemplates writes the detached signatures FetchTemplates verifies for all templates in dir as
+// the template set of the given release, replacing existing ones, and returns the names of the
+// signed templates.
+func SignTemplates(dir, release string, key ed25519.PrivateKey) ([]string, error) {
+	if err := checkTemplateRelease(release); err != nil {
+		return nil, err
+	}
+	combinations, err := ListTemplates(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, c := range combinations {
+		path := filepath.Join(dir, c.File)
+		tpl, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		sig := ed25519.Sign(key, templateSignedMessage(release, c.File, tpl))
+		if err = os.WriteFile(path+".sig", []byte(hex.EncodeToString(sig)+"\n"), 0o644); err != nil {
+			return nil, err
+		}
+		names = append(names, c.File)
+	}
+	return names, nil
+}
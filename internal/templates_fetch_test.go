@@ -0,0 +1,67 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFetchTemplatesRefetchesInvalidCache(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := t.TempDir()
+	const name = "template_qemu_cpu1.hex"
+	tpl := []byte(hex.EncodeToString(syntheticAcpiTables("RSDT")))
+	if err = os.WriteFile(filepath.Join(published, name), tpl, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = SignTemplates(published, "qemu-8.2", key); err != nil {
+		t.Fatal(err)
+	}
+	downloads := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		http.FileServer(http.Dir(published)).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cacheDir := t.TempDir()
+	dir, err := FetchTemplates(server.URL, "qemu-8.2", cacheDir, pub, 1, nil)
+	if err != nil {
+		t.Fatalf("FetchTemplates() error = %v", err)
+	}
+	if downloads != 2 {
+		t.Fatalf("first fetch made %d downloads, want 2", downloads)
+	}
+	if _, err = FetchTemplates(server.URL, "qemu-8.2", cacheDir, pub, 1, nil); err != nil || downloads != 2 {
+		t.Fatalf("cached fetch: error = %v, %d downloads", err, downloads)
+	}
+
+	// A cached template modified in place fails verification and is downloaded again.
+	if err = os.WriteFile(filepath.Join(dir, name), []byte("00"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = FetchTemplates(server.URL, "qemu-8.2", cacheDir, pub, 1, nil); err != nil {
+		t.Fatalf("FetchTemplates() of a modified cache error = %v", err)
+	}
+	if downloads != 4 {
+		t.Errorf("modified cache made %d downloads in total, want 4", downloads)
+	}
+	if cached, _ := os.ReadFile(filepath.Join(dir, name)); string(cached) != string(tpl) {
+		t.Error("modified cached template was not replaced")
+	}
+
+	// The templates served at the URL are not those of another release, and the cache of one
+	// release does not satisfy another.
+	if _, err = FetchTemplates(server.URL, "qemu-9.0", cacheDir, pub, 1, nil); err == nil {
+		t.Error("FetchTemplates() accepted a template signed for another release")
+	}
+}
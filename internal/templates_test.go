@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+func TestVerifyTemplateBindsNameAndRelease(t *testing.T) {
+	pub, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := []byte("00112233")
+	sig := []byte(hex.EncodeToString(ed25519.Sign(key, templateSignedMessage("qemu-8.2", "template_qemu_cpu1.hex", tpl))))
+	if err = verifyTemplate(pub, "qemu-8.2", "template_qemu_cpu1.hex", tpl, sig); err != nil {
+		t.Errorf("verifyTemplate() error = %v", err)
+	}
+	if err = verifyTemplate(pub, "qemu-8.2", "template_qemu_cpu2.hex", tpl, sig); err == nil {
+		t.Error("signature of a template verified for another template name")
+	}
+	if err = verifyTemplate(pub, "qemu-9.0", "template_qemu_cpu1.hex", tpl, sig); err == nil {
+		t.Error("signature of a template verified for another release")
+	}
+	// Signatures over the template alone or without the release are not accepted.
+	for _, message := range [][]byte{tpl, append([]byte("template_qemu_cpu1.hex\x00"), tpl...)} {
+		if err = verifyTemplate(pub, "qemu-8.2", "template_qemu_cpu1.hex", tpl, []byte(hex.EncodeToString(ed25519.Sign(key, message)))); err == nil {
+			t.Errorf("signature over %q verified", message)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// TrustedKey is a public key signatures are verified against.
+type TrustedKey struct {
+	// ID is the hex-encoded SHA256 digest of the PKIX encoding of the key, which signers put in
+	// the keyid of DSSE signatures.
+	ID  string
+	Key crypto.PublicKey
+}
+
+// TrustAnchors are the keys signed artifacts are accepted from. Several keys can be trusted at
+// once, so that a signing key can be rotated while artifacts signed with the previous key are
+// still in use.
+type TrustAnchors []TrustedKey
+
+// ParseTrustedKey parses a hex-encoded Ed25519 public key or reads a PEM encoded PKIX Ed25519 or
+// ECDSA (P-256/P-384) public key from the file the value names.
+func ParseTrustedKey(value string) (TrustedKey, error) {
+	if raw, err := hex.DecodeString(strings.TrimPrefix(value, "0x")); err == nil {
+		if len(raw) != ed25519.PublicKeySize {
+			return TrustedKey{}, fmt.Errorf("invalid Ed25519 public key size %d", len(raw))
+		}
+		return newTrustedKey(ed25519.PublicKey(raw))
+	}
+	data, err := os.ReadFile(value)
+	if err != nil {
+		return TrustedKey{}, fmt.Errorf("key is neither a hex-encoded Ed25519 key nor a readable file: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return TrustedKey{}, fmt.Errorf("no PEM data found in %s", value)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return TrustedKey{}, fmt.Errorf("failed to parse public key: %w", err)
+	}
+	return newTrustedKey(key)
+}
+
+// ParseTrustAnchors parses the keys of ParseTrustedKey.
+func ParseTrustAnchors(values []string) (TrustAnchors, error) {
+	anchors := make(TrustAnchors, 0, len(values))
+	for _, value := range values {
+		key, err := ParseTrustedKey(value)
+		if err != nil {
+			return nil, err
+		}
+		anchors = append(anchors, key)
+	}
+	return anchors, nil
+}
+
+// newTrustedKey checks that the key type is supported and derives its ID.
+func newTrustedKey(key crypto.PublicKey) (TrustedKey, error) {
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() && k.Curve != elliptic.P384() {
+			return TrustedKey{}, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+	default:
+		return TrustedKey{}, fmt.Errorf("unsupported public key type %T", key)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	id := sha256.Sum256(der)
+	return TrustedKey{ID: hex.EncodeToString(id[:]), Key: key}, nil
+}
+
+// KeyID returns the ID of the public key of a signer, as it is given in DSSE signatures.
+func KeyID(key crypto.Signer) (string, error) {
+	trusted, err := newTrustedKey(key.Public())
+	if err != nil {
+		return "", err
+	}
+	return trusted.ID, nil
+}
+
+// verify verifies a signature of the message. Ed25519 signatures are made over the message,
+// ECDSA signatures are ASN.1 encoded and made over its SHA256 (P-256) or SHA384 (P-384) digest.
+func (k TrustedKey) verify(message, sig []byte) bool {
+	switch key := k.Key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, sig)
+	case *ecdsa.PublicKey:
+		if key.Curve == elliptic.P384() {
+			digest := sha512.Sum384(message)
+			return ecdsa.VerifyASN1(key, digest[:], sig)
+		}
+		digest := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	default:
+		return false
+	}
+}
+
+// Verify checks that any of the signatures of the message verifies with a trusted key and
+// returns the ID of that key.
+func (a TrustAnchors) Verify(message []byte, sigs [][]byte) (string, error) {
+	if len(a) == 0 {
+		return "", fmt.Errorf("no trusted keys")
+	}
+	for _, sig := range sigs {
+		for _, key := range a {
+			if key.verify(message, sig) {
+				return key.ID, nil
+			}
+		}
+	}
+	return "", ErrSignatureInvalid
+}
+
+// signMessage signs the message with an Ed25519 or ECDSA (P-256/P-384) key as verified by
+// TrustedKey.verify.
+func signMessage(key crypto.Signer, message []byte) ([]byte, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		return ed25519.Sign(k, message), nil
+	case *ecdsa.PrivateKey:
+		var digest []byte
+		switch k.Curve {
+		case elliptic.P256():
+			d := sha256.Sum256(message)
+			digest = d[:]
+		case elliptic.P384():
+			d := sha512.Sum384(message)
+			digest = d[:]
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+		return ecdsa.SignASN1(rand.Reader, k, digest)
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCpuCount is the largest number of vCPUs KVM supports for a guest on x86.
+const maxCpuCount = 4096
+
+// validateParameters checks the measurement parameters for configurations that cannot boot, so
+// that no digests are produced for them.
+func validateParameters(kernelData []byte, initrdSize uint64, memorySize uint64, cpuCount uint32, kernelCmdline string, profile *Profile) error {
+	var errs []error
+	if cpuCount == 0 {
+		errs = append(errs, fmt.Errorf("CPU count must be at least 1"))
+	} else if cpuCount > maxCpuCount {
+		errs = append(errs, fmt.Errorf("CPU count %d exceeds the maximum of %d", cpuCount, maxCpuCount))
+	}
+
+	// Guest RAM starts after the firmware region and must at least hold the kernel and initrd.
+	memoryBytes := memorySize * 1024 * 1024
+	minMemory := profile.HobRamStart + uint64(len(kernelData)) + initrdSize
+	if memoryBytes <= minMemory {
+		errs = append(errs, fmt.Errorf("memory size %dM is too small, at least %dM are needed for the firmware region, kernel and initrd",
+			memorySize, minMemory/(1024*1024)+1))
+	}
+
+	if profile.MemoryHotplug != nil {
+		if err := profile.MemoryHotplug.validate(memorySize); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := validateInitrdDelivery(profile.initrdDelivery(), kernelData, initrdSize, kernelCmdline); err != nil {
+		errs = append(errs, err)
+	}
+
+	if limit, ok := kernelCmdlineLimit(kernelData); ok && len(kernelCmdline) > limit {
+		errs = append(errs, fmt.Errorf("kernel command line is %d bytes long, the kernel boot protocol allows at most %d", len(kernelCmdline), limit))
+	}
+	return errors.Join(errs...)
+}
+
+// kernelCmdlineLimit returns the maximum kernel command line length (without the terminating NUL)
+// supported by the boot protocol of an x86 bzImage.
+func kernelCmdlineLimit(kd []byte) (int, bool) {
+	if format, _ := DetectKernelFormat(kd); format != KernelFormatBzImage && format != KernelFormatBzImageNoStub {
+		return 0, false
+	}
+	if len(kd) < 0x23c || binary.LittleEndian.Uint16(kd[0x206:0x208]) < 0x206 {
+		// Kernels before boot protocol 2.06 do not report the limit and support 255 bytes.
+		return 255, true
+	}
+	return int(binary.LittleEndian.Uint32(kd[0x238:0x23c])), true
+}
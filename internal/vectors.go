@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// TestVectorsVersion is the version of the test vector format, incremented on incompatible changes.
+const TestVectorsVersion = 1
+
+// TestVectors are language-agnostic inputs together with the digests this tool computes from
+// them, so that other implementations of the same hashing can be checked against it.
+type TestVectors struct {
+	Version      int                 `json:"version"`
+	EventDigests []EventDigestVector `json:"event_digests"`
+	Composites   []CompositeVector   `json:"composites"`
+	RtmrReplays  []RtmrReplayVector  `json:"rtmr_replays"`
+}
+
+// EventDigestVector is the digest of a dstack runtime event: the SHA384 of the little-endian event
+// type, ":", the name, ":" and the payload.
+type EventDigestVector struct {
+	EventType uint32 `json:"event_type"`
+	Name      string `json:"name"`
+	Payload   string `json:"payload"`
+	Digest    string `json:"digest"`
+}
+
+// CompositeVector is a composite value of a composite spec: the SHA256 of the concatenation of the
+// values of its fields, in order.
+type CompositeVector struct {
+	Spec          string            `json:"spec"`
+	Name          string            `json:"name"`
+	Fields        []string          `json:"fields"`
+	Registers     map[string]string `json:"registers"`
+	MrKeyProvider string            `json:"mr_key_provider"`
+	Value         string            `json:"value"`
+}
+
+// RtmrReplayVector is the value of an RTMR after extending it, starting from zero, with each digest
+// in order. Digests shorter than 48 bytes are zero-padded before extending.
+type RtmrReplayVector struct {
+	Name     string   `json:"name"`
+	Digests  []string `json:"digests"`
+	Expected string   `json:"expected"`
+}
+
+// testVectorEvents are the runtime events of the test vectors, as extended by the dstack guest
+// agent during boot.
+var testVectorEvents = []struct {
+	name    string
+	payload []byte
+}{
+	{"system-preparing", nil},
+	{"app-id", []byte{0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14}},
+	{"compose-hash", measureSha256([]byte("services: {}\n"))},
+	{"instance-id", []byte{}},
+	{"boot-mr-done", nil},
+	{"key-provider", []byte(`{"name":"kms","id":"00"}`)},
+	{"system-ready", nil},
+}
+
+// GenerateTestVectors computes the test vectors for runtime event digests, the composite values of
+// all built-in composite specs under each of the given key providers, and RTMR replays.
+func GenerateTestVectors(keyProviders map[string]string) (*TestVectors, error) {
+	vectors := &TestVectors{Version: TestVectorsVersion}
+
+	eventDigests := make([]string, 0, len(testVectorEvents))
+	for _, e := range testVectorEvents {
+		digest := runtimeEventDigest(DstackRuntimeEventType, e.name, e.payload)
+		eventDigests = append(eventDigests, hex.EncodeToString(digest))
+		vectors.EventDigests = append(vectors.EventDigests, EventDigestVector{
+			EventType: DstackRuntimeEventType,
+			Name:      e.name,
+			Payload:   hex.EncodeToString(e.payload),
+			Digest:    hex.EncodeToString(digest),
+		})
+	}
+
+	// The register values are digests of their names, so that every field is distinct.
+	m := &TdxMeasurements{}
+	for _, r := range []struct {
+		name  string
+		value *[]byte
+	}{{"MRTD", &m.MRTD}, {"RTMR0", &m.RTMR0}, {"RTMR1", &m.RTMR1}, {"RTMR2", &m.RTMR2}, {"RTMR3", &m.RTMR3}} {
+		*r.value = measureSha384([]byte("reproduce-mr test vector " + r.name))
+	}
+	registers := RegisterValues(m)
+	names := make([]string, 0, len(keyProviders))
+	for name := range keyProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, spec := range builtinCompositeSpecs.Specs {
+		for _, name := range names {
+			mrKeyProvider := strings.TrimPrefix(keyProviders[name], "0x")
+			for _, c := range spec.Composites {
+				value, err := computeComposite(m, c, mrKeyProvider)
+				if err != nil {
+					return nil, fmt.Errorf("composite %s of spec %s: %w", c.Name, spec.Version, err)
+				}
+				vectors.Composites = append(vectors.Composites, CompositeVector{
+					Spec:          spec.Version,
+					Name:          c.Name,
+					Fields:        c.Fields,
+					Registers:     registers,
+					MrKeyProvider: mrKeyProvider,
+					Value:         value,
+				})
+			}
+		}
+	}
+
+	replays := []RtmrReplayVector{
+		{Name: "empty", Digests: []string{}},
+		{Name: "single-sha384", Digests: []string{hex.EncodeToString(measureSha384([]byte("event")))}},
+		{Name: "sha256-zero-padded", Digests: []string{
+			hex.EncodeToString(measureSha256([]byte("docker-compose"))),
+			hex.EncodeToString(measureSha256([]byte("rootfs"))),
+		}},
+		{Name: "runtime-events", Digests: eventDigests},
+	}
+	for i := range replays {
+		value, err := replayRTMR(replays[i].Digests)
+		if err != nil {
+			return nil, fmt.Errorf("replay %s: %w", replays[i].Name, err)
+		}
+		replays[i].Expected = value
+	}
+	vectors.RtmrReplays = replays
+	return vectors, nil
+}
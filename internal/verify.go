@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RegisterResult is the result of comparing a single register of a TD report against the
+// computed measurements.
+type RegisterResult struct {
+	Register string `json:"register"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Match    bool   `json:"match"`
+	// Coverage is the weakest coverage status of the events extended into the register.
+	Coverage CoverageStatus `json:"coverage"`
+	// FirstMismatch is the first differing event of a mismatching register, if an event log of the
+	// TD was given.
+	FirstMismatch *EventMismatch `json:"first_mismatch,omitempty"`
+}
+
+// CompareReport compares the given registers (e.g. "MRTD", "RTMR0") of a TD report against the
+// computed measurements.
+func CompareReport(report *TdReport, m *TdxMeasurements, registers []string) ([]RegisterResult, error) {
+	results, err := CompareRegisters(m, report, registers)
+	if err != nil {
+		return nil, err
+	}
+	coverage := m.RegisterCoverage()
+	for i := range results {
+		results[i].Coverage = coverage[results[i].Register]
+	}
+	return results, nil
+}
+
+// CompareRegisters compares the given registers of two register sets.
+func CompareRegisters(expected, actual RegisterSet, registers []string) ([]RegisterResult, error) {
+	results := make([]RegisterResult, 0, len(registers))
+	for _, name := range registers {
+		name = strings.ToUpper(name)
+		e, ok := LookupRegister(expected, name)
+		if !ok {
+			return nil, fmt.Errorf("unknown register '%s'", name)
+		}
+		a, ok := LookupRegister(actual, name)
+		if !ok {
+			return nil, fmt.Errorf("register '%s' is not present in the evidence", name)
+		}
+		results = append(results, RegisterResult{
+			Register: name,
+			Expected: hex.EncodeToString(e),
+			Actual:   hex.EncodeToString(a),
+			Match:    bytes.Equal(e, a),
+		})
+	}
+	return results, nil
+}
+
+// reportValues returns the register and composite values of a TD report by their output names.
+func reportValues(report *TdReport) map[string][]byte {
+	m := TdxMeasurementsFromRegisters(report)
+	mrImage, _ := hex.DecodeString(m.CalculateMrImage())
+	values := map[string][]byte{"mr_image": mrImage}
+	for _, r := range report.Registers() {
+		values[strings.ToLower(r.Name)] = r.Value
+	}
+	return values
+}
+
+// ReportClaims returns the values of a TD report that can be appraised, keyed by claim name: the
+// registers and composites by their output names, and the identity fields of the TD and the TDX
+// module.
+func ReportClaims(report *TdReport) map[string][]byte {
+	claims := reportValues(report)
+	claims["mr_config_id"] = report.MrConfigId
+	claims["mr_owner"] = report.MrOwner
+	claims["mr_owner_config"] = report.MrOwnerConfig
+	claims["mr_seam"] = report.MrSeam
+	claims["mr_signer_seam"] = report.MrSignerSeam
+	claims["td_attributes"] = report.TdAttributes
+	claims["xfam"] = report.Xfam
+	claims["report_data"] = report.ReportData
+	return claims
+}
+
+// FieldResult is the result of comparing a field of a TD report other than the registers.
+type FieldResult struct {
+	// Field is the claim name of the field, e.g. xfam or mr_owner.
+	Field  string `json:"field"`
+	Quoted string `json:"quoted"`
+	// Expected is the expected value, empty if the field is not checked.
+	Expected string `json:"expected,omitempty"`
+	Match    bool   `json:"match"`
+	// Note explains the expected value, e.g. the XSAVE components of the predicted XFAM.
+	Note string `json:"note,omitempty"`
+}
+
+// reportFields are the fields of a TD report CompareReportFields reports on, in their order.
+var reportFields = []string{"td_attributes", "xfam", "mr_config_id", "mr_owner", "mr_owner_config"}
+
+// CompareReportFields compares the TD attributes, XFAM, MRCONFIGID, MROWNER and MROWNERCONFIG of a
+// TD report against the expected values given by claim name. Every field is reported; fields
+// without an expected value are not checked and count as matching.
+func CompareReportFields(report *TdReport, expected map[string][]byte) []FieldResult {
+	claims := ReportClaims(report)
+	results := make([]FieldResult, 0, len(reportFields))
+	for _, name := range reportFields {
+		r := FieldResult{Field: name, Quoted: hex.EncodeToString(claims[name]), Match: true}
+		if want, ok := expected[name]; ok {
+			// Shorter values, e.g. a MRCONFIGID holding a digest, are zero-padded.
+			if len(want) < len(claims[name]) {
+				want = append(want, make([]byte, len(claims[name])-len(want))...)
+			}
+			r.Expected = hex.EncodeToString(want)
+			r.Match = bytes.Equal(want, claims[name])
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// expectedValueNames are the names of the values an expected-values file can hold, in the order
+// they are compared.
+var expectedValueNames = []string{"mrtd", "rtmr0", "rtmr1", "rtmr2", "rtmr3", "mr_image", "mr_aggregated"}
+
+// ParseExpectedValues parses a JSON object of expected hex-encoded register and composite values
+// by their output names (mrtd, rtmr0-3, mr_image and mr_aggregated), such as the JSON output of the
+// measurement command. Other fields are ignored.
+func ParseExpectedValues(data []byte) (map[string]string, error) {
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("malformed expected values: %w", err)
+	}
+	values := make(map[string]string)
+	for _, name := range expectedValueNames {
+		field, ok := fields[name]
+		if !ok {
+			continue
+		}
+		value, ok := field.(string)
+		if !ok {
+			return nil, fmt.Errorf("expected value of %s is not a string", name)
+		}
+		digest, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(value), "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("expected value of %s is not hex-encoded: %w", name, err)
+		}
+		values[name] = hex.EncodeToString(digest)
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no expected values found, expected any of %s", strings.Join(expectedValueNames, ", "))
+	}
+	return values, nil
+}
+
+// CompareExpected compares computed measurements against expected values. The given names are
+// compared and must all have an expected value; all expected values are compared if names is
+// empty. Composites are reported with the weakest coverage status of the registers.
+func CompareExpected(expected map[string]string, m *TdxMeasurements, mrKeyProvider string, names []string) ([]RegisterResult, error) {
+	if len(names) == 0 {
+		for _, name := range expectedValueNames {
+			if _, ok := expected[name]; ok {
+				names = append(names, name)
+			}
+		}
+	}
+	coverage := m.RegisterCoverage()
+	var weakest CoverageStatus = CoverageModeled
+	for _, status := range coverage {
+		if coverageRank[status] > coverageRank[weakest] {
+			weakest = status
+		}
+	}
+	results := make([]RegisterResult, 0, len(names))
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		want, ok := expected[name]
+		if !ok {
+			return nil, fmt.Errorf("no expected value for %s", name)
+		}
+		var (
+			actual string
+			status = weakest
+		)
+		switch name {
+		case "mr_image":
+			actual = m.CalculateMrImage()
+		case "mr_aggregated":
+			var err error
+			if actual, err = m.CalculateMrAggregated(mrKeyProvider); err != nil {
+				return nil, err
+			}
+		default:
+			value, ok := LookupRegister(m, name)
+			if !ok {
+				return nil, fmt.Errorf("unknown register '%s'", name)
+			}
+			actual, status = hex.EncodeToString(value), coverage[strings.ToUpper(name)]
+		}
+		results = append(results, RegisterResult{
+			Register: strings.ToUpper(name),
+			Expected: want,
+			Actual:   actual,
+			Match:    want == actual,
+			Coverage: status,
+		})
+	}
+	return results, nil
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	_ "embed"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+//go:embed release/VERSION
+var releaseVersion string
+
+// BuildInfo describes the running build of the tool.
+type BuildInfo struct {
+	// Version is the release version embedded at build time.
+	Version string `json:"version"`
+	// Commit and CommitTime identify the source revision, if the build recorded it.
+	Commit     string `json:"commit,omitempty"`
+	CommitTime string `json:"commit_time,omitempty"`
+	// Modified is set for builds from a source tree with uncommitted changes.
+	Modified  bool   `json:"modified,omitempty"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// ReadBuildInfo returns the build information of the running binary: the embedded release
+// version and the source revision recorded by the Go toolchain.
+func ReadBuildInfo() BuildInfo {
+	info := BuildInfo{
+		Version:   strings.TrimSpace(releaseVersion),
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				info.Commit = s.Value
+			case "vcs.time":
+				info.CommitTime = s.Value
+			case "vcs.modified":
+				info.Modified = s.Value == "true"
+			}
+		}
+	}
+	return info
+}
+
+// String returns the version followed by the short commit, e.g. "0.1.0 (3f2a9c1e0b7d)".
+func (b BuildInfo) String() string {
+	if b.Commit == "" {
+		return b.Version
+	}
+	commit := b.Commit
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if b.Modified {
+		commit += "-dirty"
+	}
+	return fmt.Sprintf("%s (%s)", b.Version, commit)
+}
+
+// ToolVersion returns the version of the running build including its source revision.
+func ToolVersion() string {
+	return ReadBuildInfo().String()
+}
@@ -0,0 +1,33 @@
+package internal
+
+import "fmt"
+
+// Warning codes.
+const (
+	WarningUnknownFirmware   = "unknown-firmware"
+	WarningApproximatedEvent = "approximated-event"
+	WarningConstantDigest    = "constant-digest"
+	WarningUnusualMemorySize = "unusual-memory-size"
+	WarningOverrideInEffect  = "override-in-effect"
+	WarningUnsupportedConfig = "unsupported-config"
+	WarningProfileMismatch   = "profile-mismatch"
+	WarningRuntimeEvent      = "runtime-event-digest"
+	WarningToolVersion       = "tool-version"
+	WarningTdFeature         = "td-feature"
+	WarningQuoteNotVerified  = "quote-not-verified"
+)
+
+// Warning is a machine-parsable warning about conditions that may make the measurements inaccurate.
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s", w.Code, w.Message)
+}
+
+// addWarning records a warning with the given code.
+func (m *TdxMeasurements) addWarning(code, format string, args ...any) {
+	m.Warnings = append(m.Warnings, Warning{Code: code, Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,160 @@
+//go:build !minimal
+
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PostWebhook posts v as JSON to the webhook at url.
+func PostWebhook(ctx context.Context, url string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// NotificationEvent is the kind of event a notification reports.
+type NotificationEvent string
+
+const (
+	// NotifyVerifyFailure is sent for every quote that does not match the expected measurements.
+	NotifyVerifyFailure NotificationEvent = "verify-failure"
+	// NotifyUnknownMeasurement is sent the first time a quote with a set of measurements that
+	// does not match is observed.
+	NotifyUnknownMeasurement NotificationEvent = "unknown-measurement"
+	// NotifyMeasureFailure is sent when an image cannot be measured.
+	NotifyMeasureFailure NotificationEvent = "measure-failure"
+)
+
+// notificationEvents are the events that can be subscribed to.
+var notificationEvents = []NotificationEvent{NotifyVerifyFailure, NotifyUnknownMeasurement, NotifyMeasureFailure}
+
+// Notification is the JSON body posted to generic webhooks.
+type Notification struct {
+	Event NotificationEvent `json:"event"`
+	Time  time.Time         `json:"time"`
+	// Source is the command that sent the notification, e.g. serve or watch.
+	Source  string `json:"source"`
+	Summary string `json:"summary"`
+	Details any    `json:"details,omitempty"`
+}
+
+// slackMessage is the body posted to Slack incoming webhooks.
+type slackMessage struct {
+	Text string `json:"text"`
+}
+
+// webhookTarget is a webhook notifications are posted to.
+type webhookTarget struct {
+	url   string
+	slack bool
+}
+
+// parseWebhookTarget parses a webhook specification, either slack:<url> for a Slack incoming
+// webhook or the http(s) URL of a generic webhook receiving notifications as JSON.
+func parseWebhookTarget(spec string) (webhookTarget, error) {
+	url, slack := strings.CutPrefix(spec, "slack:")
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		return webhookTarget{}, fmt.Errorf("malformed webhook '%s', expected an http(s) URL or slack:<url>", spec)
+	}
+	return webhookTarget{url: url, slack: slack}, nil
+}
+
+// Notifier posts notifications to webhooks in the background.
+type Notifier struct {
+	targets []webhookTarget
+	events  map[NotificationEvent]bool
+	// onError is called with the errors of failed deliveries.
+	onError func(error)
+	pending sync.WaitGroup
+}
+
+// NewNotifier returns a notifier posting the given events to the given webhooks. All events are
+// posted if no events are given. It returns nil if no webhooks are given.
+func NewNotifier(webhooks, events []string, onError func(error)) (*Notifier, error) {
+	if len(webhooks) == 0 {
+		return nil, nil
+	}
+	n := &Notifier{events: make(map[NotificationEvent]bool), onError: onError}
+	for _, spec := range webhooks {
+		target, err := parseWebhookTarget(spec)
+		if err != nil {
+			return nil, err
+		}
+		n.targets = append(n.targets, target)
+	}
+	if len(events) == 0 {
+		for _, e := range notificationEvents {
+			n.events[e] = true
+		}
+	}
+	for _, name := range events {
+		known := false
+		for _, e := range notificationEvents {
+			if NotificationEvent(name) == e {
+				n.events[e], known = true, true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown notification event '%s'", name)
+		}
+	}
+	return n, nil
+}
+
+// Notify posts the notification to all webhooks if its event is subscribed to. It does not wait
+// for the delivery. Notify may be called on a nil notifier, which drops all notifications.
+func (n *Notifier) Notify(notification Notification) {
+	if n == nil || !n.events[notification.Event] {
+		return
+	}
+	if notification.Time.IsZero() {
+		notification.Time = time.Now().UTC()
+	}
+	for _, target := range n.targets {
+		var body any = notification
+		if target.slack {
+			body = slackMessage{Text: fmt.Sprintf("*%s* (%s): %s", notification.Event, notification.Source, notification.Summary)}
+		}
+		n.pending.Add(1)
+		go func() {
+			defer n.pending.Done()
+			if err := PostWebhook(context.Background(), target.url, body); err != nil && n.onError != nil {
+				n.onError(fmt.Errorf("failed to deliver %s notification: %w", notification.Event, err))
+			}
+		}()
+	}
+}
+
+// Wait waits for all notifications to be delivered.
+func (n *Notifier) Wait() {
+	if n != nil {
+		n.pending.Wait()
+	}
+}
@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// xfamComponent is a group of XSAVE state components QEMU enables in XFAM when the guest CPU has
+// a feature.
+type xfamComponent struct {
+	Name    string
+	Bits    uint64
+	Feature string
+}
+
+// xfamComponents are the XSAVE state components a TD can use. x87 and SSE state are always enabled,
+// the others follow the CPUID features of the guest CPU as QEMU derives XCR0 and IA32_XSS from them.
+var xfamComponents = []xfamComponent{
+	{Name: "x87", Bits: 1 << 0},
+	{Name: "sse", Bits: 1 << 1},
+	{Name: "avx", Bits: 1 << 2, Feature: "avx"},
+	{Name: "avx512", Bits: 1<<5 | 1<<6 | 1<<7, Feature: "avx512f"},
+	{Name: "pt", Bits: 1 << 8, Feature: "intel-pt"},
+	{Name: "pkru", Bits: 1 << 9, Feature: "pku"},
+	{Name: "cet", Bits: 1<<11 | 1<<12, Feature: "shstk"},
+	{Name: "cet", Bits: 1<<11 | 1<<12, Feature: "ibt"},
+	{Name: "uintr", Bits: 1 << 14, Feature: "uintr"},
+	{Name: "lbr", Bits: 1 << 15, Feature: "arch-lbr"},
+	{Name: "amx", Bits: 1<<17 | 1<<18, Feature: "amx-tile"},
+}
+
+// xfamCpuModels are the XFAM-relevant features of the named QEMU CPU models. Models not listed
+// have none of them.
+var xfamCpuModels = map[string][]string{
+	"qemu64":             nil,
+	"kvm64":              nil,
+	"Nehalem":            nil,
+	"Westmere":           nil,
+	"SandyBridge":        {"avx"},
+	"IvyBridge":          {"avx"},
+	"Haswell":            {"avx"},
+	"Broadwell":          {"avx"},
+	"Skylake-Client":     {"avx"},
+	"Skylake-Server":     {"avx", "avx512f", "pku"},
+	"Cascadelake-Server": {"avx", "avx512f", "pku"},
+	"Cooperlake":         {"avx", "avx512f", "pku"},
+	"Icelake-Server":     {"avx", "avx512f", "pku"},
+	"SapphireRapids":     {"avx", "avx512f", "pku", "amx-tile"},
+	"GraniteRapids":      {"avx", "avx512f", "pku", "amx-tile"},
+}
+
+// XfamPrediction is the XFAM QEMU requests for a TD with a CPU model.
+type XfamPrediction struct {
+	// Xfam is the XFAM in the byte order of the TD report.
+	Xfam []byte `json:"xfam"`
+	// Value is the XFAM as a number.
+	Value uint64 `json:"value"`
+	// Components are the enabled XSAVE state components.
+	Components []string `json:"components"`
+}
+
+// normalizeCpuFeature converts a feature name of /proc/cpuinfo to the name QEMU uses.
+func normalizeCpuFeature(name string) string {
+	name = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(name), "_", "-"))
+	if name == "user-shstk" {
+		return "shstk"
+	}
+	return name
+}
+
+// PredictXfam predicts the XFAM QEMU requests for a TD from the argument of its -cpu option, a
+// CPU model followed by features to add (+feature, feature=on) or remove (-feature, feature=off),
+// e.g. "SapphireRapids,-amx-tile". The features of the host and max models are those of the host
+// CPU, which must then be given as the flags of /proc/cpuinfo.
+func PredictXfam(cpu string, hostFlags string) (*XfamPrediction, error) {
+	parts := strings.Split(cpu, ",")
+	model := strings.TrimSpace(parts[0])
+	features := map[string]bool{}
+	switch model {
+	case "host", "max":
+		if strings.TrimSpace(hostFlags) == "" {
+			return nil, fmt.Errorf("%w: the features of CPU model '%s' depend on the host, its CPU flags are required", ErrInvalidParameters, model)
+		}
+		for _, f := range strings.Fields(hostFlags) {
+			features[normalizeCpuFeature(f)] = true
+		}
+	default:
+		modelFeatures, ok := xfamCpuModels[model]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown CPU model '%s'", ErrInvalidParameters, model)
+		}
+		for _, f := range modelFeatures {
+			features[f] = true
+		}
+	}
+	for _, option := range parts[1:] {
+		option = strings.TrimSpace(option)
+		switch {
+		case strings.HasPrefix(option, "+"):
+			features[normalizeCpuFeature(option[1:])] = true
+		case strings.HasPrefix(option, "-"):
+			delete(features, normalizeCpuFeature(option[1:]))
+		case strings.Contains(option, "="):
+			name, value, _ := strings.Cut(option, "=")
+			switch value {
+			case "on", "true":
+				features[normalizeCpuFeature(name)] = true
+			case "off", "false":
+				delete(features, normalizeCpuFeature(name))
+			}
+			// Properties other than features, e.g. model-id, do not affect XFAM.
+		case option != "":
+			return nil, fmt.Errorf("%w: malformed CPU option '%s'", ErrInvalidParameters, option)
+		}
+	}
+
+	p := &XfamPrediction{}
+	seen := map[string]bool{}
+	for _, c := range xfamComponents {
+		if c.Feature != "" && !features[c.Feature] {
+			continue
+		}
+		p.Value |= c.Bits
+		if !seen[c.Name] {
+			seen[c.Name] = true
+			p.Components = append(p.Components, c.Name)
+		}
+	}
+	p.Xfam = binary.LittleEndian.AppendUint64(nil, p.Value)
+	return p, nil
+}
+
+// XfamCpuModels returns the names of the CPU models PredictXfam knows besides host and max.
+func XfamCpuModels() []string {
+	names := make([]string, 0, len(xfamCpuModels))
+	for name := range xfamCpuModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// kernelCandidate is the result of measuring a single kernel image from a kernel directory.
+type kernelCandidate struct {
+	Kernel       string `json:"kernel"`
+	Format       string `json:"format"`
+	RTMR1        string `json:"rtmr1,omitempty"`
+	MrAggregated string `json:"mr_aggregated,omitempty"`
+	MrImage      string `json:"mr_image,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// measureKernelDir measures every kernel image in dir with all other inputs fixed and prints a
+// table of the resulting RTMR1 and composite values.
+func measureKernelDir(dir string, force bool, mrKeyProvider string, jsonOutput bool, digests *digestFlags, measure func(kernelData []byte) (*internal.TdxMeasurements, error)) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	var candidates []kernelCandidate
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		kernelData, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+		format, _ := internal.DetectKernelFormat(kernelData)
+		c := kernelCandidate{Kernel: entry.Name(), Format: format.String()}
+		if err = internal.CheckKernelImage(kernelData); err != nil && !force {
+			// Other files in the directory (e.g. checksums or configs) are listed but not measured.
+			c.Error = err.Error()
+			candidates = append(candidates, c)
+			continue
+		}
+		measurements, err := measure(kernelData)
+		if err != nil {
+			c.Error = err.Error()
+		} else {
+			c.RTMR1 = digests.encode(measurements.RTMR1)
+			c.MrImage = digests.reformat(measurements.CalculateMrImage())
+			if mrAggregated, err := measurements.CalculateMrAggregated(mrKeyProvider); err != nil {
+				c.Error = err.Error()
+			} else {
+				c.MrAggregated = digests.reformat(mrAggregated)
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	if len(candidates) == 0 {
+		return fmt.Errorf("no kernel images found in %s", dir)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(candidates, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(jsonData))
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KERNEL\tRTMR1\tMR_AGGREGATED\tMR_IMAGE")
+	for _, c := range candidates {
+		if c.Error != "" {
+			fmt.Fprintf(w, "%s\tskipped: %s\t\t\n", c.Kernel, c.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Kernel, c.RTMR1, c.MrAggregated, c.MrImage)
+	}
+	return w.Flush()
+}
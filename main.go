@@ -1,10 +1,13 @@
 package main
 
 import (
+	"crypto"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -12,13 +15,30 @@ import (
 )
 
 type measurementOutput struct {
-	MRTD         string `json:"mrtd"`
-	RTMR0        string `json:"rtmr0"`
-	RTMR1        string `json:"rtmr1"`
-	RTMR2        string `json:"rtmr2"`
-	RTMR3        string `json:"rtmr3"`
-	MrAggregated string `json:"mr_aggregated"`
-	MrImage      string `json:"mr_image"`
+	MRTD         string `json:"mrtd,omitempty"`
+	RTMR0        string `json:"rtmr0,omitempty"`
+	RTMR1        string `json:"rtmr1,omitempty"`
+	RTMR2        string `json:"rtmr2,omitempty"`
+	RTMR3        string `json:"rtmr3,omitempty"`
+	MrAggregated string `json:"mr_aggregated,omitempty"`
+	MrImage      string `json:"mr_image,omitempty"`
+	// Xfam is the XFAM predicted for -cpu-model, in the byte order of the TD report.
+	Xfam string `json:"xfam,omitempty"`
+
+	// Registers holds all measurement registers by name, including registers not listed above.
+	Registers map[string]string `json:"registers"`
+
+	Coverage         []internal.CoverageEntry           `json:"coverage"`
+	RegisterCoverage map[string]internal.CoverageStatus `json:"register_coverage"`
+	ConstantDigests  []internal.CoverageEntry           `json:"constant_digests"`
+	Warnings         []internal.Warning                 `json:"warnings"`
+	Initrd           string                             `json:"initrd,omitempty"`
+
+	Composites map[string]map[string]string `json:"composites,omitempty"`
+	// ProfileDecisions trace how the profile was selected.
+	ProfileDecisions []profileDecision `json:"profile_decisions,omitempty"`
+	// Trace explains every event extended into the RTMRs, when requested.
+	Trace []internal.EventTrace `json:"trace,omitempty"`
 }
 
 var knownKeyProviders = map[string]string{
@@ -55,6 +75,26 @@ func parseMemorySize(size string) (uint64, error) {
 	}
 }
 
+// marshalOutput encodes the JSON output of a command, indented for reading or in canonical form.
+func marshalOutput(v any, canonical bool) ([]byte, error) {
+	if canonical {
+		return internal.MarshalCanonical(v)
+	}
+	return json.MarshalIndent(v, "", "  ")
+}
+
+// stringList is a flag that can be passed multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 type memoryValue uint64
 
 func (m *memoryValue) String() string {
@@ -78,134 +118,265 @@ func (m *memoryValue) Set(value string) error {
 	return nil
 }
 
-func main() {
-	const defaultMrKeyProvider = "0x0000000000000000000000000000000000000000000000000000000000000000"
-	var (
-		fwPath            string
-		kernelPath        string
-		initrdPath        string
-		rootfsPath        string
-		dockerComposePath string
-		dockerFilesPath   string
-		memorySize        memoryValue = 2048 // 2G default (in MB)
-		cpuCountUint      uint
-		tcbver            uint
-		kernelCmdline     string
-		jsonOutput        bool
-		mrKeyProvider     string = defaultMrKeyProvider
-		templatesPath     string
-	)
+// commands maps the names of subcommands to their implementations. Commands that need more than the
+// measurement engine are registered by commands_full.go, which is left out of builds with the
+// minimal tag.
+var commands = map[string]func(args []string){
+	"measure":            runMeasure,
+	"inspect":            runInspect,
+	"extract-fw-section": runExtractFwSection,
+	"make-tdvf-metadata": runMakeTdvfMetadata,
+	"tdvf-info":          runTdvfInfo,
+	"rtmr-sim":           runRtmrSim,
+	"bench":              runBench,
+	"composite":          runComposite,
+	"parse-quote":        runParseQuote,
+	"match-profile":      runMatchProfile,
+	"selfcheck":          runSelfCheck,
+	"crosscheck":         runCrosscheck,
+	"convert":            runConvert,
+	"replay":             runReplay,
+	"diff-log":           runDiffLog,
+	"verify-report":      runVerifyReport,
+	"xfam":               runXfam,
+	"templates":          runTemplates,
+	"gen-vectors":        runGenVectors,
+	"version":            runVersion,
+}
 
-	flag.StringVar(&fwPath, "fw", "", "Path to firmware file")
-	flag.StringVar(&kernelPath, "kernel", "", "Path to kernel file")
-	flag.StringVar(&initrdPath, "initrd", "", "Path to initrd file")
-	flag.StringVar(&rootfsPath, "rootfs", "", "Path to rootfs file")
-	flag.StringVar(&dockerComposePath, "dockercompose", "", "Path to docker compose file")
-	flag.StringVar(&dockerFilesPath, "dockerfiles", "", "Path to docker files file")
-	flag.Var(&memorySize, "memory", "Memory size (e.g., 512M, 1G, 2G)")
-	flag.UintVar(&tcbver, "tcbver", 0, "TCB version (currently only 6 and 7 are supported)")
-	flag.UintVar(&cpuCountUint, "cpu", 1, "Number of CPUs")
-	flag.StringVar(&kernelCmdline, "cmdline", "", "Kernel command line")
-	flag.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
-	flag.StringVar(&mrKeyProvider, "mrkp", defaultMrKeyProvider, "Measurement of key provider")
-	flag.StringVar(&templatesPath, "templates", "", "Path to templates directory")
-	flag.Parse()
-
-	// If the mrKeyProvider is in the knownKeyProviders, replace it with the value
-	if knownKeyProvider, ok := knownKeyProviders[mrKeyProvider]; ok {
-		mrKeyProvider = knownKeyProvider
-	}
-
-	if templatesPath == "" {
-		fmt.Println("Error: templates path is required")
-		flag.Usage()
-		os.Exit(1)
-	}
+// help lists the commands, so it is registered once the map is initialized.
+func init() {
+	commands["help"] = runHelp
+}
 
-	if fwPath == "" || kernelPath == "" {
-		fmt.Println("Error: firmware and kernel paths are required")
-		flag.Usage()
-		os.Exit(1)
+func main() {
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		// Without a command, the flags are those of the measure command.
+		runMeasure(os.Args[1:])
+		return
 	}
-
-	// Read files
-	fwData, err := os.ReadFile(fwPath)
-	if err != nil {
-		fmt.Printf("Error reading firmware file: %v\n", err)
-		os.Exit(1)
+	if run, ok := commands[os.Args[1]]; ok {
+		run(os.Args[2:])
+		return
 	}
+	fmt.Printf("Error: unknown command '%s'\n", os.Args[1])
+	printCommands()
+	os.Exit(1)
+}
 
-	kernelData, err := os.ReadFile(kernelPath)
-	if err != nil {
-		fmt.Printf("Error reading kernel file: %v\n", err)
-		os.Exit(1)
-	}
+// runHelp implements the help command, which lists the commands of this build.
+func runHelp(args []string) {
+	fmt.Println("Usage: reproduce-mr <command> [flags], or reproduce-mr [flags] to measure")
+	printCommands()
+}
 
-	var initrdData []byte
-	if initrdPath != "" {
-		initrdData, err = os.ReadFile(initrdPath)
-		if err != nil {
-			fmt.Printf("Error reading initrd file: %v\n", err)
-			os.Exit(1)
+// printCommands lists the names of the commands of this build.
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		if name != internal.SandboxWorkerCommand {
+			names = append(names, name)
 		}
 	}
+	sort.Strings(names)
+	fmt.Printf("Commands: %s\n", strings.Join(names, ", "))
+	fmt.Println("Run reproduce-mr <command> -h for the flags of a command.")
+}
 
-	var rootfsData []byte
-	if rootfsPath != "" {
-		rootfsData, err = os.ReadFile(rootfsPath)
-		if err != nil {
-			fmt.Printf("Error reading rootfs file: %v\n", err)
+// runMeasure implements the measure command, which computes the measurement registers of a TD
+// from its boot artifacts. It is also run when no command is given.
+func runMeasure(args []string) {
+	var (
+		opts             measureOptions
+		jsonOutput       bool
+		canonicalJSON    bool
+		format           string
+		envVarPrefix     string
+		warningsAsErrors bool
+		allowedWarnings  stringList
+		specFlags        compositeSpecFlags
+		digests          digestFlags
+		signKeys         stringList
+	)
+	fs := flag.NewFlagSet("measure", flag.ExitOnError)
+	opts.register(fs)
+	specFlags.register(fs)
+	digests.register(fs)
+	fs.StringVar(&opts.only, "only", "", "Comma separated list of registers to compute (e.g. rtmr1,rtmr2), skipping the inputs of all other registers")
+	fs.StringVar(&opts.kernelDir, "kernel-dir", "", "Path to a directory of kernel images to measure one by one with all other inputs fixed")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.BoolVar(&canonicalJSON, "canonical", false, "Output canonical JSON (RFC 8785) suitable for signing and content addressing, implies -json")
+	fs.StringVar(&format, "format", outputFormatText, "Output format: text, json or env (NAME=value lines that can be sourced by a shell)")
+	fs.StringVar(&envVarPrefix, "prefix", "", "Prefix of the variable names in env output, e.g. TD_ for TD_MRTD")
+	fs.BoolVar(&warningsAsErrors, "warnings-as-errors", false, "Exit with a non-zero status if any warnings were emitted")
+	fs.Var(&allowedWarnings, "allow-warning", "Warning code that does not fail -warnings-as-errors, e.g. constant-digest (can be repeated)")
+	fs.Var(&signKeys, "sign-key", "Path to a PEM PKCS #8 Ed25519 or ECDSA key to sign the canonical JSON report with, output as a DSSE envelope (can be repeated)")
+	parseFlags(fs, args)
+	// Signed reports are canonical, so that they can be verified after re-encoding.
+	canonicalJSON = canonicalJSON || len(signKeys) > 0
+	switch format {
+	case outputFormatText:
+	case outputFormatJSON:
+		jsonOutput = true
+	case outputFormatEnv:
+		if jsonOutput || canonicalJSON {
+			fmt.Println("Error: -format env cannot be combined with -json, -canonical or -sign-key")
 			os.Exit(1)
 		}
+		if envVarPrefix != "" && !validEnvName(envVarPrefix) {
+			fmt.Printf("Error: -prefix '%s' is not a valid variable name prefix\n", envVarPrefix)
+			os.Exit(1)
+		}
+	default:
+		fmt.Printf("Error: unknown output format '%s', expected text, json or env\n", format)
+		os.Exit(1)
 	}
-
-	var dockerComposeData []byte
-	if dockerComposePath != "" {
-		dockerComposeData, err = os.ReadFile(dockerComposePath)
+	if envVarPrefix != "" && format != outputFormatEnv {
+		fmt.Println("Error: -prefix requires -format env")
+		os.Exit(1)
+	}
+	digests.check(canonicalJSON)
+	var signers []crypto.Signer
+	for _, path := range signKeys {
+		key, err := internal.LoadSigningKey(path)
 		if err != nil {
-			fmt.Printf("Error reading docker compose file: %v\n", err)
+			fmt.Printf("Error loading signing key: %v\n", err)
 			os.Exit(1)
 		}
+		signers = append(signers, key)
 	}
 
-	var dockerFilesData []byte
-	if dockerFilesPath != "" {
-		dockerFilesData, err = os.ReadFile(dockerFilesPath)
-		if err != nil {
-			fmt.Printf("Error reading docker files file: %v\n", err)
+	job := opts.prepare(fs)
+	mrKeyProvider := job.mrKeyProvider
+	specs := specFlags.resolve()
+
+	if opts.kernelDir != "" {
+		if len(signers) > 0 || format == outputFormatEnv {
+			fmt.Println("Error: -sign-key and -format env cannot be combined with -kernel-dir")
 			os.Exit(1)
 		}
+		if err := measureKernelDir(opts.kernelDir, opts.force, mrKeyProvider, jsonOutput, &digests, job.measure); err != nil {
+			fmt.Printf("Error measuring kernel directory: %v\n", err)
+			os.Exit(1)
+		}
+		return
 	}
+
 	// Calculate measurements
-	measurements, err := internal.MeasureTdxQemu(fwData, kernelData, initrdData, rootfsData, dockerComposeData, dockerFilesData, uint64(memorySize), uint8(cpuCountUint), kernelCmdline, templatesPath, uint8(tcbver))
+	measurements, err := job.measure(job.kernelData)
 	if err != nil {
 		fmt.Printf("Error calculating measurements: %v\n", err)
 		os.Exit(1)
 	}
 
-	if jsonOutput {
+	warnings := job.warnings
+	initrdLayout := job.initrdLayout
+	warnings = append(warnings, measurements.Warnings...)
+	if warnings == nil {
+		warnings = []internal.Warning{}
+	}
+	// Aggregated values and composites need all registers.
+	complete := opts.only == ""
+	var mrAggregated, mrImage string
+	var composites map[string]map[string]string
+	if complete {
+		if mrAggregated, err = measurements.CalculateMrAggregated(mrKeyProvider); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		mrImage = measurements.CalculateMrImage()
+		composites = digests.reformatComposites(computeComposites(specs, measurements, mrKeyProvider))
+	}
+
+	if jsonOutput || canonicalJSON {
 		output := measurementOutput{
-			MRTD:         fmt.Sprintf("%x", measurements.MRTD),
-			RTMR0:        fmt.Sprintf("%x", measurements.RTMR0),
-			RTMR1:        fmt.Sprintf("%x", measurements.RTMR1),
-			RTMR2:        fmt.Sprintf("%x", measurements.RTMR2),
-			RTMR3:        fmt.Sprintf("%x", measurements.RTMR3),
-			MrAggregated: measurements.CalculateMrAggregated(mrKeyProvider),
-			MrImage:      measurements.CalculateMrImage(),
-		}
-		jsonData, err := json.MarshalIndent(output, "", "  ")
+			MRTD:         digests.encode(measurements.MRTD),
+			RTMR0:        digests.encode(measurements.RTMR0),
+			RTMR1:        digests.encode(measurements.RTMR1),
+			RTMR2:        digests.encode(measurements.RTMR2),
+			RTMR3:        digests.encode(measurements.RTMR3),
+			MrAggregated: digests.reformat(mrAggregated),
+			MrImage:      digests.reformat(mrImage),
+			Xfam:         job.xfamHex(),
+			Registers:    digests.reformatValues(internal.RegisterValues(measurements)),
+
+			Coverage:         digests.reformatCoverage(measurements.Coverage),
+			RegisterCoverage: measurements.RegisterCoverage(),
+			ConstantDigests:  digests.reformatCoverage(measurements.ConstantDigests()),
+			Warnings:         warnings,
+			Initrd:           initrdLayout,
+			Composites:       composites,
+			ProfileDecisions: job.profileDecisions,
+		}
+		jsonData, err := marshalOutput(output, canonicalJSON)
 		if err != nil {
 			fmt.Printf("Error encoding JSON: %v\n", err)
 			os.Exit(1)
 		}
+		if len(signers) > 0 {
+			if jsonData, err = signReport(jsonData, signers); err != nil {
+				fmt.Printf("Error signing report: %v\n", err)
+				os.Exit(1)
+			}
+		}
 		fmt.Println(string(jsonData))
+	} else if format == outputFormatEnv {
+		var variables []envVariable
+		for _, r := range measurements.Registers() {
+			variables = append(variables, envVariable{r.Name, digests.encode(r.Value)})
+		}
+		if complete {
+			variables = append(variables, envVariable{"MR_AGGREGATED", digests.reformat(mrAggregated)}, envVariable{"MR_IMAGE", digests.reformat(mrImage)})
+			for _, spec := range specs {
+				for _, c := range spec.Composites {
+					variables = append(variables, envVariable{envVariableName("COMPOSITE_" + spec.Version + "_" + c.Name), composites[spec.Version][c.Name]})
+				}
+			}
+		}
+		if job.xfam != nil {
+			variables = append(variables, envVariable{"XFAM", job.xfamHex()})
+		}
+		printEnv(envVarPrefix, variables)
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
 	} else {
-		fmt.Printf("MRTD: %x\n", measurements.MRTD)
-		fmt.Printf("RTMR0: %x\n", measurements.RTMR0)
-		fmt.Printf("RTMR1: %x\n", measurements.RTMR1)
-		fmt.Printf("RTMR2: %x\n", measurements.RTMR2)
-		fmt.Printf("RTMR3: %x\n", measurements.RTMR3)
-		fmt.Printf("MR_AGGREGATED: %s\n", measurements.CalculateMrAggregated(mrKeyProvider))
-		fmt.Printf("MR_IMAGE: %s\n", measurements.CalculateMrImage())
+		for _, r := range measurements.Registers() {
+			if r.Value != nil {
+				fmt.Printf("%s: %s\n", r.Name, digests.encode(r.Value))
+			}
+		}
+		if complete {
+			fmt.Printf("MR_AGGREGATED: %s\n", digests.reformat(mrAggregated))
+			fmt.Printf("MR_IMAGE: %s\n", digests.reformat(mrImage))
+			printComposites(specs, composites)
+		}
+		if job.xfam != nil {
+			fmt.Printf("XFAM: %s (%s)\n", job.xfamHex(), strings.Join(job.xfam.Components, ", "))
+		}
+		if initrdLayout != "" {
+			fmt.Printf("INITRD: %s\n", initrdLayout)
+		}
+		printProfileDecisions(job.profileDecisions)
+
+		for _, e := range measurements.Coverage {
+			if e.Status != internal.CoverageModeled {
+				fmt.Printf("COVERAGE: %s %s is %s (%s)\n", e.Register, e.Event, e.Status, e.Note)
+			}
+		}
+		for _, e := range measurements.ConstantDigests() {
+			fmt.Printf("CONSTANT: %s %s digest is not computed from the inputs\n", e.Register, e.Event)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if warningsAsErrors {
+		for _, w := range warnings {
+			if !slices.Contains(allowedWarnings, w.Code) {
+				os.Exit(1)
+			}
+		}
 	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runMakeTdvfMetadata implements the make-tdvf-metadata command, which synthesizes the TDVF
+// metadata for a section layout.
+func runMakeTdvfMetadata(args []string) {
+	var (
+		layoutPath string
+		fwPath     string
+		outPath    string
+	)
+
+	fs := flag.NewFlagSet("make-tdvf-metadata", flag.ExitOnError)
+	fs.StringVar(&layoutPath, "layout", "", "Path to the JSON description of the section layout")
+	fs.StringVar(&fwPath, "fw", "", "Path to the firmware build to append the metadata to (only the metadata is written if not set)")
+	fs.StringVar(&outPath, "out", "", "Path to output file")
+	parseFlags(fs, args)
+
+	if layoutPath == "" || outPath == "" {
+		fmt.Println("Error: layout and output path are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	layout, err := internal.LoadTdvfLayout(layoutPath)
+	if err != nil {
+		fmt.Printf("Error loading layout: %v\n", err)
+		os.Exit(1)
+	}
+	var fwData []byte
+	if fwPath != "" {
+		if fwData, err = os.ReadFile(fwPath); err != nil {
+			fmt.Printf("Error reading firmware file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	block, err := internal.MakeTdvfMetadata(layout, fwData)
+	if err != nil {
+		fmt.Printf("Error synthesizing TDVF metadata: %v\n", err)
+		os.Exit(1)
+	}
+	out := block
+	if fwData != nil {
+		out = append(fwData, block...)
+	}
+	if err = os.WriteFile(outPath, out, 0o644); err != nil {
+		fmt.Printf("Error writing output file: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %d bytes (%d bytes of TDVF metadata)\n", len(out), len(block))
+
+	if fwData != nil {
+		for _, tcbver := range []uint8{6, 7} {
+			mrtd, err := internal.ComputeFirmwareMrtd(out, tcbver)
+			if err != nil {
+				fmt.Printf("Error computing MRTD: %v\n", err)
+				os.Exit(1)
+			}
+			fmt.Printf("MRTD (tcbver %d): %x\n", tcbver, mrtd)
+		}
+	}
+}
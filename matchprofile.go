@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// matchProfileOutput is the JSON output of the match-profile command.
+type matchProfileOutput struct {
+	// Matches are the names of the profiles whose measurements match the quote.
+	Matches  []string            `json:"matches"`
+	Profiles []profileMatchEntry `json:"profiles"`
+	Warnings []internal.Warning  `json:"warnings"`
+}
+
+// profileMatchEntry is the result of comparing the measurements under a profile with the quote.
+type profileMatchEntry struct {
+	Profile   string                    `json:"profile"`
+	Match     bool                      `json:"match"`
+	Registers []internal.RegisterResult `json:"registers,omitempty"`
+	// Error is set when the inputs cannot be measured under the profile, e.g. because the templates
+	// do not fit it.
+	Error string `json:"error,omitempty"`
+}
+
+// runMatchProfile implements the match-profile command, which recomputes the measurements under
+// every known profile and reports those matching the quote. This fingerprints the QEMU/firmware
+// combination the TD was launched with.
+func runMatchProfile(args []string) {
+	var (
+		opts       measureOptions
+		quotePath  string
+		registers  string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("match-profile", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&quotePath, "quote", "", "Path to a TDX quote (binary, hex or base64)")
+	fs.StringVar(&registers, "registers", "mrtd,rtmr0,rtmr1,rtmr2", "Comma-separated list of registers to compare")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if quotePath == "" {
+		fmt.Println("Error: quote path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if opts.kernelDir != "" || opts.dumpDir != "" {
+		fmt.Println("Error: -kernel-dir and -dump-intermediate cannot be combined with match-profile")
+		os.Exit(1)
+	}
+
+	quote, err := readQuote(quotePath)
+	if err != nil {
+		fmt.Printf("Error reading quote: %v\n", err)
+		os.Exit(1)
+	}
+	evidence, err := internal.ParseEvidence(quote)
+	if err != nil {
+		fmt.Printf("Error parsing quote: %v\n", err)
+		os.Exit(1)
+	}
+	report := evidence.Report
+
+	job := opts.prepare(fs)
+	output := matchProfileOutput{Matches: []string{}, Warnings: job.warnings}
+	if output.Warnings == nil {
+		output.Warnings = []internal.Warning{}
+	}
+	for _, profile := range internal.Profiles() {
+		entry := profileMatchEntry{Profile: profile.Name}
+		candidate := *job
+		candidate.profile = opts.customizeProfile(profile, job.hotplug)
+		measurements, err := candidate.measure(job.kernelData)
+		if err == nil {
+			entry.Registers, err = internal.CompareReport(report, measurements, strings.Split(registers, ","))
+		}
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			entry.Match = true
+			for _, r := range entry.Registers {
+				entry.Match = entry.Match && r.Match
+			}
+		}
+		if entry.Match {
+			output.Matches = append(output.Matches, profile.Name)
+		}
+		output.Profiles = append(output.Profiles, entry)
+	}
+
+	if jsonOutput {
+		jsonData, err := marshalOutput(output, false)
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		printProfileMatches(output.Profiles, strings.Split(strings.ToUpper(registers), ","))
+		if len(output.Matches) > 0 {
+			fmt.Printf("MATCH: %s\n", strings.Join(output.Matches, ", "))
+		} else {
+			fmt.Println("No profile matches the quote")
+		}
+		for _, w := range output.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if len(output.Matches) == 0 {
+		os.Exit(1)
+	}
+}
+
+// printProfileMatches prints a table of the registers that match the quote under each profile.
+func printProfileMatches(entries []profileMatchEntry, registers []string) {
+	width := len("PROFILE")
+	for _, e := range entries {
+		width = max(width, len(e.Profile))
+	}
+	header := fmt.Sprintf("%-*s", width, "PROFILE")
+	for _, r := range registers {
+		header += fmt.Sprintf("  %-8s", strings.TrimSpace(r))
+	}
+	fmt.Println(strings.TrimRight(header, " "))
+	for _, e := range entries {
+		line := fmt.Sprintf("%-*s", width, e.Profile)
+		if e.Error != "" {
+			line += "  error: " + e.Error
+		}
+		for _, r := range e.Registers {
+			status := "match"
+			if !r.Match {
+				status = "mismatch"
+			}
+			line += fmt.Sprintf("  %-8s", status)
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+}
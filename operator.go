@@ -0,0 +1,294 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+const operatorFieldManager = "reproduce-mr"
+
+// retiredStatusFields are status fields earlier versions of the operator published, which are
+// removed from the status of the resources it measures.
+var retiredStatusFields = []string{"rtmr3", "mrAggregated", "mrImage"}
+
+// tdxImageSpec is the spec of a TDXImage custom resource.
+type tdxImageSpec struct {
+	Firmware   string `json:"firmware"`
+	Kernel     string `json:"kernel"`
+	Initrd     string `json:"initrd"`
+	Cmdline    string `json:"cmdline"`
+	Memory     string `json:"memory"`
+	Cpus       uint   `json:"cpus"`
+	TcbVersion uint   `json:"tcbVersion"`
+	Profile    string `json:"profile"`
+	ConfigMap  string `json:"configMap"`
+}
+
+// tdxImageStatus is the status of a TDXImage custom resource. The spec has no rootfs, docker
+// compose or other runtime inputs, so only MRTD and RTMR0-2 are published: RTMR3 and the
+// aggregated and image measurements it enters would be computed over an empty runtime.
+type tdxImageStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration"`
+	MRTD               string             `json:"mrtd,omitempty"`
+	RTMR0              string             `json:"rtmr0,omitempty"`
+	RTMR1              string             `json:"rtmr1,omitempty"`
+	RTMR2              string             `json:"rtmr2,omitempty"`
+	Warnings           []internal.Warning `json:"warnings,omitempty"`
+	Error              string             `json:"error,omitempty"`
+	MeasuredAt         string             `json:"measuredAt"`
+}
+
+// operator keeps the status of TDXImage resources up to date with their measurements.
+type operator struct {
+	client        *internal.KubeClient
+	namespace     string
+	templatesPath string
+	artifactsDir  string
+	// limits are the maximum sizes of the artifacts in megabytes.
+	limits inputLimits
+}
+
+// runOperator implements the operator command.
+func runOperator(args []string) {
+	var (
+		op        operator
+		apiServer string
+		token     string
+	)
+
+	fs := flag.NewFlagSet("operator", flag.ExitOnError)
+	fs.StringVar(&op.templatesPath, "templates", "", "Path to templates directory")
+	fs.StringVar(&op.artifactsDir, "artifacts-dir", ".", "Directory relative artifact references are resolved against")
+	op.limits = inputLimits{}
+	for _, name := range []string{"fw", "kernel", "initrd"} {
+		op.limits[name] = defaultInputLimits[name]
+	}
+	fs.Var(op.limits, "max-size", "Maximum size of an artifact as name=size, e.g. initrd=512M (can be repeated)")
+	fs.StringVar(&op.namespace, "namespace", "", "Namespace to watch (defaults to the namespace of the pod)")
+	fs.StringVar(&apiServer, "api-server", "", "Kubernetes API server URL (defaults to the in-cluster configuration)")
+	fs.StringVar(&token, "token", "", "Bearer token used with -api-server")
+	parseFlags(fs, args)
+
+	if op.templatesPath == "" {
+		fmt.Println("Error: templates path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if apiServer != "" {
+		op.client = internal.NewKubeClient(apiServer, token)
+	} else {
+		var err error
+		if op.client, err = internal.NewInClusterKubeClient(); err != nil {
+			fmt.Printf("Error creating Kubernetes client: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if op.namespace == "" {
+		op.namespace = internal.InClusterNamespace()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	op.run(ctx)
+}
+
+// resourcePath returns the API path of the TDXImage collection or of a single resource.
+func (op *operator) resourcePath(name string) string {
+	path := fmt.Sprintf("/apis/reproduce-mr.scrtlabs.com/v1alpha1/namespaces/%s/tdximages", op.namespace)
+	if name != "" {
+		path += "/" + name
+	}
+	return path
+}
+
+// run lists and watches TDXImage resources until ctx is canceled, starting over with a fresh list
+// whenever the watch ends.
+func (op *operator) run(ctx context.Context) {
+	log.Printf("watching TDXImage resources in namespace %s", op.namespace)
+	for ctx.Err() == nil {
+		items, resourceVersion, err := op.client.List(ctx, op.resourcePath(""))
+		if err != nil {
+			log.Printf("failed to list TDXImage resources: %v", err)
+			sleepContext(ctx, 5*time.Second)
+			continue
+		}
+		for _, item := range items {
+			op.reconcile(ctx, item)
+		}
+		err = op.client.Watch(ctx, op.resourcePath(""), resourceVersion, func(ev internal.KubeEvent) {
+			if ev.Type == "ADDED" || ev.Type == "MODIFIED" {
+				op.reconcile(ctx, ev.Object)
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Printf("watch ended: %v", err)
+			sleepContext(ctx, time.Second)
+		}
+	}
+}
+
+// reconcile measures the resource if its spec changed since it was last measured.
+func (op *operator) reconcile(ctx context.Context, obj internal.KubeObject) {
+	var current tdxImageStatus
+	_ = json.Unmarshal(obj.Status, &current)
+	if len(obj.Status) > 0 && current.ObservedGeneration == obj.Metadata.Generation {
+		return
+	}
+
+	status := tdxImageStatus{ObservedGeneration: obj.Metadata.Generation, MeasuredAt: time.Now().UTC().Format(time.RFC3339)}
+	var spec tdxImageSpec
+	if err := json.Unmarshal(obj.Spec, &spec); err != nil {
+		status.Error = fmt.Sprintf("malformed spec: %v", err)
+	} else if err = op.measure(&spec, &status); err != nil {
+		status.Error = err.Error()
+	}
+
+	if status.Error != "" {
+		log.Printf("%s: %s", obj.Metadata.Name, status.Error)
+	} else {
+		log.Printf("%s: measured generation %d, mrtd %s", obj.Metadata.Name, status.ObservedGeneration, status.MRTD)
+	}
+	if err := op.client.PatchStatus(ctx, op.resourcePath(obj.Metadata.Name), clearOmitted(status)); err != nil {
+		log.Printf("%s: failed to update status: %v", obj.Metadata.Name, err)
+	}
+
+	if spec.ConfigMap != "" && status.Error == "" {
+		data := map[string]string{
+			"mrtd":  status.MRTD,
+			"rtmr0": status.RTMR0,
+			"rtmr1": status.RTMR1,
+			"rtmr2": status.RTMR2,
+		}
+		if err := op.client.ApplyConfigMap(ctx, op.namespace, spec.ConfigMap, operatorFieldManager, data); err != nil {
+			log.Printf("%s: failed to update config map %s: %v", obj.Metadata.Name, spec.ConfigMap, err)
+		}
+	}
+}
+
+// measure computes the measurements described by the spec into the status.
+func (op *operator) measure(spec *tdxImageSpec, status *tdxImageStatus) error {
+	if spec.Firmware == "" || spec.Kernel == "" {
+		return fmt.Errorf("firmware and kernel are required")
+	}
+	memorySize := uint64(2048)
+	if spec.Memory != "" {
+		var err error
+		if memorySize, err = parseMemorySize(spec.Memory); err != nil {
+			return err
+		}
+	}
+	if spec.Cpus == 0 {
+		spec.Cpus = 1
+	}
+	if spec.Profile == "" {
+		spec.Profile = internal.DefaultProfile
+	}
+	profile, err := internal.LookupProfile(spec.Profile)
+	if err != nil {
+		return err
+	}
+
+	fwData, err := op.fetchArtifact("fw", spec.Firmware)
+	if err != nil {
+		return fmt.Errorf("failed to fetch firmware: %w", err)
+	}
+	kernelData, err := op.fetchArtifact("kernel", spec.Kernel)
+	if err != nil {
+		return fmt.Errorf("failed to fetch kernel: %w", err)
+	}
+	if err = internal.CheckKernelImage(kernelData); err != nil {
+		return fmt.Errorf("unsupported kernel image: %w", err)
+	}
+	var initrdData []byte
+	if spec.Initrd != "" {
+		if initrdData, err = op.fetchArtifact("initrd", spec.Initrd); err != nil {
+			return fmt.Errorf("failed to fetch initrd: %w", err)
+		}
+	}
+
+	measurements, err := internal.MeasureTdxQemu(internal.MeasureOptions{
+		Firmware: fwData, Kernel: kernelData, Initrd: initrdData, MemoryMB: memorySize, CPUs: uint32(spec.Cpus), Cmdline: spec.Cmdline,
+		TemplatesPath: op.templatesPath, TcbVersion: uint8(spec.TcbVersion), Profile: profile,
+	})
+	if err != nil {
+		return err
+	}
+	status.MRTD = fmt.Sprintf("%x", measurements.MRTD)
+	status.RTMR0 = fmt.Sprintf("%x", measurements.RTMR0)
+	status.RTMR1 = fmt.Sprintf("%x", measurements.RTMR1)
+	status.RTMR2 = fmt.Sprintf("%x", measurements.RTMR2)
+	status.Warnings = measurements.Warnings
+	return nil
+}
+
+// fetchArtifact reads the named artifact given as an http(s) URL or as a path relative to the
+// artifacts directory, failing if it exceeds its size limit.
+func (op *operator) fetchArtifact(name, ref string) ([]byte, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		client := &http.Client{Timeout: 10 * time.Minute}
+		resp, err := client.Get(ref)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		// References must not escape the artifacts directory.
+		f, err := os.Open(filepath.Join(op.artifactsDir, filepath.Clean("/"+ref)))
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return readPart(r, int64(op.limits[name])*1024*1024)
+}
+
+// clearOmitted returns the JSON object of a status for a merge patch. Fields that are left out
+// because they are empty are set to null, so that values of an earlier measurement, such as a stale
+// error or stale digests, are removed rather than kept, as are the retired status fields.
+func clearOmitted(status tdxImageStatus) map[string]any {
+	data, _ := json.Marshal(status)
+	var patch map[string]any
+	_ = json.Unmarshal(data, &patch)
+	for _, name := range retiredStatusFields {
+		patch[name] = nil
+	}
+	t := reflect.TypeOf(status)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if _, ok := patch[name]; !ok {
+			patch[name] = nil
+		}
+	}
+	return patch
+}
+
+// sleepContext sleeps for the given duration or until ctx is canceled.
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
@@ -0,0 +1,820 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+const defaultMrKeyProvider = "0x0000000000000000000000000000000000000000000000000000000000000000"
+
+// measureOptions holds the flags shared by all commands that compute measurements.
+type measureOptions struct {
+	fwPath            string
+	kernelPath        string
+	kernelDir         string
+	initrdPath        string
+	rootfsPath        string
+	dockerComposePath string
+	dockerFilesPath   string
+	memorySize        memoryValue
+	cpuCountUint      uint
+	tcbver            uint
+	kernelCmdline     string
+	mrKeyProvider     string
+	templatesPath     string
+	templatesURL      string
+	templatesRelease  string
+	templatesKey      string
+	templatesCache    string
+	force             bool
+	profileName       string
+	metadataPath      string
+	vmManifestPath    string
+	imagesDir         string
+	fwCfgFiles        stringList
+	fwCfgMeasure      stringList
+	initrdMicrocode   string
+	initrdMode        string
+	dumpDir           string
+	noKernelPatch     bool
+	kernelPrepatched  bool
+	initrdSizeAlign   uint
+	initrdDelivery    string
+	paravisorPath     string
+	paravisorLogPath  string
+	profilePacks      stringList
+	profilePackKeys   stringList
+	memorySlots       uint
+	maxMemory         memoryValue
+	eventOverrides    stringList
+	tdHobMap          string
+	cpuModel          string
+	cpuFlags          string
+	dstackVersion     string
+	inputsManifest    string
+	writeManifest     string
+	logLevel          string
+	// only lists the registers to compute, comma separated. All registers are computed if empty.
+	only string
+}
+
+// register defines the measurement flags on the given flag set.
+func (o *measureOptions) register(fs *flag.FlagSet) {
+	o.memorySize = 2048 // 2G default (in MB)
+
+	fs.StringVar(&o.fwPath, "fw", "", "Path to firmware file")
+	fs.StringVar(&o.kernelPath, "kernel", "", "Path to kernel file")
+	fs.StringVar(&o.initrdPath, "initrd", "", "Path to initrd file")
+	fs.StringVar(&o.rootfsPath, "rootfs", "", "Path to rootfs file")
+	fs.StringVar(&o.dockerComposePath, "dockercompose", "", "Path to docker compose file")
+	fs.StringVar(&o.dockerFilesPath, "dockerfiles", "", "Path to docker files file")
+	fs.Var(&o.memorySize, "memory", "Memory size (e.g., 512M, 1G, 2G)")
+	fs.UintVar(&o.memorySlots, "memory-slots", 0, "Number of memory hotplug slots (QEMU -m slots=N), requires -maxmem")
+	fs.Var(&o.maxMemory, "maxmem", "Maximum memory size with memory hotplug (QEMU -m maxmem=X), requires -memory-slots")
+	fs.UintVar(&o.tcbver, "tcbver", 0, "TCB version (currently only 6 and 7 are supported)")
+	fs.UintVar(&o.cpuCountUint, "cpu", 1, "Number of CPUs")
+	fs.StringVar(&o.kernelCmdline, "cmdline", "", "Kernel command line")
+	fs.StringVar(&o.mrKeyProvider, "mrkp", defaultMrKeyProvider, "Measurement of key provider")
+	fs.StringVar(&o.templatesPath, "templates", "", "Path to templates directory")
+	fs.StringVar(&o.templatesURL, "templates-url", "", "Base URL of a versioned template set to download templates from")
+	fs.StringVar(&o.templatesRelease, "templates-release", "", "Release of the template set at -templates-url, e.g. qemu-8.2, which the template signatures must be bound to")
+	fs.StringVar(&o.templatesKey, "templates-key", "", "Hex-encoded Ed25519 public key used to verify downloaded templates")
+	fs.StringVar(&o.templatesCache, "templates-cache", "", "Directory to cache downloaded templates in (defaults to the user cache directory)")
+	fs.StringVar(&o.profileName, "profile", internal.DefaultProfile, "Name of the QEMU/firmware profile to measure for")
+	fs.Var(&o.profilePacks, "profile-pack", "Path to a signed profile pack (tar archive with a detached <pack>.sig signature) providing additional profiles (can be repeated)")
+	fs.Var(&o.profilePackKeys, "profile-pack-key", "Key trusted to sign profile packs: hex-encoded Ed25519 public key or path to a PEM Ed25519/ECDSA public key (can be repeated)")
+	fs.BoolVar(&o.force, "force", false, "Measure the kernel even if its format is not supported")
+	fs.StringVar(&o.metadataPath, "metadata", "", "Path to dstack image metadata (metadata.json) providing firmware, kernel, initrd and cmdline")
+	fs.StringVar(&o.dstackVersion, "dstack-version", "", "dstack version of the image, used when the image metadata does not declare one")
+	fs.StringVar(&o.vmManifestPath, "vm-manifest", "", "Path to a dstack-vmm VM manifest (vm-manifest.json) providing image, CPUs and memory")
+	fs.StringVar(&o.imagesDir, "images-dir", "", "Path to the dstack images directory used to resolve the VM manifest image")
+	fs.StringVar(&o.initrdMicrocode, "initrd-microcode", "", "Path to an early microcode CPIO archive loaded in front of the initrd")
+	fs.StringVar(&o.initrdMode, "initrd-mode", internal.InitrdModeConcat, "Measured initrd: concat (early CPIO and main archive) or main (main archive only)")
+	fs.Var(&o.fwCfgFiles, "fw-cfg", "Contents of a fw_cfg file as name=path (can be repeated)")
+	fs.Var(&o.eventOverrides, "event-override", "Digest of an RTMR0 event as id=hex, replacing the modeled digest (can be repeated)")
+	fs.StringVar(&o.tdHobMap, "td-hob-map", "", "Path to a JSON list of TD HOB resource descriptors replacing the memory map QEMU describes for -memory")
+	fs.StringVar(&o.cpuModel, "cpu-model", "", "QEMU -cpu argument of the TD (e.g. SapphireRapids,-amx-tile) to predict its XFAM from")
+	fs.StringVar(&o.cpuFlags, "cpu-flags", "", "CPU flags of the host as in /proc/cpuinfo, or @<path> to read them from a cpuinfo file, for -cpu-model host or max")
+	fs.Var(&o.fwCfgMeasure, "fw-cfg-measure", "Name of an additional fw_cfg file measured into RTMR0 before BootOrder (can be repeated)")
+	fs.BoolVar(&o.noKernelPatch, "no-kernel-patch", false, "Measure the kernel image without applying the boot header modifications made by QEMU")
+	fs.BoolVar(&o.kernelPrepatched, "kernel-prepatched", false, "Measure the kernel image as is, its boot header was already patched by the boot loader")
+	fs.StringVar(&o.initrdDelivery, "initrd-delivery", "", "How the initrd reaches the kernel: fw-cfg (QEMU -initrd), cmdline (initrd= loaded by the EFI stub) or uki (embedded in the kernel image); the profile setting is used when empty")
+	fs.StringVar(&o.paravisorPath, "paravisor", "", "Path to the IGVM file of the L1 paravisor of a partitioned TD; the other inputs then describe the L2 guest")
+	fs.StringVar(&o.paravisorLogPath, "paravisor-log", "", "Path to the event log of the L1 paravisor, whose events precede those of the L2 guest (with -paravisor)")
+	fs.UintVar(&o.initrdSizeAlign, "initrd-size-align", 0, "Round the initrd size written into the kernel boot header up to this alignment in bytes (0 uses the profile setting)")
+	fs.StringVar(&o.dumpDir, "dump-intermediate", "", "Directory to write every synthesized structure that is measured into (for debugging)")
+	fs.StringVar(&o.inputsManifest, "inputs-manifest", "", "Path to an inputs manifest providing the artifacts and parameters, failing if any artifact changed since it was written")
+	fs.StringVar(&o.writeManifest, "write-inputs-manifest", "", "Path to write an inputs manifest pinning the artifacts and parameters of the measurement to")
+	fs.StringVar(&o.logLevel, "log-level", "info", "Level of the diagnostics logged to stderr: debug (every emulated RTMR extension), info, warn or error")
+}
+
+// repeatableFlags are the measurement flags that can be given several times. Inputs manifests hold
+// their values comma separated.
+var repeatableFlags = map[string]bool{"fw-cfg-measure": true, "event-override": true, "profile-pack": true, "profile-pack-key": true}
+
+// applyInputsManifest sets the flags that were not given explicitly to the artifact paths and
+// parameters of an inputs manifest.
+func applyInputsManifest(fs *flag.FlagSet, manifest *internal.InputsManifest) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	values := map[string]string{"profile": manifest.Profile}
+	for name, value := range manifest.Parameters {
+		values[name] = value
+	}
+	for _, a := range manifest.Artifacts {
+		if name, ok := strings.CutPrefix(a.Name, "fw-cfg:"); ok {
+			if !explicit["fw-cfg"] {
+				if err := fs.Set("fw-cfg", name+"="+a.Path); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		values[a.Name] = a.Path
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag '%s'", name)
+		}
+		if explicit[name] || values[name] == "" {
+			continue
+		}
+		settings := []string{values[name]}
+		if repeatableFlags[name] {
+			settings = strings.Split(values[name], ",")
+		}
+		for _, v := range settings {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("flag '%s': %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// measureJob holds all inputs of a measurement after flags, manifests and metadata were resolved
+// and the artifacts were read.
+type measureJob struct {
+	opts *measureOptions
+
+	fwData            []byte
+	kernelData        []byte
+	initrdData        []byte
+	rootfsData        []byte
+	dockerComposeData []byte
+	dockerFilesData   []byte
+	fwCfgData         map[string][]byte
+	profile           *internal.Profile
+	// hotplug is the memory hotplug configuration, nil without memory hotplug.
+	hotplug *internal.MemoryHotplug
+	// paravisorData and paravisorEvents are the IGVM file and event log of the L1 paravisor of a
+	// partitioned TD, nil for TDs booted directly.
+	paravisorData   []byte
+	paravisorEvents []internal.LogEvent
+
+	// mrKeyProvider is the resolved key provider measurement.
+	mrKeyProvider string
+	// initrdLayout describes the measured initrd layout.
+	initrdLayout string
+	// warnings are the warnings raised while preparing the inputs.
+	warnings []internal.Warning
+	// profileDecisions trace how the profile was selected.
+	profileDecisions []profileDecision
+	// logger receives the diagnostics of the measurement engine.
+	logger *slog.Logger
+	// artifacts are the files read for the measurement.
+	artifacts []internal.InputArtifact
+	// xfam is the XFAM predicted for -cpu-model, nil without it.
+	xfam *internal.XfamPrediction
+}
+
+// profileDecision is a step of the profile selection: what was detected and what it implied.
+type profileDecision struct {
+	// Source is where the detected information comes from, e.g. flag, inputs-manifest,
+	// image-metadata, dstack-version or tcb-version.
+	Source   string `json:"source"`
+	Detected string `json:"detected"`
+	Implied  string `json:"implied"`
+}
+
+// decide records a step of the profile selection.
+func (j *measureJob) decide(source, detected, implied string, args ...any) {
+	j.profileDecisions = append(j.profileDecisions, profileDecision{Source: source, Detected: detected, Implied: fmt.Sprintf(implied, args...)})
+}
+
+// printProfileDecisions prints the profile decision trace in text output.
+func printProfileDecisions(decisions []profileDecision) {
+	for _, d := range decisions {
+		fmt.Printf("PROFILE: %s: %s, %s\n", d.Source, d.Detected, d.Implied)
+	}
+}
+
+// readArtifact reads a file given with the named flag and records it as an artifact of the job.
+func (j *measureJob) readArtifact(name, path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	j.artifacts = append(j.artifacts, internal.NewInputArtifact(name, path, data))
+	return data, nil
+}
+
+// inputsManifest returns the inputs manifest pinning the artifacts and resolved parameters of the
+// job.
+func (j *measureJob) inputsManifest() *internal.InputsManifest {
+	o := j.opts
+	params := map[string]string{
+		"memory":      o.memorySize.String(),
+		"cpu":         strconv.FormatUint(uint64(o.cpuCountUint), 10),
+		"tcbver":      strconv.FormatUint(uint64(o.tcbver), 10),
+		"cmdline":     o.kernelCmdline,
+		"mrkp":        o.mrKeyProvider,
+		"initrd-mode": o.initrdMode,
+	}
+	optional := map[string]string{
+		"templates":         o.templatesPath,
+		"only":              o.only,
+		"fw-cfg-measure":    strings.Join(o.fwCfgMeasure, ","),
+		"event-override":    strings.Join(o.eventOverrides, ","),
+		"profile-pack":      strings.Join(o.profilePacks, ","),
+		"profile-pack-key":  strings.Join(o.profilePackKeys, ","),
+		"force":             strconv.FormatBool(o.force),
+		"no-kernel-patch":   strconv.FormatBool(o.noKernelPatch),
+		"kernel-prepatched": strconv.FormatBool(o.kernelPrepatched),
+		"initrd-size-align": strconv.FormatUint(uint64(o.initrdSizeAlign), 10),
+		"initrd-delivery":   o.initrdDelivery,
+		"cpu-model":         o.cpuModel,
+		"cpu-flags":         o.cpuFlags,
+		"memory-slots":      strconv.FormatUint(uint64(o.memorySlots), 10),
+	}
+	if o.maxMemory != 0 {
+		optional["maxmem"] = o.maxMemory.String()
+	}
+	for name, value := range optional {
+		if value != "" && value != "0" && value != "false" {
+			params[name] = value
+		}
+	}
+	return &internal.InputsManifest{
+		ToolVersion: internal.ToolVersion(),
+		Profile:     j.profile.Name,
+		Parameters:  params,
+		Artifacts:   j.artifacts,
+	}
+}
+
+// prepare resolves the parsed measurement flags into a measurement job, exiting on errors.
+func (o *measureOptions) prepare(fs *flag.FlagSet) *measureJob {
+	job := &measureJob{opts: o}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(o.logLevel)); err != nil {
+		fmt.Printf("Error: invalid log level '%s'\n", o.logLevel)
+		os.Exit(1)
+	}
+	job.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+	explicitProfile := false
+	fs.Visit(func(f *flag.Flag) { explicitProfile = explicitProfile || f.Name == "profile" })
+	if explicitProfile {
+		job.decide("flag", "-profile "+o.profileName, "selected profile '%s'", o.profileName)
+	}
+
+	var manifest *internal.InputsManifest
+	if o.inputsManifest != "" {
+		var err error
+		if manifest, err = internal.LoadInputsManifest(o.inputsManifest); err != nil {
+			fmt.Printf("Error reading inputs manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if err = applyInputsManifest(fs, manifest); err != nil {
+			fmt.Printf("Error applying inputs manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if !explicitProfile && manifest.Profile != "" {
+			job.decide("inputs-manifest", "manifest pins profile "+manifest.Profile, "selected profile '%s'", manifest.Profile)
+		}
+		if manifest.ToolVersion != internal.ToolVersion() {
+			job.warnings = append(job.warnings, internal.Warning{
+				Code:    internal.WarningToolVersion,
+				Message: fmt.Sprintf("inputs manifest was written by version %s of the tool, this is %s", manifest.ToolVersion, internal.ToolVersion()),
+			})
+		}
+	}
+	job.mrKeyProvider = o.mrKeyProvider
+
+	// Explicitly set flags take precedence over values from manifests and metadata.
+	setFlags := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+
+	if o.vmManifestPath != "" {
+		manifest, err := internal.LoadVmManifest(o.vmManifestPath)
+		if err != nil {
+			fmt.Printf("Error reading VM manifest: %v\n", err)
+			os.Exit(1)
+		}
+		if !setFlags["cpu"] {
+			o.cpuCountUint = uint(manifest.Vcpu)
+		}
+		if !setFlags["memory"] {
+			o.memorySize = memoryValue(manifest.Memory)
+		}
+		// The VM manifest takes precedence over the configuration hints of the image metadata.
+		setFlags["cpu"], setFlags["memory"] = true, true
+		if o.metadataPath == "" {
+			if o.imagesDir == "" {
+				fmt.Println("Error: images directory is required to resolve the VM manifest image")
+				os.Exit(1)
+			}
+			o.metadataPath = internal.FindImageMetadata(filepath.Join(o.imagesDir, manifest.Image))
+		}
+		if manifest.HasGpus() {
+			job.warnings = append(job.warnings, internal.Warning{
+				Code:    internal.WarningUnsupportedConfig,
+				Message: "VM manifest requests GPU passthrough which changes ACPI tables that are not modeled",
+			})
+		}
+	}
+
+	dstackVersion := o.dstackVersion
+	if o.metadataPath != "" {
+		metadata, err := internal.LoadImageMetadata(o.metadataPath)
+		if err != nil {
+			fmt.Printf("Error reading image metadata: %v\n", err)
+			os.Exit(1)
+		}
+		imageDir := filepath.Dir(o.metadataPath)
+		if o.fwPath == "" {
+			o.fwPath = filepath.Join(imageDir, metadata.Bios)
+		}
+		if o.kernelPath == "" {
+			o.kernelPath = filepath.Join(imageDir, metadata.Kernel)
+		}
+		if o.initrdPath == "" && metadata.Initrd != "" {
+			o.initrdPath = filepath.Join(imageDir, metadata.Initrd)
+		}
+		if o.rootfsPath == "" && metadata.Rootfs != "" {
+			o.rootfsPath = filepath.Join(imageDir, metadata.Rootfs)
+		}
+		if !setFlags["cmdline"] {
+			o.kernelCmdline = metadata.FullCmdline()
+		}
+		if hints := metadata.VmConfig; hints != nil {
+			if !setFlags["cpu"] && hints.Vcpu != 0 {
+				o.cpuCountUint = uint(hints.Vcpu)
+			}
+			if !setFlags["memory"] && hints.Memory != 0 {
+				o.memorySize = memoryValue(hints.Memory)
+			}
+			if !setFlags["tcbver"] && hints.TcbVersion != 0 {
+				o.tcbver = hints.TcbVersion
+			}
+			if hints.Profile != "" {
+				if !setFlags["profile"] {
+					o.profileName = hints.Profile
+					job.decide("image-metadata", "vm_config recommends profile "+hints.Profile, "selected profile '%s'", hints.Profile)
+				} else {
+					job.decide("image-metadata", "vm_config recommends profile "+hints.Profile, "ignored, profile '%s' was given explicitly", o.profileName)
+				}
+			}
+		}
+		if metadata.Version != "" {
+			dstackVersion = metadata.Version
+		}
+	}
+
+	// If the mrKeyProvider is in the knownKeyProviders, replace it with the value
+	if knownKeyProvider, ok := knownKeyProviders[job.mrKeyProvider]; ok {
+		job.mrKeyProvider = knownKeyProvider
+	}
+
+	if o.cpuCountUint > math.MaxUint32 {
+		fmt.Printf("Error: CPU count %d is too large\n", o.cpuCountUint)
+		os.Exit(1)
+	}
+
+	if len(o.profilePacks) > 0 {
+		if len(o.profilePackKeys) == 0 {
+			fmt.Println("Error: -profile-pack-key is required with profile packs")
+			os.Exit(1)
+		}
+		anchors, err := internal.ParseTrustAnchors(o.profilePackKeys)
+		if err != nil {
+			fmt.Printf("Error: invalid profile pack key: %v\n", err)
+			os.Exit(1)
+		}
+		cacheDir, err := internal.DefaultProfilePacksCacheDir()
+		if err != nil {
+			fmt.Printf("Error determining profile pack cache directory: %v\n", err)
+			os.Exit(1)
+		}
+		for _, pack := range o.profilePacks {
+			if _, err = internal.LoadProfilePack(pack, anchors, cacheDir); err != nil {
+				fmt.Printf("Error loading profile pack: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if len(job.profileDecisions) == 0 {
+		job.decide("default", "no profile given", "selected the default profile '%s'", o.profileName)
+	}
+	profile, err := internal.LookupProfile(o.profileName)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Cross-check the dstack version declared by the image against the profile. Unless a profile
+	// was selected explicitly, switch to the one matching the image.
+	supported, known := profile.SupportsDstackVersion(dstackVersion)
+	switch {
+	case known && supported:
+		job.decide("dstack-version", "dstack "+dstackVersion, "profile '%s' applies", profile.Name)
+	case known:
+		if match, err := internal.ProfileForDstackVersion(dstackVersion); err == nil && !setFlags["profile"] {
+			job.warnings = append(job.warnings, internal.Warning{
+				Code:    internal.WarningProfileMismatch,
+				Message: fmt.Sprintf("profile '%s' does not apply to dstack %s images, using profile '%s' instead", profile.Name, dstackVersion, match.Name),
+			})
+			job.decide("dstack-version", "dstack "+dstackVersion, "switched from profile '%s', which does not apply, to '%s'", profile.Name, match.Name)
+			profile = match
+		} else {
+			job.warnings = append(job.warnings, internal.Warning{
+				Code:    internal.WarningProfileMismatch,
+				Message: fmt.Sprintf("profile '%s' does not apply to dstack %s images", profile.Name, dstackVersion),
+			})
+			job.decide("dstack-version", "dstack "+dstackVersion, "kept profile '%s', which does not apply", profile.Name)
+		}
+	}
+	if len(profile.TcbVersions) > 0 && o.computes("MRTD") {
+		if bytes.Contains(profile.TcbVersions, []byte{uint8(o.tcbver)}) {
+			job.decide("tcb-version", fmt.Sprintf("TCB version %d", o.tcbver), "profile '%s' applies", profile.Name)
+		} else {
+			job.decide("tcb-version", fmt.Sprintf("TCB version %d", o.tcbver), "profile '%s' only applies to TCB versions %v", profile.Name, profile.TcbVersions)
+		}
+	}
+
+	// The firmware and templates are only needed for MRTD and RTMR0, the kernel only for RTMR1.
+	needsTemplates := o.computes("RTMR0")
+	needsFirmware := o.computes("MRTD") || needsTemplates
+	needsKernel := o.computes("RTMR1")
+
+	if o.templatesPath == "" && o.templatesURL == "" {
+		o.templatesPath = profile.TemplatesPath
+	}
+	if needsTemplates && o.templatesPath == "" && o.templatesURL == "" {
+		fmt.Println("Error: templates path or templates URL is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if (needsFirmware && o.fwPath == "") || (needsKernel && o.kernelPath == "" && o.kernelDir == "") {
+		fmt.Println("Error: firmware and kernel paths are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	if o.only != "" && o.kernelDir != "" {
+		fmt.Println("Error: -only cannot be combined with -kernel-dir, which reports aggregated values")
+		os.Exit(1)
+	}
+
+	if (o.inputsManifest != "" || o.writeManifest != "") && o.kernelDir != "" {
+		fmt.Println("Error: inputs manifests pin a single kernel and cannot be combined with -kernel-dir")
+		os.Exit(1)
+	}
+
+	if o.dumpDir != "" && o.kernelDir != "" {
+		fmt.Println("Error: intermediate structures can only be dumped when measuring a single kernel")
+		os.Exit(1)
+	}
+
+	var hotplug *internal.MemoryHotplug
+	if o.memorySlots != 0 || o.maxMemory != 0 {
+		if o.memorySlots == 0 || o.maxMemory == 0 || o.memorySlots > math.MaxUint32 {
+			fmt.Println("Error: memory hotplug requires both -memory-slots and -maxmem")
+			os.Exit(1)
+		}
+		hotplug = &internal.MemoryHotplug{Slots: uint32(o.memorySlots), MaxMemory: uint64(o.maxMemory)}
+	}
+
+	if needsTemplates && o.templatesURL != "" {
+		var pubKey []byte
+		pubKey, err = hex.DecodeString(strings.TrimPrefix(o.templatesKey, "0x"))
+		if err != nil || len(pubKey) != ed25519.PublicKeySize {
+			fmt.Println("Error: a valid hex-encoded Ed25519 templates key is required with templates URL")
+			os.Exit(1)
+		}
+		if o.templatesRelease == "" {
+			fmt.Println("Error: the template set release (-templates-release) is required with templates URL")
+			os.Exit(1)
+		}
+		if o.templatesCache == "" {
+			if o.templatesCache, err = internal.DefaultTemplatesCacheDir(); err != nil {
+				fmt.Printf("Error determining templates cache directory: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		o.templatesPath, err = internal.FetchTemplates(o.templatesURL, o.templatesRelease, o.templatesCache, pubKey, uint32(o.cpuCountUint), hotplug)
+		if err != nil {
+			fmt.Printf("Error fetching templates: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Read files
+	if needsFirmware {
+		job.fwData, err = job.readArtifact("fw", o.fwPath)
+		if err != nil {
+			fmt.Printf("Error reading firmware file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.kernelDir == "" && o.kernelPath != "" {
+		job.kernelData, err = job.readArtifact("kernel", o.kernelPath)
+		if err != nil {
+			fmt.Printf("Error reading kernel file: %v\n", err)
+			os.Exit(1)
+		}
+
+		if err := internal.CheckKernelImage(job.kernelData); err != nil {
+			if !o.force {
+				fmt.Printf("Error: unsupported kernel image: %v (use -force to measure anyway)\n", err)
+				os.Exit(1)
+			}
+			job.warnings = append(job.warnings, internal.Warning{
+				Code:    internal.WarningOverrideInEffect,
+				Message: fmt.Sprintf("measuring unsupported kernel image due to -force: %v", err),
+			})
+		}
+	}
+
+	if o.initrdPath != "" {
+		job.initrdData, err = job.readArtifact("initrd", o.initrdPath)
+		if err != nil {
+			fmt.Printf("Error reading initrd file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if job.initrdData != nil || o.initrdMicrocode != "" {
+		var microcodeData []byte
+		if o.initrdMicrocode != "" {
+			microcodeData, err = job.readArtifact("initrd-microcode", o.initrdMicrocode)
+			if err != nil {
+				fmt.Printf("Error reading initrd microcode file: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		job.initrdData, job.initrdLayout, err = internal.PrepareInitrd(microcodeData, job.initrdData, o.initrdMode)
+		if err != nil {
+			fmt.Printf("Error preparing initrd: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.rootfsPath != "" {
+		job.rootfsData, err = job.readArtifact("rootfs", o.rootfsPath)
+		if err != nil {
+			fmt.Printf("Error reading rootfs file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.dockerComposePath != "" {
+		job.dockerComposeData, err = job.readArtifact("dockercompose", o.dockerComposePath)
+		if err != nil {
+			fmt.Printf("Error reading docker compose file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if o.dockerFilesPath != "" {
+		job.dockerFilesData, err = job.readArtifact("dockerfiles", o.dockerFilesPath)
+		if err != nil {
+			fmt.Printf("Error reading docker files file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if o.paravisorLogPath != "" && o.paravisorPath == "" {
+		fmt.Println("Error: -paravisor-log requires -paravisor")
+		os.Exit(1)
+	}
+	if o.paravisorPath != "" {
+		job.paravisorData, err = job.readArtifact("paravisor", o.paravisorPath)
+		if err != nil {
+			fmt.Printf("Error reading paravisor file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if o.paravisorLogPath != "" {
+		data, err := job.readArtifact("paravisor-log", o.paravisorLogPath)
+		if err != nil {
+			fmt.Printf("Error reading paravisor event log: %v\n", err)
+			os.Exit(1)
+		}
+		if job.paravisorEvents, err = internal.ParseEventLog(data, internal.DetectEventLogFormat(data)); err != nil {
+			fmt.Printf("Error parsing paravisor event log: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	job.fwCfgData = make(map[string][]byte)
+	for _, item := range o.fwCfgFiles {
+		name, path, ok := strings.Cut(item, "=")
+		if !ok {
+			fmt.Printf("Error: malformed fw_cfg file '%s', expected name=path\n", item)
+			os.Exit(1)
+		}
+		job.fwCfgData[name], err = job.readArtifact("fw-cfg:"+name, path)
+		if err != nil {
+			fmt.Printf("Error reading fw_cfg file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	job.hotplug = hotplug
+	job.profile = o.customizeProfile(profile, hotplug)
+	if o.tdHobMap != "" {
+		data, err := job.readArtifact("td-hob-map", o.tdHobMap)
+		if err != nil {
+			fmt.Printf("Error reading TD HOB memory map: %v\n", err)
+			os.Exit(1)
+		}
+		resources, err := internal.ParseHobResources(data)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		job.profile = job.profile.WithHobResources(resources)
+	}
+	if o.cpuModel != "" {
+		if job.xfam, err = o.predictXfam(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if manifest != nil {
+		if err = manifest.Check(job.artifacts); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	if o.writeManifest != "" {
+		if err = job.inputsManifest().Write(o.writeManifest); err != nil {
+			fmt.Printf("Error writing inputs manifest: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	return job
+}
+
+// xfamHex returns the predicted XFAM hex-encoded in the byte order of the TD report, empty without
+// -cpu-model.
+func (j *measureJob) xfamHex() string {
+	if j.xfam == nil {
+		return ""
+	}
+	return hex.EncodeToString(j.xfam.Xfam)
+}
+
+// predictXfam predicts the XFAM for -cpu-model, reading the host CPU flags from a cpuinfo file if
+// -cpu-flags names one.
+func (o *measureOptions) predictXfam() (*internal.XfamPrediction, error) {
+	flags := o.cpuFlags
+	if path, ok := strings.CutPrefix(flags, "@"); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CPU flags: %w", err)
+		}
+		flags = cpuinfoFlags(string(data))
+	}
+	return internal.PredictXfam(o.cpuModel, flags)
+}
+
+// cpuinfoFlags returns the flags of the first CPU of /proc/cpuinfo.
+func cpuinfoFlags(cpuinfo string) string {
+	for _, line := range strings.Split(cpuinfo, "\n") {
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(name) == "flags" {
+			return value
+		}
+	}
+	return ""
+}
+
+// customizeProfile applies the adjustments to the profile selected with the measurement flags.
+func (o *measureOptions) customizeProfile(profile *internal.Profile, hotplug *internal.MemoryHotplug) *internal.Profile {
+	profile = profile.WithFwCfgEvents(o.fwCfgMeasure)
+	if hotplug != nil {
+		profile = profile.WithMemoryHotplug(hotplug)
+	}
+
+	if len(o.eventOverrides) > 0 {
+		overrides := make(map[string][]byte, len(o.eventOverrides))
+		for _, entry := range o.eventOverrides {
+			id, value, ok := strings.Cut(entry, "=")
+			digest, err := hex.DecodeString(value)
+			if !ok || err != nil {
+				fmt.Printf("Error: invalid event override '%s', expected id=hex\n", entry)
+				os.Exit(1)
+			}
+			overrides[id] = digest
+		}
+		profile = profile.WithEventOverrides(overrides)
+	}
+
+	if o.initrdSizeAlign != 0 {
+		if o.initrdSizeAlign&(o.initrdSizeAlign-1) != 0 || o.initrdSizeAlign > math.MaxUint32 {
+			fmt.Printf("Error: initrd size alignment %d is not a power of two\n", o.initrdSizeAlign)
+			os.Exit(1)
+		}
+		profile = profile.WithInitrdSizeAlignment(uint32(o.initrdSizeAlign))
+	}
+
+	if o.initrdDelivery != "" {
+		delivery, err := internal.ParseInitrdDelivery(o.initrdDelivery)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		profile = profile.WithInitrdDelivery(delivery)
+	}
+
+	switch {
+	case o.noKernelPatch && o.kernelPrepatched:
+		fmt.Println("Error: -no-kernel-patch and -kernel-prepatched are mutually exclusive")
+		os.Exit(1)
+	case o.noKernelPatch:
+		profile = profile.WithKernelPatch(internal.KernelPatchNone)
+	case o.kernelPrepatched:
+		profile = profile.WithKernelPatch(internal.KernelPatchPrepatched)
+	}
+	return profile
+}
+
+// registers returns the registers selected with -only, or nil if all registers are computed.
+func (o *measureOptions) registers() []string {
+	if o.only == "" {
+		return nil
+	}
+	return strings.Split(o.only, ",")
+}
+
+// computes reports whether the given register is computed.
+func (o *measureOptions) computes(register string) bool {
+	registers := o.registers()
+	if registers == nil {
+		return true
+	}
+	for _, r := range registers {
+		if strings.EqualFold(strings.TrimSpace(r), register) {
+			return true
+		}
+	}
+	return false
+}
+
+// measure computes the measurements of the job using the given kernel image.
+func (j *measureJob) measure(kernelData []byte) (*internal.TdxMeasurements, error) {
+	o := j.opts
+	measurements, err := internal.MeasureTdxQemu(internal.MeasureOptions{
+		Firmware: j.fwData, Kernel: kernelData, Initrd: j.initrdData, Rootfs: j.rootfsData, DockerCompose: j.dockerComposeData, DockerFiles: j.dockerFilesData,
+		MemoryMB: uint64(o.memorySize), CPUs: uint32(o.cpuCountUint), Cmdline: o.kernelCmdline, TemplatesPath: o.templatesPath,
+		TcbVersion: uint8(o.tcbver), Profile: j.profile, FwCfgFiles: j.fwCfgData, Logger: j.logger,
+	}, internal.WithRegisters(o.registers()...))
+	if err != nil {
+		return nil, err
+	}
+	if j.paravisorData != nil {
+		if measurements, err = internal.MeasureTdxPartitioned(j.paravisorData, j.paravisorEvents, measurements, j.profile); err != nil {
+			return nil, err
+		}
+	}
+	if o.dumpDir != "" {
+		if err = measurements.WriteIntermediates(o.dumpDir); err != nil {
+			return nil, err
+		}
+	}
+	return measurements, nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// quoteOutput is the JSON output of the parse-quote command. Register names match the output of
+// the measurement command so both can be compared directly.
+type quoteOutput struct {
+	// Format is the evidence format, tdx-quote, td-report or hcl-report.
+	Format         string `json:"format"`
+	Version        uint16 `json:"version,omitempty"`
+	TeeTcbSvn      string `json:"tee_tcb_svn"`
+	MrSeam         string `json:"mrseam"`
+	MrSignerSeam   string `json:"mrsignerseam"`
+	SeamAttributes string `json:"seam_attributes"`
+	TdAttributes   string `json:"td_attributes"`
+	Debug          bool   `json:"debug"`
+	Xfam           string `json:"xfam"`
+	MRTD           string `json:"mrtd"`
+	MrConfigId     string `json:"mrconfigid"`
+	MrOwner        string `json:"mrowner"`
+	MrOwnerConfig  string `json:"mrownerconfig"`
+	RTMR0          string `json:"rtmr0"`
+	RTMR1          string `json:"rtmr1"`
+	RTMR2          string `json:"rtmr2"`
+	RTMR3          string `json:"rtmr3"`
+	ReportData     string `json:"report_data"`
+	TeeTcbSvn2     string `json:"tee_tcb_svn2,omitempty"`
+	MrServiceTd    string `json:"mrservicetd,omitempty"`
+	// Features are the TD features decoded from the TD attributes and MRSERVICETD.
+	Features internal.TdFeatures `json:"features"`
+	// RuntimeClaims are the runtime claims of an HCL report.
+	RuntimeClaims json.RawMessage `json:"runtime_claims,omitempty"`
+}
+
+// readQuote reads a quote, TD report or HCL report from a file containing either the binary
+// evidence or its hex or base64 encoding.
+func readQuote(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.Join(strings.Fields(string(data)), "")
+	if decoded, err := hex.DecodeString(strings.TrimPrefix(text, "0x")); err == nil {
+		return decoded, nil
+	}
+	// Attestation APIs often return quotes base64-encoded.
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil && len(decoded) > 0 {
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// runParseQuote implements the parse-quote command.
+func runParseQuote(args []string) {
+	var (
+		quotePath  string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("parse-quote", flag.ExitOnError)
+	fs.StringVar(&quotePath, "quote", "", "Path to a TDX quote, TD report or Hyper-V HCL report (binary, hex or base64)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if quotePath == "" {
+		fmt.Println("Error: quote path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	quote, err := readQuote(quotePath)
+	if err != nil {
+		fmt.Printf("Error reading quote: %v\n", err)
+		os.Exit(1)
+	}
+	evidence, err := internal.ParseEvidence(quote)
+	if err != nil {
+		fmt.Printf("Error parsing quote: %v\n", err)
+		os.Exit(1)
+	}
+	report := evidence.Report
+
+	output := quoteOutput{
+		Format:         evidence.Format,
+		Version:        report.QuoteVersion,
+		TeeTcbSvn:      hex.EncodeToString(report.TeeTcbSvn),
+		MrSeam:         hex.EncodeToString(report.MrSeam),
+		MrSignerSeam:   hex.EncodeToString(report.MrSignerSeam),
+		SeamAttributes: hex.EncodeToString(report.SeamAttributes),
+		TdAttributes:   hex.EncodeToString(report.TdAttributes),
+		Debug:          report.Debug(),
+		Xfam:           hex.EncodeToString(report.Xfam),
+		MRTD:           hex.EncodeToString(report.MRTD),
+		MrConfigId:     hex.EncodeToString(report.MrConfigId),
+		MrOwner:        hex.EncodeToString(report.MrOwner),
+		MrOwnerConfig:  hex.EncodeToString(report.MrOwnerConfig),
+		RTMR0:          hex.EncodeToString(report.RTMR0),
+		RTMR1:          hex.EncodeToString(report.RTMR1),
+		RTMR2:          hex.EncodeToString(report.RTMR2),
+		RTMR3:          hex.EncodeToString(report.RTMR3),
+		ReportData:     hex.EncodeToString(report.ReportData),
+		TeeTcbSvn2:     hex.EncodeToString(report.TeeTcbSvn2),
+		MrServiceTd:    hex.EncodeToString(report.MrServiceTd),
+		Features:       report.Features(),
+		RuntimeClaims:  evidence.RuntimeClaims,
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+
+	fmt.Printf("FORMAT: %s\n", output.Format)
+	if output.Format == internal.EvidenceTdxQuote {
+		fmt.Printf("VERSION: %d\n", output.Version)
+	}
+	fmt.Printf("TEE_TCB_SVN: %s\n", output.TeeTcbSvn)
+	fmt.Printf("MRSEAM: %s\n", output.MrSeam)
+	fmt.Printf("MRSIGNERSEAM: %s\n", output.MrSignerSeam)
+	fmt.Printf("SEAM_ATTRIBUTES: %s\n", output.SeamAttributes)
+	fmt.Printf("TD_ATTRIBUTES: %s (debug: %t)\n", output.TdAttributes, output.Debug)
+	fmt.Printf("XFAM: %s\n", output.Xfam)
+	fmt.Printf("MRTD: %s\n", output.MRTD)
+	fmt.Printf("MRCONFIGID: %s\n", output.MrConfigId)
+	fmt.Printf("MROWNER: %s\n", output.MrOwner)
+	fmt.Printf("MROWNERCONFIG: %s\n", output.MrOwnerConfig)
+	fmt.Printf("RTMR0: %s\n", output.RTMR0)
+	fmt.Printf("RTMR1: %s\n", output.RTMR1)
+	fmt.Printf("RTMR2: %s\n", output.RTMR2)
+	fmt.Printf("RTMR3: %s\n", output.RTMR3)
+	fmt.Printf("REPORT_DATA: %s\n", output.ReportData)
+	if output.TeeTcbSvn2 != "" {
+		fmt.Printf("TEE_TCB_SVN2: %s\n", output.TeeTcbSvn2)
+		fmt.Printf("MRSERVICETD: %s\n", output.MrServiceTd)
+	}
+	f := output.Features
+	fmt.Printf("FEATURES: sept_ve_disable: %t, migratable: %t, pks: %t, kl: %t, perfmon: %t, service_td_bound: %t\n",
+		f.SeptVeDisable, f.Migratable, f.Pks, f.Kl, f.Perfmon, f.ServiceTdBound)
+	if f.UnknownAttributes != 0 {
+		fmt.Printf("UNKNOWN_TD_ATTRIBUTES: 0x%x\n", f.UnknownAttributes)
+	}
+	if output.RuntimeClaims != nil {
+		fmt.Printf("RUNTIME_CLAIMS: %s\n", output.RuntimeClaims)
+	}
+}
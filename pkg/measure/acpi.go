@@ -0,0 +1,41 @@
+package measure
+
+import (
+	"context"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// AcpiTables are the ACPI tables QEMU passes to the firmware through fw_cfg, as measured into
+// RTMR0.
+type AcpiTables struct {
+	// Tables is the contents of the etc/acpi/tables fw_cfg file.
+	Tables []byte
+	// Rsdp is the contents of the etc/acpi/rsdp fw_cfg file.
+	Rsdp []byte
+	// Loader is the contents of the etc/table-loader fw_cfg file.
+	Loader []byte
+}
+
+// GenerateAcpiTables generates the ACPI tables QEMU builds for a guest with the given memory size
+// and CPU count, from the templates and profile of the Measurer.
+func (m *Measurer) GenerateAcpiTables(memoryMB uint64, cpus uint32) (*AcpiTables, error) {
+	return m.GenerateAcpiTablesContext(context.Background(), memoryMB, cpus)
+}
+
+// GenerateAcpiTablesContext is like GenerateAcpiTables but stops when the context is done.
+func (m *Measurer) GenerateAcpiTablesContext(ctx context.Context, memoryMB uint64, cpus uint32) (*AcpiTables, error) {
+	tables, rsdp, loader, err := internal.GenerateTablesQemuContext(ctx, m.slog, m.templatesPath(), memoryMB, cpus, m.profile)
+	if err != nil {
+		return nil, err
+	}
+	return &AcpiTables{Tables: tables, Rsdp: rsdp, Loader: loader}, nil
+}
+
+// TemplateCombination is a guest configuration an ACPI table template covers.
+type TemplateCombination = internal.TemplateCombination
+
+// Templates lists the guest configurations the ACPI table templates of the Measurer cover.
+func (m *Measurer) Templates() ([]TemplateCombination, error) {
+	return internal.ListTemplates(m.templatesPath())
+}
@@ -0,0 +1,172 @@
+package measure
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// ClaimStatus is the outcome of appraising a single claim of a quote.
+type ClaimStatus string
+
+const (
+	// ClaimMatched means the quoted value equals the reference value.
+	ClaimMatched ClaimStatus = "matched"
+	// ClaimMismatched means the quoted value differs from the reference value.
+	ClaimMismatched ClaimStatus = "mismatched"
+	// ClaimNotEvaluated means the claim was not compared, because the policy does not select it
+	// or no reference value was given for it.
+	ClaimNotEvaluated ClaimStatus = "not-evaluated"
+)
+
+// ClaimDebug is the claim that the quote was not produced by a debuggable TD.
+const ClaimDebug = "debug"
+
+// ReferenceValues are the expected hex-encoded values of the claims of a quote, keyed by claim
+// name: the registers and composites by their output names (mrtd, rtmr0-3, mr_image), and the
+// identity fields mr_config_id, mr_owner, mr_owner_config, mr_seam, mr_signer_seam,
+// td_attributes, xfam and report_data.
+type ReferenceValues map[string]string
+
+// ReferenceValuesOf returns the reference values of computed measurements, i.e. the registers that
+// were computed and mr_image if all registers it covers were.
+func ReferenceValuesOf(m *Measurements) ReferenceValues {
+	ref := ReferenceValues(internal.RegisterValues(m))
+	if m.MRTD != nil && m.RTMR1 != nil && m.RTMR2 != nil && m.RTMR3 != nil {
+		ref["mr_image"] = m.CalculateMrImage()
+	}
+	return ref
+}
+
+// Policy selects the claims of a quote that are appraised.
+type Policy struct {
+	// Required are the claims that must match for the appraisal to pass. DefaultRegisters are
+	// required when it is empty.
+	Required []string
+	// Optional are the claims that are compared if a reference value is given, without affecting
+	// the outcome of the appraisal.
+	Optional []string
+	// AllowDebug accepts quotes of debuggable TDs, which fail the appraisal otherwise.
+	AllowDebug bool
+}
+
+// ClaimResult is the appraisal of a single claim.
+type ClaimResult struct {
+	Claim    string      `json:"claim"`
+	Status   ClaimStatus `json:"status"`
+	Required bool        `json:"required"`
+	Expected string      `json:"expected,omitempty"`
+	Actual   string      `json:"actual"`
+	// Reason explains why a claim was not evaluated.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Appraisal is the result of verifying a quote against reference values.
+type Appraisal struct {
+	// Passed is set when all required claims matched.
+	Passed bool          `json:"passed"`
+	Claims []ClaimResult `json:"claims"`
+	// Warnings always include a quote-not-verified warning, as the signature of the quote is not
+	// verified.
+	Warnings []Warning `json:"warnings"`
+}
+
+// Claim returns the result of the named claim, nil if it is not part of the appraisal.
+func (a *Appraisal) Claim(name string) *ClaimResult {
+	for i := range a.Claims {
+		if a.Claims[i].Claim == strings.ToLower(name) {
+			return &a.Claims[i]
+		}
+	}
+	return nil
+}
+
+// Verify appraises the claims of a TDX quote, TD report or Hyper-V HCL report against reference
+// values according to the policy.
+// Claims are reported in the order of the required and optional claims of the policy, followed by
+// the other claims with a reference value and the debug claim. The quote signature is not
+// verified, which the appraisal reports with a quote-not-verified warning; the quote must be
+// verified with DCAP separately before the appraisal can be trusted.
+func Verify(quote []byte, ref ReferenceValues, policy Policy) (*Appraisal, error) {
+	evidence, err := internal.ParseEvidence(quote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quote: %w", err)
+	}
+	report := evidence.Report
+	claims := internal.ReportClaims(report)
+
+	required := policy.Required
+	if len(required) == 0 {
+		required = DefaultRegisters
+	}
+	var names []string
+	selected := make(map[string]bool)
+	requiredSet := make(map[string]bool)
+	for i, name := range append(append([]string{}, required...), policy.Optional...) {
+		name = strings.ToLower(name)
+		if _, ok := claims[name]; !ok {
+			return nil, fmt.Errorf("unknown claim '%s' in policy", name)
+		}
+		if !selected[name] {
+			names = append(names, name)
+			selected[name] = true
+		}
+		if i < len(required) {
+			requiredSet[name] = true
+		}
+	}
+	expected := make(map[string][]byte, len(ref))
+	var unselected []string
+	for name, value := range ref {
+		name = strings.ToLower(name)
+		if _, ok := claims[name]; !ok {
+			return nil, fmt.Errorf("unknown claim '%s' in reference values", name)
+		}
+		v, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid reference value of claim '%s': %w", name, err)
+		}
+		expected[name] = v
+		if !selected[name] {
+			unselected = append(unselected, name)
+		}
+	}
+	sort.Strings(unselected)
+
+	a := &Appraisal{Passed: true, Warnings: []Warning{{Code: internal.WarningQuoteNotVerified, Message: fmt.Sprintf("the signature of the %s evidence was not verified", evidence.Format)}}}
+	for _, name := range append(names, unselected...) {
+		r := ClaimResult{Claim: name, Required: requiredSet[name], Actual: hex.EncodeToString(claims[name])}
+		want, ok := expected[name]
+		switch {
+		case !selected[name]:
+			r.Status, r.Reason = ClaimNotEvaluated, "not selected by the policy"
+			r.Expected = hex.EncodeToString(want)
+		case !ok:
+			r.Status, r.Reason = ClaimNotEvaluated, "no reference value"
+		case bytes.Equal(want, claims[name]):
+			r.Status, r.Expected = ClaimMatched, hex.EncodeToString(want)
+		default:
+			r.Status, r.Expected = ClaimMismatched, hex.EncodeToString(want)
+		}
+		if r.Required && r.Status != ClaimMatched {
+			a.Passed = false
+		}
+		a.Claims = append(a.Claims, r)
+	}
+
+	debug := ClaimResult{Claim: ClaimDebug, Required: !policy.AllowDebug, Expected: "false", Actual: fmt.Sprint(report.Debug())}
+	switch {
+	case policy.AllowDebug:
+		debug.Status, debug.Expected, debug.Reason = ClaimNotEvaluated, "", "debuggable TDs are allowed by the policy"
+	case report.Debug():
+		debug.Status, a.Passed = ClaimMismatched, false
+	default:
+		debug.Status = ClaimMatched
+	}
+	a.Claims = append(a.Claims, debug)
+	return a, nil
+}
@@ -0,0 +1,45 @@
+package measure
+
+import "sync"
+
+// Cache stores measurements by a key derived from all inputs of the measurement. Implementations
+// must be safe for concurrent use.
+type Cache interface {
+	Get(key string) (*Measurements, bool)
+	Put(key string, measurements *Measurements)
+}
+
+// memoryCache is an in-memory Cache holding a bounded number of measurements.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	keys    []string
+	entries map[string]*Measurements
+}
+
+// NewMemoryCache returns an in-memory Cache holding up to size measurements, evicting the oldest
+// ones first.
+func NewMemoryCache(size int) Cache {
+	return &memoryCache{size: size, entries: make(map[string]*Measurements)}
+}
+
+func (c *memoryCache) Get(key string) (*Measurements, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m, ok := c.entries[key]
+	return m, ok
+}
+
+func (c *memoryCache) Put(key string, measurements *Measurements) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[key]; ok || c.size < 1 {
+		return
+	}
+	if len(c.keys) >= c.size {
+		delete(c.entries, c.keys[0])
+		c.keys = c.keys[1:]
+	}
+	c.keys = append(c.keys, key)
+	c.entries[key] = measurements
+}
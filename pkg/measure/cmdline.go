@@ -0,0 +1,22 @@
+package measure
+
+import "github.com/scrtlabs/reproduce-mr/internal"
+
+// Encoding is the encoding of the kernel command line in the measured event.
+type Encoding = internal.Encoding
+
+// Kernel command line encodings accepted by MeasureKernelCmdline.
+const (
+	// EncodingOvmf widens every byte of the command line to a UCS-2 character as OVMF does. It is
+	// the encoding of TDs booted by QEMU with -kernel and the default.
+	EncodingOvmf = internal.EncodingOvmf
+	// EncodingUTF16 encodes the command line as UTF-16LE.
+	EncodingUTF16 = internal.EncodingUTF16
+)
+
+// MeasureKernelCmdline returns the digest of the kernel command line event extended into RTMR2.
+// The command line is measured in full including its terminating NUL; QEMU does not truncate it to
+// the cmdline_size of the kernel boot protocol.
+func MeasureKernelCmdline(cmdline string, encoding Encoding) ([]byte, error) {
+	return internal.MeasureKernelCmdline(cmdline, encoding)
+}
@@ -0,0 +1,31 @@
+package measure
+
+import "github.com/scrtlabs/reproduce-mr/internal"
+
+// Errors the Measurer wraps with the details of a failure. Match them with errors.Is to branch on
+// the cause, also for measurements that failed in a sandbox worker.
+var (
+	// ErrMalformedTDVF means the firmware has no valid OVMF table footer or TDVF metadata.
+	ErrMalformedTDVF = internal.ErrMalformedTDVF
+	// ErrUnsupportedKernel means the kernel image is not an x86_64 kernel TDVF can boot.
+	ErrUnsupportedKernel = internal.ErrUnsupportedKernel
+	// ErrKernelTooOld means the boot protocol of the kernel cannot load an initrd.
+	ErrKernelTooOld = internal.ErrKernelTooOld
+	// ErrInitrdTooLarge means the initrd does not fit below the highest address the kernel and the
+	// guest memory allow.
+	ErrInitrdTooLarge = internal.ErrInitrdTooLarge
+	// ErrInvalidParameters means the inputs describe a guest that cannot boot.
+	ErrInvalidParameters = internal.ErrInvalidParameters
+	// ErrTemplateNotFound means there is no ACPI table template for the CPU count and profile.
+	ErrTemplateNotFound = internal.ErrTemplateNotFound
+	// ErrUnknownProfile means WithProfile was given an unknown profile name.
+	ErrUnknownProfile = internal.ErrUnknownProfile
+	// ErrBadGUID means a GUID is not in the canonical 8-4-4-4-12 hex form.
+	ErrBadGUID = internal.ErrBadGUID
+	// ErrInvalidKeyProvider means the measurement of the key provider is not a hex-encoded digest.
+	ErrInvalidKeyProvider = internal.ErrInvalidKeyProvider
+	// ErrSignatureInvalid means no signature of a signed report verifies with a trusted key.
+	ErrSignatureInvalid = internal.ErrSignatureInvalid
+	// ErrEventLogTruncated means an event log does not hold all events of its CCEL log area.
+	ErrEventLogTruncated = internal.ErrEventLogTruncated
+)
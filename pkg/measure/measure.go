@@ -0,0 +1,406 @@
+// Package measure computes the TDX measurements of QEMU guests and verifies quotes against them.
+// It is the stable API of the measurement engine; the internal packages may change between
+// releases.
+//
+// A Measurer is created with New and configured with functional options:
+//
+//	m, err := measure.New(measure.WithProfile("qemu-tdx"), measure.WithTemplates("templates"))
+//	if err != nil {
+//		return err
+//	}
+//	measurements, err := m.MeasureBoot(measure.BootInputs{Firmware: fw, Kernel: kernel, MemoryMB: 2048, CPUs: 1, TcbVersion: 7})
+//
+// MeasureReaders and its boot and runtime variants read the artifacts from readers and hash the
+// initrd and rootfs while they are read. The ACPI tables measured into RTMR0 are available from
+// GenerateAcpiTables, and event logs captured from a TD are parsed with ParseEventLog and replayed
+// with Replay.
+//
+// The Context variants of the measuring methods stop when the context is canceled or its deadline
+// passes, returning the error of the context. This lets services bound the time spent on a request.
+package measure
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// Measurements are the computed measurement registers together with the coverage of every event
+// and the warnings raised while computing them.
+type Measurements = internal.TdxMeasurements
+
+// RegisterResult is the result of comparing a single register of a quote.
+type RegisterResult = internal.RegisterResult
+
+// Warning is a warning about conditions that may make the measurements inaccurate.
+type Warning = internal.Warning
+
+// MrtdVariant is the order in which QEMU adds and extends the firmware pages into MRTD.
+type MrtdVariant = internal.MrtdVariant
+
+// MRTD variants. By default the variant is derived from the TCB version.
+const (
+	MrtdVariantTwoPass    = internal.MrtdVariantTwoPass
+	MrtdVariantSinglePass = internal.MrtdVariantSinglePass
+)
+
+// ExtraEvent is an event extended into an RTMR after the modeled events.
+type ExtraEvent = internal.ExtraEvent
+
+// DefaultRegisters are the registers compared by Verify when none are given.
+var DefaultRegisters = []string{"MRTD", "RTMR0", "RTMR1", "RTMR2"}
+
+// Logger receives diagnostics of a Measurer. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, args ...any)
+}
+
+// BootInputs are the artifacts and parameters of a guest that determine MRTD and RTMR0-2.
+type BootInputs struct {
+	Firmware []byte
+	Kernel   []byte
+	Initrd   []byte
+	// MemoryMB is the guest memory size in megabytes.
+	MemoryMB uint64
+	CPUs     uint32
+	Cmdline  string
+	// TcbVersion selects the MRTD variant of the platform (6 or 7).
+	TcbVersion uint8
+	// FwCfgFiles holds the contents of fw_cfg files measured into RTMR0 by name.
+	FwCfgFiles map[string][]byte
+}
+
+// RuntimeInputs are the artifacts of a dstack guest that determine RTMR3.
+type RuntimeInputs struct {
+	DockerCompose []byte
+	Rootfs        []byte
+	DockerFiles   []byte
+}
+
+// Measurer computes and verifies measurements for a fixed profile and configuration. It is safe for
+// concurrent use.
+type Measurer struct {
+	profile   *internal.Profile
+	templates string
+	logger    Logger
+	slog      *slog.Logger
+	cache     Cache
+	slots     chan struct{}
+	registers []string
+	// measureOptions are applied to every measurement.
+	measureOptions []internal.MeasureOption
+	// options describes measureOptions for cache keys.
+	options []string
+}
+
+// Option configures a Measurer.
+type Option func(*Measurer) error
+
+// New returns a Measurer configured with the given options. Without options it measures for the
+// default profile. ACPI table templates must be given with WithTemplates unless the profile ships
+// them.
+func New(opts ...Option) (*Measurer, error) {
+	profile, err := internal.LookupProfile(internal.DefaultProfile)
+	if err != nil {
+		return nil, err
+	}
+	m := &Measurer{profile: profile}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// WithProfile selects the QEMU/firmware profile to measure for.
+func WithProfile(name string) Option {
+	return func(m *Measurer) error {
+		profile, err := internal.LookupProfile(name)
+		if err != nil {
+			return err
+		}
+		// Keep the overrides and memory map of earlier options.
+		if len(m.profile.EventOverrides) > 0 {
+			profile = profile.WithEventOverrides(m.profile.EventOverrides)
+		}
+		if m.profile.HobResources != nil {
+			profile = profile.WithHobResources(m.profile.HobResources)
+		}
+		m.profile = profile
+		return nil
+	}
+}
+
+// Profiles returns the names of the profiles WithProfile accepts, including those of loaded profile
+// packs.
+func Profiles() []string {
+	var names []string
+	for _, p := range internal.Profiles() {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+// WithTemplates sets the directory of the ACPI table templates.
+func WithTemplates(dir string) Option {
+	return func(m *Measurer) error {
+		m.templates = dir
+		return nil
+	}
+}
+
+// WithLogger sets the logger receiving the warnings of every measurement. WithSlog receives all
+// diagnostics by level.
+func WithLogger(logger Logger) Option {
+	return func(m *Measurer) error {
+		m.logger = logger
+		return nil
+	}
+}
+
+// WithSlog sets a structured logger receiving the diagnostics of the engine by level: every emulated
+// RTMR extension and the generated ACPI RSDP at debug level, the warnings of every measurement at
+// warn level. Diagnostics are dropped unless a logger is set.
+func WithSlog(logger *slog.Logger) Option {
+	return func(m *Measurer) error {
+		m.slog = logger
+		m.measureOptions = append(m.measureOptions, internal.WithLogger(logger))
+		return nil
+	}
+}
+
+// WithCache sets a cache for measurements, keyed by the digest of all inputs. Cached measurements
+// are shared between callers and must not be modified.
+func WithCache(cache Cache) Option {
+	return func(m *Measurer) error {
+		m.cache = cache
+		return nil
+	}
+}
+
+// WithConcurrency limits the number of measurements the Measurer computes at the same time. Calls
+// beyond the limit wait for a running measurement to finish.
+func WithConcurrency(n int) Option {
+	return func(m *Measurer) error {
+		if n < 1 {
+			return fmt.Errorf("concurrency must be at least 1")
+		}
+		m.slots = make(chan struct{}, n)
+		return nil
+	}
+}
+
+// WithRegisters restricts the Measurer to the given registers (e.g. "RTMR2"). Registers that are
+// not computed are left nil, and inputs only they depend on are not needed: the firmware is only
+// parsed for MRTD and RTMR0 and the kernel only measured for RTMR1.
+func WithRegisters(registers ...string) Option {
+	return func(m *Measurer) error {
+		m.registers = registers
+		return nil
+	}
+}
+
+// WithOverrides replaces the modeled digests of the RTMR0 events with the given identifiers (e.g.
+// "cfv-image") by the given SHA384 digests.
+func WithOverrides(overrides map[string][]byte) Option {
+	return func(m *Measurer) error {
+		m.profile = m.profile.WithEventOverrides(overrides)
+		return nil
+	}
+}
+
+// WithMrtdVariant overrides the MRTD variant derived from the TCB version of the inputs.
+func WithMrtdVariant(variant MrtdVariant) Option {
+	return func(m *Measurer) error {
+		m.measureOptions = append(m.measureOptions, internal.WithMrtdVariant(variant))
+		m.options = append(m.options, "mrtd-variant="+string(variant))
+		return nil
+	}
+}
+
+// WithAcpiDataSize sets the size of the ACPI data QEMU reserves below 4 GiB, which bounds the
+// initrd address written into the kernel boot header.
+func WithAcpiDataSize(size uint32) Option {
+	return func(m *Measurer) error {
+		m.measureOptions = append(m.measureOptions, internal.WithAcpiDataSize(size))
+		m.options = append(m.options, fmt.Sprintf("acpi-data-size=%d", size))
+		return nil
+	}
+}
+
+// WithExtraEvents extends the given events into the RTMRs after the modeled events.
+func WithExtraEvents(events ...ExtraEvent) Option {
+	return func(m *Measurer) error {
+		m.measureOptions = append(m.measureOptions, internal.WithExtraEvents(events...))
+		for _, e := range events {
+			m.options = append(m.options, fmt.Sprintf("extra-event=%s:%s:%x", e.Register, e.Name, e.Digest))
+		}
+		return nil
+	}
+}
+
+// MeasureBoot computes MRTD and RTMR0-2 of a guest booted from the given inputs. RTMR3 is set to
+// the value of an empty runtime.
+func (m *Measurer) MeasureBoot(in BootInputs) (*Measurements, error) {
+	return m.MeasureBootContext(context.Background(), in)
+}
+
+// MeasureBootContext is like MeasureBoot but stops when the context is done.
+func (m *Measurer) MeasureBootContext(ctx context.Context, in BootInputs) (*Measurements, error) {
+	return m.measure(ctx, in, RuntimeInputs{})
+}
+
+// MeasureRuntime computes RTMR3 from the given runtime inputs.
+func (m *Measurer) MeasureRuntime(in RuntimeInputs) ([]byte, error) {
+	return internal.MeasureRuntime(in.DockerCompose, in.Rootfs, in.DockerFiles)
+}
+
+// Measure computes all measurement registers of a guest booted from the given inputs.
+func (m *Measurer) Measure(boot BootInputs, runtime RuntimeInputs) (*Measurements, error) {
+	return m.MeasureContext(context.Background(), boot, runtime)
+}
+
+// MeasureContext is like Measure but stops when the context is done.
+func (m *Measurer) MeasureContext(ctx context.Context, boot BootInputs, runtime RuntimeInputs) (*Measurements, error) {
+	return m.measure(ctx, boot, runtime)
+}
+
+// Verify compares the given registers of a TDX quote, TD report or Hyper-V HCL report against the
+// measurements of the given inputs.
+// DefaultRegisters are compared when no registers are given. The quote signature is not verified.
+func (m *Measurer) Verify(quote []byte, in BootInputs, registers ...string) ([]RegisterResult, error) {
+	return m.VerifyContext(context.Background(), quote, in, registers...)
+}
+
+// VerifyContext is like Verify but stops when the context is done.
+func (m *Measurer) VerifyContext(ctx context.Context, quote []byte, in BootInputs, registers ...string) ([]RegisterResult, error) {
+	evidence, err := internal.ParseEvidence(quote)
+	if err != nil {
+		return nil, fmt.Errorf("invalid quote: %w", err)
+	}
+	report := evidence.Report
+	measurements, err := m.MeasureBootContext(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	if len(registers) == 0 {
+		registers = DefaultRegisters
+	}
+	return internal.CompareReport(report, measurements, registers)
+}
+
+func (m *Measurer) measure(ctx context.Context, boot BootInputs, runtime RuntimeInputs) (*Measurements, error) {
+	return m.run(ctx, m.measureInputs(boot, runtime), func() string { return m.cacheKey(boot, runtime) })
+}
+
+// measureInputs returns the options of the measurement of the given inputs.
+func (m *Measurer) measureInputs(boot BootInputs, runtime RuntimeInputs) internal.MeasureOptions {
+	return internal.MeasureOptions{
+		Firmware: boot.Firmware, Kernel: boot.Kernel, Initrd: boot.Initrd, Rootfs: runtime.Rootfs, DockerCompose: runtime.DockerCompose, DockerFiles: runtime.DockerFiles,
+		MemoryMB: boot.MemoryMB, CPUs: boot.CPUs, Cmdline: boot.Cmdline, TemplatesPath: m.templatesPath(), TcbVersion: boot.TcbVersion,
+		Profile: m.profile, FwCfgFiles: boot.FwCfgFiles, Registers: m.registers,
+	}
+}
+
+// run computes the measurements for the given options, or returns those cached under the key.
+// Waiting for a concurrency slot is abandoned when the context is done.
+func (m *Measurer) run(ctx context.Context, opts internal.MeasureOptions, cacheKey func() string) (*Measurements, error) {
+	var key string
+	if m.cache != nil {
+		key = cacheKey()
+		if measurements, ok := m.cache.Get(key); ok {
+			return measurements, nil
+		}
+	}
+	if m.slots != nil {
+		select {
+		case m.slots <- struct{}{}:
+			defer func() { <-m.slots }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	measurements, err := internal.MeasureTdxQemuContext(ctx, opts, m.measureOptions...)
+	if err != nil {
+		return nil, err
+	}
+	if m.logger != nil {
+		for _, w := range measurements.Warnings {
+			m.logger.Printf("Warning: %s", w)
+		}
+	}
+	if m.slog != nil {
+		for _, w := range measurements.Warnings {
+			m.slog.Warn(w.Message, "code", w.Code)
+		}
+	}
+	if m.cache != nil {
+		m.cache.Put(key, measurements)
+	}
+	return measurements, nil
+}
+
+// templatesPath returns the directory of the ACPI table templates, those of the profile unless
+// WithTemplates was given.
+func (m *Measurer) templatesPath() string {
+	if m.templates == "" {
+		return m.profile.TemplatesPath
+	}
+	return m.templates
+}
+
+// cacheKey returns the digest identifying a measurement by all of its inputs and the configuration
+// of the Measurer.
+func (m *Measurer) cacheKey(boot BootInputs, runtime RuntimeInputs) string {
+	h := sha256.New()
+	field := func(data []byte) {
+		h.Write(binary.LittleEndian.AppendUint64(nil, uint64(len(data))))
+		h.Write(data)
+	}
+	field([]byte(m.profile.Name))
+	// The template is keyed by its contents, which may change in place. A template that cannot be
+	// read fails the measurement, which is not cached.
+	tpl, _ := internal.ReadTemplate(m.templatesPath(), boot.CPUs, m.profile.MemoryHotplug)
+	field(tpl)
+	field([]byte(strings.ToUpper(strings.Join(m.registers, ","))))
+	for _, option := range m.options {
+		field([]byte(option))
+	}
+	for _, id := range sortedKeys(m.profile.EventOverrides) {
+		field([]byte(id))
+		field(m.profile.EventOverrides[id])
+	}
+	field(boot.Firmware)
+	field(boot.Kernel)
+	field(boot.Initrd)
+	field(binary.LittleEndian.AppendUint64(nil, boot.MemoryMB))
+	field(binary.LittleEndian.AppendUint32(nil, boot.CPUs))
+	field([]byte(boot.Cmdline))
+	field([]byte{boot.TcbVersion})
+	for _, name := range sortedKeys(boot.FwCfgFiles) {
+		field([]byte(name))
+		field(boot.FwCfgFiles[name])
+	}
+	field(runtime.DockerCompose)
+	field(runtime.Rootfs)
+	field(runtime.DockerFiles)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func sortedKeys(m map[string][]byte) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,82 @@
+package measure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// Event is an event extended into a register as recorded in a TD event log.
+type Event = internal.LogEvent
+
+// Event log formats accepted by ParseEventLog.
+const (
+	EventLogCcel    = internal.EventLogCcel
+	EventLogTcg2    = internal.EventLogTcg2
+	EventLogCelCbor = internal.EventLogCelCbor
+	EventLogJSON    = internal.EventLogJSON
+	EventLogDstack  = internal.EventLogDstack
+)
+
+// ParseEventLog parses a TD event log and returns the events extended into the RTMRs. The format
+// is detected from the data when it is empty.
+func ParseEventLog(data []byte, format string) ([]Event, error) {
+	if format == "" {
+		format = internal.DetectEventLogFormat(data)
+	}
+	return internal.ParseEventLog(data, format)
+}
+
+// Replay returns the value of a register (e.g. "RTMR3") after extending it, starting from zero,
+// with the digests of the events recorded for it.
+func Replay(events []Event, register string) ([]byte, error) {
+	return internal.ReplayEventLog(events, strings.ToUpper(register))
+}
+
+// ReplayStep is an event replayed into a register with the value of the register after it.
+type ReplayStep = internal.ReplayStep
+
+// ReplaySteps replays the events of a register like Replay, and also returns the value of the
+// register after each event extended into it.
+func ReplaySteps(events []Event, register string) ([]byte, []ReplayStep, error) {
+	return internal.ReplayEventLogSteps(events, strings.ToUpper(register))
+}
+
+// ParseDigestList parses a list of the digests extended into a register, one hex-encoded SHA384
+// digest per line optionally followed by the name of the event, and returns them as events of the
+// register to replay.
+func ParseDigestList(data []byte, register string) ([]Event, error) {
+	return internal.ParseDigestList(data, strings.ToUpper(register))
+}
+
+// BoundEventLog checks a CCEL event log, e.g. from /sys/firmware/acpi/tables/data/CCEL, against
+// the log area of the CCEL ACPI table from /sys/firmware/acpi/tables/CCEL and returns the log cut to
+// that area. Logs that were cut off or that filled the log area fail with ErrEventLogTruncated.
+func BoundEventLog(table, log []byte) ([]byte, error) {
+	t, err := internal.ParseCcelTable(table)
+	if err != nil {
+		return nil, err
+	}
+	return t.BoundEventLog(log)
+}
+
+// EventDiff compares an event of a register in an event log with the event the measurements
+// expect.
+type EventDiff = internal.EventDiff
+
+// DiffEventLog compares the events of a register (e.g. "RTMR0") in the event log of a TD one by one
+// with the events of the computed measurements, so that the first divergent extend can be found.
+func DiffEventLog(m *Measurements, events []Event, register string) []EventDiff {
+	return internal.DiffEventLog(m, events, strings.ToUpper(register))
+}
+
+// ReplayBank replays another digest bank of a TCG event log, e.g. "sha256" for logs without SHA384
+// digests, into a register of the bank's digest size. The result cannot be compared with the RTMRs.
+func ReplayBank(events []Event, register, bank string) ([]byte, []ReplayStep, error) {
+	alg, ok := internal.DigestBanks[strings.ToLower(bank)]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown digest bank '%s'", bank)
+	}
+	return internal.ReplayEventLogBank(events, strings.ToUpper(register), alg)
+}
@@ -0,0 +1,51 @@
+package measure
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// ReportPayloadType is the DSSE payload type of signed measurement reports.
+const ReportPayloadType = internal.ReportPayloadType
+
+// Envelope is a DSSE envelope holding a signed measurement report.
+type Envelope = internal.DsseEnvelope
+
+// TrustedKey is a public key signed reports are accepted from.
+type TrustedKey = internal.TrustedKey
+
+// ParseTrustedKey parses a hex-encoded Ed25519 public key or reads a PEM encoded PKIX Ed25519 or
+// ECDSA (P-256/P-384) public key from the file the value names.
+func ParseTrustedKey(value string) (TrustedKey, error) {
+	return internal.ParseTrustedKey(value)
+}
+
+// SignReport wraps a report, e.g. the canonical JSON output of the command line tool, in a DSSE
+// envelope signed with each of the Ed25519 or ECDSA (P-256/P-384) keys.
+func SignReport(report []byte, keys ...crypto.Signer) (*Envelope, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no signing keys")
+	}
+	env, err := internal.SignDsse(ReportPayloadType, report, keys[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys[1:] {
+		if err = env.AddSignature(key); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+// VerifyReport checks that a signature of a signed report verifies with any of the trusted keys,
+// so that a signing key can be rotated by trusting the old and the new key for a while. It
+// returns the report and the ID of the key, or an error wrapping ErrSignatureInvalid.
+func VerifyReport(env *Envelope, keys ...TrustedKey) ([]byte, string, error) {
+	if env.PayloadType != ReportPayloadType {
+		return nil, "", fmt.Errorf("envelope has payload type '%s', expected '%s'", env.PayloadType, ReportPayloadType)
+	}
+	return env.Verify(internal.TrustAnchors(keys))
+}
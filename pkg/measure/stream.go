@@ -0,0 +1,135 @@
+package measure
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// BootReaders are BootInputs whose artifacts are read from readers. The initrd is hashed while it
+// is read and never held in memory. The firmware and kernel are read into memory, as MRTD and the
+// Authenticode hash of the patched kernel need random access to them.
+type BootReaders struct {
+	Firmware io.Reader
+	Kernel   io.Reader
+	// Initrd is nil for guests without an initrd.
+	Initrd io.Reader
+	// MemoryMB is the guest memory size in megabytes.
+	MemoryMB uint64
+	CPUs     uint32
+	Cmdline  string
+	// TcbVersion selects the MRTD variant of the platform (6 or 7).
+	TcbVersion uint8
+	// FwCfgFiles holds the contents of fw_cfg files measured into RTMR0 by name.
+	FwCfgFiles map[string][]byte
+}
+
+// RuntimeReaders are RuntimeInputs whose artifacts are read from readers. The rootfs is hashed
+// while it is read and never held in memory. DockerFiles is nil for guests without docker files.
+type RuntimeReaders struct {
+	DockerCompose io.Reader
+	Rootfs        io.Reader
+	DockerFiles   io.Reader
+}
+
+// MeasureBootReaders computes MRTD and RTMR0-2 like MeasureBoot from inputs read from readers.
+func (m *Measurer) MeasureBootReaders(in BootReaders) (*Measurements, error) {
+	return m.MeasureReaders(in, RuntimeReaders{})
+}
+
+// MeasureRuntimeReaders computes RTMR3 like MeasureRuntime from inputs read from readers.
+func (m *Measurer) MeasureRuntimeReaders(in RuntimeReaders) ([]byte, error) {
+	runtime, rootfs, err := readRuntime(in)
+	if err != nil {
+		return nil, err
+	}
+	var dockerFiles []byte
+	if len(runtime.DockerFiles) > 0 {
+		digest := sha256.Sum256(runtime.DockerFiles)
+		dockerFiles = digest[:]
+	}
+	dockerCompose := sha256.Sum256(runtime.DockerCompose)
+	return internal.MeasureRuntimeDigests(dockerCompose[:], rootfs.Sha256, dockerFiles)
+}
+
+// MeasureReaders computes all measurement registers like Measure from inputs read from readers.
+func (m *Measurer) MeasureReaders(boot BootReaders, runtime RuntimeReaders) (*Measurements, error) {
+	return m.MeasureReadersContext(context.Background(), boot, runtime)
+}
+
+// MeasureReadersContext is like MeasureReaders but stops when the context is done. Reading the
+// inputs is not interrupted; readers that may block should be closed when the context is done.
+func (m *Measurer) MeasureReadersContext(ctx context.Context, boot BootReaders, runtime RuntimeReaders) (*Measurements, error) {
+	in := BootInputs{MemoryMB: boot.MemoryMB, CPUs: boot.CPUs, Cmdline: boot.Cmdline, TcbVersion: boot.TcbVersion, FwCfgFiles: boot.FwCfgFiles}
+	var err error
+	if in.Firmware, err = readAll(boot.Firmware, "firmware"); err != nil {
+		return nil, err
+	}
+	if in.Kernel, err = readAll(boot.Kernel, "kernel"); err != nil {
+		return nil, err
+	}
+	var initrd *internal.StreamDigest
+	if boot.Initrd != nil {
+		if initrd, err = internal.HashStream(boot.Initrd); err != nil {
+			return nil, fmt.Errorf("initrd: %w", err)
+		}
+	}
+	runtimeInputs, rootfs, err := readRuntime(runtime)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := m.measureInputs(in, runtimeInputs)
+	opts.InitrdDigest, opts.RootfsDigest = initrd, rootfs
+	return m.run(ctx, opts, func() string {
+		// The digests take the place of the streamed artifacts in the key, which is marked to
+		// keep it apart from the keys of in-memory inputs.
+		if initrd != nil {
+			in.Initrd = initrd.Sha384
+		}
+		runtimeInputs.Rootfs = rootfs.Sha384
+		return "stream:" + m.cacheKey(in, runtimeInputs)
+	})
+}
+
+// readRuntime reads the docker compose file and docker files and hashes the rootfs.
+func readRuntime(in RuntimeReaders) (RuntimeInputs, *internal.StreamDigest, error) {
+	var (
+		runtime RuntimeInputs
+		err     error
+	)
+	if runtime.DockerCompose, err = readAll(in.DockerCompose, "docker compose file"); err != nil {
+		return runtime, nil, err
+	}
+	if runtime.DockerFiles, err = readAll(in.DockerFiles, "docker files"); err != nil {
+		return runtime, nil, err
+	}
+	rootfs, err := internal.HashStream(emptyIfNil(in.Rootfs))
+	if err != nil {
+		return runtime, nil, fmt.Errorf("rootfs: %w", err)
+	}
+	return runtime, rootfs, nil
+}
+
+// readAll reads an artifact into memory, nil if the reader is nil.
+func readAll(r io.Reader, name string) ([]byte, error) {
+	if r == nil {
+		return nil, nil
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// emptyIfNil returns r, or an empty reader if r is nil.
+func emptyIfNil(r io.Reader) io.Reader {
+	if r == nil {
+		return io.MultiReader()
+	}
+	return r
+}
@@ -0,0 +1,61 @@
+package measure
+
+import (
+	"fmt"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// HobResource is a resource descriptor in the TD HOB: the type, attributes and range of a region
+// of guest memory.
+type HobResource = internal.HobResource
+
+// TD HOB resource types.
+const (
+	HobResourceSystemMemory     = internal.HobResourceSystemMemory
+	HobResourceMemoryUnaccepted = internal.HobResourceMemoryUnaccepted
+)
+
+// TD HOB resource attributes.
+const (
+	HobAttributePresent     = internal.HobAttributePresent
+	HobAttributeInitialized = internal.HobAttributeInitialized
+	HobAttributeTested      = internal.HobAttributeTested
+	HobAttributeEncrypted   = internal.HobAttributeEncrypted
+)
+
+// DefaultTdHobBase is the address of the TD HOB for firmware without a TD HOB section.
+const DefaultTdHobBase = internal.DefaultTdHobBase
+
+// BuildTdHob constructs the TD HOB QEMU passes to the firmware at the given guest physical address,
+// describing the given memory map. Its SHA384 digest is the TD HOB event of RTMR0, for guests
+// whose memory map differs from the one QEMU sets up by default.
+func BuildTdHob(base uint64, resources []HobResource) []byte {
+	return internal.BuildTdHob(base, resources)
+}
+
+// TdHobBase returns the address of the TD HOB declared by the TDVF metadata of a firmware.
+func TdHobBase(fw []byte) (uint64, error) {
+	return internal.TdHobBase(fw)
+}
+
+// TdHobResources returns the memory map QEMU describes in the TD HOB of a guest with the given
+// memory size, for the profile of the Measurer. It is a starting point for custom memory maps.
+func (m *Measurer) TdHobResources(memoryMB uint64) []HobResource {
+	return internal.QemuTdHobResources(memoryMB, m.profile)
+}
+
+// WithTdHobMemoryMap measures the TD HOB describing the given memory map instead of the one QEMU
+// sets up for the memory size of the inputs.
+func WithTdHobMemoryMap(resources ...HobResource) Option {
+	return func(m *Measurer) error {
+		if len(resources) == 0 {
+			return fmt.Errorf("TD HOB memory map has no resource descriptors")
+		}
+		m.profile = m.profile.WithHobResources(resources)
+		for _, r := range resources {
+			m.options = append(m.options, fmt.Sprintf("td-hob=%d:%x:%x:%x", r.Type, r.Attributes, r.Start, r.Length))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,16 @@
+package measure
+
+import "github.com/scrtlabs/reproduce-mr/internal"
+
+// TdvfMetadata is the TDVF metadata of a firmware: the offset of its descriptor and its sections.
+type TdvfMetadata = internal.TdvfMetadata
+
+// TdvfSection is a section of the TDVF metadata, with its type, GPA range, attributes and the
+// offsets of its entry and raw data in the firmware.
+type TdvfSection = internal.TdvfSection
+
+// ParseTdvfMetadata walks the OVMF GUIDed table of a firmware and parses its TDVF metadata. It
+// fails with ErrMalformedTDVF if the firmware has no valid metadata.
+func ParseTdvfMetadata(fw []byte) (*TdvfMetadata, error) {
+	return internal.ParseTdvfMetadata(fw)
+}
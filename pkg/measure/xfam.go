@@ -0,0 +1,13 @@
+package measure
+
+import "github.com/scrtlabs/reproduce-mr/internal"
+
+// XfamPrediction is the XFAM QEMU requests for a TD with a CPU model.
+type XfamPrediction = internal.XfamPrediction
+
+// PredictXfam predicts the XFAM QEMU requests for a TD from the argument of its -cpu option, e.g.
+// "SapphireRapids,-amx-tile". For the host and max models, hostFlags are the CPU flags of the host
+// as listed in /proc/cpuinfo. The hex encoding of Xfam is the xfam reference value of a quote.
+func PredictXfam(cpu, hostFlags string) (*XfamPrediction, error) {
+	return internal.PredictXfam(cpu, hostFlags)
+}
@@ -0,0 +1,156 @@
+//go:build !minimal
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runRegistry implements the registry command, which exchanges reference values between the
+// registries of different teams.
+func runRegistry(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "import":
+			runRegistryImport(args[1:])
+			return
+		case "export":
+			runRegistryExport(args[1:])
+			return
+		}
+	}
+	fmt.Println("Usage: reproduce-mr registry import|export [flags]")
+	os.Exit(1)
+}
+
+// runRegistryImport implements registry import, which adds the entries of another registry.
+func runRegistryImport(args []string) {
+	var (
+		registryPath string
+		strategy     string
+		source       string
+	)
+
+	fs := flag.NewFlagSet("registry import", flag.ExitOnError)
+	fs.StringVar(&registryPath, "registry", "", "Registry to import into: the path of a JSON file or sql:<driver>:<dsn>")
+	fs.StringVar(&strategy, "strategy", internal.ImportFailOnConflict, "How to resolve images with different reference values: prefer-newer or fail-on-conflict")
+	fs.StringVar(&source, "source", "", "Name recorded as the provenance of the imported entries (default: the imported registry)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: reproduce-mr registry import [flags] <registry>")
+		fs.PrintDefaults()
+	}
+	parseFlags(fs, args)
+
+	if registryPath == "" || fs.NArg() != 1 {
+		fmt.Println("Error: registry and the registry to import are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	other := fs.Arg(0)
+	if !strings.HasPrefix(other, "sql:") {
+		// A missing file would be imported as an empty registry.
+		if _, err := os.Stat(other); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	from, err := internal.OpenRegistryStore(other)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer from.Close()
+	entries, err := from.Entries()
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", from, err)
+		os.Exit(1)
+	}
+	if source == "" {
+		source = from.String()
+	}
+
+	store, err := internal.OpenRegistryStore(registryPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	result, err := internal.ImportRegistry(store, entries, source, strategy)
+	if result != nil {
+		fmt.Printf("Added: %d, replaced: %d, kept: %d, unchanged: %d\n", len(result.Added), len(result.Replaced), len(result.Kept), len(result.Unchanged))
+		for _, image := range result.Replaced {
+			fmt.Printf("  %s: replaced by the newer imported entry\n", image)
+		}
+		for _, image := range result.Kept {
+			fmt.Printf("  %s: kept the newer existing entry\n", image)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// runRegistryExport implements registry export, which writes the entries of a registry as a JSON
+// registry file.
+func runRegistryExport(args []string) {
+	var (
+		registryPath string
+		since        string
+		outPath      string
+	)
+
+	fs := flag.NewFlagSet("registry export", flag.ExitOnError)
+	fs.StringVar(&registryPath, "registry", "", "Registry to export: the path of a JSON file or sql:<driver>:<dsn>")
+	fs.StringVar(&since, "since", "", "Only export images of this dstack version or later (e.g., v0.5)")
+	fs.StringVar(&outPath, "out", "", "Path to write the exported registry to (default: stdout)")
+	parseFlags(fs, args)
+
+	if registryPath == "" {
+		fmt.Println("Error: registry is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	store, err := internal.OpenRegistryStore(registryPath)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer store.Close()
+	entries, err := store.Entries()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if since != "" {
+		if entries, err = internal.RegistryEntriesSince(entries, since); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	exported := &internal.Registry{Entries: entries}
+	if exported.Entries == nil {
+		exported.Entries = []internal.RegistryEntry{}
+	}
+
+	if outPath != "" {
+		if err = exported.Write(outPath); err != nil {
+			fmt.Printf("Error writing %s: %v\n", outPath, err)
+			os.Exit(1)
+		}
+		return
+	}
+	jsonData, err := marshalOutput(exported, false)
+	if err != nil {
+		fmt.Printf("Error encoding JSON: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(jsonData))
+}
@@ -0,0 +1,7 @@
+//go:build postgres && !minimal
+
+package main
+
+// The PostgreSQL driver of registries given as sql:postgres:<dsn> is only built in with the
+// postgres build tag, so that default builds carry no database driver.
+import _ "github.com/lib/pq"
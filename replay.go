@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// replayOutput is the JSON output of the replay command.
+type replayOutput struct {
+	// Bank is the digest bank that was replayed, sha384 for the RTMRs.
+	Bank      string            `json:"bank"`
+	Registers map[string]string `json:"registers"`
+	// Steps lists, per register, the events extended into it with the value after each of them.
+	Steps    map[string][]replayStepOutput `json:"steps,omitempty"`
+	Warnings []internal.Warning            `json:"warnings"`
+}
+
+// replayStepOutput is an event replayed into a register.
+type replayStepOutput struct {
+	Type   uint32 `json:"type"`
+	Name   string `json:"name,omitempty"`
+	Digest string `json:"digest"`
+	Value  string `json:"value"`
+}
+
+// ccelTableUsage is the usage of the -ccel-table flag of the commands reading event logs.
+const ccelTableUsage = "Path to the CCEL ACPI table (/sys/firmware/acpi/tables/CCEL) to check the CCEL event log against its log area"
+
+// boundEventLog checks a CCEL event log against the log area of the CCEL ACPI table read from
+// tablePath, if any, and returns the log cut to the log area.
+func boundEventLog(data []byte, tablePath string) ([]byte, error) {
+	if tablePath == "" {
+		return data, nil
+	}
+	tableData, err := os.ReadFile(tablePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading CCEL ACPI table: %w", err)
+	}
+	table, err := internal.ParseCcelTable(tableData)
+	if err != nil {
+		return nil, err
+	}
+	return table.BoundEventLog(data)
+}
+
+// runReplay implements the replay command, which replays the events of an event log, or a list of
+// digests, into the RTMRs.
+func runReplay(args []string) {
+	var (
+		inPath     string
+		ccelTable  string
+		from       string
+		registers  string
+		bank       string
+		steps      bool
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&inPath, "in", "", "Path to the event log to replay (defaults to stdin)")
+	fs.StringVar(&ccelTable, "ccel-table", "", ccelTableUsage)
+	fs.StringVar(&from, "from", "", "Format of the event log: "+strings.Join(append(internal.EventLogFormats, internal.EventLogDigests), ", ")+" (detected from the contents by default)")
+	fs.StringVar(&registers, "registers", "rtmr0,rtmr1,rtmr2,rtmr3", "Comma-separated list of the registers to replay")
+	fs.StringVar(&bank, "bank", "sha384", "Digest bank of a TCG event log to replay, e.g. sha256 for logs without SHA384 digests (other banks than sha384 cannot be compared with the RTMRs)")
+	fs.BoolVar(&steps, "steps", false, "Also output the value of the registers after each event")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	var (
+		data []byte
+		err  error
+	)
+	if inPath == "" || inPath == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(inPath)
+	}
+	if err != nil {
+		fmt.Printf("Error reading event log: %v\n", err)
+		os.Exit(1)
+	}
+	if data, err = boundEventLog(data, ccelTable); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if ccelTable != "" {
+		from = internal.EventLogCcel
+	}
+	if from == "" {
+		from = internal.DetectEventLogFormat(data)
+		if internal.IsDigestList(data) {
+			from = internal.EventLogDigests
+		}
+	}
+	var events []internal.LogEvent
+	if from == internal.EventLogDigests {
+		// A digest list does not name its register, it is replayed into the one selected.
+		register := strings.ToUpper(strings.TrimSpace(registers))
+		if strings.Contains(register, ",") {
+			fmt.Println("Error: a digest list is replayed into one register, select it with -registers")
+			os.Exit(1)
+		}
+		events, err = internal.ParseDigestList(data, register)
+	} else {
+		events, err = internal.ParseEventLog(data, from)
+	}
+	if err != nil {
+		fmt.Printf("Error parsing event log: %v\n", err)
+		os.Exit(1)
+	}
+
+	alg, ok := internal.DigestBanks[strings.ToLower(bank)]
+	if !ok {
+		fmt.Printf("Error: unknown digest bank '%s'\n", bank)
+		os.Exit(1)
+	}
+	output := replayOutput{
+		Bank:      strings.ToLower(bank),
+		Registers: map[string]string{},
+		Warnings:  internal.CheckRuntimeEvents(events),
+	}
+	if output.Warnings == nil {
+		output.Warnings = []internal.Warning{}
+	}
+	if steps {
+		output.Steps = map[string][]replayStepOutput{}
+	}
+	var names []string
+	for _, name := range strings.Split(registers, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value, replayed, err := internal.ReplayEventLogBank(events, name, alg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		names = append(names, name)
+		output.Registers[name] = hex.EncodeToString(value)
+		if steps {
+			output.Steps[name] = []replayStepOutput{}
+			for _, s := range replayed {
+				output.Steps[name] = append(output.Steps[name], replayStepOutput{
+					Type:   s.Event.Type,
+					Name:   s.Event.Name,
+					Digest: hex.EncodeToString(s.Digest),
+					Value:  hex.EncodeToString(s.Value),
+				})
+			}
+		}
+	}
+
+	if jsonOutput {
+		out, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error generating JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	} else {
+		for _, name := range names {
+			if steps {
+				for i, s := range output.Steps[name] {
+					label := s.Name
+					if label == "" && s.Type != 0 {
+						label = fmt.Sprintf("type 0x%08x", s.Type)
+					} else if label == "" {
+						label = "event"
+					}
+					fmt.Printf("%s #%d %s digest %s\n", name, i+1, label, s.Digest)
+					fmt.Printf("   %s: %s\n", name, s.Value)
+				}
+			}
+			if output.Bank != "sha384" {
+				fmt.Printf("%s (%s): %s\n", name, output.Bank, output.Registers[name])
+				continue
+			}
+			fmt.Printf("%s: %s\n", name, output.Registers[name])
+		}
+		for _, w := range output.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+const rtmrSimHelp = `Commands:
+  event <name> [payload]         extend a dstack runtime event
+  typed <type> <name> [payload]  extend a runtime event of another event type, e.g. 0x08000002
+  digest <hex> [name]            extend a raw digest, zero-padded to 48 bytes
+  log                            list the events with their digests and the value after each
+  undo                           remove the last event
+  reset                          remove all events
+  save <path> [format]           write the events as an event log (default: dstack-json)
+  help                           show this help
+  quit                           exit
+Payloads are text, hex:<hex> or @<path> to read a file.`
+
+// rtmrSim holds the events extended into the simulated RTMR3 and its value after each of them.
+type rtmrSim struct {
+	events []internal.LogEvent
+	values [][]byte
+}
+
+// value returns the current value of the register.
+func (s *rtmrSim) value() []byte {
+	if len(s.values) == 0 {
+		return make([]byte, 48)
+	}
+	return s.values[len(s.values)-1]
+}
+
+// extend extends the event into the register and prints its digest and the new value.
+func (s *rtmrSim) extend(e internal.LogEvent) {
+	s.events = append(s.events, e)
+	s.values = append(s.values, internal.ExtendRegister(s.value(), e.Digest))
+	fmt.Printf("#%d %s digest %x\n", len(s.events), rtmrSimName(e), e.Digest)
+	fmt.Printf("RTMR3: %x\n", s.value())
+}
+
+// rtmrSimName returns the name of an event for display.
+func rtmrSimName(e internal.LogEvent) string {
+	if e.Name == "" {
+		return "(digest)"
+	}
+	return e.Name
+}
+
+// runRtmrSim implements the rtmr-sim command, an interactive simulator of RTMR3 for designing
+// runtime measurement schemes.
+func runRtmrSim(args []string) {
+	var logPath string
+
+	fs := flag.NewFlagSet("rtmr-sim", flag.ExitOnError)
+	fs.StringVar(&logPath, "log", "", "Path to an event log whose RTMR3 events the simulation starts from")
+	parseFlags(fs, args)
+
+	sim := &rtmrSim{}
+	if logPath != "" {
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			fmt.Printf("Error reading event log: %v\n", err)
+			os.Exit(1)
+		}
+		events, err := internal.ParseEventLog(data, internal.DetectEventLogFormat(data))
+		if err != nil {
+			fmt.Printf("Error parsing event log: %v\n", err)
+			os.Exit(1)
+		}
+		if err = internal.CheckSha384Bank(events, "RTMR3"); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, e := range events {
+			if e.Register == "RTMR3" {
+				sim.extend(e)
+			}
+		}
+	}
+
+	// Prompts are only shown on terminals, so that scripts can pipe commands in.
+	interactive := false
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		interactive = true
+		fmt.Println("RTMR3 simulator, type help for the commands.")
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	scanner.Buffer(nil, 16*1024*1024)
+	for {
+		if interactive {
+			fmt.Print("> ")
+		}
+		if !scanner.Scan() {
+			break
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "quit" || line == "exit" {
+			return
+		}
+		if err := sim.run(line); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			if !interactive {
+				os.Exit(1)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Printf("Error reading commands: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes a command of the simulator.
+func (s *rtmrSim) run(line string) error {
+	command, rest, _ := strings.Cut(line, " ")
+	rest = strings.TrimSpace(rest)
+	switch command {
+	case "event", "typed":
+		eventType := uint64(internal.DstackRuntimeEventType)
+		if command == "typed" {
+			var value string
+			value, rest, _ = strings.Cut(rest, " ")
+			var err error
+			if eventType, err = strconv.ParseUint(value, 0, 32); err != nil {
+				return fmt.Errorf("invalid event type '%s'", value)
+			}
+			rest = strings.TrimSpace(rest)
+		}
+		name, payload, _ := strings.Cut(rest, " ")
+		if name == "" {
+			return fmt.Errorf("usage: %s", strings.TrimSpace(line))
+		}
+		data, err := rtmrSimPayload(strings.TrimSpace(payload))
+		if err != nil {
+			return err
+		}
+		s.extend(internal.RuntimeEvent(uint32(eventType), name, data))
+	case "digest":
+		value, name, _ := strings.Cut(rest, " ")
+		digest, err := hex.DecodeString(strings.TrimPrefix(value, "0x"))
+		if err != nil || len(digest) == 0 || len(digest) > 48 {
+			return fmt.Errorf("invalid digest '%s', expected up to 48 hex-encoded bytes", value)
+		}
+		s.extend(internal.LogEvent{Register: "RTMR3", Digest: digest, Name: strings.TrimSpace(name)})
+	case "log":
+		if len(s.events) == 0 {
+			fmt.Println("No events.")
+		}
+		for i, e := range s.events {
+			fmt.Printf("#%d %s type 0x%08x digest %x\n", i+1, rtmrSimName(e), e.Type, e.Digest)
+			fmt.Printf("   RTMR3: %x\n", s.values[i])
+		}
+	case "undo":
+		if len(s.events) == 0 {
+			return fmt.Errorf("no events to undo")
+		}
+		s.events, s.values = s.events[:len(s.events)-1], s.values[:len(s.values)-1]
+		fmt.Printf("RTMR3: %x\n", s.value())
+	case "reset":
+		s.events, s.values = nil, nil
+		fmt.Printf("RTMR3: %x\n", s.value())
+	case "save":
+		path, format, _ := strings.Cut(rest, " ")
+		if path == "" {
+			return fmt.Errorf("usage: save <path> [format]")
+		}
+		if format = strings.TrimSpace(format); format == "" {
+			format = internal.EventLogDstack
+		}
+		// Raw digests shorter than a SHA384 digest are recorded zero-padded, as they are extended.
+		events := make([]internal.LogEvent, len(s.events))
+		for i, e := range s.events {
+			events[i] = e
+			if len(e.Digest) < 48 {
+				events[i].Digest = make([]byte, 48)
+				copy(events[i].Digest, e.Digest)
+			}
+		}
+		data, err := internal.EncodeEventLog(events, format)
+		if err != nil {
+			return err
+		}
+		if err = os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %d events to %s\n", len(events), path)
+	case "help":
+		fmt.Println(rtmrSimHelp)
+	default:
+		return fmt.Errorf("unknown command '%s', type help for the commands", command)
+	}
+	return nil
+}
+
+// rtmrSimPayload decodes the payload of an event: text, hex:<hex> or @<path>.
+func rtmrSimPayload(payload string) ([]byte, error) {
+	if value, ok := strings.CutPrefix(payload, "hex:"); ok {
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex payload: %w", err)
+		}
+		return data, nil
+	}
+	if path, ok := strings.CutPrefix(payload, "@"); ok {
+		return os.ReadFile(path)
+	}
+	return []byte(payload), nil
+}
@@ -0,0 +1,139 @@
+//go:build !minimal
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runtimeCheckOutput is the JSON output of the check-runtime command.
+type runtimeCheckOutput struct {
+	// Events is the number of events extended into RTMR3.
+	Events    int    `json:"events"`
+	Replayed  string `json:"replayed"`
+	Reported  string `json:"reported,omitempty"`
+	Predicted string `json:"predicted"`
+	// Consistent reports whether the replayed value equals the value reported by the agent.
+	Consistent bool               `json:"consistent"`
+	Match      bool               `json:"match"`
+	Warnings   []internal.Warning `json:"warnings"`
+}
+
+// runCheckRuntime implements the check-runtime command, which replays the runtime events of a TD
+// into RTMR3 and compares the result against the value computed from the runtime inputs.
+func runCheckRuntime(args []string) {
+	var (
+		opts       measureOptions
+		agent      string
+		eventLog   string
+		ccelTable  string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("check-runtime", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&agent, "agent", "", "Fetch the event log through the Info API of the dstack guest agent (http(s) URL or unix:<socket path>)")
+	fs.StringVar(&eventLog, "event-log", "", "Path to the event log of the TD in any format of the convert command")
+	fs.StringVar(&ccelTable, "ccel-table", "", ccelTableUsage)
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if (agent == "") == (eventLog == "") {
+		fmt.Println("Error: exactly one of -agent and -event-log is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	var log *internal.AgentEventLog
+	if agent != "" {
+		var err error
+		if log, err = internal.FetchAgentEventLog(agent); err != nil {
+			fmt.Printf("Error fetching event log: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		data, err := os.ReadFile(eventLog)
+		if err != nil {
+			fmt.Printf("Error reading event log: %v\n", err)
+			os.Exit(1)
+		}
+		if data, err = boundEventLog(data, ccelTable); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		events, err := internal.ParseEventLog(data, internal.DetectEventLogFormat(data))
+		if err != nil {
+			fmt.Printf("Error parsing event log: %v\n", err)
+			os.Exit(1)
+		}
+		log = &internal.AgentEventLog{Events: events}
+	}
+
+	replayed, err := internal.ReplayEventLog(log.Events, "RTMR3")
+	if err != nil {
+		fmt.Printf("Error replaying event log: %v\n", err)
+		os.Exit(1)
+	}
+
+	opts.only = "RTMR3"
+	job := opts.prepare(fs)
+	measurements, err := job.measure(job.kernelData)
+	if err != nil {
+		fmt.Printf("Error calculating measurements: %v\n", err)
+		os.Exit(1)
+	}
+
+	output := runtimeCheckOutput{
+		Replayed:   hex.EncodeToString(replayed),
+		Predicted:  hex.EncodeToString(measurements.RTMR3),
+		Consistent: log.RTMR3 == nil || bytes.Equal(log.RTMR3, replayed),
+		Warnings:   append(append([]internal.Warning{}, job.warnings...), internal.CheckRuntimeEvents(log.Events)...),
+	}
+	for _, e := range log.Events {
+		if e.Register == "RTMR3" {
+			output.Events++
+		}
+	}
+	if log.RTMR3 != nil {
+		output.Reported = hex.EncodeToString(log.RTMR3)
+	}
+	output.Match = output.Consistent && bytes.Equal(replayed, measurements.RTMR3)
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(output, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		fmt.Printf("Runtime events: %d\n", output.Events)
+		fmt.Printf("RTMR3 replayed:  %s\n", output.Replayed)
+		if output.Reported != "" {
+			fmt.Printf("RTMR3 reported:  %s\n", output.Reported)
+		}
+		fmt.Printf("RTMR3 predicted: %s\n", output.Predicted)
+		switch {
+		case !output.Consistent:
+			fmt.Println("Result: MISMATCH (the event log does not replay to the RTMR3 reported by the agent)")
+		case !output.Match:
+			fmt.Println("Result: MISMATCH")
+		default:
+			fmt.Println("Result: MATCH")
+		}
+		for _, w := range output.Warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if !output.Match {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runSelfCheck implements the selfcheck command.
+func runSelfCheck(args []string) {
+	var (
+		fixturesPath  string
+		templatesPath string
+		cacheDir      string
+		jsonOutput    bool
+	)
+
+	fs := flag.NewFlagSet("selfcheck", flag.ExitOnError)
+	fs.StringVar(&fixturesPath, "fixtures", "", "Path to additional fixtures, e.g. for official dstack images")
+	fs.StringVar(&templatesPath, "templates", "", "Path to templates directory used for image fixtures")
+	fs.StringVar(&cacheDir, "cache", "", "Directory to cache downloaded images in (defaults to the user cache directory)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	fixtures, err := internal.SyntheticFixtures()
+	if err != nil {
+		fmt.Printf("Error loading built-in fixtures: %v\n", err)
+		os.Exit(1)
+	}
+	if fixturesPath != "" {
+		extra, err := internal.LoadFixtures(fixturesPath)
+		if err != nil {
+			fmt.Printf("Error loading fixtures: %v\n", err)
+			os.Exit(1)
+		}
+		fixtures = append(fixtures, extra...)
+	}
+	if cacheDir == "" {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			fmt.Printf("Error determining cache directory: %v\n", err)
+			os.Exit(1)
+		}
+		cacheDir = filepath.Join(dir, "reproduce-mr", "fixtures")
+	}
+	if err = os.MkdirAll(cacheDir, 0o755); err != nil {
+		fmt.Printf("Error creating cache directory: %v\n", err)
+		os.Exit(1)
+	}
+
+	results, err := internal.RunSelfCheck(fixtures, templatesPath, cacheDir)
+	if err != nil {
+		fmt.Printf("Error running self check: %v\n", err)
+		os.Exit(1)
+	}
+
+	passed, synthetic := true, true
+	for _, r := range results {
+		passed = passed && r.Passed
+		synthetic = synthetic && r.Synthetic
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		for _, r := range results {
+			switch {
+			case r.Passed:
+				fmt.Printf("PASS %s\n", r.Name)
+			case r.Error != "":
+				fmt.Printf("FAIL %s: %s\n", r.Name, r.Error)
+			default:
+				fmt.Printf("FAIL %s: %s differ\n", r.Name, strings.Join(r.Mismatches, ", "))
+			}
+		}
+		if synthetic {
+			fmt.Fprintln(os.Stderr, "Note: only synthetic fixtures were checked. Their expected values are regression values of this tool, not measurements of real TDs; pass -fixtures with values taken from quotes of real TDs to check that the measurements are correct.")
+		}
+	}
+
+	if !passed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,788 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// maxParamSize is the maximum size of a measurement parameter of a request.
+const maxParamSize = 64 << 10
+
+// defaultInputLimits are the default maximum sizes of the artifacts of a request in megabytes.
+var defaultInputLimits = map[string]uint64{
+	"fw":             64,
+	"kernel":         256,
+	"initrd":         1024,
+	"rootfs":         1024,
+	"docker-compose": 16,
+	"docker-files":   256,
+	"quote":          1,
+}
+
+// inputLimits is a flag setting the maximum sizes of artifacts as name=size, e.g. initrd=512M.
+type inputLimits map[string]uint64
+
+func (l inputLimits) String() string {
+	names := make([]string, 0, len(l))
+	for name := range l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		size := memoryValue(l[name])
+		parts = append(parts, name+"="+size.String())
+	}
+	return strings.Join(parts, ",")
+}
+
+func (l inputLimits) Set(value string) error {
+	name, size, ok := strings.Cut(value, "=")
+	if !ok {
+		return fmt.Errorf("expected name=size, got '%s'", value)
+	}
+	if _, known := defaultInputLimits[name]; !known {
+		return fmt.Errorf("unknown input '%s'", name)
+	}
+	mb, err := parseMemorySize(size)
+	if err != nil {
+		return err
+	}
+	l[name] = mb
+	return nil
+}
+
+// debugLevel is the debugging output a request asks for with the debug parameter. Each level
+// includes the output of the levels before it.
+type debugLevel int
+
+const (
+	debugNone debugLevel = iota
+	// debugTrace explains every event extended into the RTMRs.
+	debugTrace
+	// debugEvents adds the data of every event to the trace.
+	debugEvents
+)
+
+// debugLevels are the names of the debug levels.
+var debugLevels = []string{"none", "trace", "events"}
+
+func (l *debugLevel) String() string {
+	return debugLevels[*l]
+}
+
+func (l *debugLevel) Set(value string) error {
+	level, err := parseDebugLevel(value)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// parseDebugLevel parses the name of a debug level.
+func parseDebugLevel(value string) (debugLevel, error) {
+	for i, name := range debugLevels {
+		if value == name {
+			return debugLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown debug level '%s', expected one of %s", value, strings.Join(debugLevels, ", "))
+}
+
+// server serves measurement and verification requests over HTTP.
+type server struct {
+	templatesPath string
+	audit         *internal.AuditLog
+	// limits are the maximum sizes of the artifacts of a request in megabytes, maxRequestSize the
+	// maximum size of a request in megabytes.
+	limits         inputLimits
+	maxRequestSize uint64
+	// slots bounds the number of measure and verify requests served concurrently, each of which
+	// holds its artifacts in memory. It is nil if the number is not bounded.
+	slots chan struct{}
+	// sandbox holds the limits of sandboxed measurements, nil if measurements run in-process.
+	sandbox    *internal.SandboxLimits
+	executable string
+	// profilePacks are the profile packs loaded at startup with the profiles they provide.
+	profilePacks map[string][]string
+	// maxDebug is the highest debug level requests may ask for.
+	maxDebug debugLevel
+	// dcapRoot is the root CA quotes are verified against with DCAP collateral fetched through the
+	// collateral cache, nil if quotes are not verified. acceptTcb are the accepted TCB statuses.
+	dcapRoot   *x509.Certificate
+	collateral *internal.DcapCollateralCache
+	acceptTcb  string
+	// registry is the registry browsed through GET /registry, nil if none is served.
+	registry internal.RegistryStore
+	// draining is set once the server shuts down, after which it no longer reports ready.
+	draining atomic.Bool
+	// notifier posts verification failures to webhooks, nil if none are configured. unknown holds
+	// up to maxUnknownMeasurements mismatching quoted measurement sets observed so far.
+	notifier     *internal.Notifier
+	unknown      sync.Map
+	unknownCount atomic.Int64
+}
+
+// maxUnknownMeasurements bounds the memory used to remember unknown measurement sets. Sets beyond
+// it are reported as unknown every time they are observed.
+const maxUnknownMeasurements = 10000
+
+// verifyFailure are the details of a verification failure notification.
+type verifyFailure struct {
+	Client     string                    `json:"client"`
+	Identity   string                    `json:"identity,omitempty"`
+	Mismatches []internal.RegisterResult `json:"mismatches"`
+}
+
+// serveRequest holds the artifacts and parameters of a measurement request.
+type serveRequest struct {
+	files  map[string][]byte
+	params map[string]string
+}
+
+// runServe implements the serve command.
+func runServe(args []string) {
+	var (
+		listen        string
+		templatesPath string
+		auditPath     string
+		auditMaxSize  uint
+		auditMaxFiles uint
+		tlsCert       string
+		tlsKey        string
+		clientCA      string
+		sandbox       bool
+		sandboxMemory uint64
+		sandboxCPU    uint64
+		sandboxTime   time.Duration
+		maxRequest    memoryValue = 2048
+		maxConcurrent uint
+		profilePacks  stringList
+		packKeys      stringList
+		headerTimeout time.Duration
+		readTimeout   time.Duration
+		writeTimeout  time.Duration
+		idleTimeout   time.Duration
+		drainTimeout  time.Duration
+		notify        stringList
+		notifyEvents  string
+		maxDebug      = debugTrace
+		ui            bool
+		registryPath  string
+		rootCa        string
+		pcsURL        string
+		collateralTTL time.Duration
+		acceptTcb     string
+	)
+	limits := inputLimits{}
+	for name, size := range defaultInputLimits {
+		limits[name] = size
+	}
+
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&listen, "listen", ":8080", "Address to listen on")
+	fs.StringVar(&templatesPath, "templates", "", "Path to the ACPI table templates directory")
+	fs.StringVar(&auditPath, "audit-log", "", "Path to a JSONL file recording every request and the values issued")
+	fs.UintVar(&auditMaxSize, "audit-log-max-size", 100, "Size in megabytes at which the audit log is rotated (0 disables rotation)")
+	fs.UintVar(&auditMaxFiles, "audit-log-max-files", 10, "Number of rotated audit log files to keep")
+	fs.StringVar(&tlsCert, "tls-cert", "", "Path to a PEM encoded TLS server certificate")
+	fs.StringVar(&tlsKey, "tls-key", "", "Path to the PEM encoded key of the TLS server certificate")
+	fs.StringVar(&clientCA, "tls-client-ca", "", "Path to PEM encoded CA certificates that client certificates must be issued by")
+	fs.BoolVar(&sandbox, "sandbox", false, "Parse and measure the artifacts of every request in a resource-limited, seccomp-restricted worker process")
+	fs.Uint64Var(&sandboxMemory, "sandbox-memory", 4096, "Maximum address space of a sandbox worker in megabytes")
+	fs.Uint64Var(&sandboxCPU, "sandbox-cpu-time", 300, "Maximum CPU time of a sandbox worker in seconds")
+	fs.DurationVar(&sandboxTime, "sandbox-timeout", 10*time.Minute, "Maximum duration of a sandboxed measurement")
+	fs.Var(limits, "max-size", "Maximum size of an artifact as name=size, e.g. initrd=512M (can be repeated)")
+	fs.Var(&maxRequest, "max-request-size", "Maximum size of a request including all artifacts")
+	fs.UintVar(&maxConcurrent, "max-concurrent", 4, "Maximum number of measure and verify requests served at once, further requests are rejected with 503 (0 for no limit)")
+	fs.Var(&profilePacks, "profile-pack", "Path to a signed profile pack providing additional profiles (can be repeated, not with -sandbox)")
+	fs.Var(&packKeys, "profile-pack-key", "Key trusted to sign profile packs: hex-encoded Ed25519 public key or path to a PEM Ed25519/ECDSA public key (can be repeated)")
+	fs.DurationVar(&headerTimeout, "read-header-timeout", 30*time.Second, "Maximum duration for reading the headers of a request")
+	fs.DurationVar(&readTimeout, "read-timeout", 10*time.Minute, "Maximum duration for reading a request including all artifacts")
+	fs.DurationVar(&writeTimeout, "write-timeout", 15*time.Minute, "Maximum duration from the end of the request headers until the response is written")
+	fs.DurationVar(&idleTimeout, "idle-timeout", 2*time.Minute, "Maximum duration a keep-alive connection waits for the next request")
+	fs.Var(&notify, "notify", "Webhook to notify of verification failures: an http(s) URL receiving JSON or slack:<url> (can be repeated)")
+	fs.StringVar(&notifyEvents, "notify-events", "", "Comma-separated notification events to send: verify-failure, unknown-measurement (defaults to all)")
+	fs.Var(&maxDebug, "max-debug", "Highest debug level requests may ask for: none, trace (per-event explanation) or events (trace with the data of every event)")
+	fs.BoolVar(&ui, "ui", false, "Serve a web UI under /ui/ to submit artifacts, view and compare reports, and browse the registry")
+	fs.StringVar(&registryPath, "registry", "", "Registry served read-only under /registry: the path of a JSON file or sql:<driver>:<dsn>")
+	fs.StringVar(&rootCa, "dcap-root-ca", "", "Path to a verified copy of the Intel SGX Root CA certificate (PEM or DER) submitted quotes are verified against with DCAP (quotes are not verified without it)")
+	fs.StringVar(&pcsURL, "pcs-url", internal.DefaultPcsURL, "URL of the Intel Provisioning Certification Service to fetch DCAP collateral from")
+	fs.DurationVar(&collateralTTL, "collateral-ttl", time.Hour, "Maximum duration DCAP collateral fetched for a platform is reused, shorter if the collateral is due to be updated earlier (0 fetches it for every quote)")
+	fs.StringVar(&acceptTcb, "accept-tcb-status", "UpToDate", "Comma-separated list of accepted TCB statuses, e.g. UpToDate,SWHardeningNeeded")
+	fs.DurationVar(&drainTimeout, "shutdown-timeout", 5*time.Minute, "Maximum duration in-flight requests may take to complete on SIGTERM before they are aborted")
+	parseFlags(fs, args)
+
+	if templatesPath == "" {
+		fmt.Println("Error: templates path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if (tlsCert == "") != (tlsKey == "") || (clientCA != "" && tlsCert == "") {
+		fmt.Println("Error: -tls-cert and -tls-key are required together, and for -tls-client-ca")
+		os.Exit(1)
+	}
+
+	s := &server{templatesPath: templatesPath, limits: limits, maxRequestSize: uint64(maxRequest), profilePacks: map[string][]string{}, maxDebug: maxDebug, collateral: internal.NewDcapCollateralCache(pcsURL, collateralTTL), acceptTcb: acceptTcb}
+	if maxConcurrent > 0 {
+		s.slots = make(chan struct{}, maxConcurrent)
+	}
+	if rootCa != "" {
+		root, err := internal.LoadDcapRootCa(rootCa)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		s.dcapRoot = root
+	}
+	var events []string
+	if notifyEvents != "" {
+		events = strings.Split(notifyEvents, ",")
+	}
+	notifier, err := internal.NewNotifier(notify, events, func(err error) { fmt.Fprintf(os.Stderr, "Error: %v\n", err) })
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	s.notifier = notifier
+	defer notifier.Wait()
+	if len(profilePacks) > 0 {
+		if sandbox {
+			fmt.Println("Error: sandbox workers only support built-in profiles and cannot be combined with -profile-pack")
+			os.Exit(1)
+		}
+		if len(packKeys) == 0 {
+			fmt.Println("Error: -profile-pack-key is required with profile packs")
+			os.Exit(1)
+		}
+		anchors, err := internal.ParseTrustAnchors(packKeys)
+		if err != nil {
+			fmt.Printf("Error: invalid profile pack key: %v\n", err)
+			os.Exit(1)
+		}
+		cacheDir, err := internal.DefaultProfilePacksCacheDir()
+		if err != nil {
+			fmt.Printf("Error determining profile pack cache directory: %v\n", err)
+			os.Exit(1)
+		}
+		for _, pack := range profilePacks {
+			loaded, err := internal.LoadProfilePack(pack, anchors, cacheDir)
+			if err != nil {
+				fmt.Printf("Error loading profile pack: %v\n", err)
+				os.Exit(1)
+			}
+			names := []string{}
+			for _, p := range loaded {
+				names = append(names, p.Name)
+			}
+			s.profilePacks[filepath.Base(pack)] = names
+		}
+	}
+	if sandbox {
+		executable, err := os.Executable()
+		if err != nil {
+			fmt.Printf("Error locating executable for the sandbox worker: %v\n", err)
+			os.Exit(1)
+		}
+		s.executable = executable
+		s.sandbox = &internal.SandboxLimits{Memory: sandboxMemory * 1024 * 1024, CPUTime: sandboxCPU, Timeout: sandboxTime}
+	}
+	if registryPath != "" {
+		store, err := internal.OpenRegistryStore(registryPath)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+		s.registry = store
+	}
+	if auditPath != "" {
+		audit, err := internal.OpenAuditLog(auditPath, int64(auditMaxSize)*1024*1024, int(auditMaxFiles))
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		defer audit.Close()
+		s.audit = audit
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /measure", s.limitConcurrency("measure", s.handleMeasure))
+	mux.HandleFunc("POST /verify", s.limitConcurrency("verify", s.handleVerify))
+	mux.HandleFunc("GET /healthz", s.handleHealth)
+	mux.HandleFunc("GET /readyz", s.handleReady)
+	if s.registry != nil {
+		mux.HandleFunc("GET /registry", s.handleRegistry)
+	}
+	if ui {
+		s.registerUI(mux)
+	}
+	httpServer := &http.Server{
+		Addr:              listen,
+		Handler:           mux,
+		ReadHeaderTimeout: headerTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	// On SIGTERM or SIGINT the server stops reporting ready and accepting connections, and waits for
+	// in-flight requests to complete before the audit log is closed.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	drained := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		s.draining.Store(true)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+		defer cancel()
+		drained <- httpServer.Shutdown(shutdownCtx)
+	}()
+
+	if tlsCert != "" {
+		if clientCA != "" {
+			pem, err := os.ReadFile(clientCA)
+			if err != nil {
+				fmt.Printf("Error reading client CA certificates: %v\n", err)
+				os.Exit(1)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				fmt.Println("Error: no client CA certificates found")
+				os.Exit(1)
+			}
+			httpServer.TLSConfig = &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+		}
+		err = httpServer.ListenAndServeTLS(tlsCert, tlsKey)
+	} else {
+		err = httpServer.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("Error serving: %v\n", err)
+		os.Exit(1)
+	}
+	if err = <-drained; err != nil {
+		fmt.Printf("Error draining in-flight requests: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// handleHealth reports that the service is alive, together with its version.
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	info := internal.ReadBuildInfo()
+	writeJSON(w, map[string]string{"status": "ok", "version": info.String()})
+}
+
+// readiness is the response of the readiness endpoint.
+type readiness struct {
+	Ready bool `json:"ready"`
+	// Templates is the number of ACPI table templates available.
+	Templates int `json:"templates"`
+	// ProfilePacks maps the loaded profile packs to the profiles they provide.
+	ProfilePacks map[string][]string `json:"profile_packs"`
+	Errors       []string            `json:"errors,omitempty"`
+}
+
+// handleReady reports whether the service can serve requests: the ACPI templates are available, the
+// profile packs are loaded and the service is not shutting down.
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	status := readiness{Ready: true, ProfilePacks: s.profilePacks}
+	count, err := internal.CountTemplates(s.templatesPath)
+	status.Templates = count
+	switch {
+	case err != nil:
+		status.Errors = append(status.Errors, fmt.Sprintf("templates: %v", err))
+	case count == 0:
+		status.Errors = append(status.Errors, "templates: no ACPI table templates found")
+	}
+	if s.draining.Load() {
+		status.Errors = append(status.Errors, "shutting down")
+	}
+	code := http.StatusOK
+	if len(status.Errors) > 0 {
+		status.Ready, code = false, http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	writeJSON(w, status)
+}
+
+// handleMeasure computes the measurements of the submitted artifacts.
+func (s *server) handleMeasure(w http.ResponseWriter, r *http.Request) {
+	req, entry, ok := s.readRequest(w, r, "measure")
+	if !ok {
+		return
+	}
+	debug, ok := s.requestDebugLevel(w, entry, req)
+	if !ok {
+		return
+	}
+	measurements, err := s.measure(r.Context(), req)
+	if err != nil {
+		s.fail(w, entry, http.StatusUnprocessableEntity, err)
+		return
+	}
+	mrAggregated, err := measurements.CalculateMrAggregated(defaultMrKeyProvider)
+	if err != nil {
+		s.fail(w, entry, http.StatusInternalServerError, err)
+		return
+	}
+	entry.Registers = internal.RegisterValues(measurements)
+	if !s.record(w, entry) {
+		return
+	}
+	writeJSON(w, measurementOutput{
+		MRTD:             hex.EncodeToString(measurements.MRTD),
+		RTMR0:            hex.EncodeToString(measurements.RTMR0),
+		RTMR1:            hex.EncodeToString(measurements.RTMR1),
+		RTMR2:            hex.EncodeToString(measurements.RTMR2),
+		RTMR3:            hex.EncodeToString(measurements.RTMR3),
+		MrAggregated:     mrAggregated,
+		MrImage:          measurements.CalculateMrImage(),
+		Registers:        entry.Registers,
+		Coverage:         measurements.Coverage,
+		RegisterCoverage: measurements.RegisterCoverage(),
+		ConstantDigests:  measurements.ConstantDigests(),
+		Warnings:         append([]internal.Warning{}, measurements.Warnings...),
+		Trace:            debug.trace(measurements),
+	})
+}
+
+// handleVerify compares the submitted quote against the measurements of the submitted artifacts.
+func (s *server) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req, entry, ok := s.readRequest(w, r, "verify")
+	if !ok {
+		return
+	}
+	evidence, err := internal.ParseEvidence(req.files["quote"])
+	if err != nil {
+		s.fail(w, entry, http.StatusBadRequest, fmt.Errorf("invalid quote: %w", err))
+		return
+	}
+	report := evidence.Report
+	debug, ok := s.requestDebugLevel(w, entry, req)
+	if !ok {
+		return
+	}
+	// The quote must be genuine before its measurements mean anything.
+	var dcap *dcapOutput
+	warnings := []internal.Warning{}
+	switch {
+	case s.dcapRoot == nil && evidence.Format != internal.EvidenceTdxQuote:
+		warnings = append(warnings, internal.Warning{Code: internal.WarningQuoteNotVerified, Message: fmt.Sprintf("%s evidence carries no DCAP signature, it was not verified", evidence.Format)})
+	case s.dcapRoot == nil:
+		warnings = append(warnings, internal.Warning{Code: internal.WarningQuoteNotVerified, Message: "the quote signature was not verified, the service runs without -dcap-root-ca"})
+	default:
+		// Evidence without a DCAP signature is rejected like a quote whose signature is invalid.
+		var collateral *internal.DcapCollateral
+		if err = checkEvidenceSigned(evidence); err == nil {
+			collateral, err = s.collateral.Fetch(req.files["quote"], time.Now())
+		}
+		if err == nil {
+			dcap, err = verifyDcap(req.files["quote"], collateral, s.dcapRoot, s.acceptTcb)
+		}
+		if err != nil {
+			status := http.StatusBadGateway
+			if errors.Is(err, internal.ErrQuoteInvalid) {
+				status = http.StatusUnprocessableEntity
+			}
+			s.fail(w, entry, status, err)
+			return
+		}
+	}
+	measurements, err := s.measure(r.Context(), req)
+	if err != nil {
+		s.fail(w, entry, http.StatusUnprocessableEntity, err)
+		return
+	}
+	registers := "mrtd,rtmr0,rtmr1,rtmr2"
+	if v := req.params["registers"]; v != "" {
+		registers = v
+	}
+	results, err := internal.CompareReport(report, measurements, strings.Split(registers, ","))
+	if err != nil {
+		s.fail(w, entry, http.StatusBadRequest, err)
+		return
+	}
+	match := dcap == nil || dcap.Accepted
+	for _, r := range results {
+		match = match && r.Match
+	}
+	entry.Registers = internal.RegisterValues(measurements)
+	entry.Match = &match
+	if !match {
+		s.notifyVerifyFailure(entry, results)
+	}
+	if !s.record(w, entry) {
+		return
+	}
+	warnings = append(warnings, measurements.Warnings...)
+	if profile, err := internal.LookupProfile(paramOr(req.params, "profile", internal.DefaultProfile)); err == nil {
+		warnings = append(warnings, internal.CheckTdFeatures(report, profile)...)
+	}
+	writeJSON(w, verifyOutput{Registers: results, Dcap: dcap, Match: match, Diagnoses: internal.DiagnoseMismatch(results), Warnings: warnings, Trace: debug.trace(measurements)})
+}
+
+// notifyVerifyFailure notifies the webhooks of a failed verification, and of an unknown
+// measurement if the quoted values of the compared registers were not observed before.
+func (s *server) notifyVerifyFailure(entry *internal.AuditEntry, results []internal.RegisterResult) {
+	if s.notifier == nil {
+		return
+	}
+	failure := verifyFailure{Client: entry.Client, Identity: entry.Identity}
+	var quoted, mismatching []string
+	for _, r := range results {
+		quoted = append(quoted, r.Register+"="+r.Actual)
+		if !r.Match {
+			failure.Mismatches = append(failure.Mismatches, r)
+			mismatching = append(mismatching, r.Register)
+		}
+	}
+	summary := fmt.Sprintf("quote from %s does not match the expected %s", entry.Client, strings.Join(mismatching, ", "))
+	s.notifier.Notify(internal.Notification{Event: internal.NotifyVerifyFailure, Source: "serve", Summary: summary, Details: failure})
+	key := strings.Join(quoted, ",")
+	seen := false
+	if _, seen = s.unknown.Load(key); !seen && s.unknownCount.Load() < maxUnknownMeasurements {
+		if _, seen = s.unknown.LoadOrStore(key, true); !seen {
+			s.unknownCount.Add(1)
+		}
+	}
+	if !seen {
+		summary = fmt.Sprintf("first quote with unknown %s values: %s", strings.Join(mismatching, ", "), strings.Join(quoted, ", "))
+		s.notifier.Notify(internal.Notification{Event: internal.NotifyUnknownMeasurement, Source: "serve", Summary: summary, Details: failure})
+	}
+}
+
+// readRequest reads the multipart form of a request. Files are the artifacts (fw, kernel, initrd,
+// quote) and all other fields are measurement parameters. The form is read part by part, so a
+// request is rejected as soon as an artifact exceeds its size limit, and every artifact is hashed
+// for the audit log while it is read.
+func (s *server) readRequest(w http.ResponseWriter, r *http.Request, operation string) (*serveRequest, *internal.AuditEntry, bool) {
+	entry := newAuditEntry(r, operation)
+	r.Body = http.MaxBytesReader(w, r.Body, int64(s.maxRequestSize)*1024*1024)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		s.fail(w, entry, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return nil, nil, false
+	}
+	req := &serveRequest{files: map[string][]byte{}, params: map[string]string{}}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.fail(w, entry, readErrorStatus(err), fmt.Errorf("invalid request: %w", err))
+			return nil, nil, false
+		}
+		name := part.FormName()
+		if part.FileName() == "" {
+			value, err := readPart(part, maxParamSize)
+			if err != nil {
+				s.fail(w, entry, readErrorStatus(err), fmt.Errorf("parameter %s: %w", name, err))
+				return nil, nil, false
+			}
+			if _, ok := req.params[name]; !ok {
+				req.params[name] = string(value)
+				entry.Parameters[name] = string(value)
+			}
+			continue
+		}
+
+		limit, ok := s.limits[name]
+		if !ok {
+			s.fail(w, entry, http.StatusBadRequest, fmt.Errorf("unknown artifact '%s'", name))
+			return nil, nil, false
+		}
+		hash := sha256.New()
+		data, err := readPart(io.TeeReader(part, hash), int64(limit)*1024*1024)
+		if err != nil {
+			s.fail(w, entry, readErrorStatus(err), fmt.Errorf("%s: %w", name, err))
+			return nil, nil, false
+		}
+		if _, ok := req.files[name]; !ok {
+			req.files[name] = data
+			entry.Inputs[name] = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+	return req, entry, true
+}
+
+// newAuditEntry returns the audit entry of a request for the operation.
+func newAuditEntry(r *http.Request, operation string) *internal.AuditEntry {
+	entry := &internal.AuditEntry{
+		Time:       time.Now().UTC(),
+		Client:     r.RemoteAddr,
+		Operation:  operation,
+		Inputs:     map[string]string{},
+		Parameters: map[string]string{},
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		entry.Identity = r.TLS.PeerCertificates[0].Subject.String()
+	}
+	return entry
+}
+
+// limitConcurrency wraps the handler of an operation to reject requests with 503 while all slots
+// are taken, so that at most len(s.slots) requests hold their artifacts in memory at once.
+func (s *server) limitConcurrency(operation string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.slots != nil {
+			select {
+			case s.slots <- struct{}{}:
+				defer func() { <-s.slots }()
+			default:
+				w.Header().Set("Retry-After", "1")
+				s.fail(w, newAuditEntry(r, operation), http.StatusServiceUnavailable, fmt.Errorf("too many concurrent requests"))
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// errPartTooLarge is returned for form parts exceeding their size limit.
+var errPartTooLarge = errors.New("exceeds the maximum size")
+
+// readPart reads a form part of at most limit bytes.
+func readPart(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%w of %d bytes", errPartTooLarge, limit)
+	}
+	return data, nil
+}
+
+// readErrorStatus returns the HTTP status for an error reading a request.
+func readErrorStatus(err error) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.Is(err, errPartTooLarge) || errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// requestDebugLevel returns the debug level a request asks for, failing the request if it is unknown or
+// exceeds the level the server allows.
+func (s *server) requestDebugLevel(w http.ResponseWriter, entry *internal.AuditEntry, req *serveRequest) (debugLevel, bool) {
+	level, err := parseDebugLevel(paramOr(req.params, "debug", "none"))
+	if err != nil {
+		s.fail(w, entry, http.StatusBadRequest, err)
+		return 0, false
+	}
+	if level > s.maxDebug {
+		s.fail(w, entry, http.StatusForbidden, fmt.Errorf("debug level '%s' exceeds the maximum '%s' of the service", level.String(), s.maxDebug.String()))
+		return 0, false
+	}
+	return level, true
+}
+
+// trace returns the event trace of the measurements at the debug level, nil if none is requested.
+func (l debugLevel) trace(measurements *internal.TdxMeasurements) []internal.EventTrace {
+	if l == debugNone {
+		return nil
+	}
+	return measurements.Trace(l >= debugEvents)
+}
+
+// measure computes the measurements of a request, stopping when the client goes away.
+func (s *server) measure(ctx context.Context, req *serveRequest) (*internal.TdxMeasurements, error) {
+	for _, name := range []string{"fw", "kernel"} {
+		if req.files[name] == nil {
+			return nil, fmt.Errorf("%s is required", name)
+		}
+	}
+	memory, err := parseMemorySize(paramOr(req.params, "memory", "2G"))
+	if err != nil {
+		return nil, err
+	}
+	cpus, err := strconv.ParseUint(paramOr(req.params, "cpu", "1"), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CPU count: %w", err)
+	}
+	tcbver, err := strconv.ParseUint(paramOr(req.params, "tcbver", "7"), 10, 8)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TCB version: %w", err)
+	}
+	profile, err := internal.LookupProfile(paramOr(req.params, "profile", internal.DefaultProfile))
+	if err != nil {
+		return nil, err
+	}
+	if s.sandbox != nil {
+		return internal.MeasureSandboxed(ctx, s.executable, &internal.SandboxRequest{
+			Firmware: req.files["fw"], Kernel: req.files["kernel"], Initrd: req.files["initrd"],
+			Rootfs: req.files["rootfs"], DockerCompose: req.files["docker-compose"], DockerFiles: req.files["docker-files"],
+			MemorySize: memory, CPUCount: uint32(cpus), Cmdline: req.params["cmdline"], TemplatesPath: s.templatesPath,
+			TcbVersion: uint8(tcbver), Profile: profile.Name, Limits: *s.sandbox,
+		})
+	}
+	return internal.MeasureTdxQemuContext(ctx, internal.MeasureOptions{
+		Firmware: req.files["fw"], Kernel: req.files["kernel"], Initrd: req.files["initrd"],
+		Rootfs: req.files["rootfs"], DockerCompose: req.files["docker-compose"], DockerFiles: req.files["docker-files"],
+		MemoryMB: memory, CPUs: uint32(cpus), Cmdline: req.params["cmdline"], TemplatesPath: s.templatesPath, TcbVersion: uint8(tcbver), Profile: profile,
+	})
+}
+
+// record writes the audit entry of a request, failing the request if it cannot be recorded.
+func (s *server) record(w http.ResponseWriter, entry *internal.AuditEntry) bool {
+	if s.audit == nil {
+		return true
+	}
+	if err := s.audit.Record(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Error recording audit log entry: %v\n", err)
+		http.Error(w, "failed to record audit log entry", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// fail records a failed request and responds with the error.
+func (s *server) fail(w http.ResponseWriter, entry *internal.AuditEntry, status int, err error) {
+	entry.Error = err.Error()
+	if s.record(w, entry) {
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// writeJSON responds with the JSON encoding of v.
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// paramOr returns the parameter with the given name or the fallback if it is not set.
+func paramOr(params map[string]string, name, fallback string) string {
+	if v, ok := params[name]; ok && v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,124 @@
+//go:build !minimal
+
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testRootCa returns a self-signed CA certificate standing in for the Intel SGX Root CA.
+func testRootCa(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Root CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// verifyRequest returns a verify request submitting the evidence as the quote.
+func verifyRequest(t *testing.T, evidence []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	part, err := mw.CreateFormFile("quote", "quote.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(evidence)
+	mw.Close()
+	r := httptest.NewRequest(http.MethodPost, "/verify", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestHandleVerifyRejectsUnsignedEvidence(t *testing.T) {
+	limits := inputLimits{}
+	for name, size := range defaultInputLimits {
+		limits[name] = size
+	}
+	for _, tt := range []struct {
+		name     string
+		evidence []byte
+	}{
+		{"td report", tdReport(nil)},
+		{"hcl report", hclReport()},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &server{limits: limits, maxRequestSize: 16, dcapRoot: testRootCa(t)}
+			w := httptest.NewRecorder()
+			s.handleVerify(w, verifyRequest(t, tt.evidence))
+			if w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "no DCAP signature") {
+				t.Errorf("with a root CA: status %d, body %q, want 422 for evidence without DCAP signature", w.Code, w.Body.String())
+			}
+
+			// Without a root CA the evidence is accepted with a warning and goes on to be
+			// measured, which fails for want of artifacts.
+			s.dcapRoot = nil
+			w = httptest.NewRecorder()
+			s.handleVerify(w, verifyRequest(t, tt.evidence))
+			if strings.Contains(w.Body.String(), "no DCAP signature") {
+				t.Errorf("without a root CA: evidence was rejected: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestLimitConcurrency(t *testing.T) {
+	s := &server{slots: make(chan struct{}, 1)}
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := s.limitConcurrency("verify", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	})
+
+	first, done := verifyRequest(t, nil), make(chan struct{})
+	go func() {
+		handler(httptest.NewRecorder(), first)
+		close(done)
+	}()
+	<-started
+	w := httptest.NewRecorder()
+	handler(w, verifyRequest(t, nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("request beyond the limit: status %d, want 503", w.Code)
+	}
+
+	// Once the first request completes its slot is free again.
+	close(release)
+	<-done
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	handler(w, verifyRequest(t, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("request after the first completed: status %d, want 200", w.Code)
+	}
+}
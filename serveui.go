@@ -0,0 +1,86 @@
+//go:build !minimal
+
+package main
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// uiFiles holds the web UI: the page template and the static assets it loads.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+var uiTemplate = template.Must(template.ParseFS(uiFiles, "ui/index.html.tmpl"))
+
+// uiPage is the data the page template is rendered with.
+type uiPage struct {
+	Version        string
+	Profiles       []string
+	DefaultProfile string
+	DebugLevels    []string
+	Registry       bool
+}
+
+// uiContentSecurityPolicy only allows the UI to load its own assets and to talk to the service.
+const uiContentSecurityPolicy = "default-src 'none'; script-src 'self'; style-src 'self'; connect-src 'self'; img-src 'self' data:; form-action 'none'; frame-ancestors 'none'; base-uri 'none'"
+
+// registerUI adds the web UI to the mux. It is served under /ui/, / redirects to it.
+func (s *server) registerUI(mux *http.ServeMux) {
+	static, err := fs.Sub(uiFiles, "ui/static")
+	if err != nil {
+		panic(err)
+	}
+	files := http.StripPrefix("/ui/static/", http.FileServerFS(static))
+	mux.Handle("GET /ui/static/", uiHeaders(files))
+	mux.Handle("GET /ui/{$}", uiHeaders(http.HandlerFunc(s.handleUI)))
+	mux.Handle("GET /{$}", http.RedirectHandler("/ui/", http.StatusFound))
+}
+
+// uiHeaders sets the security headers of the web UI.
+func uiHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Security-Policy", uiContentSecurityPolicy)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleUI renders the page of the web UI.
+func (s *server) handleUI(w http.ResponseWriter, r *http.Request) {
+	page := uiPage{
+		Version:        internal.ReadBuildInfo().String(),
+		DefaultProfile: internal.DefaultProfile,
+		DebugLevels:    debugLevels[:s.maxDebug+1],
+		Registry:       s.registry != nil,
+	}
+	for _, p := range internal.Profiles() {
+		page.Profiles = append(page.Profiles, p.Name)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := uiTemplate.Execute(w, page); err != nil {
+		fmt.Fprintf(os.Stderr, "Error rendering web UI: %v\n", err)
+	}
+}
+
+// handleRegistry lists the entries of the registry of the service.
+func (s *server) handleRegistry(w http.ResponseWriter, r *http.Request) {
+	entries, err := s.registry.Entries()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading registry: %v\n", err)
+		http.Error(w, "failed to read registry", http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []internal.RegistryEntry{}
+	}
+	writeJSON(w, entries)
+}
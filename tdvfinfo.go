@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runTdvfInfo implements the tdvf-info command, which lists the sections of the TDVF metadata of
+// a firmware.
+func runTdvfInfo(args []string) {
+	var (
+		fwPath     string
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("tdvf-info", flag.ExitOnError)
+	fs.StringVar(&fwPath, "fw", "", "Path to firmware file")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if fwPath == "" {
+		fmt.Println("Error: firmware path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	fwData, err := os.ReadFile(fwPath)
+	if err != nil {
+		fmt.Printf("Error reading firmware file: %v\n", err)
+		os.Exit(1)
+	}
+	meta, err := internal.ParseTdvfMetadata(fwData)
+	if err != nil {
+		fmt.Printf("Error parsing TDVF metadata: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(meta, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Printf("TDVF metadata descriptor at 0x%x, %d sections\n", meta.DescriptorOffset, len(meta.Sections))
+	for i, s := range meta.Sections {
+		fmt.Printf("  %d: %-13s GPA 0x%x-0x%x  data 0x%x+0x%x  attributes 0x%x", i, s.Type, s.MemoryAddress, s.MemoryEnd(), s.DataOffset, s.RawDataSize, s.Attributes)
+		if s.MrExtend {
+			fmt.Print(" mr_extend")
+		}
+		if s.PageAug {
+			fmt.Print(" page_aug")
+		}
+		fmt.Println()
+	}
+}
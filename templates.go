@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runTemplates implements the templates command, which manages ACPI table templates.
+func runTemplates(args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			runTemplatesList(args[1:])
+			return
+		case "sign":
+			runTemplatesSign(args[1:])
+			return
+		}
+	}
+	fmt.Println("Usage: reproduce-mr templates list|sign [flags]")
+	os.Exit(1)
+}
+
+// runTemplatesList implements templates list, which shows the guest configurations the templates
+// of a directory cover.
+func runTemplatesList(args []string) {
+	var (
+		templatesPath string
+		profileName   string
+		jsonOutput    bool
+	)
+
+	fs := flag.NewFlagSet("templates list", flag.ExitOnError)
+	fs.StringVar(&templatesPath, "templates", "", "Path to templates directory (default: the templates of the profile)")
+	fs.StringVar(&profileName, "profile", internal.DefaultProfile, "Name of the QEMU/firmware profile whose templates are listed")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if templatesPath == "" {
+		profile, err := internal.LookupProfile(profileName)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		templatesPath = profile.TemplatesPath
+	}
+	if templatesPath == "" {
+		fmt.Println("Error: templates path is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	combinations, err := internal.ListTemplates(templatesPath)
+	if err != nil {
+		fmt.Printf("Error listing templates: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		if combinations == nil {
+			combinations = []internal.TemplateCombination{}
+		}
+		jsonData, err := json.MarshalIndent(combinations, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	if len(combinations) == 0 {
+		fmt.Printf("No templates in %s\n", templatesPath)
+		return
+	}
+	fmt.Printf("%-6s %-10s %-6s %s\n", "CPUS", "HOTPLUG", "SIGNED", "FILE")
+	for _, c := range combinations {
+		hotplug := "-"
+		if c.Slots != 0 {
+			maxMemory := memoryValue(c.MaxMemoryMB)
+			hotplug = fmt.Sprintf("%dx%s", c.Slots, maxMemory.String())
+		}
+		signed := "no"
+		if c.Signed {
+			signed = "yes"
+		}
+		fmt.Printf("%-6d %-10s %-6s %s\n", c.CPUs, hotplug, signed, c.File)
+	}
+}
+
+// runTemplatesSign implements templates sign, which writes the detached signatures of the templates
+// of a directory for publishing it as a template set.
+func runTemplatesSign(args []string) {
+	var (
+		templatesPath string
+		release       string
+		keyPath       string
+	)
+
+	fs := flag.NewFlagSet("templates sign", flag.ExitOnError)
+	fs.StringVar(&templatesPath, "templates", "", "Path to templates directory")
+	fs.StringVar(&release, "release", "", "Release of the template set, e.g. qemu-8.2, which the signatures are bound to")
+	fs.StringVar(&keyPath, "key", "", "Path to the PEM PKCS #8 Ed25519 key to sign the templates with")
+	parseFlags(fs, args)
+
+	if templatesPath == "" || release == "" || keyPath == "" {
+		fmt.Println("Error: templates path, release and key are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	signer, err := internal.LoadSigningKey(keyPath)
+	if err != nil {
+		fmt.Printf("Error loading signing key: %v\n", err)
+		os.Exit(1)
+	}
+	key, ok := signer.(ed25519.PrivateKey)
+	if !ok {
+		fmt.Println("Error: templates are signed with Ed25519 keys only")
+		os.Exit(1)
+	}
+
+	names, err := internal.SignTemplates(templatesPath, release, key)
+	if err != nil {
+		fmt.Printf("Error signing templates: %v\n", err)
+		os.Exit(1)
+	}
+	for _, name := range names {
+		fmt.Printf("signed %s\n", name)
+	}
+	fmt.Printf("public key: %x\n", key.Public())
+}
@@ -0,0 +1,358 @@
+// Web UI of reproduce-mr serve. Reports of the runs submitted in this browser session are kept in
+// sessionStorage, so that they can be viewed and compared without being stored by the service.
+"use strict";
+
+const runsKey = "reproduce-mr-runs";
+const maxRuns = 20;
+const valueNames = ["mrtd", "rtmr0", "rtmr1", "rtmr2", "rtmr3", "mr_aggregated", "mr_image"];
+
+let runs = loadRuns();
+
+function loadRuns() {
+  try {
+    return JSON.parse(sessionStorage.getItem(runsKey)) || [];
+  } catch {
+    return [];
+  }
+}
+
+function saveRuns() {
+  // Reports with event data can exceed the storage quota; older runs are dropped until they fit.
+  while (runs.length > 0) {
+    try {
+      sessionStorage.setItem(runsKey, JSON.stringify(runs));
+      return;
+    } catch {
+      runs.shift();
+    }
+  }
+  sessionStorage.removeItem(runsKey);
+}
+
+function addRun(label, report) {
+  runs.push({label: `${label} (${new Date().toLocaleTimeString()})`, report: report});
+  if (runs.length > maxRuns) {
+    runs.splice(0, runs.length - maxRuns);
+  }
+  saveRuns();
+  updateRunLists();
+  return runs.length - 1;
+}
+
+// el creates an element with the given attributes and children. Strings are added as text, so
+// values from reports are never interpreted as HTML.
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  for (const [name, value] of Object.entries(attrs || {})) {
+    e.setAttribute(name, value);
+  }
+  for (const child of children.flat()) {
+    if (child !== null && child !== undefined) {
+      e.append(child instanceof Node ? child : String(child));
+    }
+  }
+  return e;
+}
+
+function table(headers, rows) {
+  return el("table", {},
+    el("thead", {}, el("tr", {}, headers.map(h => el("th", {}, h)))),
+    el("tbody", {}, rows));
+}
+
+function hexCell(value) {
+  return el("td", {class: "hex"}, value || "");
+}
+
+function statusCell(status) {
+  return el("td", {class: `coverage-${status}`}, status || "");
+}
+
+function showTab(name) {
+  for (const button of document.querySelectorAll("nav button")) {
+    button.classList.toggle("active", button.dataset.tab === name);
+  }
+  for (const tab of document.querySelectorAll(".tab")) {
+    tab.classList.toggle("active", tab.id === name);
+  }
+}
+
+function updateRunLists() {
+  for (const id of ["report-run", "compare-left", "compare-right"]) {
+    const select = document.getElementById(id);
+    const selected = select.value;
+    select.replaceChildren(...runs.map((run, i) => el("option", {value: i}, run.label)));
+    if (selected !== "" && selected < runs.length) {
+      select.value = selected;
+    }
+  }
+  const right = document.getElementById("compare-right");
+  if (runs.length > 1 && document.getElementById("compare-left").value === right.value) {
+    right.value = runs.length - 1;
+  }
+}
+
+// values returns the computed register and composite values of a report: the registers of a
+// measurement, the expected values of a verification or the values of a registry entry.
+function values(report) {
+  const result = {};
+  if (Array.isArray(report.registers)) {
+    for (const r of report.registers) {
+      result[r.register.toLowerCase()] = r.expected;
+    }
+  } else {
+    Object.assign(result, report.registers || report.values || {});
+  }
+  for (const name of ["mr_aggregated", "mr_image"]) {
+    if (report[name]) {
+      result[name] = report[name];
+    }
+  }
+  return result;
+}
+
+// events returns the events of a report by register, from its trace if it has one and its
+// coverage otherwise.
+function events(report) {
+  const result = {};
+  for (const e of report.trace || report.coverage || []) {
+    (result[e.register] = result[e.register] || []).push(e);
+  }
+  return result;
+}
+
+function sortedNames(...objects) {
+  const names = new Set(objects.flatMap(o => Object.keys(o)));
+  const rank = name => valueNames.includes(name) ? valueNames.indexOf(name) : valueNames.length;
+  return [...names].sort((a, b) => rank(a) - rank(b) || a.localeCompare(b));
+}
+
+function renderReport(report) {
+  const view = document.getElementById("report-view");
+  const parts = [];
+  if (Array.isArray(report.registers)) {
+    parts.push(el("p", {class: report.match ? "status ok" : "status error"},
+      report.match ? "The quote matches the measurements." : "The quote does not match the measurements."));
+    parts.push(table(["Register", "Expected", "Quoted", "Match", "Coverage"], report.registers.map(r =>
+      el("tr", {}, el("td", {}, r.register), hexCell(r.expected), hexCell(r.actual),
+        el("td", {class: r.match ? "match" : "mismatch"}, r.match ? "yes" : "no"), statusCell(r.coverage)))));
+    if (report.diagnoses && report.diagnoses.length > 0) {
+      parts.push(el("h3", {}, "Likely causes"));
+      parts.push(table(["Cause", "Suggestion"], report.diagnoses.map(d =>
+        el("tr", {}, el("td", {}, d.cause), el("td", {}, d.suggestion)))));
+    }
+  } else {
+    const v = values(report);
+    const coverage = report.register_coverage || {};
+    parts.push(table(["Register", "Value", "Coverage"], sortedNames(v).map(name =>
+      el("tr", {}, el("td", {}, name), hexCell(v[name]), statusCell(coverage[name.toUpperCase()])))));
+  }
+  if (report.warnings && report.warnings.length > 0) {
+    parts.push(el("h3", {}, "Warnings"));
+    parts.push(report.warnings.map(w => el("div", {class: "warning"}, `[${w.code}] ${w.message}`)));
+  }
+  if (report.constant_digests && report.constant_digests.length > 0) {
+    parts.push(el("h3", {}, "Constant digests"));
+    parts.push(table(["Register", "Event", "Digest", "Note"], report.constant_digests.map(e =>
+      el("tr", {}, el("td", {}, e.register), el("td", {}, e.event), hexCell(e.digest), el("td", {}, e.note)))));
+  }
+  if (report.trace) {
+    parts.push(el("h3", {}, "Event trace"));
+    parts.push(table(["Register", "#", "Event", "Type", "Digest", "Status", "Value after"], report.trace.map(e =>
+      el("tr", {}, el("td", {}, e.register), el("td", {}, e.index), el("td", {}, e.event, e.note ? ` (${e.note})` : ""),
+        el("td", {}, `0x${e.type.toString(16)}`), hexCell(e.digest), statusCell(e.status), hexCell(e.value)))));
+  } else if (report.coverage) {
+    parts.push(el("h3", {}, "Events"));
+    parts.push(table(["Register", "Event", "Status", "Source", "Digest"], report.coverage.map(e =>
+      el("tr", {}, el("td", {}, e.register), el("td", {}, e.event, e.note ? ` (${e.note})` : ""), statusCell(e.status),
+        el("td", {}, e.source), hexCell(e.digest)))));
+  }
+  view.replaceChildren(...parts.flat());
+}
+
+function showRun(index) {
+  document.getElementById("report-run").value = index;
+  renderReport(runs[index].report);
+}
+
+function renderCompare() {
+  const view = document.getElementById("compare-view");
+  const left = runs[document.getElementById("compare-left").value];
+  const right = runs[document.getElementById("compare-right").value];
+  if (!left || !right) {
+    return;
+  }
+  const lv = values(left.report);
+  const rv = values(right.report);
+  const parts = [el("h3", {}, "Registers")];
+  parts.push(table(["Register", left.label, right.label], sortedNames(lv, rv).map(name =>
+    el("tr", {class: lv[name] === rv[name] ? "" : "different"}, el("td", {}, name), hexCell(lv[name]), hexCell(rv[name])))));
+
+  // Events are paired by their position in the register, so an inserted event shows up as a
+  // difference of all events after it.
+  const le = events(left.report);
+  const re = events(right.report);
+  const rows = [];
+  for (const register of sortedNames(le, re)) {
+    const l = le[register] || [];
+    const r = re[register] || [];
+    for (let i = 0; i < Math.max(l.length, r.length); i++) {
+      const a = l[i] || {};
+      const b = r[i] || {};
+      if (a.digest !== b.digest || a.event !== b.event) {
+        rows.push(el("tr", {class: "different"}, el("td", {}, register), el("td", {}, i + 1),
+          el("td", {}, a.event || ""), hexCell(a.digest), el("td", {}, b.event || ""), hexCell(b.digest)));
+      }
+    }
+  }
+  parts.push(el("h3", {}, "Differing events"));
+  if (rows.length > 0) {
+    parts.push(table(["Register", "#", "Left event", "Left digest", "Right event", "Right digest"], rows));
+  } else if (Object.keys(le).length === 0 || Object.keys(re).length === 0) {
+    parts.push(el("p", {class: "empty"}, "A run has no events to compare."));
+  } else {
+    parts.push(el("p", {class: "empty"}, "All events are the same."));
+  }
+  view.replaceChildren(...parts);
+}
+
+async function submitMeasurement(event) {
+  event.preventDefault();
+  const form = event.target;
+  const status = document.getElementById("measure-status");
+  const data = new FormData();
+  const names = [];
+  for (const input of form.querySelectorAll("input, select")) {
+    if (input.type === "file") {
+      if (input.files.length > 0) {
+        data.append(input.name, input.files[0], input.files[0].name);
+        names.push(input.files[0].name);
+      }
+    } else if (input.value !== "") {
+      data.append(input.name, input.value);
+    }
+  }
+  const operation = data.has("quote") ? "verify" : "measure";
+
+  status.className = "status";
+  status.textContent = "Measuring...";
+  try {
+    const response = await fetch(`/${operation}`, {method: "POST", body: data});
+    if (!response.ok) {
+      throw new Error((await response.text()).trim() || response.statusText);
+    }
+    const report = await response.json();
+    status.textContent = "";
+    showRun(addRun(`${operation} ${names.join(", ")}`, report));
+    showTab("report");
+  } catch (err) {
+    status.className = "status error";
+    status.textContent = `Error: ${err.message}`;
+  }
+}
+
+async function openReport(event) {
+  const file = event.target.files[0];
+  if (!file) {
+    return;
+  }
+  try {
+    showRun(addRun(file.name, JSON.parse(await file.text())));
+  } catch (err) {
+    document.getElementById("report-view").replaceChildren(el("p", {class: "status error"}, `Error: ${err.message}`));
+  }
+  event.target.value = "";
+}
+
+function downloadReport() {
+  const run = runs[document.getElementById("report-run").value];
+  if (!run) {
+    return;
+  }
+  const blob = new Blob([JSON.stringify(run.report, null, 2)], {type: "application/json"});
+  const link = el("a", {href: URL.createObjectURL(blob), download: "report.json"});
+  link.click();
+  URL.revokeObjectURL(link.href);
+}
+
+let registryEntries = [];
+
+async function loadRegistry() {
+  const view = document.getElementById("registry-view");
+  try {
+    const response = await fetch("/registry");
+    if (!response.ok) {
+      throw new Error((await response.text()).trim() || response.statusText);
+    }
+    registryEntries = await response.json();
+    renderRegistry();
+  } catch (err) {
+    view.replaceChildren(el("p", {class: "status error"}, `Error: ${err.message}`));
+  }
+}
+
+function renderRegistry() {
+  const filter = document.getElementById("registry-filter").value.toLowerCase();
+  const entries = registryEntries.filter(e =>
+    e.image.toLowerCase().includes(filter) || (e.version || "").toLowerCase().includes(filter));
+  const view = document.getElementById("registry-view");
+  if (entries.length === 0) {
+    view.replaceChildren(el("p", {class: "empty"}, "No entries."));
+    return;
+  }
+  view.replaceChildren(table(["Image", "Version", "Measured at", "Profile", "Memory", "CPUs", "TCB", "Source", ""],
+    entries.map(e => {
+      const compare = el("button", {type: "button"}, "Add to runs");
+      compare.addEventListener("click", () => {
+        addRun(`registry ${e.image}`, e);
+        showTab("compare");
+        document.getElementById("compare-right").value = runs.length - 1;
+        renderCompare();
+      });
+      const row = el("tr", {class: "entry"}, el("td", {}, e.image), el("td", {}, e.version || ""), el("td", {}, e.measured_at),
+        el("td", {}, e.profile), el("td", {}, `${e.memory_mb}M`), el("td", {}, e.cpus), el("td", {}, e.tcb_version),
+        el("td", {}, e.provenance ? e.provenance.source : "local"), el("td", {}, compare));
+      const details = el("tr", {hidden: ""}, el("td", {colspan: 9},
+        table(["Value", ""], sortedNames(e.values).map(name => el("tr", {}, el("td", {}, name), hexCell(e.values[name])))),
+        (e.artifacts || []).length > 0 ? table(["Artifact", "SHA-256"], e.artifacts.map(a =>
+          el("tr", {}, el("td", {}, a.name || a.path || ""), hexCell(a.sha256)))) : null));
+      row.addEventListener("click", event => {
+        if (event.target !== compare) {
+          details.hidden = !details.hidden;
+        }
+      });
+      return [row, details];
+    })));
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  for (const button of document.querySelectorAll("nav button")) {
+    button.addEventListener("click", () => {
+      showTab(button.dataset.tab);
+      if (button.dataset.tab === "registry" && registryEntries.length === 0) {
+        loadRegistry();
+      }
+    });
+  }
+  document.getElementById("measure-form").addEventListener("submit", submitMeasurement);
+  document.getElementById("report-run").addEventListener("change", event => showRun(event.target.value));
+  document.getElementById("report-file").addEventListener("change", openReport);
+  document.getElementById("report-download").addEventListener("click", downloadReport);
+  document.getElementById("compare-left").addEventListener("change", renderCompare);
+  document.getElementById("compare-right").addEventListener("change", renderCompare);
+  const filter = document.getElementById("registry-filter");
+  if (filter) {
+    filter.addEventListener("input", renderRegistry);
+    document.getElementById("registry-reload").addEventListener("click", loadRegistry);
+  }
+
+  updateRunLists();
+  if (runs.length > 0) {
+    showRun(runs.length - 1);
+    if (runs.length > 1) {
+      document.getElementById("compare-left").value = runs.length - 2;
+      document.getElementById("compare-right").value = runs.length - 1;
+      renderCompare();
+    }
+  }
+});
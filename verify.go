@@ -0,0 +1,420 @@
+//go:build !minimal
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// verifyOutput is the JSON output of the verify command.
+type verifyOutput struct {
+	Registers []internal.RegisterResult `json:"registers"`
+	Allowlist *allowlistOutput          `json:"allowlist,omitempty"`
+	// Dcap is the result of verifying the quote signature and the TCB status of the platform.
+	Dcap *dcapOutput `json:"dcap,omitempty"`
+	// Fields are the results of comparing the other fields of the TD report, e.g. the XFAM against
+	// the XFAM predicted for -cpu-model.
+	Fields []internal.FieldResult `json:"fields,omitempty"`
+	Match  bool                   `json:"match"`
+	// Diagnoses are the likely causes of mismatching registers.
+	Diagnoses []internal.Diagnosis `json:"diagnoses,omitempty"`
+	Warnings  []internal.Warning   `json:"warnings"`
+	// ProfileDecisions trace how the profile of the expected measurements was selected.
+	ProfileDecisions []profileDecision `json:"profile_decisions,omitempty"`
+	// Trace explains every event extended into the RTMRs, when requested.
+	Trace []internal.EventTrace `json:"trace,omitempty"`
+}
+
+// allowlistOutput is the result of checking a quote against an allowlist source.
+type allowlistOutput struct {
+	Source  string `json:"source"`
+	Allowed bool   `json:"allowed"`
+	Entry   string `json:"entry,omitempty"`
+}
+
+// dcapOutput is the result of verifying a quote with DCAP.
+type dcapOutput struct {
+	*internal.DcapResult
+	// Accepted reports whether the TCB status is among the accepted statuses.
+	Accepted bool `json:"accepted"`
+}
+
+// loadDcapCollateral loads the collateral to verify the quote from collateralPath or fetches it
+// from the PCS, and saves it to savePath if set.
+func loadDcapCollateral(quote []byte, collateralPath, savePath, pcsURL string) (*internal.DcapCollateral, error) {
+	var (
+		collateral *internal.DcapCollateral
+		err        error
+	)
+	if collateralPath != "" {
+		collateral, err = internal.LoadDcapCollateral(collateralPath)
+	} else {
+		collateral, err = internal.FetchDcapCollateral(pcsURL, quote)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if savePath != "" {
+		data, err := json.MarshalIndent(collateral, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		if err = os.WriteFile(savePath, data, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to save collateral: %w", err)
+		}
+	}
+	return collateral, nil
+}
+
+// verifyDcap verifies the signature of the quote and the TCB status of the platform against the
+// collateral.
+func verifyDcap(quote []byte, collateral *internal.DcapCollateral, root *x509.Certificate, accept string) (*dcapOutput, error) {
+	result, err := internal.VerifyDcapQuote(quote, collateral, root, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	output := &dcapOutput{DcapResult: result}
+	for _, status := range strings.Split(accept, ",") {
+		output.Accepted = output.Accepted || strings.TrimSpace(status) == result.TcbStatus
+	}
+	return output, nil
+}
+
+// checkEvidenceSigned returns an ErrQuoteInvalid error for evidence that carries no DCAP signature,
+// such as raw TD reports and HCL reports. Anyone can forge their registers, so they are only
+// compared when DCAP verification is disabled.
+func checkEvidenceSigned(evidence *internal.ParsedEvidence) error {
+	if evidence.Format == internal.EvidenceTdxQuote {
+		return nil
+	}
+	return fmt.Errorf("%w: %s evidence carries no DCAP signature", internal.ErrQuoteInvalid, evidence.Format)
+}
+
+// verifyEvidenceSignature verifies the DCAP signature and TCB status of the evidence with the
+// root CA certificate at rootCaPath, rejecting evidence that carries no DCAP signature.
+func verifyEvidenceSignature(evidence *internal.ParsedEvidence, quote []byte, rootCaPath, collateralPath, savePath, pcsURL, accept string) (*dcapOutput, error) {
+	if err := checkEvidenceSigned(evidence); err != nil {
+		return nil, fmt.Errorf("%w, its registers can only be compared with -no-dcap", err)
+	}
+	if rootCaPath == "" {
+		return nil, fmt.Errorf("a verified copy of the Intel SGX Root CA (%s) must be given with -dcap-root-ca to verify the quote, or the check skipped with -no-dcap", internal.IntelRootCaURL)
+	}
+	root, err := internal.LoadDcapRootCa(rootCaPath)
+	if err != nil {
+		return nil, err
+	}
+	collateral, err := loadDcapCollateral(quote, collateralPath, savePath, pcsURL)
+	if err != nil {
+		return nil, err
+	}
+	return verifyDcap(quote, collateral, root, accept)
+}
+
+// printDcapResult prints the result of verifying the quote signature and TCB status.
+func printDcapResult(d *dcapOutput) {
+	verdict := "accepted"
+	if !d.Accepted {
+		verdict = "NOT ACCEPTED"
+	}
+	fmt.Printf("QUOTE: signature OK, TCB status %s %s (FMSPC %s)\n", d.TcbStatus, verdict, d.Fmspc)
+	if d.TcbStatus != d.PlatformTcbStatus || d.TcbStatus != d.QeTcbStatus {
+		fmt.Printf("  platform %s, QE %s", d.PlatformTcbStatus, d.QeTcbStatus)
+		if d.ModuleTcbStatus != "" {
+			fmt.Printf(", TDX module %s", d.ModuleTcbStatus)
+		}
+		fmt.Println()
+	}
+	if len(d.AdvisoryIDs) > 0 {
+		fmt.Printf("  advisories: %s\n", strings.Join(d.AdvisoryIDs, ", "))
+	}
+}
+
+// runVerify implements the verify command.
+func runVerify(args []string) {
+	var (
+		opts       measureOptions
+		quotePath  string
+		expected   string
+		registers  string
+		jsonOutput bool
+		canonical  bool
+		earPath    string
+		earKeyPath string
+		allowlist  string
+		rpcURL     string
+		eventLog   string
+		ccelTable  string
+		noColor    bool
+		noDcap     bool
+		collateral string
+		saveColl   string
+		pcsURL     string
+		rootCa     string
+		acceptTcb  string
+		fields     = map[string]*string{}
+	)
+
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	opts.register(fs)
+	fs.StringVar(&quotePath, "quote", "", "Path to a TDX quote (binary, hex or base64)")
+	fs.StringVar(&expected, "expected", "", "Path to a JSON file of expected mrtd, rtmr0-3, mr_image and mr_aggregated values (e.g. an earlier JSON output) to compare the measurements against instead of a quote")
+	fs.StringVar(&registers, "registers", "mrtd,rtmr0,rtmr1,rtmr2", "Comma-separated list of registers to compare (with -expected: all values of the file)")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	fs.BoolVar(&canonical, "canonical", false, "Output canonical JSON (RFC 8785) suitable for signing and content addressing, implies -json")
+	fs.StringVar(&earPath, "ear", "", "Path to write a signed EAR attestation result token to")
+	fs.StringVar(&earKeyPath, "ear-key", "", "Path to a PEM encoded PKCS #8 Ed25519 or ECDSA key used to sign the EAR token")
+	fs.StringVar(&allowlist, "allowlist-source", "", "Source of allowed measurement sets: kms:<url> or chain:<contract>")
+	fs.StringVar(&rpcURL, "rpc-url", "", "Ethereum JSON-RPC endpoint used with on-chain allowlist sources")
+	fs.StringVar(&eventLog, "event-log", "", "Path to the event log of the TD (CCEL binary, dstack JSON or any format of the convert command) used to find the first differing event")
+	fs.StringVar(&ccelTable, "ccel-table", "", ccelTableUsage)
+	fs.BoolVar(&noColor, "no-color", false, "Disable ANSI colors in the result table")
+	fs.BoolVar(&noDcap, "no-dcap", false, "Do not verify the quote signature and TCB status with DCAP")
+	fs.StringVar(&collateral, "collateral", "", "Path to DCAP collateral saved with -save-collateral, to verify the quote offline instead of fetching the collateral from the PCS")
+	fs.StringVar(&saveColl, "save-collateral", "", "Path to save the DCAP collateral the quote was verified against to")
+	fs.StringVar(&pcsURL, "pcs-url", internal.DefaultPcsURL, "URL of the Intel Provisioning Certification Service to fetch DCAP collateral from")
+	fs.StringVar(&rootCa, "dcap-root-ca", "", "Path to a verified copy of the Intel SGX Root CA certificate (PEM or DER) quotes must chain up to, required unless -no-dcap is given")
+	fs.StringVar(&acceptTcb, "accept-tcb-status", "UpToDate", "Comma-separated list of accepted TCB statuses, e.g. UpToDate,SWHardeningNeeded")
+	for _, f := range []struct{ name, field, usage string }{
+		{"td-attributes", "td_attributes", "Expected hex-encoded TD attributes of the quote"},
+		{"mr-config-id", "mr_config_id", "Expected hex-encoded MRCONFIGID of the quote, zero-padded if shorter"},
+		{"mr-owner", "mr_owner", "Expected hex-encoded MROWNER of the quote, zero-padded if shorter"},
+		{"mr-owner-config", "mr_owner_config", "Expected hex-encoded MROWNERCONFIG of the quote, zero-padded if shorter"},
+	} {
+		fields[f.field] = fs.String(f.name, "", f.usage)
+	}
+	parseFlags(fs, args)
+
+	if (quotePath == "") == (expected == "") {
+		fmt.Println("Error: exactly one of -quote and -expected is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if earPath != "" && earKeyPath == "" {
+		fmt.Println("Error: a signing key is required to emit an EAR token")
+		os.Exit(1)
+	}
+	if expected != "" && (earPath != "" || allowlist != "") {
+		fmt.Println("Error: -ear and -allowlist-source appraise a quote and cannot be combined with -expected")
+		os.Exit(1)
+	}
+
+	// Without a quote, the measurements are compared against the expected values.
+	var (
+		report         *internal.TdReport
+		expectedValues map[string]string
+		dcap           *dcapOutput
+		warnings       = []internal.Warning{}
+	)
+	if expected != "" {
+		data, err := os.ReadFile(expected)
+		if err != nil {
+			fmt.Printf("Error reading expected values: %v\n", err)
+			os.Exit(1)
+		}
+		if expectedValues, err = internal.ParseExpectedValues(data); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	} else {
+		quote, err := readQuote(quotePath)
+		if err != nil {
+			fmt.Printf("Error reading quote: %v\n", err)
+			os.Exit(1)
+		}
+		evidence, err := internal.ParseEvidence(quote)
+		if err != nil {
+			fmt.Printf("Error parsing quote: %v\n", err)
+			os.Exit(1)
+		}
+		report = evidence.Report
+
+		// The quote must be genuine before its measurements mean anything.
+		if noDcap {
+			warnings = append(warnings, internal.Warning{Code: internal.WarningQuoteNotVerified, Message: "the quote signature was not verified (-no-dcap)"})
+		} else if dcap, err = verifyEvidenceSignature(evidence, quote, rootCa, collateral, saveColl, pcsURL, acceptTcb); err != nil {
+			fmt.Printf("Error verifying quote: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var (
+		results      = []internal.RegisterResult{}
+		decisions    []profileDecision
+		fieldResults []internal.FieldResult
+		match        = dcap == nil || dcap.Accepted
+	)
+
+	// With an allowlist source the artifacts are optional, the quote is then only checked against
+	// the allowed measurement sets.
+	if allowlist == "" || opts.fwPath != "" || opts.metadataPath != "" || opts.vmManifestPath != "" {
+		job := opts.prepare(fs)
+		measurements, err := job.measure(job.kernelData)
+		if err != nil {
+			fmt.Printf("Error calculating measurements: %v\n", err)
+			os.Exit(1)
+		}
+
+		if expectedValues != nil {
+			// All expected values are compared unless registers are selected explicitly.
+			var names []string
+			fs.Visit(func(f *flag.Flag) {
+				if f.Name == "registers" {
+					names = strings.Split(registers, ",")
+				}
+			})
+			results, err = internal.CompareExpected(expectedValues, measurements, job.mrKeyProvider, names)
+		} else {
+			results, err = internal.CompareReport(report, measurements, strings.Split(registers, ","))
+		}
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		for _, r := range results {
+			match = match && r.Match
+		}
+		if report != nil {
+			expectedFields := map[string][]byte{}
+			for name, value := range fields {
+				if *value == "" {
+					continue
+				}
+				if expectedFields[name], err = hex.DecodeString(strings.TrimPrefix(*value, "0x")); err != nil {
+					fmt.Printf("Error: expected %s is not hex-encoded: %v\n", name, err)
+					os.Exit(1)
+				}
+			}
+			if job.xfam != nil {
+				expectedFields["xfam"] = job.xfam.Xfam
+			}
+			fieldResults = internal.CompareReportFields(report, expectedFields)
+			for i, f := range fieldResults {
+				if f.Field == "xfam" && job.xfam != nil {
+					fieldResults[i].Note = strings.Join(job.xfam.Components, ", ")
+				}
+				match = match && f.Match
+			}
+		}
+		if eventLog != "" && !match {
+			data, err := os.ReadFile(eventLog)
+			if err != nil {
+				fmt.Printf("Error reading event log: %v\n", err)
+				os.Exit(1)
+			}
+			if data, err = boundEventLog(data, ccelTable); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				os.Exit(1)
+			}
+			events, err := internal.ParseEventLog(data, internal.DetectEventLogFormat(data))
+			if err != nil {
+				fmt.Printf("Error parsing event log: %v\n", err)
+				os.Exit(1)
+			}
+			for i := range results {
+				if err = internal.CheckSha384Bank(events, results[i].Register); err != nil {
+					fmt.Printf("Error: %v\n", err)
+					os.Exit(1)
+				}
+				if !results[i].Match {
+					results[i].FirstMismatch = internal.FirstEventMismatch(measurements, events, results[i].Register)
+				}
+			}
+		}
+		warnings = append(warnings, job.warnings...)
+		decisions = job.profileDecisions
+		warnings = append(warnings, measurements.Warnings...)
+		if report != nil {
+			warnings = append(warnings, internal.CheckTdFeatures(report, job.profile)...)
+		}
+	}
+
+	var allowlistResult *allowlistOutput
+	if allowlist != "" {
+		source, err := internal.ParseAllowlistSource(allowlist, rpcURL)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		allowed, entry, err := source.Check(report)
+		if err != nil {
+			fmt.Printf("Error checking allowlist: %v\n", err)
+			os.Exit(1)
+		}
+		allowlistResult = &allowlistOutput{Source: source.String(), Allowed: allowed, Entry: entry}
+		match = match && allowed
+	}
+
+	if earPath != "" {
+		key, err := internal.LoadSigningKey(earKeyPath)
+		if err != nil {
+			fmt.Printf("Error loading EAR signing key: %v\n", err)
+			os.Exit(1)
+		}
+		ar := internal.NewAttestationResult(results, time.Now())
+		ar.AddConfigurationAppraisal(fieldResults)
+		if allowlistResult != nil {
+			ar.AddAllowlistAppraisal(allowlistResult.Allowed)
+		}
+		if dcap != nil {
+			ar.AddPlatformAppraisal(dcap.TcbStatus, dcap.Accepted)
+		} else {
+			ar.AddUnverifiedPlatformAppraisal()
+		}
+		token, err := ar.Sign(key)
+		if err != nil {
+			fmt.Printf("Error signing EAR token: %v\n", err)
+			os.Exit(1)
+		}
+		if err = os.WriteFile(earPath, []byte(token), 0o644); err != nil {
+			fmt.Printf("Error writing EAR token: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	diagnoses := internal.DiagnoseMismatch(results)
+
+	if jsonOutput || canonical {
+		jsonData, err := marshalOutput(verifyOutput{Registers: results, Allowlist: allowlistResult, Dcap: dcap, Fields: fieldResults, Match: match, Diagnoses: diagnoses, Warnings: warnings, ProfileDecisions: decisions}, canonical)
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+	} else {
+		if dcap != nil {
+			printDcapResult(dcap)
+		}
+		if len(results) > 0 {
+			printVerifyTable(results, useColor(noColor))
+		}
+		printProfileDecisions(decisions)
+		for _, d := range diagnoses {
+			fmt.Printf("LIKELY CAUSE: %s\n", d.Cause)
+			fmt.Printf("  SUGGESTION: %s\n", d.Suggestion)
+		}
+		printFieldResults(fieldResults)
+		if allowlistResult != nil {
+			if allowlistResult.Allowed {
+				fmt.Printf("ALLOWLIST: allowed by %s (%s)\n", allowlistResult.Source, allowlistResult.Entry)
+			} else {
+				fmt.Printf("ALLOWLIST: NOT ALLOWED by %s\n", allowlistResult.Source)
+			}
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", w)
+		}
+	}
+
+	if !match {
+		os.Exit(1)
+	}
+}
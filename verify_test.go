@@ -0,0 +1,48 @@
+//go:build !minimal
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// tdReport returns a raw TDREPORT with the given report data and zero registers.
+func tdReport(reportData []byte) []byte {
+	report := make([]byte, 1024)
+	report[0] = 0x81
+	copy(report[128:192], reportData)
+	return report
+}
+
+// hclReport returns a Hyper-V HCL report wrapping a TDREPORT bound to empty runtime claims.
+func hclReport() []byte {
+	claims := []byte("{}")
+	digest := sha256.Sum256(claims)
+	data := binary.LittleEndian.AppendUint32(nil, 0x414C4348)
+	data = append(data, make([]byte, 28)...)
+	data = append(data, tdReport(digest[:])...)
+	data = append(data, make([]byte, 1184-1024)...)
+	request := make([]byte, 20)
+	binary.LittleEndian.PutUint32(request[8:12], 4)
+	binary.LittleEndian.PutUint32(request[12:16], 1)
+	binary.LittleEndian.PutUint32(request[16:20], uint32(len(claims)))
+	return append(append(data, request...), claims...)
+}
+
+func TestVerifyEvidenceSignatureRejectsUnsignedEvidence(t *testing.T) {
+	for _, data := range [][]byte{tdReport(nil), hclReport()} {
+		evidence, err := internal.ParseEvidence(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Unsigned evidence is rejected before the root CA is even needed.
+		if _, err = verifyEvidenceSignature(evidence, data, "", "", "", "", "UpToDate"); !errors.Is(err, internal.ErrQuoteInvalid) {
+			t.Errorf("%s evidence: error = %v, want %v", evidence.Format, err, internal.ErrQuoteInvalid)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// verifyReportOutput is the JSON output of the verify-report command.
+type verifyReportOutput struct {
+	KeyID       string          `json:"key_id"`
+	PayloadType string          `json:"payload_type"`
+	Report      json.RawMessage `json:"report"`
+}
+
+// signReport wraps a canonical JSON report in a DSSE envelope signed with every key.
+func signReport(report []byte, keys []crypto.Signer) ([]byte, error) {
+	env, err := internal.SignDsse(internal.ReportPayloadType, report, keys[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys[1:] {
+		if err = env.AddSignature(key); err != nil {
+			return nil, err
+		}
+	}
+	return json.MarshalIndent(env, "", "  ")
+}
+
+// runVerifyReport implements the verify-report command, which verifies the signature of a signed
+// measurement report and prints the report.
+func runVerifyReport(args []string) {
+	var (
+		keys        stringList
+		payloadType string
+		outPath     string
+		jsonOutput  bool
+	)
+
+	fs := flag.NewFlagSet("verify-report", flag.ExitOnError)
+	fs.Var(&keys, "key", "Key trusted to sign reports: hex-encoded Ed25519 public key or path to a PEM Ed25519/ECDSA public key (can be repeated)")
+	fs.StringVar(&payloadType, "payload-type", internal.ReportPayloadType, "Expected DSSE payload type")
+	fs.StringVar(&outPath, "out", "", "Path to write the verified report to")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+	// The report may be given before or after the flags.
+	var reportPath string
+	if fs.NArg() > 0 {
+		reportPath = fs.Arg(0)
+		parseFlags(fs, fs.Args()[1:])
+	}
+	if reportPath == "" || fs.NArg() > 0 || len(keys) == 0 {
+		fmt.Println("Error: one report and at least one -key are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	anchors, err := internal.ParseTrustAnchors(keys)
+	if err != nil {
+		fmt.Printf("Error: invalid key: %v\n", err)
+		os.Exit(1)
+	}
+	data, err := os.ReadFile(reportPath)
+	if err != nil {
+		fmt.Printf("Error reading report: %v\n", err)
+		os.Exit(1)
+	}
+	env, err := internal.ParseDsseEnvelope(data)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if env.PayloadType != payloadType {
+		fmt.Printf("Error: report has payload type '%s', expected '%s'\n", env.PayloadType, payloadType)
+		os.Exit(1)
+	}
+	payload, keyID, err := env.Verify(anchors)
+	if err != nil {
+		fmt.Printf("Error: report %v\n", err)
+		os.Exit(1)
+	}
+	if outPath != "" {
+		if err = os.WriteFile(outPath, payload, 0o644); err != nil {
+			fmt.Printf("Error writing report: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if jsonOutput {
+		if !json.Valid(payload) {
+			fmt.Println("Error: verified report is not JSON")
+			os.Exit(1)
+		}
+		jsonData, err := json.MarshalIndent(verifyReportOutput{KeyID: keyID, PayloadType: env.PayloadType, Report: payload}, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Printf("Signature: OK (key %s)\n", keyID)
+	var report measurementOutput
+	if err = json.Unmarshal(payload, &report); err != nil || payloadType != internal.ReportPayloadType {
+		fmt.Println(string(payload))
+		return
+	}
+	for _, r := range []struct{ name, value string }{
+		{"MRTD", report.MRTD}, {"RTMR0", report.RTMR0}, {"RTMR1", report.RTMR1}, {"RTMR2", report.RTMR2},
+		{"RTMR3", report.RTMR3}, {"MR_AGGREGATED", report.MrAggregated}, {"MR_IMAGE", report.MrImage},
+	} {
+		if r.value != "" {
+			fmt.Printf("%s: %s\n", r.name, r.value)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// hexColumnWidth is the number of hex characters shown per line in the verify table.
+const hexColumnWidth = 48
+
+// ANSI escape sequences used in the verify table.
+const (
+	ansiReset = "\x1b[0m"
+	ansiGreen = "\x1b[32m"
+	ansiRed   = "\x1b[1;31m"
+	ansiDim   = "\x1b[2m"
+)
+
+// useColor reports whether the verify table is printed with ANSI colors, which is the case when
+// stdout is a terminal unless disabled with -no-color or the NO_COLOR environment variable.
+func useColor(noColor bool) bool {
+	if noColor || os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	fi, err := os.Stdout.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// tablePainter applies ANSI colors to table cells if enabled.
+type tablePainter bool
+
+func (p tablePainter) paint(code, s string) string {
+	if !p || s == "" {
+		return s
+	}
+	return code + s + ansiReset
+}
+
+// diff returns s with the characters that differ from other highlighted.
+func (p tablePainter) diff(s, other string) string {
+	if !p {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if i < len(other) && s[i] == other[i] {
+			b.WriteByte(s[i])
+		} else {
+			b.WriteString(p.paint(ansiRed, s[i:i+1]))
+		}
+	}
+	return b.String()
+}
+
+// printVerifyTable prints the expected and actual values of the compared registers side by side,
+// together with the first differing event of mismatching registers when it is known.
+func printVerifyTable(results []internal.RegisterResult, color bool) {
+	p := tablePainter(color)
+	// Composites such as MR_AGGREGATED are longer than the register names.
+	nameWidth := len("REGISTER")
+	for _, r := range results {
+		nameWidth = max(nameWidth, len(r.Register))
+	}
+	fmt.Printf("%-*s  %-*s  %-*s  %s\n", nameWidth, "REGISTER", hexColumnWidth, "EXPECTED", hexColumnWidth, "ACTUAL", "STATUS")
+	for _, r := range results {
+		status := p.paint(ansiGreen, fmt.Sprintf("match (%s)", r.EarStatus()))
+		if !r.Match {
+			status = p.paint(ansiRed, "MISMATCH")
+		}
+		for i := 0; i < max(len(r.Expected), len(r.Actual)); i += hexColumnWidth {
+			expected, actual := hexChunk(r.Expected, i), hexChunk(r.Actual, i)
+			name := ""
+			if i == 0 {
+				name = r.Register
+			} else {
+				status = ""
+			}
+			line := fmt.Sprintf("%-*s  %s%s  %s%s  %s", nameWidth, name,
+				expected, strings.Repeat(" ", hexColumnWidth-len(expected)),
+				p.diff(actual, expected), strings.Repeat(" ", hexColumnWidth-len(actual)), status)
+			fmt.Println(strings.TrimRight(line, " "))
+		}
+		if m := r.FirstMismatch; m != nil {
+			printEventMismatch(p, m)
+		}
+	}
+}
+
+// printEventMismatch prints the first differing event of a register below its table row.
+func printEventMismatch(p tablePainter, m *internal.EventMismatch) {
+	indent := strings.Repeat(" ", 10)
+	switch {
+	case m.Event == "":
+		fmt.Printf("%s%s\n", indent, p.paint(ansiRed, fmt.Sprintf("event #%d is not expected", m.Index)))
+	case m.Actual == "":
+		fmt.Printf("%s%s\n", indent, p.paint(ansiRed, fmt.Sprintf("event #%d (%s) is missing from the event log", m.Index, m.Event)))
+	default:
+		fmt.Printf("%s%s\n", indent, p.paint(ansiRed, fmt.Sprintf("first differing event #%d (%s)", m.Index, m.Event)))
+	}
+	if m.Expected != "" {
+		fmt.Printf("%s%s %s\n", indent, p.paint(ansiDim, "expected"), m.Expected)
+	}
+	if m.Actual != "" {
+		fmt.Printf("%s%s   %s\n", indent, p.paint(ansiDim, "actual"), p.diff(m.Actual, m.Expected))
+	}
+}
+
+// hexChunk returns the line of a hex value starting at the given offset.
+func hexChunk(s string, offset int) string {
+	if offset >= len(s) {
+		return ""
+	}
+	return s[offset:min(offset+hexColumnWidth, len(s))]
+}
+
+// fieldLabels are the names of TD report fields in text output, as printed by parse-quote.
+var fieldLabels = map[string]string{
+	"td_attributes":   "TD_ATTRIBUTES",
+	"xfam":            "XFAM",
+	"mr_config_id":    "MRCONFIGID",
+	"mr_owner":        "MROWNER",
+	"mr_owner_config": "MROWNERCONFIG",
+}
+
+// printFieldResults prints the results of comparing the other fields of a TD report, one line per
+// field.
+func printFieldResults(fields []internal.FieldResult) {
+	for _, f := range fields {
+		name := fieldLabels[f.Field]
+		switch {
+		case f.Expected == "":
+			fmt.Printf("%s: %s (not checked)\n", name, f.Quoted)
+		case f.Match:
+			fmt.Printf("%s: %s MATCH\n", name, f.Quoted)
+		default:
+			fmt.Printf("%s: %s MISMATCH, expected %s\n", name, f.Quoted, f.Expected)
+		}
+		if f.Note != "" {
+			fmt.Printf("  %s\n", f.Note)
+		}
+	}
+}
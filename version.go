@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runVersion implements the version command.
+func runVersion(args []string) {
+	var jsonOutput bool
+
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	info := internal.ReadBuildInfo()
+	if !jsonOutput {
+		fmt.Printf("reproduce-mr %s %s %s\n", info, info.GoVersion, info.Platform)
+		return
+	}
+	jsonData, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		fmt.Printf("Error encoding JSON: %v\n", err)
+		os.Exit(1)
+	}
+	fmt.Println(string(jsonData))
+}
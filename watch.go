@@ -0,0 +1,275 @@
+//go:build !minimal
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// watcher measures the images appearing in a release directory and records their reference
+// values in a registry.
+type watcher struct {
+	imagesDir     string
+	registry      internal.RegistryStore
+	templatesPath string
+	webhookURL    string
+	settle        time.Duration
+	// notifier posts images that cannot be measured to webhooks, nil if none are configured.
+	notifier *internal.Notifier
+
+	// Measurement parameters used when the image metadata does not recommend any.
+	memorySize    memoryValue
+	cpus          uint
+	tcbver        uint
+	profileName   string
+	mrKeyProvider string
+
+	// failed maps images that could not be measured to the time their directory was last
+	// modified, so that they are only retried once they change.
+	failed map[string]time.Time
+}
+
+// runWatch implements the watch command.
+func runWatch(args []string) {
+	w := watcher{memorySize: 2048, failed: make(map[string]time.Time)}
+	var (
+		interval     time.Duration
+		once         bool
+		notify       stringList
+		registryPath string
+	)
+
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.StringVar(&w.imagesDir, "images-dir", "", "Release directory with one subdirectory per dstack image")
+	fs.StringVar(&registryPath, "registry", "", "Registry the reference values of new images are added to: the path of a JSON file or sql:<driver>:<dsn>")
+	fs.StringVar(&w.templatesPath, "templates", "", "Path to templates directory")
+	fs.StringVar(&w.webhookURL, "webhook", "", "URL to POST the registry entry of every newly measured image to")
+	fs.Var(&notify, "notify", "Webhook to notify of images that cannot be measured: an http(s) URL receiving JSON or slack:<url> (can be repeated)")
+	fs.DurationVar(&w.settle, "settle", 30*time.Second, "Time an image directory must remain unmodified before it is measured")
+	fs.DurationVar(&interval, "interval", time.Minute, "Interval between scans of the images directory")
+	fs.BoolVar(&once, "once", false, "Scan the images directory once and exit, with a non-zero status if an image could not be measured")
+	fs.Var(&w.memorySize, "memory", "Memory size (e.g., 512M, 1G, 2G) for images that do not recommend one")
+	fs.UintVar(&w.cpus, "cpu", 1, "Number of CPUs for images that do not recommend one")
+	fs.UintVar(&w.tcbver, "tcbver", 0, "TCB version for images that do not recommend one")
+	fs.StringVar(&w.profileName, "profile", internal.DefaultProfile, "Name of the QEMU/firmware profile for images that do not recommend one")
+	fs.StringVar(&w.mrKeyProvider, "mrkp", defaultMrKeyProvider, "Measurement of key provider")
+	parseFlags(fs, args)
+
+	if w.imagesDir == "" || registryPath == "" || w.templatesPath == "" {
+		fmt.Println("Error: images directory, registry and templates path are required")
+		fs.Usage()
+		os.Exit(1)
+	}
+	if knownKeyProvider, ok := knownKeyProviders[w.mrKeyProvider]; ok {
+		w.mrKeyProvider = knownKeyProvider
+	}
+	var err error
+	if w.registry, err = internal.OpenRegistryStore(registryPath); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.registry.Close()
+	if w.notifier, err = internal.NewNotifier(notify, []string{string(internal.NotifyMeasureFailure)}, func(err error) { log.Print(err) }); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer w.notifier.Wait()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if once {
+		err = w.scan(ctx)
+		w.notifier.Wait()
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		if len(w.failed) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Printf("watching %s every %s", w.imagesDir, interval)
+	for ctx.Err() == nil {
+		if err := w.scan(ctx); err != nil {
+			log.Printf("scan failed: %v", err)
+		}
+		sleepContext(ctx, interval)
+	}
+}
+
+// scan measures the images that are not in the registry yet, adding each one to the registry as
+// soon as it is measured.
+func (w *watcher) scan(ctx context.Context) error {
+	registered, err := w.registry.Entries()
+	if err != nil {
+		return err
+	}
+	known := make(map[string]bool, len(registered))
+	for _, e := range registered {
+		known[e.Image] = true
+	}
+	entries, err := os.ReadDir(w.imagesDir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		name := entry.Name()
+		if !entry.IsDir() || known[name] {
+			continue
+		}
+		dir := filepath.Join(w.imagesDir, name)
+		modified, err := lastModified(dir)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			continue
+		}
+		// Images still being copied are picked up by a later scan.
+		if time.Since(modified) < w.settle {
+			continue
+		}
+		if failedAt, ok := w.failed[name]; ok && !modified.After(failedAt) {
+			continue
+		}
+
+		e, err := w.measureImage(name, dir)
+		if err != nil {
+			log.Printf("%s: %v", name, err)
+			w.failed[name] = modified
+			w.notifier.Notify(internal.Notification{Event: internal.NotifyMeasureFailure, Source: "watch", Summary: fmt.Sprintf("image %s cannot be measured: %v", name, err),
+				Details: map[string]string{"image": name, "error": err.Error()}})
+			continue
+		}
+		delete(w.failed, name)
+		if err = w.registry.Add(*e); err != nil {
+			return fmt.Errorf("failed to write registry: %w", err)
+		}
+		known[name] = true
+		log.Printf("%s: measured, mr_image %s", name, e.Values["mr_image"])
+
+		if w.webhookURL != "" {
+			if err = internal.PostWebhook(ctx, w.webhookURL, e); err != nil {
+				log.Printf("%s: failed to notify webhook: %v", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// measureImage computes the registry entry of the image in dir.
+func (w *watcher) measureImage(name, dir string) (*internal.RegistryEntry, error) {
+	metaPath := internal.FindImageMetadata(dir)
+	metadata, err := internal.LoadImageMetadata(metaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &internal.RegistryEntry{
+		Image:      name,
+		Version:    metadata.Version,
+		Profile:    w.profileName,
+		MemoryMB:   uint64(w.memorySize),
+		Cpus:       uint32(w.cpus),
+		TcbVersion: uint8(w.tcbver),
+	}
+	if hints := metadata.VmConfig; hints != nil {
+		if hints.Vcpu != 0 {
+			e.Cpus = hints.Vcpu
+		}
+		if hints.Memory != 0 {
+			e.MemoryMB = hints.Memory
+		}
+		if hints.TcbVersion != 0 {
+			e.TcbVersion = uint8(hints.TcbVersion)
+		}
+		if hints.Profile != "" {
+			e.Profile = hints.Profile
+		}
+	}
+	profile, err := internal.LookupProfile(e.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	read := func(artifact, file string) ([]byte, error) {
+		path := filepath.Join(dir, file)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		e.Artifacts = append(e.Artifacts, internal.NewInputArtifact(artifact, path, data))
+		return data, nil
+	}
+	if _, err = read("metadata", filepath.Base(metaPath)); err != nil {
+		return nil, err
+	}
+	fwData, err := read("fw", metadata.Bios)
+	if err != nil {
+		return nil, err
+	}
+	kernelData, err := read("kernel", metadata.Kernel)
+	if err != nil {
+		return nil, err
+	}
+	if err = internal.CheckKernelImage(kernelData); err != nil {
+		return nil, fmt.Errorf("unsupported kernel image: %w", err)
+	}
+	var initrdData []byte
+	if metadata.Initrd != "" {
+		if initrdData, err = read("initrd", metadata.Initrd); err != nil {
+			return nil, err
+		}
+	}
+
+	measurements, err := internal.MeasureTdxQemu(internal.MeasureOptions{
+		Firmware: fwData, Kernel: kernelData, Initrd: initrdData, MemoryMB: e.MemoryMB, CPUs: e.Cpus, Cmdline: metadata.FullCmdline(),
+		TemplatesPath: w.templatesPath, TcbVersion: e.TcbVersion, Profile: profile,
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.Values = internal.RegisterValues(measurements)
+	if e.Values["mr_aggregated"], err = measurements.CalculateMrAggregated(w.mrKeyProvider); err != nil {
+		return nil, err
+	}
+	e.Values["mr_image"] = measurements.CalculateMrImage()
+	e.Warnings = measurements.Warnings
+	e.MeasuredAt = time.Now().UTC().Format(time.RFC3339)
+	return e, nil
+}
+
+// lastModified returns the latest modification time of the directory and the files in it.
+func lastModified(dir string) (time.Time, error) {
+	latest := time.Time{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/scrtlabs/reproduce-mr/internal"
+)
+
+// runXfam implements the xfam command, which predicts the XFAM of a TD from its QEMU CPU model.
+func runXfam(args []string) {
+	var (
+		opts       measureOptions
+		list       bool
+		jsonOutput bool
+	)
+
+	fs := flag.NewFlagSet("xfam", flag.ExitOnError)
+	fs.StringVar(&opts.cpuModel, "cpu-model", "", "QEMU -cpu argument of the TD, e.g. SapphireRapids,-amx-tile")
+	fs.StringVar(&opts.cpuFlags, "cpu-flags", "", "CPU flags of the host as in /proc/cpuinfo, or @<path> to read them from a cpuinfo file, for -cpu-model host or max")
+	fs.BoolVar(&list, "list", false, "List the known CPU models")
+	fs.BoolVar(&jsonOutput, "json", false, "Output in JSON format")
+	parseFlags(fs, args)
+
+	if list {
+		fmt.Println(strings.Join(append([]string{"host", "max"}, internal.XfamCpuModels()...), "\n"))
+		return
+	}
+	if opts.cpuModel == "" {
+		fmt.Println("Error: -cpu-model is required")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	prediction, err := opts.predictXfam()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		jsonData, err := json.MarshalIndent(struct {
+			Xfam       string   `json:"xfam"`
+			Value      string   `json:"value"`
+			Components []string `json:"components"`
+		}{hex.EncodeToString(prediction.Xfam), fmt.Sprintf("0x%x", prediction.Value), prediction.Components}, "", "  ")
+		if err != nil {
+			fmt.Printf("Error encoding JSON: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(jsonData))
+		return
+	}
+	fmt.Printf("XFAM: %s (0x%x)\n", hex.EncodeToString(prediction.Xfam), prediction.Value)
+	fmt.Printf("Components: %s\n", strings.Join(prediction.Components, ", "))
+}